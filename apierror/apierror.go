@@ -0,0 +1,131 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// 错误类型，与 OpenAI 错误响应中的 type 一致
+const (
+	TYPE_INVALID_REQUEST = "invalid_request_error"
+	TYPE_AUTHENTICATION  = "authentication_error"
+	TYPE_PERMISSION      = "permission_error"
+	TYPE_NOT_FOUND       = "not_found_error"
+	TYPE_RATE_LIMIT      = "rate_limit_error"
+	TYPE_SERVER          = "server_error"
+)
+
+// Error 携带 HTTP 状态码的 OpenAI 格式错误
+type Error struct {
+	Status  int
+	Message string
+	Type    string
+	Code    string
+	Param   string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// New 创建错误，类型由状态码推断
+func New(status int, code string, message string) *Error {
+	return &Error{Status: status, Message: message, Type: TypeForStatus(status), Code: code}
+}
+
+// InvalidRequest 400 错误，param 为出错的参数名
+func InvalidRequest(param string, code string, message string) *Error {
+	return &Error{Status: http.StatusBadRequest, Message: message, Type: TYPE_INVALID_REQUEST, Code: code, Param: param}
+}
+
+// Unauthorized 401 错误
+func Unauthorized(message string) *Error {
+	return New(http.StatusUnauthorized, "invalid_api_key", message)
+}
+
+// Forbidden 403 错误
+func Forbidden(message string) *Error {
+	return New(http.StatusForbidden, "forbidden", message)
+}
+
+// NotFound 404 错误
+func NotFound(message string) *Error {
+	return New(http.StatusNotFound, "not_found", message)
+}
+
+// UpstreamUnavailable 无法连接上游时的 502 错误，不向客户端暴露连接细节
+func UpstreamUnavailable() *Error {
+	return New(http.StatusBadGateway, "upstream_unavailable", "Failed to connect to the upstream service")
+}
+
+// RequestTooLarge 读取请求体超过大小上限时的 413 错误
+func RequestTooLarge(limit int64) *Error {
+	return New(http.StatusRequestEntityTooLarge, "request_too_large",
+		fmt.Sprintf("Request body is too large, the limit for this endpoint is %d bytes.", limit))
+}
+
+// TypeForStatus 返回状态码对应的错误类型
+func TypeForStatus(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return TYPE_AUTHENTICATION
+	case status == http.StatusForbidden:
+		return TYPE_PERMISSION
+	case status == http.StatusNotFound:
+		return TYPE_NOT_FOUND
+	case status == http.StatusTooManyRequests:
+		return TYPE_RATE_LIMIT
+	case status >= http.StatusInternalServerError:
+		return TYPE_SERVER
+	default:
+		return TYPE_INVALID_REQUEST
+	}
+}
+
+// From 将任意错误转换为 *Error，非 *Error 的错误视为 500
+func From(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	return New(http.StatusInternalServerError, "internal_error", err.Error())
+}
+
+// body 错误响应体，code 与 param 为空时输出 null
+type body struct {
+	Error detail `json:"error"`
+}
+
+type detail struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+func nullable(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// MarshalJSON 编码为 {"error":{"message","type","param","code"}}
+func (e *Error) MarshalJSON() ([]byte, error) {
+	return json.Marshal(body{Error: detail{
+		Message: e.Message,
+		Type:    e.Type,
+		Param:   nullable(e.Param),
+		Code:    nullable(e.Code),
+	}})
+}
+
+// Write 以 OpenAI 格式写入错误响应
+func Write(w http.ResponseWriter, err *Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(err.Status)
+	_ = json.NewEncoder(w).Encode(err)
+}
@@ -0,0 +1,123 @@
+package apierror
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func decode(t *testing.T, data []byte) map[string]any {
+	t.Helper()
+	var body struct {
+		Error map[string]any `json:"error"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		t.Fatalf("Invalid error body %q: %v", data, err)
+	}
+	return body.Error
+}
+
+func TestWrite(t *testing.T) {
+	w := httptest.NewRecorder()
+	Write(w, Unauthorized("unauthorized"))
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected application/json, got %q", ct)
+	}
+	detail := decode(t, w.Body.Bytes())
+	if detail["type"] != TYPE_AUTHENTICATION || detail["code"] != "invalid_api_key" || detail["message"] != "unauthorized" {
+		t.Errorf("Unexpected error body: %v", detail)
+	}
+	if value, ok := detail["param"]; !ok || value != nil {
+		t.Errorf("Expected param to be null, got %v", value)
+	}
+}
+
+func TestFrom(t *testing.T) {
+	wrapped := errors.Join(errors.New("context"), NotFound("team not found"))
+	if err := From(wrapped); err.Status != http.StatusNotFound {
+		t.Errorf("Expected wrapped *Error to keep status 404, got %d", err.Status)
+	}
+	if err := From(errors.New("boom")); err.Status != http.StatusInternalServerError || err.Type != TYPE_SERVER {
+		t.Errorf("Expected 500 server_error, got %d %s", err.Status, err.Type)
+	}
+}
+
+func TestFromUpstream(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		wantStatus  int
+		wantType    string
+		wantCode    string
+		wantMessage string
+	}{
+		{"openai error kept", 400, "application/json",
+			`{"error":{"message":"bad","type":"invalid_request_error","param":"messages","code":null}}`,
+			400, TYPE_INVALID_REQUEST, "", "bad"},
+		{"azure deployment not found", 404, "application/json",
+			`{"error":{"code":"DeploymentNotFound","message":"The API deployment for this resource does not exist."}}`,
+			404, TYPE_INVALID_REQUEST, "model_not_found", "The API deployment for this resource does not exist."},
+		{"azure rate limit", 429, "application/json",
+			`{"error":{"code":"429","message":"Requests have exceeded the call rate limit."}}`,
+			429, TYPE_RATE_LIMIT, "rate_limit_exceeded", "Requests have exceeded the call rate limit."},
+		{"azure gateway format", 401, "application/json",
+			`{"statusCode":401,"message":"Unauthorized. Access token is missing."}`,
+			401, TYPE_AUTHENTICATION, "", "Unauthorized. Access token is missing."},
+		{"plain text", 502, "text/plain", "upstream connect error\n",
+			502, TYPE_SERVER, "", "upstream connect error"},
+		{"html page", 503, "text/html", "<html>down</html>",
+			503, TYPE_SERVER, "", "Service Unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := FromUpstream(tt.status, tt.contentType, []byte(tt.body))
+			if err.Status != tt.wantStatus || err.Type != tt.wantType || err.Code != tt.wantCode || err.Message != tt.wantMessage {
+				t.Errorf("Got %+v", err)
+			}
+		})
+	}
+}
+
+func TestNormalizeResponse(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: http.StatusBadGateway,
+		Header:     http.Header{"Content-Type": {"text/plain"}},
+		Body:       io.NopCloser(strings.NewReader("bad gateway")),
+	}
+	if err := NormalizeResponse(resp); err != nil {
+		t.Fatalf("NormalizeResponse failed: %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	if int64(len(data)) != resp.ContentLength || resp.Header.Get("Content-Length") == "" {
+		t.Errorf("Content-Length not updated: %d vs %d", len(data), resp.ContentLength)
+	}
+	if resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("Expected application/json, got %q", resp.Header.Get("Content-Type"))
+	}
+	if detail := decode(t, data); detail["message"] != "bad gateway" || detail["type"] != TYPE_SERVER {
+		t.Errorf("Unexpected error body: %v", detail)
+	}
+
+	// 成功响应与流式响应不改写
+	for _, resp := range []*http.Response{
+		{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("ok"))},
+		{StatusCode: http.StatusBadRequest, Header: http.Header{"Content-Type": {"text/event-stream"}}, Body: io.NopCloser(strings.NewReader("data: x"))},
+	} {
+		_ = NormalizeResponse(resp)
+		data, _ := io.ReadAll(resp.Body)
+		if bytes.HasPrefix(data, []byte("{")) {
+			t.Errorf("Response with status %d should not be rewritten: %s", resp.StatusCode, data)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package apierror
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxUpstreamErrorBytes 归一化的上游错误响应体大小上限，超出时原样转发
+const maxUpstreamErrorBytes = 64 << 10
+
+// upstreamCode 已知的上游错误码映射
+type upstreamCode struct {
+	Status int
+	Type   string
+	Code   string
+}
+
+// knownCodes 上游（主要是 Azure）错误码到 OpenAI 错误的映射
+var knownCodes = map[string]upstreamCode{
+	"DeploymentNotFound":       {http.StatusNotFound, TYPE_INVALID_REQUEST, "model_not_found"},
+	"model_not_found":          {http.StatusNotFound, TYPE_INVALID_REQUEST, "model_not_found"},
+	"content_filter":           {http.StatusBadRequest, TYPE_INVALID_REQUEST, "content_filter"},
+	"content_policy_violation": {http.StatusBadRequest, TYPE_INVALID_REQUEST, "content_policy_violation"},
+	"context_length_exceeded":  {http.StatusBadRequest, TYPE_INVALID_REQUEST, "context_length_exceeded"},
+	"429":                      {http.StatusTooManyRequests, TYPE_RATE_LIMIT, "rate_limit_exceeded"},
+	"rate_limit_exceeded":      {http.StatusTooManyRequests, TYPE_RATE_LIMIT, "rate_limit_exceeded"},
+	"insufficient_quota":       {http.StatusTooManyRequests, "insufficient_quota", "insufficient_quota"},
+	"401":                      {http.StatusUnauthorized, TYPE_AUTHENTICATION, "invalid_api_key"},
+	"invalid_api_key":          {http.StatusUnauthorized, TYPE_AUTHENTICATION, "invalid_api_key"},
+	"Unauthorized":             {http.StatusUnauthorized, TYPE_AUTHENTICATION, "invalid_api_key"},
+	"PermissionDenied":         {http.StatusForbidden, TYPE_PERMISSION, "permission_denied"},
+	"OperationNotSupported":    {http.StatusBadRequest, TYPE_INVALID_REQUEST, "operation_not_supported"},
+}
+
+// upstreamBody 上游错误响应体，兼容 OpenAI 与 Azure 的格式
+type upstreamBody struct {
+	Error *struct {
+		Message string          `json:"message"`
+		Type    string          `json:"type"`
+		Param   json.RawMessage `json:"param"`
+		Code    json.RawMessage `json:"code"`
+	} `json:"error"`
+	// Message Azure 网关层错误（如 401、404）的格式为 {"statusCode":..., "message":...}
+	Message string `json:"message"`
+}
+
+// FromUpstream 解析上游的错误响应，无法识别的响应体以其文本作为错误信息
+//
+// 已知的上游错误码会映射到对应的状态码与错误类型，其余保留上游的状态码。
+func FromUpstream(status int, contentType string, data []byte) *Error {
+	err := &Error{Status: status}
+
+	var parsed upstreamBody
+	if json.Unmarshal(data, &parsed) == nil && (parsed.Error != nil || parsed.Message != "") {
+		if parsed.Error != nil {
+			err.Message = parsed.Error.Message
+			err.Type = parsed.Error.Type
+			err.Param = rawString(parsed.Error.Param)
+			err.Code = rawString(parsed.Error.Code)
+		} else {
+			err.Message = parsed.Message
+		}
+	} else if !strings.HasPrefix(contentType, "text/html") {
+		err.Message = strings.TrimSpace(string(data))
+	}
+	if err.Message == "" {
+		err.Message = http.StatusText(status)
+	}
+
+	if known, ok := knownCodes[err.Code]; ok {
+		err.Status = known.Status
+		err.Type = known.Type
+		err.Code = known.Code
+	}
+	if err.Type == "" {
+		err.Type = TypeForStatus(err.Status)
+	}
+	return err
+}
+
+// rawString 将字符串或数字形式的 JSON 值转为字符串，null 返回空字符串
+func rawString(raw json.RawMessage) string {
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return text
+	}
+	var number json.Number
+	if json.Unmarshal(raw, &number) == nil {
+		return number.String()
+	}
+	return ""
+}
+
+// NormalizeResponse 将上游的错误响应改写为 OpenAI 格式，成功响应及流式响应保持不变
+func NormalizeResponse(resp *http.Response) error {
+	if resp.StatusCode < http.StatusBadRequest || strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return nil
+	}
+	if resp.ContentLength > maxUpstreamErrorBytes {
+		return nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxUpstreamErrorBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxUpstreamErrorBytes {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), resp.Body), resp.Body}
+		return nil
+	}
+	_ = resp.Body.Close()
+
+	apiErr := FromUpstream(resp.StatusCode, resp.Header.Get("Content-Type"), data)
+	encoded, err := json.Marshal(apiErr)
+	if err != nil {
+		return err
+	}
+	encoded = append(encoded, '\n')
+
+	resp.StatusCode = apiErr.Status
+	resp.Status = strconv.Itoa(apiErr.Status) + " " + http.StatusText(apiErr.Status)
+	resp.Body = io.NopCloser(bytes.NewReader(encoded))
+	resp.ContentLength = int64(len(encoded))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(encoded)))
+	resp.Header.Set("Content-Type", "application/json")
+	resp.Header.Del("Content-Encoding")
+	return nil
+}
@@ -0,0 +1,224 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"openai-forward/logging"
+	"openai-forward/redact"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scope 审计范围
+type Scope string
+
+const (
+	// SCOPE_OFF 不记录审计日志
+	SCOPE_OFF Scope = "off"
+	// SCOPE_TEAMS 仅记录开启审计的团队的请求
+	SCOPE_TEAMS Scope = "teams"
+	// SCOPE_ALL 记录所有代理请求
+	SCOPE_ALL Scope = "all"
+)
+
+// queueSize 待写入审计记录的队列长度，队列满时丢弃记录以免阻塞请求
+const queueSize = 1024
+
+// Record 一次代理请求的审计记录
+type Record struct {
+	ID                string    `json:"id"`
+	RequestID         string    `json:"request_id"`
+	Time              time.Time `json:"time"`
+	Subject           string    `json:"subject,omitempty"`
+	Email             string    `json:"email,omitempty"`
+	TeamID            string    `json:"team_id,omitempty"`
+	KeyPrefix         string    `json:"key_prefix,omitempty"`
+	ClientIP          string    `json:"client_ip"`
+	Upstream          string    `json:"upstream"`
+	Method            string    `json:"method"`
+	Path              string    `json:"path"`
+	Model             string    `json:"model"`
+	Status            int       `json:"status"`
+	LatencyMs         int64     `json:"latency_ms"`
+	PromptTokens      int       `json:"prompt_tokens"`
+	CompletionTokens  int       `json:"completion_tokens"`
+	RequestBody       string    `json:"request_body"`
+	ResponseBody      string    `json:"response_body"`
+	Streamed          bool      `json:"streamed"`
+	RequestTruncated  bool      `json:"request_truncated,omitempty"`
+	ResponseTruncated bool      `json:"response_truncated,omitempty"`
+	// Redactions 各脱敏规则的命中次数
+	Redactions map[string]int `json:"redactions,omitempty"`
+}
+
+// Sink 审计记录的写入目标，可自行实现以接入外部系统
+type Sink interface {
+	Write(record *Record) error
+}
+
+// Purger 支持按保留期清理历史记录的 Sink
+type Purger interface {
+	Purge(before time.Time) (int64, error)
+}
+
+// Config 审计配置
+type Config struct {
+	Scope Scope `json:"scope"`
+	// Sinks 启用的写入目标：storage、file、stdout
+	Sinks []string `json:"sinks"`
+	// FileDir file 写入目标的目录
+	FileDir string `json:"file_dir"`
+	// MaxBodyBytes 请求体与响应体各自记录的最大字节数
+	MaxBodyBytes int `json:"max_body_bytes"`
+	// Retention 审计记录保留时长
+	Retention time.Duration `json:"retention"`
+	// RedactBuiltin 启用的内置脱敏规则，all 表示全部，none 表示不启用
+	RedactBuiltin []string `json:"redact_builtin"`
+	// RedactPatterns 自定义脱敏正则表达式
+	RedactPatterns []string `json:"redact_patterns"`
+}
+
+// LoadConfigFromEnv 从环境变量加载审计配置
+func LoadConfigFromEnv() (*Config, error) {
+	cfg := &Config{
+		Scope:         ParseScope(os.Getenv("AUDIT_SCOPE")),
+		Sinks:         []string{"storage"},
+		FileDir:       "./audit",
+		MaxBodyBytes:  1 << 20,
+		Retention:     30 * 24 * time.Hour,
+		RedactBuiltin: []string{"all"},
+	}
+
+	if sinks := os.Getenv("AUDIT_SINKS"); sinks != "" {
+		cfg.Sinks = strings.Split(sinks, ",")
+	}
+	if dir := os.Getenv("AUDIT_FILE_DIR"); dir != "" {
+		cfg.FileDir = dir
+	}
+	if maxBodyBytes, err := strconv.Atoi(os.Getenv("AUDIT_MAX_BODY_BYTES")); err == nil && maxBodyBytes > 0 {
+		cfg.MaxBodyBytes = maxBodyBytes
+	}
+	if retention, err := time.ParseDuration(os.Getenv("AUDIT_RETENTION")); err == nil && retention > 0 {
+		cfg.Retention = retention
+	}
+	if builtin := os.Getenv("AUDIT_REDACT_BUILTIN"); builtin != "" {
+		cfg.RedactBuiltin = strings.Split(builtin, ",")
+	}
+	if patterns := os.Getenv("AUDIT_REDACT_PATTERNS"); patterns != "" {
+		if err := json.Unmarshal([]byte(patterns), &cfg.RedactPatterns); err != nil {
+			return cfg, fmt.Errorf("invalid AUDIT_REDACT_PATTERNS: %w", err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// ParseScope 解析审计范围，无法识别时为 off
+func ParseScope(value string) Scope {
+	switch Scope(strings.ToLower(strings.TrimSpace(value))) {
+	case SCOPE_TEAMS:
+		return SCOPE_TEAMS
+	case SCOPE_ALL:
+		return SCOPE_ALL
+	default:
+		return SCOPE_OFF
+	}
+}
+
+// Auditor 对审计记录脱敏后异步写入各 Sink
+type Auditor struct {
+	config   *Config
+	redactor *redact.Redactor
+	sinks    []Sink
+	queue    chan *Record
+	wg       sync.WaitGroup
+}
+
+// NewAuditor 创建审计器并启动后台写入
+func NewAuditor(cfg *Config, sinks ...Sink) (*Auditor, error) {
+	redactor, err := redact.New(cfg.RedactBuiltin, cfg.RedactPatterns)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Auditor{
+		config:   cfg,
+		redactor: redactor,
+		sinks:    sinks,
+		queue:    make(chan *Record, queueSize),
+	}
+	a.wg.Add(1)
+	go a.run()
+	return a, nil
+}
+
+// Config 返回审计配置
+func (a *Auditor) Config() *Config {
+	return a.config
+}
+
+// Submit 脱敏并提交审计记录，队列已满时丢弃并记录错误日志
+func (a *Auditor) Submit(record *Record) {
+	var requestHits, responseHits map[string]int
+	record.RequestBody, requestHits = a.redactor.Redact(record.RequestBody)
+	record.ResponseBody, responseHits = a.redactor.Redact(record.ResponseBody)
+	for _, hits := range []map[string]int{requestHits, responseHits} {
+		for name, count := range hits {
+			if record.Redactions == nil {
+				record.Redactions = make(map[string]int)
+			}
+			record.Redactions[name] += count
+		}
+	}
+
+	select {
+	case a.queue <- record:
+	default:
+		logging.Logger.Errorf("Audit queue is full, dropping record %s", record.RequestID)
+	}
+}
+
+func (a *Auditor) run() {
+	defer a.wg.Done()
+	for record := range a.queue {
+		for _, sink := range a.sinks {
+			err := sink.Write(record)
+			if err != nil {
+				logging.Logger.Errorf("Failed to write audit record %s: %v", record.RequestID, err)
+			}
+		}
+	}
+}
+
+// Purge 清理超出保留期的审计记录
+func (a *Auditor) Purge() {
+	before := time.Now().Add(-a.config.Retention)
+	for _, sink := range a.sinks {
+		purger, ok := sink.(Purger)
+		if !ok {
+			continue
+		}
+		n, err := purger.Purge(before)
+		if err != nil {
+			logging.Logger.Errorf("Failed to purge audit records: %v", err)
+			continue
+		}
+		if n > 0 {
+			logging.Logger.Infof("Purged %d audit records before %s", n, before.Format(time.RFC3339))
+		}
+	}
+}
+
+// Close 等待队列中的记录写入完毕
+func (a *Auditor) Close() {
+	close(a.queue)
+	a.wg.Wait()
+}
+
+// String 返回审计配置的简要描述
+func (c *Config) String() string {
+	return fmt.Sprintf("scope=%s sinks=%s retention=%s", c.Scope, strings.Join(c.Sinks, ","), c.Retention)
+}
@@ -0,0 +1,131 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCappedBuffer(t *testing.T) {
+	b := NewCappedBuffer(5)
+	n, _ := b.Write([]byte("hello world"))
+	if n != 11 {
+		t.Errorf("Expected write to report full length, got %d", n)
+	}
+	if b.String() != "hello" || !b.Truncated() {
+		t.Errorf("Unexpected buffer '%s' (truncated=%v)", b.String(), b.Truncated())
+	}
+}
+
+func TestResponseCapture_Stream(t *testing.T) {
+	header := http.Header{}
+	header.Set("Content-Type", "text/event-stream")
+	capture := NewResponseCapture(header, 1024)
+
+	_, _ = capture.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\ndata: {\"choi"))
+	_, _ = capture.Write([]byte("ces\":[{\"delta\":{\"content\":\"lo\"}}]}\n\ndata: [DONE]\n\n"))
+
+	body, streamed, truncated := capture.Body()
+	if body != "Hello" || !streamed || truncated {
+		t.Errorf("Unexpected capture '%s' (streamed=%v, truncated=%v)", body, streamed, truncated)
+	}
+}
+
+func TestResponseCapture_Responses(t *testing.T) {
+	header := http.Header{}
+	header.Set("Content-Type", "text/event-stream; charset=utf-8")
+	capture := NewResponseCapture(header, 1024)
+
+	_, _ = capture.Write([]byte("event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"delta\":\"Hi\"}\n\n"))
+
+	body, _, _ := capture.Body()
+	if body != "Hi" {
+		t.Errorf("Expected 'Hi', got '%s'", body)
+	}
+}
+
+func TestResponseCapture_Binary(t *testing.T) {
+	header := http.Header{}
+	header.Set("Content-Type", "audio/mpeg")
+	capture := NewResponseCapture(header, 1024)
+
+	_, _ = capture.Write([]byte{0xff, 0xfb})
+	body, _, _ := capture.Body()
+	if !strings.Contains(body, "omitted") {
+		t.Errorf("Expected binary response to be omitted, got '%s'", body)
+	}
+}
+
+func TestAuditor_SubmitRedacts(t *testing.T) {
+	var out bytes.Buffer
+	auditor, err := NewAuditor(&Config{Scope: SCOPE_ALL, RedactBuiltin: []string{"email"}}, NewWriterSink(&out))
+	if err != nil {
+		t.Fatalf("Failed to create auditor: %v", err)
+	}
+
+	auditor.Submit(&Record{ID: "1", RequestBody: `{"content":"mail me at alice@example.com"}`})
+	auditor.Close()
+
+	var record Record
+	err = json.Unmarshal(out.Bytes(), &record)
+	if err != nil {
+		t.Fatalf("Failed to decode record: %v", err)
+	}
+	if strings.Contains(record.RequestBody, "alice@example.com") {
+		t.Errorf("Expected email to be redacted, got '%s'", record.RequestBody)
+	}
+	if record.Redactions["email"] != 1 {
+		t.Errorf("Unexpected redactions: %v", record.Redactions)
+	}
+}
+
+func TestFileSink_Purge(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewFileSink(dir)
+	if err != nil {
+		t.Fatalf("Failed to create file sink: %v", err)
+	}
+	defer sink.Close()
+
+	old := time.Now().Add(-48 * time.Hour)
+	err = os.WriteFile(filepath.Join(dir, "audit-"+old.UTC().Format(fileDateLayout)+".jsonl"), []byte("{}\n"), 0o640)
+	if err != nil {
+		t.Fatalf("Failed to write old file: %v", err)
+	}
+	err = sink.Write(&Record{ID: "1", Time: time.Now()})
+	if err != nil {
+		t.Fatalf("Failed to write record: %v", err)
+	}
+
+	n, err := sink.Purge(time.Now().Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to purge: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 file to be purged, got %d", n)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Expected current file to be kept, got %d files", len(entries))
+	}
+}
+
+func TestLoadConfigFromEnv_InvalidPatterns(t *testing.T) {
+	t.Setenv("AUDIT_SCOPE", "all")
+	t.Setenv("AUDIT_REDACT_PATTERNS", `["EMP-\d+"`)
+	if _, err := LoadConfigFromEnv(); err == nil {
+		t.Error("Expected invalid AUDIT_REDACT_PATTERNS to be rejected")
+	}
+
+	t.Setenv("AUDIT_REDACT_PATTERNS", `["EMP-\\d+"]`)
+	cfg, err := LoadConfigFromEnv()
+	if err != nil || cfg.Scope != SCOPE_ALL || len(cfg.RedactPatterns) != 1 {
+		t.Errorf("Unexpected config %+v: %v", cfg, err)
+	}
+}
@@ -0,0 +1,194 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// CappedBuffer 最多保留 limit 字节的缓冲区，超出部分丢弃并标记为截断
+type CappedBuffer struct {
+	limit     int
+	buf       bytes.Buffer
+	truncated bool
+}
+
+// NewCappedBuffer 创建容量上限为 limit 字节的缓冲区
+func NewCappedBuffer(limit int) *CappedBuffer {
+	return &CappedBuffer{limit: limit}
+}
+
+// Write 实现 io.Writer，始终返回 len(p) 以免影响调用方
+func (b *CappedBuffer) Write(p []byte) (int, error) {
+	remaining := b.limit - b.buf.Len()
+	if remaining <= 0 {
+		if len(p) > 0 {
+			b.truncated = true
+		}
+		return len(p), nil
+	}
+	if len(p) > remaining {
+		b.buf.Write(p[:remaining])
+		b.truncated = true
+		return len(p), nil
+	}
+	b.buf.Write(p)
+	return len(p), nil
+}
+
+// WriteString 写入字符串
+func (b *CappedBuffer) WriteString(s string) {
+	_, _ = b.Write([]byte(s))
+}
+
+// String 已保留的内容
+func (b *CappedBuffer) String() string {
+	return b.buf.String()
+}
+
+// Truncated 是否发生截断
+func (b *CappedBuffer) Truncated() bool {
+	return b.truncated
+}
+
+// IsTextual 判断内容类型是否为可记录的文本（JSON、文本、表单）
+func IsTextual(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	return contentType == "" ||
+		strings.HasPrefix(contentType, "application/json") ||
+		strings.HasPrefix(contentType, "text/") ||
+		strings.HasPrefix(contentType, "application/x-www-form-urlencoded")
+}
+
+// TeeBody 在请求体被读取的同时将其写入 capture
+func TeeBody(body io.ReadCloser, capture io.Writer) io.ReadCloser {
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(body, capture), body}
+}
+
+// ResponseCapture 捕获响应体，SSE 流式响应会被重组为完整的文本内容
+type ResponseCapture struct {
+	header    http.Header
+	body      *CappedBuffer
+	decided   bool
+	streamed  bool
+	omitted   bool
+	assembler *StreamAssembler
+}
+
+// NewResponseCapture 创建响应捕获，header 为响应头，在首次写入时据此判断响应类型
+func NewResponseCapture(header http.Header, limit int) *ResponseCapture {
+	return &ResponseCapture{header: header, body: NewCappedBuffer(limit)}
+}
+
+// Write 实现 io.Writer
+func (c *ResponseCapture) Write(p []byte) (int, error) {
+	if !c.decided {
+		c.decided = true
+		contentType := c.header.Get("Content-Type")
+		switch {
+		case strings.HasPrefix(contentType, "text/event-stream"):
+			c.streamed = true
+			c.assembler = NewStreamAssembler(c.body)
+		case !IsTextual(contentType):
+			c.omitted = true
+		}
+	}
+
+	switch {
+	case c.omitted:
+		return len(p), nil
+	case c.assembler != nil:
+		return c.assembler.Write(p)
+	default:
+		return c.body.Write(p)
+	}
+}
+
+// Body 返回捕获的响应内容，以及是否为流式响应、是否被截断
+func (c *ResponseCapture) Body() (string, bool, bool) {
+	if c.omitted {
+		return "[binary response omitted: " + c.header.Get("Content-Type") + "]", false, false
+	}
+	if c.assembler != nil {
+		c.assembler.Flush()
+	}
+	return c.body.String(), c.streamed, c.body.Truncated()
+}
+
+// StreamAssembler 解析 SSE 分片，将 Chat Completions、Completions 及 Responses 接口的增量文本拼接为完整内容
+type StreamAssembler struct {
+	out     io.Writer
+	pending []byte
+}
+
+// NewStreamAssembler 创建流式响应重组器，重组后的文本写入 out
+func NewStreamAssembler(out io.Writer) *StreamAssembler {
+	return &StreamAssembler{out: out}
+}
+
+// streamChunk SSE 分片中与文本内容相关的字段
+type streamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+		Text string `json:"text"`
+	} `json:"choices"`
+	Type  string          `json:"type"`
+	Delta json.RawMessage `json:"delta"`
+}
+
+// Write 实现 io.Writer，按行解析 data: 分片
+func (a *StreamAssembler) Write(p []byte) (int, error) {
+	a.pending = append(a.pending, p...)
+	for {
+		index := bytes.IndexByte(a.pending, '\n')
+		if index < 0 {
+			break
+		}
+		a.handleLine(a.pending[:index])
+		a.pending = a.pending[index+1:]
+	}
+	return len(p), nil
+}
+
+// Flush 处理末尾未以换行结束的分片
+func (a *StreamAssembler) Flush() {
+	if len(a.pending) > 0 {
+		a.handleLine(a.pending)
+		a.pending = nil
+	}
+}
+
+func (a *StreamAssembler) handleLine(line []byte) {
+	line = bytes.TrimSpace(line)
+	if !bytes.HasPrefix(line, []byte("data:")) {
+		return
+	}
+	data := bytes.TrimSpace(line[len("data:"):])
+	if len(data) == 0 || bytes.Equal(data, []byte("[DONE]")) {
+		return
+	}
+
+	var chunk streamChunk
+	err := json.Unmarshal(data, &chunk)
+	if err != nil {
+		return
+	}
+
+	for _, choice := range chunk.Choices {
+		_, _ = io.WriteString(a.out, choice.Delta.Content)
+		_, _ = io.WriteString(a.out, choice.Text)
+	}
+	if chunk.Type == "response.output_text.delta" {
+		var delta string
+		if json.Unmarshal(chunk.Delta, &delta) == nil {
+			_, _ = io.WriteString(a.out, delta)
+		}
+	}
+}
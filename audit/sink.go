@@ -0,0 +1,123 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WriterSink 将审计记录以 JSON Lines 格式写入任意 io.Writer
+type WriterSink struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewWriterSink 创建写入 w 的 Sink
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{encoder: json.NewEncoder(w)}
+}
+
+// Write 实现 Sink
+func (s *WriterSink) Write(record *Record) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.encoder.Encode(record)
+}
+
+// fileDateLayout 审计文件名中的日期格式
+const fileDateLayout = "2006-01-02"
+
+// FileSink 将审计记录按天写入本地 JSON Lines 文件（audit-YYYY-MM-DD.jsonl）
+type FileSink struct {
+	dir   string
+	mutex sync.Mutex
+	date  string
+	file  *os.File
+}
+
+// NewFileSink 创建写入 dir 目录的 Sink
+func NewFileSink(dir string) (*FileSink, error) {
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{dir: dir}, nil
+}
+
+// Write 实现 Sink
+func (s *FileSink) Write(record *Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	date := record.Time.UTC().Format(fileDateLayout)
+	if s.file == nil || s.date != date {
+		if s.file != nil {
+			_ = s.file.Close()
+		}
+		s.file, err = os.OpenFile(s.path(date), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+		if err != nil {
+			s.file = nil
+			return err
+		}
+		s.date = date
+	}
+
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+// Purge 删除日期早于 before 的审计文件，返回删除的文件数
+func (s *FileSink) Purge(before time.Time) (int64, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	cutoff := before.UTC().Format(fileDateLayout)
+	var n int64
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, "audit-") || !strings.HasSuffix(name, ".jsonl") {
+			continue
+		}
+		date := strings.TrimSuffix(strings.TrimPrefix(name, "audit-"), ".jsonl")
+		if date >= cutoff || date == s.date {
+			continue
+		}
+		err = os.Remove(filepath.Join(s.dir, name))
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Close 关闭当前文件
+func (s *FileSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (s *FileSink) path(date string) string {
+	return filepath.Join(s.dir, fmt.Sprintf("audit-%s.jsonl", date))
+}
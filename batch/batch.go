@@ -0,0 +1,164 @@
+package batch
+
+import (
+	"fmt"
+	"openai-forward/catalog"
+	"os"
+	"strconv"
+	"time"
+)
+
+// 批处理状态，与 OpenAI Batch API 一致
+const (
+	STATUS_VALIDATING  = "validating"
+	STATUS_FAILED      = "failed"
+	STATUS_IN_PROGRESS = "in_progress"
+	STATUS_FINALIZING  = "finalizing"
+	STATUS_COMPLETED   = "completed"
+	STATUS_EXPIRED     = "expired"
+	STATUS_CANCELLING  = "cancelling"
+	STATUS_CANCELLED   = "cancelled"
+)
+
+// PURPOSE_BATCH 批处理输入文件上传时的 purpose
+const PURPOSE_BATCH = "batch"
+
+// Terminal 批处理是否已结束，结束后状态不再变化
+func Terminal(status string) bool {
+	switch status {
+	case STATUS_FAILED, STATUS_COMPLETED, STATUS_EXPIRED, STATUS_CANCELLED:
+		return true
+	default:
+		return false
+	}
+}
+
+// Config 批处理跟踪配置
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// PollInterval 轮询未结束批处理状态的间隔
+	PollInterval time.Duration `json:"poll_interval"`
+	// PriceFactor 批处理价格相对于实时接口的比例，OpenAI 批处理为半价
+	PriceFactor float64 `json:"price_factor"`
+	// MaxInputBytes 上传的输入文件的大小上限，超出时无法校验，拒绝上传
+	MaxInputBytes int `json:"max_input_bytes"`
+}
+
+// LoadConfigFromEnv 从环境变量加载批处理跟踪配置
+func LoadConfigFromEnv() *Config {
+	cfg := &Config{
+		Enabled:       os.Getenv("BATCH_TRACKING") != "off",
+		PollInterval:  5 * time.Minute,
+		PriceFactor:   0.5,
+		MaxInputBytes: 200 << 20,
+	}
+	if interval, err := time.ParseDuration(os.Getenv("BATCH_POLL_INTERVAL")); err == nil && interval > 0 {
+		cfg.PollInterval = interval
+	}
+	if factor, err := strconv.ParseFloat(os.Getenv("BATCH_PRICE_FACTOR"), 64); err == nil && factor > 0 {
+		cfg.PriceFactor = factor
+	}
+	if maxBytes, err := strconv.Atoi(os.Getenv("BATCH_MAX_INPUT_BYTES")); err == nil && maxBytes > 0 {
+		cfg.MaxInputBytes = maxBytes
+	}
+	return cfg
+}
+
+// String 返回批处理跟踪配置的简要描述
+func (c *Config) String() string {
+	return fmt.Sprintf("poll_interval=%s price_factor=%g max_input_bytes=%d", c.PollInterval, c.PriceFactor, c.MaxInputBytes)
+}
+
+// Cost 按每百万 token 价格及批处理价格比例计算费用（美元），价格未知时为 0
+func (c *Config) Cost(pricing *catalog.Pricing, promptTokens int, completionTokens int) float64 {
+	if pricing == nil {
+		return 0
+	}
+	return (float64(promptTokens)*pricing.Input + float64(completionTokens)*pricing.Output) / 1e6 * c.PriceFactor
+}
+
+// Object 上游返回的批处理对象中网关关心的字段
+type Object struct {
+	ID            string `json:"id"`
+	Endpoint      string `json:"endpoint"`
+	InputFileID   string `json:"input_file_id"`
+	OutputFileID  string `json:"output_file_id"`
+	ErrorFileID   string `json:"error_file_id"`
+	Status        string `json:"status"`
+	CompletedAt   int64  `json:"completed_at"`
+	RequestCounts struct {
+		Total     int `json:"total"`
+		Completed int `json:"completed"`
+		Failed    int `json:"failed"`
+	} `json:"request_counts"`
+}
+
+// Job 经网关提交的批处理任务
+type Job struct {
+	ID       string `json:"id"`
+	Upstream string `json:"upstream"`
+	Subject  string `json:"subject,omitempty"`
+	Email    string `json:"email,omitempty"`
+	TeamID   string `json:"team_id,omitempty"`
+	Endpoint string `json:"endpoint"`
+	Status   string `json:"status"`
+	// UpstreamKey 使用客户端自带的上游密钥提交，网关无法轮询状态及读取输出文件
+	UpstreamKey  bool   `json:"upstream_key"`
+	InputFileID  string `json:"input_file_id"`
+	OutputFileID string `json:"output_file_id,omitempty"`
+	ErrorFileID  string `json:"error_file_id,omitempty"`
+	// Requests 各状态的请求数
+	Requests          int `json:"requests"`
+	CompletedRequests int `json:"completed_requests"`
+	FailedRequests    int `json:"failed_requests"`
+	// Models 输入文件中各模型的请求数，输入文件未经网关校验时为空
+	Models map[string]int `json:"models,omitempty"`
+	// EstimatedCost 提交时按输入文件估算的费用（美元）
+	EstimatedCost float64 `json:"estimated_cost"`
+	// PromptTokens / CompletionTokens / Cost 结束后按输出文件统计的用量及费用
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Cost             float64 `json:"cost"`
+	// Accounted 输出文件的用量是否已统计
+	Accounted   bool       `json:"accounted"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// Apply 以上游返回的批处理对象更新任务状态
+func (j *Job) Apply(object *Object) {
+	if object.Status != "" {
+		j.Status = object.Status
+	}
+	if object.Endpoint != "" {
+		j.Endpoint = object.Endpoint
+	}
+	if object.InputFileID != "" {
+		j.InputFileID = object.InputFileID
+	}
+	j.OutputFileID = object.OutputFileID
+	j.ErrorFileID = object.ErrorFileID
+	if object.RequestCounts.Total > 0 {
+		j.Requests = object.RequestCounts.Total
+	}
+	j.CompletedRequests = object.RequestCounts.Completed
+	j.FailedRequests = object.RequestCounts.Failed
+	if object.CompletedAt > 0 {
+		completedAt := time.Unix(object.CompletedAt, 0)
+		j.CompletedAt = &completedAt
+	}
+	j.UpdatedAt = time.Now()
+}
+
+// Store 批处理存储，Get 不存在时返回 nil, nil
+type Store interface {
+	SaveBatchInput(fileID string, summary *InputSummary) error
+	GetBatchInput(fileID string) (*InputSummary, error)
+	SaveBatchJob(job *Job) error
+	GetBatchJob(id string) (*Job, error)
+	// ListPendingBatchJobs 列出网关可轮询（非客户端自带密钥）且用量尚未统计的任务
+	ListPendingBatchJobs() ([]*Job, error)
+	// ClaimBatchAccounting 标记任务用量已统计，已被其他实例标记时返回 false
+	ClaimBatchAccounting(id string) (bool, error)
+}
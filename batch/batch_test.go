@@ -0,0 +1,153 @@
+package batch
+
+import (
+	"errors"
+	"math"
+	"openai-forward/catalog"
+	"strings"
+	"testing"
+)
+
+func TestParseInput(t *testing.T) {
+	input := `{"custom_id":"1","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o-mini","messages":[{"role":"user","content":"hi"}],"max_tokens":100}}
+{"custom_id":"2","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o-mini","max_completion_tokens":50}}
+
+{"custom_id":"3","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o"}}
+`
+	summary, err := ParseInput(strings.NewReader(input), nil)
+	if err != nil {
+		t.Fatalf("Failed to parse input: %v", err)
+	}
+	if summary.Requests != 3 || summary.Endpoint != "/v1/chat/completions" {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+	mini := summary.Models["gpt-4o-mini"]
+	if mini == nil || mini.Requests != 2 || mini.MaxOutputTokens != 150 || mini.PromptTokens == 0 {
+		t.Errorf("Unexpected gpt-4o-mini estimate: %+v", mini)
+	}
+	if counts := summary.ModelCounts(); counts["gpt-4o"] != 1 {
+		t.Errorf("Unexpected model counts: %v", counts)
+	}
+}
+
+func TestParseInput_Invalid(t *testing.T) {
+	allowed := func(model string) bool { return model == "gpt-4o-mini" }
+	line := func(id string, method string, url string, model string) string {
+		return `{"custom_id":"` + id + `","method":"` + method + `","url":"` + url + `","body":{"model":"` + model + `"}}`
+	}
+	valid := line("1", "POST", "/v1/chat/completions", "gpt-4o-mini")
+
+	tests := []struct {
+		name  string
+		input string
+		line  int
+	}{
+		{"invalid json", valid + "\n{", 2},
+		{"missing custom_id", line("", "POST", "/v1/chat/completions", "gpt-4o-mini"), 1},
+		{"duplicate custom_id", valid + "\n" + valid, 2},
+		{"method", line("1", "GET", "/v1/chat/completions", "gpt-4o-mini"), 1},
+		{"unsupported url", line("1", "POST", "/v1/images/generations", "gpt-4o-mini"), 1},
+		{"mixed url", valid + "\n" + line("2", "POST", "/v1/embeddings", "gpt-4o-mini"), 2},
+		{"missing model", line("1", "POST", "/v1/chat/completions", ""), 1},
+		{"stream", `{"custom_id":"1","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o-mini","stream":true}}`, 1},
+		{"model not allowed", valid + "\n" + line("2", "POST", "/v1/chat/completions", "gpt-4o"), 2},
+		{"empty", "\n\n", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseInput(strings.NewReader(tt.input), allowed)
+			var inputErr *InputError
+			if !errors.As(err, &inputErr) {
+				t.Fatalf("Expected InputError, got %v", err)
+			}
+			if inputErr.Line != tt.line {
+				t.Errorf("Expected error on line %d, got %v", tt.line, err)
+			}
+		})
+	}
+}
+
+func TestParseRequests(t *testing.T) {
+	input := `{"custom_id":"a","method":"POST","url":"/v1/embeddings","body":{"model":"text-embedding-3-small","input":"a"}}
+{"custom_id":"b","method":"POST","url":"/v1/embeddings","body":{"model":"text-embedding-3-large","input":"b"}}`
+	requests, summary, err := ParseRequests(strings.NewReader(input), nil)
+	if err != nil {
+		t.Fatalf("Failed to parse requests: %v", err)
+	}
+	if len(requests) != 2 || summary.Requests != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(requests))
+	}
+	if requests[1].CustomID != "b" || requests[1].Model != "text-embedding-3-large" || !strings.Contains(string(requests[1].Body), `"input":"b"`) {
+		t.Errorf("Unexpected request: %+v", requests[1])
+	}
+}
+
+func TestInputSummary_EstimateCost(t *testing.T) {
+	cfg := &Config{PriceFactor: 0.5}
+	summary := &InputSummary{Models: map[string]*ModelEstimate{
+		"gpt-4o":  {Requests: 1, PromptTokens: 1_000_000, MaxOutputTokens: 1_000_000},
+		"unknown": {Requests: 1, PromptTokens: 1_000_000},
+	}}
+	pricing := func(model string) *catalog.Pricing {
+		if model == "gpt-4o" {
+			return &catalog.Pricing{Input: 2.5, Output: 10}
+		}
+		return nil
+	}
+	if cost := summary.EstimateCost(cfg, pricing); math.Abs(cost-6.25) > 1e-9 {
+		t.Errorf("Expected cost 6.25, got %f", cost)
+	}
+}
+
+func TestParseOutput(t *testing.T) {
+	output := `{"id":"r1","custom_id":"1","response":{"status_code":200,"body":{"model":"gpt-4o-mini-2024-07-18","usage":{"prompt_tokens":10,"completion_tokens":5}}}}
+{"id":"r2","custom_id":"2","response":{"status_code":200,"body":{"model":"gpt-4o-mini-2024-07-18","usage":{"prompt_tokens":20,"completion_tokens":7}}}}
+{"id":"r3","custom_id":"3","response":{"status_code":400,"body":{"error":{"message":"bad"}}}}
+{"id":"r4","custom_id":"4","response":null,"error":{"code":"batch_expired"}}
+`
+	summary, err := ParseOutput(strings.NewReader(output))
+	if err != nil {
+		t.Fatalf("Failed to parse output: %v", err)
+	}
+	if summary.Succeeded != 2 || summary.Failed != 2 {
+		t.Errorf("Unexpected counts: %+v", summary)
+	}
+	prompt, completion := summary.Totals()
+	if prompt != 30 || completion != 12 {
+		t.Errorf("Expected 30/12 tokens, got %d/%d", prompt, completion)
+	}
+}
+
+func TestJob_Apply(t *testing.T) {
+	job := &Job{ID: "batch_1", Status: STATUS_VALIDATING, Requests: 3}
+	object := &Object{Status: STATUS_COMPLETED, OutputFileID: "file-out", CompletedAt: 1700000000}
+	object.RequestCounts.Total = 3
+	object.RequestCounts.Completed = 2
+	object.RequestCounts.Failed = 1
+
+	job.Apply(object)
+	if !Terminal(job.Status) || job.OutputFileID != "file-out" || job.CompletedRequests != 2 || job.FailedRequests != 1 {
+		t.Errorf("Unexpected job: %+v", job)
+	}
+	if job.CompletedAt == nil || job.CompletedAt.Unix() != 1700000000 {
+		t.Errorf("Unexpected completed_at: %v", job.CompletedAt)
+	}
+	if Terminal(STATUS_IN_PROGRESS) {
+		t.Error("Expected in_progress not to be terminal")
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	t.Setenv("BATCH_TRACKING", "")
+	t.Setenv("BATCH_POLL_INTERVAL", "1m")
+	t.Setenv("BATCH_PRICE_FACTOR", "invalid")
+	cfg := LoadConfigFromEnv()
+	if !cfg.Enabled || cfg.PollInterval.Minutes() != 1 || cfg.PriceFactor != 0.5 {
+		t.Errorf("Unexpected config: %+v", cfg)
+	}
+
+	t.Setenv("BATCH_TRACKING", "off")
+	if LoadConfigFromEnv().Enabled {
+		t.Error("Expected batch tracking to be disabled")
+	}
+}
@@ -0,0 +1,186 @@
+package batch
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"openai-forward/catalog"
+	"sort"
+	"strings"
+)
+
+// maxLineBytes 输入文件单行的大小上限
+const maxLineBytes = 16 << 20
+
+// bytesPerToken 估算 token 数时每个 token 对应的字节数
+const bytesPerToken = 4
+
+// supportedEndpoints 批处理支持的接口
+var supportedEndpoints = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/embeddings":       true,
+	"/v1/responses":        true,
+}
+
+// InputError 输入文件中的错误，Line 从 1 开始
+type InputError struct {
+	Line    int
+	Message string
+}
+
+func (e *InputError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// ModelEstimate 输入文件中某个模型的请求数及估算的 token 数
+type ModelEstimate struct {
+	Requests int `json:"requests"`
+	// PromptTokens 按请求体大小估算的输入 token 数
+	PromptTokens int `json:"prompt_tokens"`
+	// MaxOutputTokens 请求中声明的输出 token 上限之和，未声明时不计入
+	MaxOutputTokens int `json:"max_output_tokens"`
+}
+
+// InputSummary 批处理输入文件的摘要
+type InputSummary struct {
+	Requests int                       `json:"requests"`
+	Endpoint string                    `json:"endpoint"`
+	Models   map[string]*ModelEstimate `json:"models"`
+}
+
+// ModelCounts 各模型的请求数
+func (s *InputSummary) ModelCounts() map[string]int {
+	counts := make(map[string]int, len(s.Models))
+	for model, estimate := range s.Models {
+		counts[model] = estimate.Requests
+	}
+	return counts
+}
+
+// EstimateCost 估算费用（美元），输出 token 按请求声明的上限计算，价格未知的模型不计入
+func (s *InputSummary) EstimateCost(cfg *Config, pricing func(model string) *catalog.Pricing) float64 {
+	models := make([]string, 0, len(s.Models))
+	for model := range s.Models {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+
+	cost := 0.0
+	for _, model := range models {
+		estimate := s.Models[model]
+		cost += cfg.Cost(pricing(model), estimate.PromptTokens, estimate.MaxOutputTokens)
+	}
+	return cost
+}
+
+// Request 输入文件中的一个请求
+type Request struct {
+	CustomID string          `json:"custom_id"`
+	Method   string          `json:"method"`
+	URL      string          `json:"url"`
+	Body     json.RawMessage `json:"body"`
+	// Model 请求体中的模型，不在输入文件中
+	Model string `json:"-"`
+}
+
+// inputBody 请求体中用于校验及估算的字段
+type inputBody struct {
+	Model               string `json:"model"`
+	Stream              bool   `json:"stream"`
+	MaxTokens           int    `json:"max_tokens"`
+	MaxCompletionTokens int    `json:"max_completion_tokens"`
+	MaxOutputTokens     int    `json:"max_output_tokens"`
+}
+
+// ParseInput 校验批处理输入文件（JSONL）并生成摘要，allowed 为 nil 时不校验模型
+//
+// 每行须包含唯一的 custom_id、method 为 POST、url 为支持的接口且所有行一致，body 中须指定模型且不能为流式请求。
+func ParseInput(r io.Reader, allowed func(model string) bool) (*InputSummary, error) {
+	return parseInput(r, allowed, nil)
+}
+
+// ParseRequests 校验输入文件并返回其中的请求及摘要，校验规则与 ParseInput 一致
+func ParseRequests(r io.Reader, allowed func(model string) bool) ([]*Request, *InputSummary, error) {
+	requests := []*Request{}
+	summary, err := parseInput(r, allowed, func(request *Request) {
+		requests = append(requests, request)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return requests, summary, nil
+}
+
+// parseInput 逐行校验输入文件，visit 不为 nil 时以每个请求调用
+func parseInput(r io.Reader, allowed func(model string) bool, visit func(request *Request)) (*InputSummary, error) {
+	summary := &InputSummary{Models: map[string]*ModelEstimate{}}
+	customIDs := map[string]bool{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxLineBytes)
+	number := 0
+	for scanner.Scan() {
+		number++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var line Request
+		if json.Unmarshal([]byte(text), &line) != nil {
+			return nil, &InputError{Line: number, Message: "invalid JSON"}
+		}
+		if line.CustomID == "" {
+			return nil, &InputError{Line: number, Message: "missing custom_id"}
+		}
+		if customIDs[line.CustomID] {
+			return nil, &InputError{Line: number, Message: "duplicate custom_id '" + line.CustomID + "'"}
+		}
+		customIDs[line.CustomID] = true
+		if line.Method != "POST" {
+			return nil, &InputError{Line: number, Message: "method must be POST"}
+		}
+		if !supportedEndpoints[line.URL] {
+			return nil, &InputError{Line: number, Message: "unsupported url '" + line.URL + "'"}
+		}
+		if summary.Endpoint == "" {
+			summary.Endpoint = line.URL
+		} else if line.URL != summary.Endpoint {
+			return nil, &InputError{Line: number, Message: "all requests must use the same url"}
+		}
+
+		var body inputBody
+		if json.Unmarshal(line.Body, &body) != nil || body.Model == "" {
+			return nil, &InputError{Line: number, Message: "missing body.model"}
+		}
+		if body.Stream {
+			return nil, &InputError{Line: number, Message: "streaming is not supported"}
+		}
+		if allowed != nil && !allowed(body.Model) {
+			return nil, &InputError{Line: number, Message: "model '" + body.Model + "' is not allowed"}
+		}
+
+		estimate := summary.Models[body.Model]
+		if estimate == nil {
+			estimate = &ModelEstimate{}
+			summary.Models[body.Model] = estimate
+		}
+		estimate.Requests++
+		estimate.PromptTokens += len(line.Body) / bytesPerToken
+		estimate.MaxOutputTokens += max(body.MaxTokens, body.MaxCompletionTokens, body.MaxOutputTokens)
+		summary.Requests++
+		if visit != nil {
+			line.Model = body.Model
+			visit(&line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &InputError{Line: number + 1, Message: err.Error()}
+	}
+	if summary.Requests == 0 {
+		return nil, &InputError{Line: 1, Message: "file contains no requests"}
+	}
+	return summary, nil
+}
@@ -0,0 +1,77 @@
+package batch
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"openai-forward/proxy"
+	"strings"
+)
+
+// ModelUsage 输出文件中某个模型的用量
+type ModelUsage struct {
+	Requests         int `json:"requests"`
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// OutputSummary 批处理输出文件的用量汇总
+type OutputSummary struct {
+	Succeeded int                    `json:"succeeded"`
+	Failed    int                    `json:"failed"`
+	Models    map[string]*ModelUsage `json:"models"`
+}
+
+// outputLine 输出文件中的一行
+type outputLine struct {
+	Response *struct {
+		StatusCode int `json:"status_code"`
+		Body       struct {
+			Model string       `json:"model"`
+			Usage *proxy.Usage `json:"usage"`
+		} `json:"body"`
+	} `json:"response"`
+}
+
+// ParseOutput 汇总批处理输出文件（JSONL）中各模型的用量，无法解析的行计为失败
+func ParseOutput(r io.Reader) (*OutputSummary, error) {
+	summary := &OutputSummary{Models: map[string]*ModelUsage{}}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxLineBytes)
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var line outputLine
+		if json.Unmarshal([]byte(text), &line) != nil || line.Response == nil || line.Response.StatusCode != 200 {
+			summary.Failed++
+			continue
+		}
+		summary.Succeeded++
+
+		body := line.Response.Body
+		usage := summary.Models[body.Model]
+		if usage == nil {
+			usage = &ModelUsage{}
+			summary.Models[body.Model] = usage
+		}
+		usage.Requests++
+		if body.Usage != nil {
+			usage.PromptTokens += body.Usage.Prompt()
+			usage.CompletionTokens += body.Usage.Completion()
+		}
+	}
+	return summary, scanner.Err()
+}
+
+// Totals 所有模型的输入与输出 token 数之和
+func (s *OutputSummary) Totals() (promptTokens int, completionTokens int) {
+	for _, usage := range s.Models {
+		promptTokens += usage.PromptTokens
+		completionTokens += usage.CompletionTokens
+	}
+	return promptTokens, completionTokens
+}
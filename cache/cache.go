@@ -0,0 +1,210 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"openai-forward/logging"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Backend 缓存后端
+type Backend string
+
+const (
+	// BACKEND_OFF 不启用缓存
+	BACKEND_OFF Backend = "off"
+	// BACKEND_MEMORY 进程内存缓存，按大小淘汰
+	BACKEND_MEMORY Backend = "memory"
+	// BACKEND_STORAGE 使用数据库存储，多实例共享
+	BACKEND_STORAGE Backend = "storage"
+)
+
+// Entry 缓存的响应
+type Entry struct {
+	Status      int       `json:"status"`
+	ContentType string    `json:"content_type"`
+	Body        []byte    `json:"body"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// Expired 缓存是否已过期
+func (e *Entry) Expired(now time.Time) bool {
+	return !now.Before(e.ExpiresAt)
+}
+
+// Store 缓存存储，Get 未命中时返回 nil, nil
+type Store interface {
+	Get(key string) (*Entry, error)
+	Set(key string, entry *Entry) error
+}
+
+// Config 响应缓存配置
+type Config struct {
+	Backend Backend `json:"backend"`
+	// MaxBytes 内存缓存的总大小上限
+	MaxBytes int64 `json:"max_bytes"`
+	// MaxEntryBytes 单个请求体或响应体的大小上限，超出时不缓存
+	MaxEntryBytes int `json:"max_entry_bytes"`
+	// TTLs 各接口的缓存时长，未配置的接口不缓存
+	TTLs map[string]time.Duration `json:"ttls"`
+}
+
+// defaultTTLs 默认缓存的接口及时长
+var defaultTTLs = map[string]time.Duration{
+	"embeddings":       24 * time.Hour,
+	"chat/completions": time.Hour,
+	"completions":      time.Hour,
+}
+
+// LoadConfigFromEnv 从环境变量加载缓存配置
+func LoadConfigFromEnv() *Config {
+	cfg := &Config{
+		Backend:       ParseBackend(os.Getenv("CACHE_BACKEND")),
+		MaxBytes:      64 << 20,
+		MaxEntryBytes: 1 << 20,
+		TTLs:          defaultTTLs,
+	}
+
+	if maxBytes, err := strconv.ParseInt(os.Getenv("CACHE_MAX_BYTES"), 10, 64); err == nil && maxBytes > 0 {
+		cfg.MaxBytes = maxBytes
+	}
+	if maxEntryBytes, err := strconv.Atoi(os.Getenv("CACHE_MAX_ENTRY_BYTES")); err == nil && maxEntryBytes > 0 {
+		cfg.MaxEntryBytes = maxEntryBytes
+	}
+	if ttls := os.Getenv("CACHE_TTLS"); ttls != "" {
+		parsed, err := ParseTTLs(ttls)
+		if err != nil {
+			logging.Logger.Errorf("Failed to parse CACHE_TTLS: %v", err)
+		} else {
+			cfg.TTLs = parsed
+		}
+	}
+
+	return cfg
+}
+
+// ParseBackend 解析缓存后端，无法识别时为 off
+func ParseBackend(value string) Backend {
+	switch Backend(strings.ToLower(strings.TrimSpace(value))) {
+	case BACKEND_MEMORY:
+		return BACKEND_MEMORY
+	case BACKEND_STORAGE:
+		return BACKEND_STORAGE
+	default:
+		return BACKEND_OFF
+	}
+}
+
+// ParseTTLs 解析 JSON 格式的接口缓存时长，如 {"embeddings": "24h"}
+func ParseTTLs(value string) (map[string]time.Duration, error) {
+	raw := map[string]string{}
+	err := json.Unmarshal([]byte(value), &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	ttls := make(map[string]time.Duration, len(raw))
+	for route, text := range raw {
+		ttl, err := time.ParseDuration(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ttl for %s: %w", route, err)
+		}
+		if ttl > 0 {
+			ttls[route] = ttl
+		}
+	}
+	return ttls, nil
+}
+
+// TTL 返回接口的缓存时长，为 0 时不缓存
+func (c *Config) TTL(route string) time.Duration {
+	return c.TTLs[route]
+}
+
+// String 返回缓存配置的简要描述
+func (c *Config) String() string {
+	routes := make([]string, 0, len(c.TTLs))
+	for route, ttl := range c.TTLs {
+		routes = append(routes, route+"="+ttl.String())
+	}
+	sort.Strings(routes)
+	return fmt.Sprintf("backend=%s ttls=%s", c.Backend, strings.Join(routes, ","))
+}
+
+// Cacheable 判断请求结果是否确定，可以缓存
+//
+// embeddings 始终可缓存；chat/completions 与 completions 仅在非流式、temperature 为 0 且指定 seed 时可缓存。
+func Cacheable(route string, body []byte) bool {
+	var req struct {
+		Stream      bool     `json:"stream"`
+		Temperature *float64 `json:"temperature"`
+		Seed        *int64   `json:"seed"`
+	}
+	err := json.Unmarshal(body, &req)
+	if err != nil {
+		return false
+	}
+
+	switch route {
+	case "embeddings":
+		return true
+	case "chat/completions", "completions":
+		return !req.Stream && req.Temperature != nil && *req.Temperature == 0 && req.Seed != nil
+	default:
+		return false
+	}
+}
+
+// Key 根据作用域（如上游与团队）、路径和规范化后的 JSON 请求体生成缓存键
+//
+// 请求体会重新编码以消除字段顺序与空白的差异。
+func Key(scope string, path string, body []byte) (string, error) {
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	err := decoder.Decode(&value)
+	if err != nil {
+		return "", err
+	}
+	canonical, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(scope))
+	hash.Write([]byte{0})
+	hash.Write([]byte(path))
+	hash.Write([]byte{0})
+	hash.Write(canonical)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// NoCache 客户端是否要求跳过缓存（Cache-Control: no-cache / no-store 或 Pragma: no-cache）
+func NoCache(header http.Header) bool {
+	for _, directive := range strings.Split(strings.ToLower(header.Get("Cache-Control")), ",") {
+		switch strings.TrimSpace(directive) {
+		case "no-cache", "no-store":
+			return true
+		}
+	}
+	return strings.EqualFold(strings.TrimSpace(header.Get("Pragma")), "no-cache")
+}
+
+// NoStore 客户端是否禁止保存响应（Cache-Control: no-store）
+func NoStore(header http.Header) bool {
+	for _, directive := range strings.Split(strings.ToLower(header.Get("Cache-Control")), ",") {
+		if strings.TrimSpace(directive) == "no-store" {
+			return true
+		}
+	}
+	return false
+}
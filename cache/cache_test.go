@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestKey_Canonical(t *testing.T) {
+	a, err := Key("openai", "/openai/v1/embeddings", []byte(`{"model":"text-embedding-3-small","input":"hello"}`))
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	b, _ := Key("openai", "/openai/v1/embeddings", []byte("{\n  \"input\": \"hello\",\n  \"model\": \"text-embedding-3-small\"\n}"))
+	if a != b {
+		t.Error("Expected field order and whitespace to be ignored")
+	}
+
+	c, _ := Key("openai/team-1", "/openai/v1/embeddings", []byte(`{"model":"text-embedding-3-small","input":"hello"}`))
+	if a == c {
+		t.Error("Expected different scopes to produce different keys")
+	}
+
+	_, err = Key("openai", "/openai/v1/embeddings", []byte(`not json`))
+	if err == nil {
+		t.Error("Expected error for invalid JSON")
+	}
+}
+
+func TestCacheable(t *testing.T) {
+	testCases := []struct {
+		route    string
+		body     string
+		expected bool
+	}{
+		{"embeddings", `{"model":"text-embedding-3-small","input":"hello"}`, true},
+		{"chat/completions", `{"model":"gpt-4o","temperature":0,"seed":1}`, true},
+		{"chat/completions", `{"model":"gpt-4o","temperature":0}`, false},
+		{"chat/completions", `{"model":"gpt-4o","temperature":0.7,"seed":1}`, false},
+		{"chat/completions", `{"model":"gpt-4o","temperature":0,"seed":1,"stream":true}`, false},
+		{"images/generations", `{"model":"dall-e-3"}`, false},
+	}
+
+	for _, tc := range testCases {
+		if result := Cacheable(tc.route, []byte(tc.body)); result != tc.expected {
+			t.Errorf("Expected Cacheable(%s, %s) to be %v", tc.route, tc.body, tc.expected)
+		}
+	}
+}
+
+func TestNoCache(t *testing.T) {
+	header := http.Header{}
+	if NoCache(header) {
+		t.Error("Expected empty header to allow cache")
+	}
+	header.Set("Cache-Control", "max-age=0, no-cache")
+	if !NoCache(header) || NoStore(header) {
+		t.Error("Expected no-cache to bypass lookup but allow store")
+	}
+	header.Set("Cache-Control", "no-store")
+	if !NoCache(header) || !NoStore(header) {
+		t.Error("Expected no-store to bypass lookup and store")
+	}
+}
+
+func TestParseTTLs(t *testing.T) {
+	ttls, err := ParseTTLs(`{"embeddings":"24h","chat/completions":"0s"}`)
+	if err != nil {
+		t.Fatalf("Failed to parse ttls: %v", err)
+	}
+	if ttls["embeddings"] != 24*time.Hour {
+		t.Errorf("Unexpected embeddings ttl: %s", ttls["embeddings"])
+	}
+	if _, ok := ttls["chat/completions"]; ok {
+		t.Error("Expected zero ttl to disable cache")
+	}
+
+	_, err = ParseTTLs(`{"embeddings":"forever"}`)
+	if err == nil {
+		t.Error("Expected error for invalid duration")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore(10)
+	expires := time.Now().Add(time.Hour)
+
+	_ = store.Set("a", &Entry{Body: []byte("1234"), ExpiresAt: expires})
+	_ = store.Set("b", &Entry{Body: []byte("1234"), ExpiresAt: expires})
+	// 访问 a 后 b 成为最久未使用的条目
+	_, _ = store.Get("a")
+	_ = store.Set("c", &Entry{Body: []byte("1234"), ExpiresAt: expires})
+
+	if entry, _ := store.Get("b"); entry != nil {
+		t.Error("Expected least recently used entry to be evicted")
+	}
+	if entry, _ := store.Get("a"); entry == nil {
+		t.Error("Expected recently used entry to be kept")
+	}
+
+	_ = store.Set("d", &Entry{Body: []byte("12"), ExpiresAt: time.Now().Add(-time.Second)})
+	if entry, _ := store.Get("d"); entry != nil {
+		t.Error("Expected expired entry to be ignored")
+	}
+
+	_ = store.Set("e", &Entry{Body: []byte("12345678901"), ExpiresAt: expires})
+	if entry, _ := store.Get("e"); entry != nil {
+		t.Error("Expected oversized entry to be skipped")
+	}
+}
@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// MemoryStore 进程内 LRU 缓存，总大小超过上限时淘汰最久未使用的条目
+type MemoryStore struct {
+	mutex    sync.Mutex
+	maxBytes int64
+	size     int64
+	order    *list.List
+	items    map[string]*list.Element
+}
+
+type memoryItem struct {
+	key   string
+	entry *Entry
+}
+
+// NewMemoryStore 创建总大小上限为 maxBytes 的内存缓存
+func NewMemoryStore(maxBytes int64) *MemoryStore {
+	return &MemoryStore{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Get 实现 Store，过期条目会被删除
+func (s *MemoryStore) Get(key string) (*Entry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	element, ok := s.items[key]
+	if !ok {
+		return nil, nil
+	}
+	item := element.Value.(*memoryItem)
+	if item.entry.Expired(time.Now()) {
+		s.remove(element)
+		return nil, nil
+	}
+	s.order.MoveToFront(element)
+	return item.entry, nil
+}
+
+// Set 实现 Store，超过总大小上限的条目不会被保存
+func (s *MemoryStore) Set(key string, entry *Entry) error {
+	size := entrySize(key, entry)
+	if size > s.maxBytes {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if element, ok := s.items[key]; ok {
+		s.remove(element)
+	}
+	s.items[key] = s.order.PushFront(&memoryItem{key: key, entry: entry})
+	s.size += size
+
+	for s.size > s.maxBytes {
+		s.remove(s.order.Back())
+	}
+	return nil
+}
+
+// Len 当前缓存条目数
+func (s *MemoryStore) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.order.Len()
+}
+
+func (s *MemoryStore) remove(element *list.Element) {
+	item := s.order.Remove(element).(*memoryItem)
+	delete(s.items, item.key)
+	s.size -= entrySize(item.key, item.entry)
+}
+
+func entrySize(key string, entry *Entry) int64 {
+	return int64(len(key) + len(entry.ContentType) + len(entry.Body))
+}
@@ -0,0 +1,100 @@
+package catalog
+
+import (
+	"context"
+	"openai-forward/logging"
+	"os"
+	"sync"
+	"time"
+)
+
+// refreshTimeout 后台刷新模型目录的超时时间
+const refreshTimeout = 30 * time.Second
+
+// retryInterval 尚无数据且拉取失败时，再次尝试拉取的最小间隔
+const retryInterval = 30 * time.Second
+
+// Fetcher 从上游拉取模型列表
+type Fetcher func(ctx context.Context) ([]*ModelInfo, error)
+
+// Catalog 缓存上游模型列表：过期后先返回旧数据并在后台刷新，刷新失败时继续使用旧数据
+type Catalog struct {
+	fetch      Fetcher
+	ttl        time.Duration
+	mutex      sync.Mutex
+	models     []*ModelInfo
+	fetchedAt  time.Time
+	attemptAt  time.Time
+	lastErr    error
+	refreshing bool
+}
+
+// New 创建模型目录，ttl 为缓存时长
+func New(fetch Fetcher, ttl time.Duration) *Catalog {
+	return &Catalog{fetch: fetch, ttl: ttl}
+}
+
+// TTLFromEnv 读取 MODEL_CATALOG_TTL，默认 10 分钟
+func TTLFromEnv() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("MODEL_CATALOG_TTL"))
+	if err == nil && ttl > 0 {
+		return ttl
+	}
+	return 10 * time.Minute
+}
+
+// Models 返回模型列表，尚无数据时同步拉取
+func (c *Catalog) Models(ctx context.Context) ([]*ModelInfo, error) {
+	c.mutex.Lock()
+	if c.fetchedAt.IsZero() {
+		if time.Since(c.attemptAt) < retryInterval {
+			err := c.lastErr
+			c.mutex.Unlock()
+			return nil, err
+		}
+		c.mutex.Unlock()
+		err := c.Refresh(ctx)
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if c.fetchedAt.IsZero() {
+			return nil, err
+		}
+		return c.models, nil
+	}
+	defer c.mutex.Unlock()
+
+	if time.Since(c.fetchedAt) >= c.ttl && !c.refreshing {
+		c.refreshing = true
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+			defer cancel()
+			_ = c.Refresh(ctx)
+		}()
+	}
+	return c.models, nil
+}
+
+// Refresh 立即从上游拉取模型列表，失败时保留旧数据
+func (c *Catalog) Refresh(ctx context.Context) error {
+	models, err := c.fetch(ctx)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.refreshing = false
+	c.attemptAt = time.Now()
+	c.lastErr = err
+	if err != nil {
+		logging.Logger.Errorf("Failed to refresh model catalogue: %v", err)
+		return err
+	}
+	c.models = sortModels(models)
+	c.fetchedAt = time.Now()
+	return nil
+}
+
+// Status 返回最近一次成功拉取的时间及最近一次拉取的错误
+func (c *Catalog) Status() (time.Time, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.fetchedAt, c.lastErr
+}
@@ -0,0 +1,129 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCatalog_StaleWhileError(t *testing.T) {
+	calls := 0
+	fail := false
+	c := New(func(ctx context.Context) ([]*ModelInfo, error) {
+		calls++
+		if fail {
+			return nil, errors.New("upstream unavailable")
+		}
+		return []*ModelInfo{{ID: "b"}, {ID: "a"}}, nil
+	}, time.Hour)
+
+	models, err := c.Models(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to load models: %v", err)
+	}
+	if len(models) != 2 || models[0].ID != "a" {
+		t.Errorf("Expected sorted models, got %v", IDs(models))
+	}
+
+	// 缓存未过期时不会再次拉取
+	_, _ = c.Models(context.Background())
+	if calls != 1 {
+		t.Errorf("Expected 1 fetch, got %d", calls)
+	}
+
+	fail = true
+	err = c.Refresh(context.Background())
+	if err == nil {
+		t.Error("Expected refresh error")
+	}
+	models, err = c.Models(context.Background())
+	if err != nil || len(models) != 2 {
+		t.Errorf("Expected stale models after failed refresh, got %v (%v)", IDs(models), err)
+	}
+	fetchedAt, lastErr := c.Status()
+	if fetchedAt.IsZero() || lastErr == nil {
+		t.Errorf("Unexpected status: %s, %v", fetchedAt, lastErr)
+	}
+}
+
+func TestCatalog_NoData(t *testing.T) {
+	calls := 0
+	c := New(func(ctx context.Context) ([]*ModelInfo, error) {
+		calls++
+		return nil, errors.New("upstream unavailable")
+	}, time.Hour)
+
+	_, err := c.Models(context.Background())
+	if err == nil {
+		t.Error("Expected error without cached models")
+	}
+	// 失败后短时间内不会重复拉取
+	_, _ = c.Models(context.Background())
+	if calls != 1 {
+		t.Errorf("Expected 1 fetch, got %d", calls)
+	}
+}
+
+func TestMergeOpenAI(t *testing.T) {
+	upstream := []*ModelInfo{
+		{ID: "gpt-4o", OwnedBy: "system"},
+		{ID: "gpt-4o-2024-08-06", OwnedBy: "system"},
+		{ID: "gpt-4o-mini", OwnedBy: "system"},
+		{ID: "dall-e-3", OwnedBy: "system"},
+	}
+	table := MetadataTable{"gpt-4o": {ContextWindow: 128000, Pricing: &Pricing{Input: 2.5, Output: 10}}}
+
+	models := MergeOpenAI(upstream, []string{"gpt-4o-mini", "gpt-4o", "o3-mini"}, table)
+	if len(models) != 2 || models[0].ID != "gpt-4o" || models[1].ID != "gpt-4o-mini" {
+		t.Fatalf("Unexpected models: %v", IDs(models))
+	}
+	if len(models[0].Aliases) != 1 || models[0].Aliases[0] != "gpt-4o-2024-08-06" {
+		t.Errorf("Expected snapshot alias, got %v", models[0].Aliases)
+	}
+	if len(models[1].Aliases) != 0 {
+		t.Errorf("Expected gpt-4o-mini not to be an alias of gpt-4o, got %v", models[1].Aliases)
+	}
+	if models[0].ContextWindow != 128000 || models[0].Upstream != "openai" {
+		t.Errorf("Unexpected metadata: %+v", models[0])
+	}
+	if Find(models, "gpt-4o-2024-08-06") != models[0] {
+		t.Error("Expected to find model by alias")
+	}
+}
+
+func TestMetadataTable_Lookup(t *testing.T) {
+	table := MetadataTable{
+		"gpt-4o":      {ContextWindow: 1},
+		"gpt-4o-mini": {ContextWindow: 2},
+	}
+	if metadata := table.Lookup("gpt-4o-mini-2024-07-18"); metadata == nil || metadata.ContextWindow != 2 {
+		t.Errorf("Expected longest prefix match, got %+v", metadata)
+	}
+	if metadata := table.Lookup("gpt-4"); metadata != nil {
+		t.Errorf("Expected no match, got %+v", metadata)
+	}
+}
+
+func TestSnapshotBase(t *testing.T) {
+	for id, expected := range map[string]string{
+		"gpt-4o-2024-08-06": "gpt-4o",
+		"gpt-4o":            "",
+		"gpt-4o-mini":       "",
+		"-2024-08-06":       "",
+	} {
+		if base := SnapshotBase(id); base != expected {
+			t.Errorf("Expected base %q for %q, got %q", expected, id, base)
+		}
+	}
+}
+
+func TestFromAzure(t *testing.T) {
+	models := FromAzure(map[string]string{"gpt-4o": "prod-gpt-4o", "embedding": "embedding", "a": ""}, MetadataTable{})
+	if ids := IDs(models); len(ids) != 3 || ids[0] != "a" || ids[1] != "embedding" || ids[2] != "gpt-4o" {
+		t.Errorf("Expected deterministic order, got %v", ids)
+	}
+	if len(models[2].Aliases) != 1 || models[2].Aliases[0] != "prod-gpt-4o" {
+		t.Errorf("Expected deployment alias, got %v", models[2].Aliases)
+	}
+}
@@ -0,0 +1,262 @@
+package catalog
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// 模型能力
+const (
+	CAPABILITY_CHAT          = "chat"
+	CAPABILITY_VISION        = "vision"
+	CAPABILITY_REASONING     = "reasoning"
+	CAPABILITY_EMBEDDINGS    = "embeddings"
+	CAPABILITY_TRANSCRIPTION = "transcription"
+	CAPABILITY_SPEECH        = "speech"
+	CAPABILITY_VIDEO         = "video"
+)
+
+// Pricing 每百万 token 的价格（美元）
+type Pricing struct {
+	Input       float64 `json:"input"`
+	CachedInput float64 `json:"cached_input,omitempty"`
+	Output      float64 `json:"output,omitempty"`
+}
+
+// Metadata 模型元数据
+type Metadata struct {
+	Capabilities  []string `json:"capabilities,omitempty"`
+	ContextWindow int      `json:"context_window,omitempty"`
+	Pricing       *Pricing `json:"pricing,omitempty"`
+}
+
+// ModelInfo 模型目录条目，兼容 OpenAI /v1/models 的返回格式
+type ModelInfo struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+	// Upstream 提供该模型的上游：openai 或 azure
+	Upstream string `json:"upstream"`
+	// Aliases 同一模型的其他名称，如带日期的快照版本或 Azure 部署名称
+	Aliases []string `json:"aliases,omitempty"`
+	Metadata
+}
+
+// builtinMetadata 内置的常用模型元数据，价格仅供参考，可通过 MODEL_METADATA 覆盖
+var builtinMetadata = map[string]*Metadata{
+	"gpt-4o": {
+		Capabilities:  []string{CAPABILITY_CHAT, CAPABILITY_VISION},
+		ContextWindow: 128000,
+		Pricing:       &Pricing{Input: 2.5, CachedInput: 1.25, Output: 10},
+	},
+	"gpt-4o-mini": {
+		Capabilities:  []string{CAPABILITY_CHAT, CAPABILITY_VISION},
+		ContextWindow: 128000,
+		Pricing:       &Pricing{Input: 0.15, CachedInput: 0.075, Output: 0.6},
+	},
+	"gpt-4.1": {
+		Capabilities:  []string{CAPABILITY_CHAT, CAPABILITY_VISION},
+		ContextWindow: 1047576,
+		Pricing:       &Pricing{Input: 2, CachedInput: 0.5, Output: 8},
+	},
+	"gpt-4.1-mini": {
+		Capabilities:  []string{CAPABILITY_CHAT, CAPABILITY_VISION},
+		ContextWindow: 1047576,
+		Pricing:       &Pricing{Input: 0.4, CachedInput: 0.1, Output: 1.6},
+	},
+	"o3-mini": {
+		Capabilities:  []string{CAPABILITY_CHAT, CAPABILITY_REASONING},
+		ContextWindow: 200000,
+		Pricing:       &Pricing{Input: 1.1, CachedInput: 0.55, Output: 4.4},
+	},
+	"o4-mini": {
+		Capabilities:  []string{CAPABILITY_CHAT, CAPABILITY_VISION, CAPABILITY_REASONING},
+		ContextWindow: 200000,
+		Pricing:       &Pricing{Input: 1.1, CachedInput: 0.275, Output: 4.4},
+	},
+	"gpt-5-mini": {
+		Capabilities:  []string{CAPABILITY_CHAT, CAPABILITY_VISION, CAPABILITY_REASONING},
+		ContextWindow: 400000,
+		Pricing:       &Pricing{Input: 0.25, CachedInput: 0.025, Output: 2},
+	},
+	"gpt-5-chat-latest": {
+		Capabilities:  []string{CAPABILITY_CHAT, CAPABILITY_VISION},
+		ContextWindow: 128000,
+		Pricing:       &Pricing{Input: 1.25, CachedInput: 0.125, Output: 10},
+	},
+	"text-embedding-3-large": {
+		Capabilities:  []string{CAPABILITY_EMBEDDINGS},
+		ContextWindow: 8191,
+		Pricing:       &Pricing{Input: 0.13},
+	},
+	"text-embedding-3-small": {
+		Capabilities:  []string{CAPABILITY_EMBEDDINGS},
+		ContextWindow: 8191,
+		Pricing:       &Pricing{Input: 0.02},
+	},
+	"text-embedding-ada-002": {
+		Capabilities:  []string{CAPABILITY_EMBEDDINGS},
+		ContextWindow: 8191,
+		Pricing:       &Pricing{Input: 0.1},
+	},
+	"whisper-1": {Capabilities: []string{CAPABILITY_TRANSCRIPTION}},
+	"tts-1":     {Capabilities: []string{CAPABILITY_SPEECH}},
+	"sora":      {Capabilities: []string{CAPABILITY_VIDEO}},
+}
+
+// MetadataTable 按模型名称查找元数据
+type MetadataTable map[string]*Metadata
+
+// LoadMetadataFromEnv 加载内置元数据，并以 MODEL_METADATA（JSON 对象，键为模型名称）覆盖
+func LoadMetadataFromEnv() (MetadataTable, error) {
+	table := MetadataTable{}
+	for id, metadata := range builtinMetadata {
+		table[id] = metadata
+	}
+
+	value := os.Getenv("MODEL_METADATA")
+	if value == "" {
+		return table, nil
+	}
+	overrides := map[string]*Metadata{}
+	err := json.Unmarshal([]byte(value), &overrides)
+	if err != nil {
+		return table, err
+	}
+	for id, metadata := range overrides {
+		table[id] = metadata
+	}
+	return table, nil
+}
+
+// Lookup 查找模型元数据，未精确匹配时使用最长的前缀匹配（如 gpt-4o-2024-08-06 使用 gpt-4o）
+func (t MetadataTable) Lookup(id string) *Metadata {
+	if metadata, ok := t[id]; ok {
+		return metadata
+	}
+	var matched string
+	for base := range t {
+		if strings.HasPrefix(id, base+"-") && len(base) > len(matched) {
+			matched = base
+		}
+	}
+	if matched == "" {
+		return nil
+	}
+	return t[matched]
+}
+
+// snapshotSuffix 带日期的快照版本后缀
+var snapshotSuffix = regexp.MustCompile(`^-\d{4}-\d{2}-\d{2}$`)
+
+// SnapshotBase 返回带日期的快照版本对应的模型名称，如 gpt-4o-2024-08-06 返回 gpt-4o，不是快照版本时返回空
+func SnapshotBase(id string) string {
+	if len(id) <= 11 || !snapshotSuffix.MatchString(id[len(id)-11:]) {
+		return ""
+	}
+	return id[:len(id)-11]
+}
+
+// MergeOpenAI 按白名单筛选上游模型，并将带日期的快照版本作为别名合并到白名单中的模型
+func MergeOpenAI(upstream []*ModelInfo, whiteList []string, table MetadataTable) []*ModelInfo {
+	byID := make(map[string]*ModelInfo, len(upstream))
+	for _, model := range upstream {
+		byID[model.ID] = model
+	}
+
+	models := []*ModelInfo{}
+	for _, id := range whiteList {
+		id = strings.TrimSpace(id)
+		source, ok := byID[id]
+		if !ok {
+			continue
+		}
+		model := &ModelInfo{
+			ID:       id,
+			Object:   "model",
+			Created:  source.Created,
+			OwnedBy:  source.OwnedBy,
+			Upstream: "openai",
+		}
+		for _, other := range upstream {
+			if strings.HasPrefix(other.ID, id) && snapshotSuffix.MatchString(other.ID[len(id):]) {
+				model.Aliases = append(model.Aliases, other.ID)
+			}
+		}
+		sort.Strings(model.Aliases)
+		if metadata := table.Lookup(id); metadata != nil {
+			model.Metadata = *metadata
+		}
+		models = append(models, model)
+	}
+
+	return sortModels(models)
+}
+
+// FromWhiteList 根据白名单生成模型目录，用于上游模型列表不可用时
+func FromWhiteList(whiteList []string, table MetadataTable) []*ModelInfo {
+	models := []*ModelInfo{}
+	for _, id := range whiteList {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		model := &ModelInfo{ID: id, Object: "model", OwnedBy: "openai", Upstream: "openai"}
+		if metadata := table.Lookup(id); metadata != nil {
+			model.Metadata = *metadata
+		}
+		models = append(models, model)
+	}
+	return sortModels(models)
+}
+
+// FromAzure 根据 Azure 模型映射生成模型目录，部署名称与模型名称不同时作为别名
+func FromAzure(mappings map[string]string, table MetadataTable) []*ModelInfo {
+	models := make([]*ModelInfo, 0, len(mappings))
+	for id, deployment := range mappings {
+		model := &ModelInfo{ID: id, Object: "model", OwnedBy: "azure", Upstream: "azure"}
+		if deployment != "" && deployment != id {
+			model.Aliases = []string{deployment}
+		}
+		if metadata := table.Lookup(id); metadata != nil {
+			model.Metadata = *metadata
+		}
+		models = append(models, model)
+	}
+	return sortModels(models)
+}
+
+// Find 按名称或别名查找模型
+func Find(models []*ModelInfo, id string) *ModelInfo {
+	for _, model := range models {
+		if model.ID == id {
+			return model
+		}
+		for _, alias := range model.Aliases {
+			if alias == id {
+				return model
+			}
+		}
+	}
+	return nil
+}
+
+// IDs 返回模型名称列表
+func IDs(models []*ModelInfo) []string {
+	ids := make([]string, 0, len(models))
+	for _, model := range models {
+		ids = append(ids, model.ID)
+	}
+	return ids
+}
+
+func sortModels(models []*ModelInfo) []*ModelInfo {
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].ID < models[j].ID
+	})
+	return models
+}
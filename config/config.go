@@ -14,6 +14,8 @@ type Config struct {
 	OrgID           string
 	ProjectID       string
 	ModelsWhiteList []string
+	// UpstreamKeyMode 客户端自带上游密钥模式：off、allow、require
+	UpstreamKeyMode string
 }
 
 // LoadConfig 加载配置
@@ -34,6 +36,7 @@ func LoadConfig() (*Config, error) {
 		OrgID:           getEnv("OPENAI_ORG_ID", ""),
 		ProjectID:       getEnv("OPENAI_PROJECT_ID", ""),
 		ModelsWhiteList: whiteList,
+		UpstreamKeyMode: getEnv("OPENAI_UPSTREAM_KEY_MODE", "off"),
 	}, nil
 }
 
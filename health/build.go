@@ -0,0 +1,38 @@
+package health
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Version 版本号，构建时通过 -ldflags "-X openai-forward/health.Version=<version>" 注入
+var Version = "dev"
+
+// BuildInfo 构建信息
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// Build 返回当前二进制的构建信息，Revision 等来自 go build 记录的版本控制信息
+func Build() BuildInfo {
+	info := BuildInfo{Version: Version, GoVersion: runtime.Version()}
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, setting := range buildInfo.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Revision = setting.Value
+		case "vcs.time":
+			info.BuildTime = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return info
+}
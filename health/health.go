@@ -0,0 +1,230 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"openai-forward/tracing"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 检查结果状态
+const (
+	STATUS_OK      = "ok"
+	STATUS_ERROR   = "error"
+	STATUS_SKIPPED = "skipped"
+)
+
+// ErrSkipped 检查项未配置时返回，不影响就绪状态
+var ErrSkipped = errors.New("not configured")
+
+// CheckFunc 检查函数，返回 nil 表示正常
+type CheckFunc func(ctx context.Context) error
+
+// Result 单项检查结果
+type Result struct {
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	LatencyMs int64     `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+	// Optional 可选检查项失败时不影响就绪状态
+	Optional bool `json:"optional,omitempty"`
+}
+
+// Report 全部检查项的结果，任一必需检查项失败时 Status 为 error
+type Report struct {
+	Status string    `json:"status"`
+	Checks []*Result `json:"checks"`
+}
+
+// Summary 返回只包含检查项名称与状态的结果，用于无需认证的探针，不暴露错误信息、地址及耗时
+func (r *Report) Summary() *Report {
+	summary := &Report{Status: r.Status, Checks: make([]*Result, len(r.Checks))}
+	for i, result := range r.Checks {
+		summary.Checks[i] = &Result{Name: result.Name, Status: result.Status, Optional: result.Optional}
+	}
+	return summary
+}
+
+// Config 健康检查配置
+type Config struct {
+	// Timeout 单项检查超时时间
+	Timeout time.Duration
+	// CacheTTL 上游、OIDC 等外部依赖检查结果的缓存时间，避免探针频繁请求外部服务
+	CacheTTL time.Duration
+	// Optional 失败时不影响就绪状态的检查项
+	Optional []string
+}
+
+// LoadConfigFromEnv 从环境变量加载健康检查配置
+func LoadConfigFromEnv() *Config {
+	cfg := &Config{
+		Timeout:  3 * time.Second,
+		CacheTTL: 30 * time.Second,
+	}
+	if timeout, err := time.ParseDuration(os.Getenv("HEALTH_TIMEOUT")); err == nil && timeout > 0 {
+		cfg.Timeout = timeout
+	}
+	if ttl, err := time.ParseDuration(os.Getenv("HEALTH_CACHE_TTL")); err == nil && ttl >= 0 {
+		cfg.CacheTTL = ttl
+	}
+	for _, name := range strings.Split(os.Getenv("READINESS_OPTIONAL"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			cfg.Optional = append(cfg.Optional, name)
+		}
+	}
+	return cfg
+}
+
+// check 已注册的检查项
+type check struct {
+	name     string
+	fn       CheckFunc
+	cached   bool
+	optional bool
+
+	// mutex 同一检查项同时只执行一次，并发的探针共享结果
+	mutex sync.Mutex
+	last  *Result
+}
+
+// Checker 依次注册检查项，Run 时并发执行
+type Checker struct {
+	config *Config
+	checks []*check
+	// onChange 检查项在正常与失败之间变化时调用
+	onChange func(previous *Result, current *Result)
+}
+
+// NewChecker 创建健康检查器
+func NewChecker(cfg *Config) *Checker {
+	return &Checker{config: cfg}
+}
+
+// Register 注册检查项，cached 为 true 时结果在 CacheTTL 内复用
+func (c *Checker) Register(name string, cached bool, fn CheckFunc) {
+	optional := false
+	for _, value := range c.config.Optional {
+		if value == name {
+			optional = true
+		}
+	}
+	c.checks = append(c.checks, &check{name: name, fn: fn, cached: cached, optional: optional})
+}
+
+// OnChange 注册状态变化回调，检查项首次失败、由正常变为失败或由失败恢复时调用，首次检查时 previous 为 nil
+func (c *Checker) OnChange(fn func(previous *Result, current *Result)) {
+	c.onChange = fn
+}
+
+// Watch 每隔 interval 执行一次所有检查项，使状态变化回调不依赖外部探针，ctx 结束时返回
+func (c *Checker) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	c.Run(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.Run(ctx)
+		}
+	}
+}
+
+// Run 执行所有检查项
+func (c *Checker) Run(ctx context.Context) *Report {
+	report := &Report{Status: STATUS_OK, Checks: make([]*Result, len(c.checks))}
+
+	var wg sync.WaitGroup
+	for i, ch := range c.checks {
+		wg.Add(1)
+		go func(i int, ch *check) {
+			defer wg.Done()
+			report.Checks[i] = c.run(ctx, ch)
+		}(i, ch)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status == STATUS_ERROR && !result.Optional {
+			report.Status = STATUS_ERROR
+		}
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, ch *check) *Result {
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+
+	if ch.cached && ch.last != nil && time.Since(ch.last.CheckedAt) < c.config.CacheTTL {
+		return ch.last
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := ch.fn(ctx)
+	result := &Result{
+		Name:      ch.name,
+		Status:    STATUS_OK,
+		LatencyMs: time.Since(start).Milliseconds(),
+		CheckedAt: start,
+		Optional:  ch.optional,
+	}
+	if errors.Is(err, ErrSkipped) {
+		result.Status = STATUS_SKIPPED
+	} else if err != nil {
+		result.Status = STATUS_ERROR
+		result.Error = err.Error()
+	}
+
+	previous := ch.last
+	ch.last = result
+	if c.onChange != nil && changed(previous, result) {
+		c.onChange(previous, result)
+	}
+	return result
+}
+
+// changed 判断检查项是否在正常与失败之间变化，未配置的检查项不参与
+func changed(previous *Result, current *Result) bool {
+	if current.Status == STATUS_SKIPPED {
+		return false
+	}
+	if previous == nil || previous.Status == STATUS_SKIPPED {
+		return current.Status == STATUS_ERROR
+	}
+	return previous.Status != current.Status
+}
+
+// HTTPProbe 请求指定地址检查连通性，strict 为 false 时任何非 5xx 响应（如未认证的 401）均视为可达
+func HTTPProbe(client *http.Client, url string, strict bool) CheckFunc {
+	if client == nil {
+		client = &http.Client{Transport: tracing.NewTransport(nil, "health")}
+	}
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+
+		if resp.StatusCode >= http.StatusInternalServerError || (strict && resp.StatusCode >= http.StatusBadRequest) {
+			return fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,135 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChecker_Run(t *testing.T) {
+	checker := NewChecker(&Config{Timeout: time.Second, CacheTTL: time.Minute, Optional: []string{"optional"}})
+	checker.Register("ok", false, func(ctx context.Context) error { return nil })
+	checker.Register("skipped", false, func(ctx context.Context) error { return ErrSkipped })
+	checker.Register("optional", false, func(ctx context.Context) error { return errors.New("down") })
+
+	report := checker.Run(context.Background())
+	if report.Status != STATUS_OK {
+		t.Errorf("Optional failures should not affect readiness, got %s", report.Status)
+	}
+	want := []string{STATUS_OK, STATUS_SKIPPED, STATUS_ERROR}
+	for i, result := range report.Checks {
+		if result.Status != want[i] {
+			t.Errorf("Check %s: expected %s, got %s", result.Name, want[i], result.Status)
+		}
+	}
+
+	checker.Register("required", false, func(ctx context.Context) error { return errors.New("down") })
+	if report := checker.Run(context.Background()); report.Status != STATUS_ERROR {
+		t.Errorf("Required failure should fail readiness, got %s", report.Status)
+	}
+}
+
+func TestChecker_Cached(t *testing.T) {
+	calls := 0
+	checker := NewChecker(&Config{Timeout: time.Second, CacheTTL: time.Minute})
+	checker.Register("upstream", true, func(ctx context.Context) error {
+		calls++
+		return nil
+	})
+
+	checker.Run(context.Background())
+	checker.Run(context.Background())
+	if calls != 1 {
+		t.Errorf("Cached check should run once within TTL, ran %d times", calls)
+	}
+}
+
+func TestChecker_Timeout(t *testing.T) {
+	checker := NewChecker(&Config{Timeout: 10 * time.Millisecond})
+	checker.Register("slow", false, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	if report := checker.Run(context.Background()); report.Status != STATUS_ERROR {
+		t.Errorf("Timed out check should fail, got %s", report.Status)
+	}
+}
+
+func TestHTTPProbe(t *testing.T) {
+	status := http.StatusUnauthorized
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	if err := HTTPProbe(nil, server.URL, false)(ctx); err != nil {
+		t.Errorf("401 should count as reachable: %v", err)
+	}
+	if err := HTTPProbe(nil, server.URL, true)(ctx); err == nil {
+		t.Error("Strict probe should reject 401")
+	}
+	status = http.StatusBadGateway
+	if err := HTTPProbe(nil, server.URL, false)(ctx); err == nil {
+		t.Error("5xx should count as unreachable")
+	}
+}
+
+func TestChecker_OnChange(t *testing.T) {
+	var down bool
+	var transitions []string
+	checker := NewChecker(&Config{Timeout: time.Second})
+	checker.Register("database", false, func(ctx context.Context) error {
+		if down {
+			return errors.New("down")
+		}
+		return nil
+	})
+	checker.OnChange(func(previous *Result, current *Result) {
+		transitions = append(transitions, current.Status)
+	})
+
+	for _, state := range []bool{false, false, true, true, false} {
+		down = state
+		checker.Run(context.Background())
+	}
+	if len(transitions) != 2 || transitions[0] != STATUS_ERROR || transitions[1] != STATUS_OK {
+		t.Errorf("Expected failure and recovery, got %v", transitions)
+	}
+}
+
+func TestChecker_Watch(t *testing.T) {
+	runs := make(chan struct{}, 10)
+	checker := NewChecker(&Config{Timeout: time.Second})
+	checker.Register("upstream", false, func(ctx context.Context) error {
+		runs <- struct{}{}
+		return errors.New("connection refused")
+	})
+	var failures int
+	checker.OnChange(func(previous *Result, current *Result) {
+		failures++
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		checker.Watch(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+	for i := 0; i < 3; i++ {
+		select {
+		case <-runs:
+		case <-time.After(time.Second):
+			t.Fatal("Expected checks to run periodically")
+		}
+	}
+	cancel()
+	<-done
+	if failures != 1 {
+		t.Errorf("Expected a single failure notification, got %d", failures)
+	}
+}
@@ -0,0 +1,155 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"openai-forward/logging"
+	"openai-forward/proxy"
+	"openai-forward/retry"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader 请求 ID 请求头，客户端可自行指定，否则由网关生成；响应中保留上游返回的值，上游未返回时为网关的请求 ID
+const RequestIDHeader = "X-Request-ID"
+
+// GatewayRequestIDHeader 响应中始终返回网关自身请求 ID 的响应头
+const GatewayRequestIDHeader = "X-Gateway-Request-Id"
+
+// validRequestID 客户端指定的请求 ID 须为不超过 128 个字符的安全字符，避免日志注入
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:\-]{1,128}$`)
+
+// accessLogEntry 访问日志中由内层处理器补充的信息
+type accessLogEntry struct {
+	key      *APIKey
+	upstream string
+	model    string
+	usage    *proxy.Usage
+	// cache 响应缓存状态（HIT 或 MISS），未使用缓存时为空
+	cache string
+	// moderation 内容审核结果，如 input:redact、output:block
+	moderation []string
+	// scrubbed 从请求中清除或检测到的敏感信息数量
+	scrubbed int
+}
+
+// requestID 生成或沿用 X-Request-ID 并返回给客户端
+func requestID(r *http.Request) string {
+	id := r.Header.Get(RequestIDHeader)
+	if validRequestID.MatchString(id) {
+		return id
+	}
+	return uuid.New().String()
+}
+
+// logRequest 为请求分配请求 ID，并在请求结束后输出一行访问日志
+func (s *Server) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := requestID(r)
+		w.Header().Set(GatewayRequestIDHeader, id)
+
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("request.id", id))
+
+		entry := &accessLogEntry{}
+		ctx := withRequestID(r.Context(), id)
+		ctx = context.WithValue(ctx, accessLogContextKey, entry)
+
+		recorder := newStatusRecorder(w)
+		recorder.requestID = id
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		recorder.writeRequestID()
+
+		// 指标抓取与探针过于频繁，不记录访问日志
+		if r.URL.Path == "/metrics" || r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			return
+		}
+
+		fields := logrus.Fields{
+			"request_id": id,
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"status":     recorder.Status(),
+			"bytes":      recorder.bytes,
+			"latency_ms": time.Since(start).Milliseconds(),
+			"client_ip":  ClientIP(r),
+		}
+		if spanContext := trace.SpanContextFromContext(r.Context()); spanContext.IsValid() {
+			fields["trace_id"] = spanContext.TraceID().String()
+		}
+		if entry.key != nil {
+			fields["user"] = entry.key.Email
+			fields["key_prefix"] = entry.key.Prefix()
+			if entry.key.TeamID != "" {
+				fields["team"] = entry.key.TeamID
+			}
+		}
+		if entry.upstream != "" {
+			fields["upstream"] = entry.upstream
+			fields["model"] = entry.model
+			if upstreamID := recorder.Header().Get(RequestIDHeader); upstreamID != "" && upstreamID != id {
+				fields["upstream_request_id"] = upstreamID
+			}
+			if retries := recorder.Header().Get(retry.RetriesHeader); retries != "" {
+				fields["retries"] = retries
+			}
+		}
+		if entry.cache != "" {
+			fields["cache"] = entry.cache
+		}
+		if len(entry.moderation) > 0 {
+			fields["moderation"] = strings.Join(entry.moderation, ",")
+		}
+		if entry.scrubbed > 0 {
+			fields["scrubbed"] = entry.scrubbed
+		}
+		if entry.usage != nil {
+			fields["prompt_tokens"] = entry.usage.Prompt()
+			fields["completion_tokens"] = entry.usage.Completion()
+		}
+
+		logging.Logger.WithFields(fields).Info("access")
+	})
+}
+
+// LogLevelRequest 日志级别设置请求
+type LogLevelRequest struct {
+	Level string `json:"level"`
+}
+
+// handleGetLogLevel 管理员查看当前日志级别
+func (s *Server) handleGetLogLevel(w http.ResponseWriter, r *http.Request) {
+	s.ResponseJSON(LogLevelRequest{Level: logging.Logger.GetLevel().String()}, w)
+}
+
+// handleSetLogLevel 管理员在运行时调整日志级别
+func (s *Server) handleSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req LogLevelRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		s.ResponseErrorWithStatus(err, http.StatusBadRequest, w)
+		return
+	}
+	if req.Level == "" {
+		s.ResponseErrorWithStatus(errors.New("level is required"), http.StatusBadRequest, w)
+		return
+	}
+
+	err = logging.SetLevel(req.Level)
+	if err != nil {
+		s.ResponseErrorWithStatus(err, http.StatusBadRequest, w)
+		return
+	}
+
+	admin := APIKeyFromContext(r.Context())
+	logging.Logger.Warnf("Log level changed to %s by %s", logging.Logger.GetLevel(), admin.Email)
+	s.ResponseJSON(LogLevelRequest{Level: logging.Logger.GetLevel().String()}, w)
+}
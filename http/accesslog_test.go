@@ -0,0 +1,76 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(RequestIDHeader, "client-id_1")
+	if id := requestID(req); id != "client-id_1" {
+		t.Errorf("Expected client request ID to be kept, got '%s'", id)
+	}
+
+	req.Header.Set(RequestIDHeader, "bad id\nforged")
+	if id := requestID(req); id == "bad id\nforged" || id == "" {
+		t.Errorf("Expected invalid request ID to be replaced, got '%s'", id)
+	}
+}
+
+func TestLogRequest(t *testing.T) {
+	s := &Server{}
+
+	var seenID string
+	handler := s.logRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seenID = RequestIDFromContext(r.Context())
+		r = r.WithContext(withAPIKey(r.Context(), &APIKey{Key: "abcdefghijkl", Email: "user@example.com"}))
+		if entry := accessLogFromContext(r.Context()); entry == nil || entry.key == nil {
+			t.Error("Expected API key to be recorded for access log")
+		}
+	}))
+
+	req := httptest.NewRequest("GET", "/api/v1/auth/sessions", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	id := rec.Header().Get(RequestIDHeader)
+	if id == "" || id != seenID {
+		t.Errorf("Expected request ID in response and context, got '%s' and '%s'", id, seenID)
+	}
+	if strings.TrimSpace(id) != id {
+		t.Errorf("Unexpected request ID '%s'", id)
+	}
+}
+
+func TestLogRequest_UpstreamRequestID(t *testing.T) {
+	s := &Server{}
+	upstreamID := ""
+	handler := s.logRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if upstreamID != "" {
+			w.Header().Set("X-Request-Id", upstreamID)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// 上游返回的请求 ID 原样透传，网关的请求 ID 在单独的响应头中返回
+	upstreamID = "req_upstream"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/openai/v1/chat/completions", nil))
+	if ids := rec.Header().Values(RequestIDHeader); len(ids) != 1 || ids[0] != "req_upstream" {
+		t.Errorf("Expected upstream request ID to be kept, got %v", ids)
+	}
+	if id := rec.Header().Get(GatewayRequestIDHeader); id == "" || id == "req_upstream" {
+		t.Errorf("Expected gateway request ID, got '%s'", id)
+	}
+
+	// 上游未返回请求 ID 时使用网关的请求 ID
+	upstreamID = ""
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/openai/v1/chat/completions", nil))
+	if id := rec.Header().Get(RequestIDHeader); id == "" || id != rec.Header().Get(GatewayRequestIDHeader) {
+		t.Errorf("Expected gateway request ID as fallback, got '%s'", id)
+	}
+}
@@ -4,8 +4,22 @@ import (
 	"crypto/rand"
 	"crypto/sha1"
 	"encoding/hex"
+	"errors"
 	"openai-forward/logging"
+	"openai-forward/service"
 	"time"
+
+	"github.com/google/uuid"
+)
+
+// touchInterval 记录密钥使用时间的最小间隔
+const touchInterval = time.Minute
+
+var (
+	// ErrStorageUnavailable 存储未初始化
+	ErrStorageUnavailable = errors.New("storage unavailable")
+	// ErrRefreshUnavailable 密钥未关联可用的 refresh token
+	ErrRefreshUnavailable = errors.New("refresh token not available for this key")
 )
 
 // APIKeyType API密钥类型
@@ -14,10 +28,28 @@ type APIKeyType string
 const (
 	// TEMPORARY_KEY 临时密钥，用于API访问，具有过期时间
 	TEMPORARY_KEY APIKeyType = "temporary"
+	// SERVICE_KEY 服务密钥，由用户为脚本或服务签发，有效期较长且不能续期
+	SERVICE_KEY APIKeyType = "service"
 )
 
+// MAX_SERVICE_KEYS 每个用户未过期服务密钥的数量上限
+const MAX_SERVICE_KEYS = 20
+
+// KeyCounts API密钥数量统计
+type KeyCounts struct {
+	Total int64 `json:"total"`
+	// Active 未过期的密钥数
+	Active int64 `json:"active"`
+	// Expired 已过期但尚未清理的密钥数
+	Expired int64 `json:"expired"`
+	// Users 持有未过期密钥的用户数
+	Users int64 `json:"users"`
+}
+
 // APIKey API密钥结构
 type APIKey struct {
+	// ID 密钥标识，用于会话列表与吊销，不泄露密钥本身
+	ID string `json:"id"`
 	// Key 密钥字符串
 	Key string `json:"key"`
 	// Type 密钥类型
@@ -26,6 +58,48 @@ type APIKey struct {
 	CreatedAt time.Time `json:"created_at"`
 	// ExpireAt 过期时间
 	ExpireAt time.Time `json:"expire_at"`
+	// Subject 密钥所属用户的 OIDC 标识
+	Subject string `json:"subject,omitempty"`
+	// Email 密钥所属用户的邮箱
+	Email string `json:"email,omitempty"`
+	// Name 密钥所属用户的名称
+	Name string `json:"name,omitempty"`
+	// RefreshToken 加密后的 OIDC refresh token，不对外输出
+	RefreshToken string `json:"-"`
+	// SessionID IdP 会话标识（sid），用于 back-channel logout
+	SessionID string `json:"-"`
+	// LastUsedAt 最后使用时间
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	// UserAgent 最后使用时的 User-Agent
+	UserAgent string `json:"user_agent,omitempty"`
+	// TeamID 密钥所属团队
+	TeamID string `json:"team_id,omitempty"`
+	// AllowedIPs 允许使用该密钥的 IP 或 CIDR，逗号分隔，为空时不限制
+	AllowedIPs string `json:"allowed_ips,omitempty"`
+	// Priority 密钥请求的调度优先级，为空时使用团队或默认优先级
+	Priority string `json:"priority,omitempty"`
+	// Label 服务密钥的用途说明
+	Label string `json:"label,omitempty"`
+}
+
+// Prefix 返回密钥前缀，用于日志及展示
+func (k *APIKey) Prefix() string {
+	if len(k.Key) <= 8 {
+		return k.Key
+	}
+	return k.Key[:8]
+}
+
+// UserKeyOptions 签发用户密钥的参数
+type UserKeyOptions struct {
+	// User OIDC 用户信息
+	User *service.UserInfo
+	// RefreshToken OIDC refresh token 明文
+	RefreshToken string
+	// UserAgent 登入时的 User-Agent
+	UserAgent string
+	// TeamID 密钥所属团队
+	TeamID string
 }
 
 // IsValid 检查API密钥是否有效
@@ -38,6 +112,8 @@ func (k *APIKey) IsValid() bool {
 type APIKeyManager struct {
 	// storage 存储实例
 	storage IStorage
+	// secretBox 用于加密 refresh token，为空时不保存 refresh token
+	secretBox *service.SecretBox
 }
 
 // NewAPIKeyManager 创建API密钥管理器实例
@@ -47,6 +123,11 @@ func NewAPIKeyManager(storage IStorage) *APIKeyManager {
 	}
 }
 
+// SetSecretBox 设置 refresh token 加密器
+func (m *APIKeyManager) SetSecretBox(box *service.SecretBox) {
+	m.secretBox = box
+}
+
 // GenerateApiKey 创建API密钥
 func (m *APIKeyManager) GenerateApiKey() string {
 	// 使用 sha1 算法創建 hash
@@ -62,6 +143,7 @@ func (m *APIKeyManager) GenerateApiKey() string {
 // GenerateTemporaryKey 生成临时API密钥
 func (m *APIKeyManager) GenerateTemporaryKey(expireIn time.Duration) (*APIKey, error) {
 	key := &APIKey{
+		ID:        uuid.New().String(),
 		Key:       m.GenerateApiKey(),
 		Type:      TEMPORARY_KEY,
 		CreatedAt: time.Now(),
@@ -81,20 +163,218 @@ func (m *APIKeyManager) GenerateTemporaryKey(expireIn time.Duration) (*APIKey, e
 	return key, nil
 }
 
+// GenerateUserKey 为 OIDC 登录用户生成临时API密钥，并加密保存 refresh token
+func (m *APIKeyManager) GenerateUserKey(opts UserKeyOptions, expireIn time.Duration) (*APIKey, error) {
+	key := &APIKey{
+		ID:        uuid.New().String(),
+		Key:       m.GenerateApiKey(),
+		Type:      TEMPORARY_KEY,
+		CreatedAt: time.Now(),
+		ExpireAt:  time.Now().Add(expireIn),
+		UserAgent: opts.UserAgent,
+		TeamID:    opts.TeamID,
+	}
+	if opts.User != nil {
+		key.Subject = opts.User.Subject
+		key.Email = opts.User.Email
+		key.Name = opts.User.Name
+		key.SessionID = opts.User.SessionID
+	}
+
+	err := m.sealRefreshToken(key, opts.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.storage == nil {
+		return nil, ErrStorageUnavailable
+	}
+	err = m.storage.SaveAPIKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// GenerateServiceKey 为当前用户签发服务密钥，沿用所属用户、团队及优先级，不关联 IdP 会话及 refresh token
+func (m *APIKeyManager) GenerateServiceKey(owner *APIKey, label string, allowedIPs string, expireIn time.Duration) (*APIKey, error) {
+	if m.storage == nil {
+		return nil, ErrStorageUnavailable
+	}
+
+	key := &APIKey{
+		ID:         uuid.New().String(),
+		Key:        m.GenerateApiKey(),
+		Type:       SERVICE_KEY,
+		CreatedAt:  time.Now(),
+		ExpireAt:   time.Now().Add(expireIn),
+		Subject:    owner.Subject,
+		Email:      owner.Email,
+		Name:       owner.Name,
+		TeamID:     owner.TeamID,
+		AllowedIPs: allowedIPs,
+		Priority:   owner.Priority,
+		Label:      label,
+	}
+	err := m.storage.SaveAPIKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// GetAPIKey 从存储中获取密钥（包括已过期但尚未清理的密钥）
+func (m *APIKeyManager) GetAPIKey(key string) (*APIKey, error) {
+	if m.storage == nil {
+		return nil, ErrStorageUnavailable
+	}
+	return m.storage.GetAPIKey(key)
+}
+
+// GetRefreshToken 解密密钥关联的 refresh token
+func (m *APIKeyManager) GetRefreshToken(key *APIKey) (string, error) {
+	if key.RefreshToken == "" || m.secretBox == nil {
+		return "", ErrRefreshUnavailable
+	}
+	return m.secretBox.Open(key.RefreshToken)
+}
+
+// RenewKey 续期密钥，rotate 为 true 时签发新密钥并删除旧密钥，否则延长原密钥有效期
+func (m *APIKeyManager) RenewKey(key *APIKey, refreshToken string, expireIn time.Duration, rotate bool) (*APIKey, error) {
+	if m.storage == nil {
+		return nil, ErrStorageUnavailable
+	}
+
+	renewed := *key
+	renewed.ExpireAt = time.Now().Add(expireIn)
+	if rotate {
+		renewed.ID = uuid.New().String()
+		renewed.Key = m.GenerateApiKey()
+		renewed.CreatedAt = time.Now()
+		renewed.LastUsedAt = nil
+	}
+
+	err := m.sealRefreshToken(&renewed, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.storage.SaveAPIKey(&renewed)
+	if err != nil {
+		return nil, err
+	}
+
+	if rotate {
+		err = m.storage.DeleteAPIKey(key.Key)
+		if err != nil {
+			logging.Logger.Errorf("Failed to delete rotated API key: %v", err)
+		}
+	}
+
+	return &renewed, nil
+}
+
+// SaveKey 保存密钥的变更
+func (m *APIKeyManager) SaveKey(key *APIKey) error {
+	if m.storage == nil {
+		return ErrStorageUnavailable
+	}
+	return m.storage.SaveAPIKey(key)
+}
+
+// RevokeKey 吊销密钥
+func (m *APIKeyManager) RevokeKey(key string) error {
+	if m.storage == nil {
+		return ErrStorageUnavailable
+	}
+	return m.storage.DeleteAPIKey(key)
+}
+
+func (m *APIKeyManager) sealRefreshToken(key *APIKey, refreshToken string) error {
+	if refreshToken == "" || m.secretBox == nil {
+		key.RefreshToken = ""
+		return nil
+	}
+
+	sealed, err := m.secretBox.Seal(refreshToken)
+	if err != nil {
+		return err
+	}
+	key.RefreshToken = sealed
+	return nil
+}
+
 // ValidateTemporaryKey 验证临时密钥
 func (m *APIKeyManager) ValidateTemporaryKey(key string) bool {
+	return m.GetValidKey(key) != nil
+}
+
+// GetValidKey 获取有效的密钥，不存在或已过期时返回 nil
+func (m *APIKeyManager) GetValidKey(key string) *APIKey {
+	if m.storage == nil {
+		return nil
+	}
+
 	// 如果内存中不存在或已过期，尝试从存储中获取
 	dbKey, err := m.storage.GetAPIKey(key)
 	if err != nil || dbKey == nil {
-		return false
+		return nil
 	}
 
 	// 检查密钥是否有效
 	if !dbKey.IsValid() {
-		return false
+		return nil
+	}
+
+	return dbKey
+}
+
+// TouchKey 记录密钥的使用时间与 User-Agent，同一分钟内不重复写入
+func (m *APIKeyManager) TouchKey(key *APIKey, userAgent string) {
+	if m.storage == nil {
+		return
+	}
+	if key.LastUsedAt != nil && time.Since(*key.LastUsedAt) < touchInterval && key.UserAgent == userAgent {
+		return
+	}
+
+	err := m.storage.TouchAPIKey(key.Key, time.Now(), userAgent)
+	if err != nil {
+		logging.Logger.Errorf("Failed to update API key usage: %v", err)
+	}
+}
+
+// ListUserKeys 列出用户所有未过期的密钥
+func (m *APIKeyManager) ListUserKeys(subject string) ([]*APIKey, error) {
+	if m.storage == nil {
+		return nil, ErrStorageUnavailable
+	}
+	return m.storage.ListAPIKeysBySubject(subject)
+}
+
+// CountKeys 统计密钥数量
+func (m *APIKeyManager) CountKeys() (*KeyCounts, error) {
+	if m.storage == nil {
+		return nil, ErrStorageUnavailable
+	}
+	return m.storage.CountAPIKeys()
+}
+
+// RevokeUserKeys 吊销用户（按 subject 或邮箱匹配）的所有密钥
+func (m *APIKeyManager) RevokeUserKeys(user string) (int64, error) {
+	if m.storage == nil {
+		return 0, ErrStorageUnavailable
 	}
+	return m.storage.DeleteAPIKeysByUser(user)
+}
 
-	return true
+// RevokeSessionKeys 吊销 IdP 会话（sid）关联的所有密钥
+func (m *APIKeyManager) RevokeSessionKeys(sessionID string) (int64, error) {
+	if m.storage == nil {
+		return 0, ErrStorageUnavailable
+	}
+	return m.storage.DeleteAPIKeysBySessionID(sessionID)
 }
 
 // CleanupExpiredKeys 清理过期密钥
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"openai-forward/service"
 	"openai-forward/test"
 	"os"
 	"testing"
@@ -139,3 +140,91 @@ func TestAPIKeyManager_CleanupExpiredKeys(t *testing.T) {
 		t.Errorf("Expected to clean up 1 key, got %d", count)
 	}
 }
+
+func TestAPIKeyManager_RenewKey(t *testing.T) {
+	// 测试续期临时密钥
+	manager := GetTestKeyManager()
+	box, err := service.NewSecretBox("test-secret")
+	if err != nil {
+		t.Fatalf("Failed to create secret box: %v", err)
+	}
+	manager.SetSecretBox(box)
+
+	user := &service.UserInfo{Subject: "user-1", Email: "user@example.com", Name: "User"}
+	key, err := manager.GenerateUserKey(UserKeyOptions{User: user, RefreshToken: "refresh-1"}, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to generate user key: %v", err)
+	}
+
+	refreshToken, err := manager.GetRefreshToken(key)
+	if err != nil {
+		t.Fatalf("Failed to get refresh token: %v", err)
+	}
+	if refreshToken != "refresh-1" {
+		t.Errorf("Expected refresh token 'refresh-1', got '%s'", refreshToken)
+	}
+
+	// 轮换密钥
+	renewed, err := manager.RenewKey(key, "refresh-2", time.Hour, true)
+	if err != nil {
+		t.Fatalf("Failed to renew key: %v", err)
+	}
+	if renewed.Key == key.Key {
+		t.Error("Rotated key should differ from the original key")
+	}
+	if renewed.Subject != user.Subject {
+		t.Errorf("Expected subject '%s', got '%s'", user.Subject, renewed.Subject)
+	}
+	if manager.ValidateTemporaryKey(key.Key) {
+		t.Error("Original key should be revoked after rotation")
+	}
+
+	// 仅延长有效期
+	extended, err := manager.RenewKey(renewed, "refresh-2", 2*time.Hour, false)
+	if err != nil {
+		t.Fatalf("Failed to extend key: %v", err)
+	}
+	if extended.Key != renewed.Key {
+		t.Error("Extended key should keep the same value")
+	}
+	if !extended.ExpireAt.After(renewed.ExpireAt) {
+		t.Error("Extended key should expire later")
+	}
+}
+
+func TestAPIKeyManager_RevokeUserKeys(t *testing.T) {
+	// 测试吊销用户的所有密钥
+	manager := GetTestKeyManager()
+
+	user := &service.UserInfo{Subject: "user-revoke", Email: "revoke@example.com", SessionID: "sid-revoke"}
+	for i := 0; i < 2; i++ {
+		_, err := manager.GenerateUserKey(UserKeyOptions{User: user}, time.Hour)
+		if err != nil {
+			t.Fatalf("Failed to generate user key: %v", err)
+		}
+	}
+
+	keys, err := manager.ListUserKeys(user.Subject)
+	if err != nil {
+		t.Fatalf("Failed to list user keys: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Errorf("Expected 2 keys, got %d", len(keys))
+	}
+
+	n, err := manager.RevokeSessionKeys(user.SessionID)
+	if err != nil {
+		t.Fatalf("Failed to revoke session keys: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Expected to revoke 2 keys, got %d", n)
+	}
+
+	n, err = manager.RevokeUserKeys(user.Email)
+	if err != nil {
+		t.Fatalf("Failed to revoke user keys: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("Expected no keys left to revoke, got %d", n)
+	}
+}
@@ -0,0 +1,172 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"openai-forward/audit"
+	"openai-forward/logging"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// newAuditor 根据配置创建审计器，未启用时返回 nil；脱敏规则无效或没有可用的写入目标时返回错误
+func newAuditor(cfg *audit.Config, storage IStorage) (*audit.Auditor, error) {
+	if cfg.Scope == audit.SCOPE_OFF {
+		return nil, nil
+	}
+
+	sinks := []audit.Sink{}
+	for _, name := range cfg.Sinks {
+		switch strings.TrimSpace(name) {
+		case "storage":
+			if storage == nil {
+				logging.Logger.Warn("Audit storage sink requires a database, skipped")
+				continue
+			}
+			sinks = append(sinks, &storageAuditSink{storage: storage})
+		case "file":
+			fileSink, err := audit.NewFileSink(cfg.FileDir)
+			if err != nil {
+				logging.Logger.Errorf("Failed to create audit file sink: %v", err)
+				continue
+			}
+			sinks = append(sinks, fileSink)
+		case "stdout":
+			sinks = append(sinks, audit.NewWriterSink(logging.Logger.Out))
+		default:
+			return nil, fmt.Errorf("unknown audit sink: %s", name)
+		}
+	}
+	if len(sinks) == 0 {
+		return nil, errors.New("no audit sink available")
+	}
+
+	auditor, err := audit.NewAuditor(cfg, sinks...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create auditor: %w", err)
+	}
+	logging.Logger.Infof("Audit log enabled: %s", cfg)
+	return auditor, nil
+}
+
+// shouldAudit 判断请求是否需要记录审计日志
+func (s *Server) shouldAudit(r *http.Request) bool {
+	if s.auditor == nil {
+		return false
+	}
+	switch s.auditor.Config().Scope {
+	case audit.SCOPE_ALL:
+		return true
+	case audit.SCOPE_TEAMS:
+		// 团队配置无法读取的请求在转发前被拒绝，无需审计
+		team, _ := s.teamFromRequest(r)
+		return team != nil && team.AuditEnabled
+	default:
+		return false
+	}
+}
+
+// auditCapture 一次代理请求的请求体与响应体捕获
+type auditCapture struct {
+	request  *audit.CappedBuffer
+	response *audit.ResponseCapture
+	textual  bool
+}
+
+// startAudit 开始捕获请求体，响应体在 recorder 写入时同步捕获
+func (s *Server) startAudit(r *http.Request, recorder *responseRecorder) *auditCapture {
+	limit := s.auditor.Config().MaxBodyBytes
+	capture := &auditCapture{
+		request:  audit.NewCappedBuffer(limit),
+		response: audit.NewResponseCapture(recorder.Header(), limit),
+		textual:  audit.IsTextual(r.Header.Get("Content-Type")),
+	}
+	if capture.textual && r.Body != nil && r.Body != http.NoBody {
+		r.Body = audit.TeeBody(r.Body, capture.request)
+	}
+	recorder.addMirror(capture.response)
+	return capture
+}
+
+// finishAudit 生成审计记录并提交
+func (s *Server) finishAudit(r *http.Request, capture *auditCapture, recorder *responseRecorder, entry *accessLogEntry, start time.Time) {
+	record := &audit.Record{
+		ID:               uuid.New().String(),
+		RequestID:        RequestIDFromContext(r.Context()),
+		Time:             start,
+		ClientIP:         ClientIP(r),
+		Method:           r.Method,
+		Path:             r.URL.Path,
+		Status:           recorder.Status(),
+		LatencyMs:        time.Since(start).Milliseconds(),
+		RequestBody:      capture.request.String(),
+		RequestTruncated: capture.request.Truncated(),
+	}
+	if !capture.textual {
+		record.RequestBody = "[binary request omitted: " + r.Header.Get("Content-Type") + "]"
+	}
+	record.ResponseBody, record.Streamed, record.ResponseTruncated = capture.response.Body()
+
+	if key := APIKeyFromContext(r.Context()); key != nil {
+		record.Subject = key.Subject
+		record.Email = key.Email
+		record.TeamID = key.TeamID
+		record.KeyPrefix = key.Prefix()
+	}
+	if entry != nil {
+		record.Upstream = entry.upstream
+		record.Model = entry.model
+		if entry.usage != nil {
+			record.PromptTokens = entry.usage.Prompt()
+			record.CompletionTokens = entry.usage.Completion()
+		}
+	}
+
+	s.auditor.Submit(record)
+}
+
+// handleListAuditRecords 管理员查询审计记录
+func (s *Server) handleListAuditRecords(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		s.ResponseError(ErrStorageUnavailable, w)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := &AuditFilter{
+		User:   query.Get("user"),
+		TeamID: query.Get("team"),
+		Model:  query.Get("model"),
+	}
+	var err error
+	for name, target := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		*target, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			s.ResponseErrorWithStatus(errors.New(name+" must be an RFC 3339 timestamp"), http.StatusBadRequest, w)
+			return
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			s.ResponseErrorWithStatus(errors.New("limit must be a number"), http.StatusBadRequest, w)
+			return
+		}
+	}
+
+	records, err := s.db.ListAuditRecords(filter)
+	if err != nil {
+		logging.Logger.Errorf("Failed to list audit records: %v", err)
+		s.ResponseError(err, w)
+		return
+	}
+	s.ResponseJSON(records, w)
+}
@@ -0,0 +1,23 @@
+package http
+
+import (
+	"openai-forward/audit"
+	"testing"
+)
+
+func TestNewAuditor(t *testing.T) {
+	if auditor, err := newAuditor(&audit.Config{Scope: audit.SCOPE_OFF}, nil); auditor != nil || err != nil {
+		t.Errorf("Expected disabled auditor, got %v", err)
+	}
+
+	// 已启用审计但无法记录时返回错误，由 NewServer 拒绝启动
+	for name, cfg := range map[string]*audit.Config{
+		"no storage":     {Scope: audit.SCOPE_ALL, Sinks: []string{"storage"}},
+		"unknown sink":   {Scope: audit.SCOPE_ALL, Sinks: []string{"stdout", "kafka"}},
+		"invalid regexp": {Scope: audit.SCOPE_ALL, Sinks: []string{"stdout"}, RedactPatterns: []string{`(`}},
+	} {
+		if auditor, err := newAuditor(cfg, nil); auditor != nil || err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+}
@@ -0,0 +1,496 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"openai-forward/apierror"
+	"openai-forward/audit"
+	"openai-forward/batch"
+	"openai-forward/catalog"
+	"openai-forward/config"
+	"openai-forward/logging"
+	"openai-forward/metrics"
+	"openai-forward/ownership"
+	"openai-forward/proxy"
+	"openai-forward/usage"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// batchUpstream 轮询批处理状态及读取输出文件使用的上游
+type batchUpstream interface {
+	Get(ctx context.Context, path string) (io.ReadCloser, error)
+}
+
+// batchTracker 校验批处理输入文件、记录经网关提交的批处理任务，并在结束后统计输出文件中的用量
+//
+// 仅跟踪 OpenAI 上游：Azure 代理会将路径改写为部署接口，无法转发 Files 与 Batch API。
+type batchTracker struct {
+	config *batch.Config
+	store  batch.Store
+	// models 返回请求者可用的模型，用于校验输入文件
+	models func(r *http.Request, upstream string) ([]*catalog.ModelInfo, error)
+	// pricing 返回模型价格，未知时为 nil
+	pricing func(model string) *catalog.Pricing
+	// catalogs 模型目录，用于生成指标中的模型标签，为空时不归并
+	catalogs *ModelCatalogs
+	// upstream 返回任务提交者所属团队对应的上游
+	upstream func(job *batch.Job) (batchUpstream, error)
+	// usage 记录输出文件中的用量，未启用用量统计时为 nil
+	usage *usage.Recorder
+}
+
+// newBatchTracker 根据配置创建批处理跟踪，未启用或没有数据库时返回 nil
+func (s *Server) newBatchTracker(cfg *batch.Config, storage IStorage) *batchTracker {
+	if !cfg.Enabled {
+		return nil
+	}
+	if storage == nil {
+		logging.Logger.Warn("Batch tracking requires a database, tracking disabled")
+		return nil
+	}
+
+	logging.Logger.Infof("Batch tracking enabled: %s", cfg)
+	return &batchTracker{
+		config:   cfg,
+		store:    storage,
+		models:   s.modelsForRequest,
+		pricing:  s.modelPricing,
+		catalogs: s.models,
+		upstream: s.batchUpstream,
+		usage:    s.usage,
+	}
+}
+
+// batchUpstream 使用服务端配置及任务提交者所属团队的凭证访问 OpenAI 上游
+func (s *Server) batchUpstream(job *batch.Job) (batchUpstream, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if job.TeamID != "" {
+		team, err := s.teamManager.GetTeam(job.TeamID)
+		if err != nil {
+			return nil, err
+		}
+		if team != nil {
+			team.ApplyOpenAI(cfg)
+		}
+	}
+	return proxy.NewOpenAIProxy(cfg), nil
+}
+
+// batchRequest 一次上传批处理输入文件、提交或查询批处理的请求
+type batchRequest struct {
+	tracker *batchTracker
+	target  *ownership.Target
+	key     *APIKey
+	// input 校验通过的输入文件摘要，上传成功后按文件 ID 保存
+	input *batch.InputSummary
+	// upstreamKey 请求是否携带客户端自带的上游密钥
+	upstreamKey bool
+	body        *audit.CappedBuffer
+}
+
+// begin 校验上传的批处理输入文件，提交、查询及取消批处理的请求在完成后记录任务，无需跟踪时返回 nil
+func (t *batchTracker) begin(r *http.Request, upstream string) (*batchRequest, *apierror.Error) {
+	if t == nil || upstream != "openai" {
+		return nil, nil
+	}
+	target := ownership.ParseTarget(r.URL.Path)
+	if target == nil {
+		return nil, nil
+	}
+	br := &batchRequest{
+		tracker:     t,
+		target:      target,
+		key:         APIKeyFromContext(r.Context()),
+		upstreamKey: r.Header.Get(proxy.UpstreamKeyHeader) != "",
+	}
+
+	switch {
+	case target.Type == ownership.TYPE_FILE && target.IsCreate(r.Method):
+		input, denied := t.parseBatchInput(r, upstream)
+		if denied != nil {
+			return nil, denied
+		}
+		if input == nil {
+			return nil, nil
+		}
+		br.input = input
+	case target.Type == ownership.TYPE_BATCH && target.IsCreate(r.Method):
+	case target.Type == ownership.TYPE_BATCH && target.ID != "" && target.Sub == "" && r.Method == http.MethodGet:
+	case target.Type == ownership.TYPE_BATCH && target.ID != "" && target.Sub == "cancel" && r.Method == http.MethodPost:
+	default:
+		return nil, nil
+	}
+	return br, nil
+}
+
+// parseBatchInput 边读取边逐行校验 purpose 为 batch 的上传文件，不在内存中缓存请求体：已读取的内容写入临时文件，
+// 完成后以临时文件及未读取的部分恢复请求体，请求结束时删除临时文件。
+// 非批处理文件返回 nil；批处理文件不合法、超出 BATCH_MAX_INPUT_BYTES 或无法读取时返回错误，不转发未经校验的文件
+func (t *batchTracker) parseBatchInput(r *http.Request, upstream string) (*batch.InputSummary, *apierror.Error) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return nil, nil
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+
+	spool, err := os.CreateTemp("", "batch-input-*")
+	if err != nil {
+		logging.Logger.Errorf("Failed to create batch input spool file: %v", err)
+		return nil, apierror.New(http.StatusInternalServerError, "internal_error", "Failed to read the uploaded file")
+	}
+	context.AfterFunc(r.Context(), func() {
+		_ = spool.Close()
+		_ = os.Remove(spool.Name())
+	})
+	original := r.Body
+	defer func() {
+		_, _ = spool.Seek(0, io.SeekStart)
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(spool, original), original}
+	}()
+
+	limit := int64(t.config.MaxInputBytes)
+	var purpose string
+	var summary *batch.InputSummary
+	var parseErr, readErr error
+	var size int64
+	fileSeen := false
+	reader := multipart.NewReader(io.TeeReader(original, spool), params["boundary"])
+	for readErr == nil {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+		switch part.FormName() {
+		case "purpose":
+			value, _ := io.ReadAll(io.LimitReader(part, 64))
+			purpose = strings.TrimSpace(string(value))
+			if purpose != batch.PURPOSE_BATCH {
+				// 其余内容无需读取，直接转发
+				return nil, nil
+			}
+		case "file":
+			fileSeen = true
+			limited := &io.LimitedReader{R: part, N: limit + 1}
+			summary, parseErr = batch.ParseInput(limited, t.allowed(r, upstream))
+			rest, err := io.Copy(io.Discard, part)
+			size = limit + 1 - limited.N + rest
+			readErr = err
+		}
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(readErr, &maxBytesErr) {
+		return nil, apierror.RequestTooLarge(maxBytesErr.Limit)
+	}
+	if purpose != batch.PURPOSE_BATCH || !fileSeen {
+		return nil, nil
+	}
+	if readErr != nil {
+		logging.Logger.Warnf("Failed to read batch input file: %v", readErr)
+		return nil, apierror.InvalidRequest("file", "invalid_batch_input", "Failed to read batch input file")
+	}
+	if size > limit {
+		return nil, apierror.New(http.StatusRequestEntityTooLarge, "request_too_large",
+			fmt.Sprintf("Batch input file is too large to be validated, the limit is %d bytes.", limit))
+	}
+	if parseErr != nil {
+		logging.Logger.Warnf("Rejected batch input file: %v", parseErr)
+		return nil, apierror.InvalidRequest("file", "invalid_batch_input", "Invalid batch input file: "+parseErr.Error())
+	}
+	return summary, nil
+}
+
+// allowed 返回校验输入文件中模型的函数，未配置白名单时不校验
+func (t *batchTracker) allowed(r *http.Request, upstream string) func(model string) bool {
+	models, err := t.models(r, upstream)
+	if err != nil {
+		logging.Logger.Errorf("Failed to load models for batch validation: %v", err)
+		return nil
+	}
+	if len(models) == 0 {
+		return nil
+	}
+	return func(model string) bool {
+		return catalog.Find(models, model) != nil
+	}
+}
+
+// capture 捕获上游响应体
+func (br *batchRequest) capture(recorder *responseRecorder) {
+	br.body = audit.NewCappedBuffer(resourceBodyLimit)
+	recorder.addMirror(br.body)
+}
+
+// finish 保存输入文件摘要，或记录、更新批处理任务
+func (br *batchRequest) finish(recorder *responseRecorder) {
+	if recorder.Status() != http.StatusOK {
+		return
+	}
+	body := []byte(br.body.String())
+	store := br.tracker.store
+
+	if br.target.Type == ownership.TYPE_FILE {
+		var file struct {
+			ID string `json:"id"`
+		}
+		if json.Unmarshal(body, &file) != nil || file.ID == "" {
+			return
+		}
+		if err := store.SaveBatchInput(file.ID, br.input); err != nil {
+			logging.Logger.Errorf("Failed to save batch input %s: %v", file.ID, err)
+		}
+		return
+	}
+
+	var object batch.Object
+	if json.Unmarshal(body, &object) != nil || object.ID == "" {
+		return
+	}
+	if br.target.ID != "" {
+		br.tracker.update(&object)
+		return
+	}
+	br.tracker.submit(&object, br.key, br.upstreamKey)
+}
+
+// submit 记录新提交的批处理任务，按输入文件摘要估算费用
+func (t *batchTracker) submit(object *batch.Object, key *APIKey, upstreamKey bool) {
+	now := time.Now()
+	job := &batch.Job{ID: object.ID, Upstream: "openai", UpstreamKey: upstreamKey, CreatedAt: now}
+	if key != nil {
+		job.Subject, job.Email, job.TeamID = key.Subject, key.Email, key.TeamID
+	}
+	job.Apply(object)
+
+	input, err := t.store.GetBatchInput(object.InputFileID)
+	if err != nil {
+		logging.Logger.Errorf("Failed to load batch input %s: %v", object.InputFileID, err)
+	}
+	if input != nil {
+		job.Models = input.ModelCounts()
+		job.EstimatedCost = input.EstimateCost(t.config, t.pricing)
+		if job.Requests == 0 {
+			job.Requests = input.Requests
+		}
+	}
+
+	if err := t.store.SaveBatchJob(job); err != nil {
+		logging.Logger.Errorf("Failed to save batch job %s: %v", job.ID, err)
+		return
+	}
+	metrics.BatchJobs.WithLabelValues("submitted").Inc()
+	logging.Logger.Infof("Batch %s submitted by %s with %d requests, estimated cost $%.4f",
+		job.ID, job.Subject, job.Requests, job.EstimatedCost)
+}
+
+// update 以客户端查询到的批处理状态更新任务，未经网关提交的批处理不记录
+func (t *batchTracker) update(object *batch.Object) {
+	job, err := t.store.GetBatchJob(object.ID)
+	if err != nil {
+		logging.Logger.Errorf("Failed to load batch job %s: %v", object.ID, err)
+		return
+	}
+	if job == nil || job.Accounted {
+		return
+	}
+	job.Apply(object)
+	if err := t.store.SaveBatchJob(job); err != nil {
+		logging.Logger.Errorf("Failed to save batch job %s: %v", job.ID, err)
+	}
+}
+
+// run 定期轮询未结束的批处理任务
+func (t *batchTracker) run(ctx context.Context) {
+	ticker := time.NewTicker(t.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.poll(ctx)
+		}
+	}
+}
+
+// poll 刷新所有用量尚未统计的批处理任务
+func (t *batchTracker) poll(ctx context.Context) {
+	jobs, err := t.store.ListPendingBatchJobs()
+	if err != nil {
+		logging.Logger.Errorf("Failed to list pending batch jobs: %v", err)
+		return
+	}
+	for _, job := range jobs {
+		if err := t.refresh(ctx, job); err != nil {
+			logging.Logger.Errorf("Failed to refresh batch job %s: %v", job.ID, err)
+		}
+	}
+}
+
+// refresh 查询批处理状态，结束后读取输出文件统计用量
+//
+// 先读取输出文件再标记已统计，读取失败时在下次轮询中重试；多个实例同时统计时仅有一个计入用量。
+func (t *batchTracker) refresh(ctx context.Context, job *batch.Job) error {
+	upstream, err := t.upstream(job)
+	if err != nil {
+		return err
+	}
+
+	if !batch.Terminal(job.Status) {
+		var object batch.Object
+		if err := getJSON(ctx, upstream, "/v1/batches/"+job.ID, &object); err != nil {
+			return err
+		}
+		job.Apply(&object)
+		if !batch.Terminal(job.Status) {
+			return t.store.SaveBatchJob(job)
+		}
+	}
+
+	var output *batch.OutputSummary
+	if job.OutputFileID != "" {
+		body, err := upstream.Get(ctx, "/v1/files/"+job.OutputFileID+"/content")
+		if err != nil {
+			return err
+		}
+		output, err = batch.ParseOutput(body)
+		body.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	claimed, err := t.store.ClaimBatchAccounting(job.ID)
+	if err != nil || !claimed {
+		return err
+	}
+	job.Accounted = true
+	if output != nil {
+		t.account(job, output)
+	}
+	metrics.BatchJobs.WithLabelValues(job.Status).Inc()
+	logging.Logger.Infof("Batch %s %s: %d prompt tokens, %d completion tokens, cost $%.4f",
+		job.ID, job.Status, job.PromptTokens, job.CompletionTokens, job.Cost)
+	return t.store.SaveBatchJob(job)
+}
+
+// account 将输出文件中的用量计入任务提交者所属团队
+func (t *batchTracker) account(job *batch.Job, output *batch.OutputSummary) {
+	team := "none"
+	if job.TeamID != "" {
+		team = job.TeamID
+	}
+
+	models := make([]string, 0, len(output.Models))
+	for model := range output.Models {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+
+	job.PromptTokens, job.CompletionTokens, job.Cost = 0, 0, 0
+	for _, model := range models {
+		usage := output.Models[model]
+		metrics.TokensTotal.WithLabelValues(t.catalogs.MetricLabel(model), team, "prompt").Add(float64(usage.PromptTokens))
+		metrics.TokensTotal.WithLabelValues(t.catalogs.MetricLabel(model), team, "completion").Add(float64(usage.CompletionTokens))
+		cost := t.config.Cost(t.pricing(model), usage.PromptTokens, usage.CompletionTokens)
+		job.PromptTokens += usage.PromptTokens
+		job.CompletionTokens += usage.CompletionTokens
+		job.Cost += cost
+		t.recordUsage(job, model, usage.PromptTokens, usage.CompletionTokens, cost)
+	}
+}
+
+// recordUsage 将批处理任务中一个模型的用量计入用量报表，按任务结束时间记录
+func (t *batchTracker) recordUsage(job *batch.Job, model string, promptTokens int, completionTokens int, cost float64) {
+	if t.usage == nil {
+		return
+	}
+	at := job.UpdatedAt
+	if job.CompletedAt != nil {
+		at = *job.CompletedAt
+	}
+	t.usage.Submit(&usage.Record{
+		RequestID:        job.ID,
+		Time:             at,
+		Subject:          job.Subject,
+		Email:            job.Email,
+		TeamID:           job.TeamID,
+		Upstream:         job.Upstream,
+		Route:            "batches",
+		Model:            modelLabel(model),
+		Status:           http.StatusOK,
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		Cost:             cost,
+	})
+}
+
+// getJSON 请求上游接口并解析 JSON 响应
+func getJSON(ctx context.Context, upstream batchUpstream, path string, v any) error {
+	body, err := upstream.Get(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return json.NewDecoder(body).Decode(v)
+}
+
+// handleListBatches 列出批处理任务，普通用户仅能查看自己提交的任务，管理员可按用户或团队查询
+func (s *Server) handleListBatches(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		s.ResponseError(ErrStorageUnavailable, w)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := &BatchFilter{
+		User:   query.Get("user"),
+		TeamID: query.Get("team"),
+		Status: query.Get("status"),
+	}
+	filter.Limit, _ = strconv.Atoi(query.Get("limit"))
+
+	key := APIKeyFromContext(r.Context())
+	if !s.authMiddleware.IsAdmin(key) {
+		filter.User = key.Subject
+		if filter.User == "" {
+			filter.User = key.Email
+		}
+		filter.TeamID = ""
+		if filter.User == "" {
+			s.ResponseError(apierror.Forbidden("API key has no owner"), w)
+			return
+		}
+	}
+
+	jobs, err := s.db.ListBatchJobs(filter)
+	if err != nil {
+		logging.Logger.Errorf("Failed to list batch jobs: %v", err)
+		s.ResponseError(err, w)
+		return
+	}
+	s.ResponseJSON(jobs, w)
+}
@@ -0,0 +1,232 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"openai-forward/batch"
+	"openai-forward/catalog"
+	"openai-forward/usage"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryBatchStore 测试用的批处理存储
+type memoryBatchStore struct {
+	mu     sync.Mutex
+	inputs map[string]*batch.InputSummary
+	jobs   map[string]*batch.Job
+}
+
+func (m *memoryBatchStore) SaveBatchInput(fileID string, summary *batch.InputSummary) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inputs[fileID] = summary
+	return nil
+}
+
+func (m *memoryBatchStore) GetBatchInput(fileID string) (*batch.InputSummary, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.inputs[fileID], nil
+}
+
+func (m *memoryBatchStore) SaveBatchJob(job *batch.Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	saved := *job
+	if existing, ok := m.jobs[job.ID]; ok {
+		saved.Accounted = existing.Accounted
+	}
+	m.jobs[job.ID] = &saved
+	return nil
+}
+
+func (m *memoryBatchStore) GetBatchJob(id string) (*batch.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if job, ok := m.jobs[id]; ok {
+		copied := *job
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (m *memoryBatchStore) ListPendingBatchJobs() ([]*batch.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := []*batch.Job{}
+	for _, job := range m.jobs {
+		if !job.Accounted && !job.UpstreamKey {
+			copied := *job
+			jobs = append(jobs, &copied)
+		}
+	}
+	return jobs, nil
+}
+
+func (m *memoryBatchStore) ClaimBatchAccounting(id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok || job.Accounted {
+		return false, nil
+	}
+	job.Accounted = true
+	return true, nil
+}
+
+// fakeBatchUpstream 按路径返回固定响应的上游
+type fakeBatchUpstream map[string]string
+
+func (f fakeBatchUpstream) Get(ctx context.Context, path string) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(f[path])), nil
+}
+
+// batchUploadRequest 构造上传批处理输入文件的 multipart 请求
+func batchUploadRequest(t *testing.T, content string) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField("purpose", batch.PURPOSE_BATCH)
+	part, err := writer.CreateFormFile("file", "input.jsonl")
+	if err != nil {
+		t.Fatalf("Failed to create form file: %v", err)
+	}
+	_, _ = part.Write([]byte(content))
+	_ = writer.Close()
+
+	req := httptest.NewRequest("POST", "/openai/v1/files", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req.WithContext(withAPIKey(context.Background(), &APIKey{Subject: "alice", TeamID: "data"}))
+}
+
+func TestObserveProxy_BatchTracking(t *testing.T) {
+	store := &memoryBatchStore{inputs: map[string]*batch.InputSummary{}, jobs: map[string]*batch.Job{}}
+	upstream := fakeBatchUpstream{
+		"/v1/batches/batch_1": `{"id":"batch_1","status":"completed","output_file_id":"file-out","request_counts":{"total":2,"completed":2}}`,
+		"/v1/files/file-out/content": `{"custom_id":"1","response":{"status_code":200,"body":{"model":"gpt-4o","usage":{"prompt_tokens":1000000,"completion_tokens":100000}}}}
+{"custom_id":"2","response":{"status_code":200,"body":{"model":"gpt-4o","usage":{"prompt_tokens":1000000,"completion_tokens":100000}}}}`,
+	}
+	tracker := &batchTracker{
+		config: &batch.Config{Enabled: true, PriceFactor: 0.5, MaxInputBytes: 1 << 20},
+		store:  store,
+		models: func(r *http.Request, upstream string) ([]*catalog.ModelInfo, error) {
+			return []*catalog.ModelInfo{{ID: "gpt-4o"}}, nil
+		},
+		pricing: func(model string) *catalog.Pricing {
+			return &catalog.Pricing{Input: 2.5, Output: 10}
+		},
+		upstream: func(job *batch.Job) (batchUpstream, error) { return upstream, nil },
+		usage:    usage.NewRecorder(&usage.Config{Enabled: true, FlushInterval: time.Hour, BatchSize: 100}, usage.NewMemoryStore()),
+	}
+	s := &Server{batches: tracker, teamManager: newTestTeamManager(&Team{ID: "data"})}
+
+	forwarded := 0
+	handler := s.observeProxy("openai", func(w http.ResponseWriter, r *http.Request) {
+		forwarded++
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/openai/v1/files" {
+			_, _ = w.Write([]byte(`{"id":"file-in","object":"file","purpose":"batch"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"batch_1","object":"batch","endpoint":"/v1/chat/completions","input_file_id":"file-in","status":"validating"}`))
+	})
+
+	rec := httptest.NewRecorder()
+	handler(rec, batchUploadRequest(t, `{"custom_id":"1","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o-mini"}}`))
+	if rec.Code != http.StatusBadRequest || forwarded != 0 {
+		t.Fatalf("Expected disallowed model to be rejected, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	input := `{"custom_id":"1","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o","max_tokens":10}}
+{"custom_id":"2","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o","max_tokens":10}}`
+	rec = httptest.NewRecorder()
+	handler(rec, batchUploadRequest(t, input))
+	if rec.Code != http.StatusOK || store.inputs["file-in"] == nil {
+		t.Fatalf("Expected input summary to be saved, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest("POST", "/openai/v1/batches", strings.NewReader(`{"input_file_id":"file-in","endpoint":"/v1/chat/completions"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(withAPIKey(context.Background(), &APIKey{Subject: "alice", TeamID: "data"}))
+	handler(httptest.NewRecorder(), req)
+	job := store.jobs["batch_1"]
+	if job == nil || job.Subject != "alice" || job.Models["gpt-4o"] != 2 || job.Requests != 2 || job.EstimatedCost <= 0 {
+		t.Fatalf("Unexpected job: %+v", job)
+	}
+
+	// 两次轮询只统计一次用量
+	tracker.poll(context.Background())
+	tracker.poll(context.Background())
+	job = store.jobs["batch_1"]
+	if !job.Accounted || job.Status != batch.STATUS_COMPLETED {
+		t.Fatalf("Expected job to be accounted, got %+v", job)
+	}
+	if job.PromptTokens != 2000000 || job.CompletionTokens != 200000 {
+		t.Errorf("Unexpected usage: %d/%d", job.PromptTokens, job.CompletionTokens)
+	}
+	// (2 * 2.5 + 0.2 * 10) * 0.5
+	if job.Cost < 3.4999 || job.Cost > 3.5001 {
+		t.Errorf("Expected cost 3.5, got %f", job.Cost)
+	}
+
+	// 输出文件中的用量计入用量报表
+	tracker.usage.Close()
+	rows, _ := tracker.usage.Store().QueryUsage(&usage.Filter{GroupBy: []string{usage.GROUP_USER, usage.GROUP_MODEL}})
+	if len(rows) != 1 || rows[0].User != "alice" || rows[0].PromptTokens != 2000000 || rows[0].Cost < 3.4999 || rows[0].Cost > 3.5001 {
+		t.Errorf("Unexpected usage rows: %+v", rows)
+	}
+}
+
+func TestBatchTracker_ParseBatchInput(t *testing.T) {
+	tracker := &batchTracker{
+		config: &batch.Config{Enabled: true, MaxInputBytes: 512},
+		models: func(r *http.Request, upstream string) ([]*catalog.ModelInfo, error) { return nil, nil },
+	}
+	line := `{"custom_id":"%d","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o"}}` + "\n"
+
+	// 校验后转发的请求体与原始请求体一致
+	req := batchUploadRequest(t, fmt.Sprintf(line, 1)+fmt.Sprintf(line, 2))
+	original, _ := io.ReadAll(req.Body)
+	req.Body = io.NopCloser(bytes.NewReader(original))
+	input, denied := tracker.parseBatchInput(req, "openai")
+	if denied != nil || input == nil || input.Requests != 2 {
+		t.Fatalf("Expected valid input, got %+v (%v)", input, denied)
+	}
+	forwarded, _ := io.ReadAll(req.Body)
+	if !bytes.Equal(forwarded, original) {
+		t.Errorf("Expected body to be restored")
+	}
+
+	// 超出上限的批处理文件无法校验，拒绝而不是直接转发
+	large := ""
+	for i := 0; len(large) <= 512; i++ {
+		large += fmt.Sprintf(line, i)
+	}
+	if _, denied := tracker.parseBatchInput(batchUploadRequest(t, large), "openai"); denied == nil || denied.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected oversized batch input to be rejected, got %v", denied)
+	}
+
+	// 其他用途的文件不校验
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField("purpose", "fine-tune")
+	part, _ := writer.CreateFormFile("file", "train.jsonl")
+	_, _ = part.Write([]byte(large + "not json"))
+	_ = writer.Close()
+	original = append([]byte{}, body.Bytes()...)
+	req = httptest.NewRequest("POST", "/openai/v1/files", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if input, denied := tracker.parseBatchInput(req, "openai"); input != nil || denied != nil {
+		t.Errorf("Expected other purposes to pass through, got %+v (%v)", input, denied)
+	}
+	if forwarded, _ := io.ReadAll(req.Body); !bytes.Equal(forwarded, original) {
+		t.Errorf("Expected body to be restored for other purposes")
+	}
+}
@@ -0,0 +1,178 @@
+package http
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"openai-forward/audit"
+	"openai-forward/cache"
+	"openai-forward/logging"
+	"openai-forward/metrics"
+	"openai-forward/proxy"
+	"strings"
+	"time"
+)
+
+// CacheHeader 响应缓存状态响应头，取值为 HIT 或 MISS
+const CacheHeader = "X-Cache"
+
+// responseCache 代理响应缓存
+type responseCache struct {
+	config *cache.Config
+	store  cache.Store
+}
+
+// newResponseCache 根据配置创建响应缓存，未启用时返回 nil
+func newResponseCache(cfg *cache.Config, storage IStorage) *responseCache {
+	var store cache.Store
+	switch cfg.Backend {
+	case cache.BACKEND_MEMORY:
+		store = cache.NewMemoryStore(cfg.MaxBytes)
+	case cache.BACKEND_STORAGE:
+		if storage == nil {
+			logging.Logger.Error("Response cache storage backend requires a database, cache disabled")
+			return nil
+		}
+		store = &storageCacheStore{storage: storage}
+	default:
+		return nil
+	}
+
+	logging.Logger.Infof("Response cache enabled: %s", cfg)
+	return &responseCache{config: cfg, store: store}
+}
+
+// cacheLookup 一次代理请求的缓存查询结果
+type cacheLookup struct {
+	key string
+	ttl time.Duration
+	// entry 命中的缓存响应，未命中时为 nil
+	entry *cache.Entry
+	// noStore 客户端禁止保存响应
+	noStore bool
+	body    *audit.CappedBuffer
+}
+
+// lookup 查询请求对应的缓存，请求不可缓存时返回 nil
+func (c *responseCache) lookup(r *http.Request, upstream string, route string) *cacheLookup {
+	if c == nil || r.Method != http.MethodPost {
+		return nil
+	}
+	ttl := c.config.TTL(route)
+	if ttl <= 0 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return nil
+	}
+	// 自带上游密钥（BYOK）的请求由客户端的上游账号付费，不读取也不写入共享的缓存
+	if r.Header.Get(proxy.UpstreamKeyHeader) != "" || proxy.UpstreamKeyModeFromEnv(upstream) == proxy.UPSTREAM_KEY_REQUIRE {
+		return nil
+	}
+
+	body, complete := peekBody(r, c.config.MaxEntryBytes)
+	if !complete || !cache.Cacheable(route, body) {
+		return nil
+	}
+
+	// 不同团队使用不同的上游账号与模型映射，缓存按上游与团队隔离
+	scope := upstream
+	if key := APIKeyFromContext(r.Context()); key != nil && key.TeamID != "" {
+		scope += "/" + key.TeamID
+	}
+	key, err := cache.Key(scope, r.URL.Path, body)
+	if err != nil {
+		return nil
+	}
+
+	result := &cacheLookup{key: key, ttl: ttl, noStore: cache.NoStore(r.Header)}
+	if cache.NoCache(r.Header) {
+		metrics.CacheRequests.WithLabelValues(route, "bypass").Inc()
+		return result
+	}
+
+	result.entry, err = c.store.Get(key)
+	if err != nil {
+		logging.Logger.Errorf("Failed to read response cache: %v", err)
+	}
+	if result.entry != nil {
+		metrics.CacheRequests.WithLabelValues(route, "hit").Inc()
+	} else {
+		metrics.CacheRequests.WithLabelValues(route, "miss").Inc()
+	}
+	return result
+}
+
+// hit 是否命中缓存
+func (l *cacheLookup) hit() bool {
+	return l != nil && l.entry != nil
+}
+
+// serve 返回缓存的响应
+func (l *cacheLookup) serve(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", l.entry.ContentType)
+	w.Header().Set(CacheHeader, "HIT")
+	w.WriteHeader(l.entry.Status)
+	_, _ = w.Write(l.entry.Body)
+}
+
+// capture 未命中时捕获上游响应以便保存
+func (c *responseCache) capture(l *cacheLookup, recorder *responseRecorder) {
+	recorder.Header().Set(CacheHeader, "MISS")
+	if l.noStore {
+		return
+	}
+	l.body = audit.NewCappedBuffer(c.config.MaxEntryBytes)
+	recorder.addMirror(l.body)
+}
+
+// save 保存成功的非流式 JSON 响应
+func (c *responseCache) save(l *cacheLookup, recorder *responseRecorder) {
+	if l.body == nil || l.body.Truncated() || recorder.Status() != http.StatusOK || recorder.Streamed() {
+		return
+	}
+	contentType := recorder.Header().Get("Content-Type")
+	if !strings.HasPrefix(contentType, "application/json") {
+		return
+	}
+
+	err := c.store.Set(l.key, &cache.Entry{
+		Status:      http.StatusOK,
+		ContentType: contentType,
+		Body:        []byte(l.body.String()),
+		ExpiresAt:   time.Now().Add(l.ttl),
+	})
+	if err != nil {
+		logging.Logger.Errorf("Failed to save response cache: %v", err)
+	}
+}
+
+// purge 清理过期的缓存响应，仅存储后端需要
+func (c *responseCache) purge() {
+	store, ok := c.store.(*storageCacheStore)
+	if !ok {
+		return
+	}
+	n, err := store.storage.DeleteExpiredCacheEntries()
+	if err != nil {
+		logging.Logger.Errorf("Failed to purge response cache: %v", err)
+		return
+	}
+	if n > 0 {
+		logging.Logger.Infof("Purged %d expired response cache entries", n)
+	}
+}
+
+// peekBody 读取至多 limit 字节的请求体并恢复请求体，请求体超出 limit 时 complete 为 false
+func peekBody(r *http.Request, limit int) (body []byte, complete bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, int64(limit)+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if err != nil {
+		return nil, false
+	}
+	return body, len(body) <= limit
+}
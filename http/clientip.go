@@ -0,0 +1,181 @@
+package http
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseCIDRList 解析以逗号分隔的 CIDR 或 IP 列表，单个 IP 视为 /32 或 /128
+func ParseCIDRList(value string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %s", item)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR: %s", item)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// containsIP 判断 IP 是否属于任一网段
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithinCIDRList 判断 nets 中的每个网段是否都包含在 within 的某个网段内
+func WithinCIDRList(nets []*net.IPNet, within []*net.IPNet) bool {
+	for _, n := range nets {
+		ones, bits := n.Mask.Size()
+		contained := false
+		for _, w := range within {
+			wOnes, wBits := w.Mask.Size()
+			if bits == wBits && ones >= wOnes && w.Contains(n.IP) {
+				contained = true
+				break
+			}
+		}
+		if !contained {
+			return false
+		}
+	}
+	return true
+}
+
+// IPRules IP 访问规则，拒绝列表优先，允许列表为空时允许所有地址
+type IPRules struct {
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+}
+
+// NewIPRules 根据逗号分隔的允许及拒绝列表创建规则
+func NewIPRules(allow string, deny string) (*IPRules, error) {
+	allowNets, err := ParseCIDRList(allow)
+	if err != nil {
+		return nil, err
+	}
+	denyNets, err := ParseCIDRList(deny)
+	if err != nil {
+		return nil, err
+	}
+	return &IPRules{Allow: allowNets, Deny: denyNets}, nil
+}
+
+// Allowed 判断 IP 是否允许访问
+func (r *IPRules) Allowed(ip string) bool {
+	if r == nil {
+		return true
+	}
+	parsed := net.ParseIP(ip)
+	if containsIP(r.Deny, parsed) {
+		return false
+	}
+	if len(r.Allow) == 0 {
+		return true
+	}
+	return containsIP(r.Allow, parsed)
+}
+
+// ClientIPResolver 根据可信代理网段推导真实客户端 IP
+type ClientIPResolver struct {
+	trusted []*net.IPNet
+}
+
+// NewClientIPResolver 创建客户端 IP 解析器，trustedProxies 为逗号分隔的可信代理 CIDR
+func NewClientIPResolver(trustedProxies string) (*ClientIPResolver, error) {
+	trusted, err := ParseCIDRList(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return &ClientIPResolver{trusted: trusted}, nil
+}
+
+// Resolve 返回客户端 IP，以及请求是否来自可信代理（决定是否采信 X-Forwarded-* 头）
+func (c *ClientIPResolver) Resolve(r *http.Request) (string, bool) {
+	remoteIP := remoteAddrIP(r.RemoteAddr)
+	if c == nil || !containsIP(c.trusted, net.ParseIP(remoteIP)) {
+		return remoteIP, false
+	}
+
+	// 从右向左跳过可信代理，第一个不可信的地址即为客户端
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(ip) == nil {
+			continue
+		}
+		if !containsIP(c.trusted, net.ParseIP(ip)) {
+			return ip, true
+		}
+		remoteIP = ip
+	}
+
+	realIP := strings.TrimSpace(r.Header.Get("X-Real-IP"))
+	if net.ParseIP(realIP) != nil && r.Header.Get("X-Forwarded-For") == "" {
+		return realIP, true
+	}
+
+	return remoteIP, true
+}
+
+// Handler 将客户端 IP 及可信代理标记写入请求上下文
+func (c *ClientIPResolver) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, trusted := c.Resolve(r)
+		next.ServeHTTP(w, r.WithContext(withClientIP(r.Context(), ip, trusted)))
+	})
+}
+
+// remoteAddrIP 去除 RemoteAddr 中的端口
+func remoteAddrIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// ClientIP 获取请求的客户端 IP，未经解析时使用 RemoteAddr
+func ClientIP(r *http.Request) string {
+	if info, ok := r.Context().Value(clientIPContextKey).(*clientIPInfo); ok {
+		return info.ip
+	}
+	return remoteAddrIP(r.RemoteAddr)
+}
+
+// isTrustedProxyRequest 判断请求是否来自可信代理
+func isTrustedProxyRequest(r *http.Request) bool {
+	if info, ok := r.Context().Value(clientIPContextKey).(*clientIPInfo); ok {
+		return info.trusted
+	}
+	return false
+}
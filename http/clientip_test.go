@@ -0,0 +1,133 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseCIDRList(t *testing.T) {
+	nets, err := ParseCIDRList("10.0.0.0/8, 192.168.1.10, ::1")
+	if err != nil {
+		t.Fatalf("Failed to parse CIDR list: %v", err)
+	}
+	if len(nets) != 3 {
+		t.Fatalf("Expected 3 networks, got %d", len(nets))
+	}
+	if nets[1].String() != "192.168.1.10/32" {
+		t.Errorf("Expected single IP to become /32, got %s", nets[1].String())
+	}
+
+	_, err = ParseCIDRList("10.0.0.0/33")
+	if err == nil {
+		t.Error("Expected error for invalid CIDR")
+	}
+}
+
+func TestIPRules_Allowed(t *testing.T) {
+	rules, err := NewIPRules("10.0.0.0/8", "10.0.0.5")
+	if err != nil {
+		t.Fatalf("Failed to create IP rules: %v", err)
+	}
+
+	if !rules.Allowed("10.1.2.3") {
+		t.Error("Expected IP in allow list to be allowed")
+	}
+	if rules.Allowed("10.0.0.5") {
+		t.Error("Expected deny list to take precedence")
+	}
+	if rules.Allowed("8.8.8.8") {
+		t.Error("Expected IP outside allow list to be rejected")
+	}
+
+	var empty *IPRules
+	if !empty.Allowed("8.8.8.8") {
+		t.Error("Expected nil rules to allow all")
+	}
+}
+
+func TestClientIPResolver_Resolve(t *testing.T) {
+	resolver, err := NewClientIPResolver("127.0.0.1, 172.16.0.0/12")
+	if err != nil {
+		t.Fatalf("Failed to create resolver: %v", err)
+	}
+
+	// 来自可信代理，跳过链路中的可信代理
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "1.1.1.1, 2.2.2.2, 172.16.0.9")
+	ip, trusted := resolver.Resolve(req)
+	if ip != "2.2.2.2" || !trusted {
+		t.Errorf("Expected 2.2.2.2 from trusted proxy, got %s (trusted=%v)", ip, trusted)
+	}
+
+	// 来自不可信地址，忽略转发头
+	req = httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "8.8.8.8:1234"
+	req.Header.Set("X-Forwarded-For", "1.1.1.1")
+	ip, trusted = resolver.Resolve(req)
+	if ip != "8.8.8.8" || trusted {
+		t.Errorf("Expected 8.8.8.8 from untrusted remote, got %s (trusted=%v)", ip, trusted)
+	}
+}
+
+func TestAuthMiddleware_IPRules(t *testing.T) {
+	m, _ := NewAuthMiddleware(NewAPIKeyManager(nil))
+	m.EnableAuth = false
+	m.IPRules, _ = NewIPRules("", "192.0.2.0/24")
+
+	handler := m.AuthRequired(func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "198.51.100.1:1234"
+	rec = httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", rec.Code)
+	}
+}
+
+func TestNewAuthMiddleware_InvalidIPRules(t *testing.T) {
+	// IP 规则无效时拒绝启动，而不是允许所有地址
+	t.Setenv("HTTP_IP_ALLOW", "10.0.0.0/33")
+	if m, err := NewAuthMiddleware(NewAPIKeyManager(nil)); err == nil || m != nil {
+		t.Errorf("Expected invalid HTTP_IP_ALLOW to be rejected")
+	}
+}
+
+func TestHandleRefresh_IPRules(t *testing.T) {
+	storage := &memoryKeyStorage{keys: map[string]*APIKey{
+		"sk-restricted": {Key: "sk-restricted", Email: "alice@example.com", AllowedIPs: "10.0.0.0/8"},
+	}}
+	m, _ := NewAuthMiddleware(NewAPIKeyManager(storage))
+	m.IPRules, _ = NewIPRules("", "192.0.2.0/24")
+	s := &Server{apiKeyManager: m.apiKeyManager, authMiddleware: m}
+
+	refresh := func(remoteAddr string) int {
+		req := httptest.NewRequest("POST", "/api/v1/auth/refresh", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Authorization", "Bearer sk-restricted")
+		rec := httptest.NewRecorder()
+		s.handleRefresh(rec, req)
+		return rec.Code
+	}
+
+	if code := refresh("192.0.2.1:1234"); code != http.StatusForbidden {
+		t.Errorf("Expected globally denied address to be rejected, got %d", code)
+	}
+	if code := refresh("198.51.100.1:1234"); code != http.StatusForbidden {
+		t.Errorf("Expected address outside the key's allowed IPs to be rejected, got %d", code)
+	}
+	// 允许的地址通过 IP 校验，因未关联 refresh token 而失败
+	if code := refresh("10.0.0.1:1234"); code == http.StatusForbidden {
+		t.Errorf("Expected allowed address to pass IP checks, got %d", code)
+	}
+}
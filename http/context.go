@@ -0,0 +1,60 @@
+package http
+
+import (
+	"context"
+)
+
+type contextKey string
+
+const (
+	// apiKeyContextKey 认证通过的API密钥在请求上下文中的键
+	apiKeyContextKey contextKey = "api_key"
+	// clientIPContextKey 客户端 IP 信息在请求上下文中的键
+	clientIPContextKey contextKey = "client_ip"
+	// requestIDContextKey 请求 ID 在请求上下文中的键
+	requestIDContextKey contextKey = "request_id"
+	// accessLogContextKey 访问日志信息在请求上下文中的键
+	accessLogContextKey contextKey = "access_log"
+)
+
+// clientIPInfo 客户端 IP 及请求是否来自可信代理
+type clientIPInfo struct {
+	ip      string
+	trusted bool
+}
+
+// withClientIP 将客户端 IP 信息写入请求上下文
+func withClientIP(ctx context.Context, ip string, trusted bool) context.Context {
+	return context.WithValue(ctx, clientIPContextKey, &clientIPInfo{ip: ip, trusted: trusted})
+}
+
+// withAPIKey 将认证通过的API密钥写入请求上下文，并记录到访问日志
+func withAPIKey(ctx context.Context, key *APIKey) context.Context {
+	if entry := accessLogFromContext(ctx); entry != nil {
+		entry.key = key
+	}
+	return context.WithValue(ctx, apiKeyContextKey, key)
+}
+
+// APIKeyFromContext 获取请求上下文中认证通过的API密钥，未认证时返回 nil
+func APIKeyFromContext(ctx context.Context) *APIKey {
+	key, _ := ctx.Value(apiKeyContextKey).(*APIKey)
+	return key
+}
+
+// withRequestID 将请求 ID 写入请求上下文
+func withRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, requestID)
+}
+
+// RequestIDFromContext 获取请求 ID，未经访问日志中间件时返回空字符串
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDContextKey).(string)
+	return requestID
+}
+
+// accessLogFromContext 获取请求的访问日志信息，未经访问日志中间件时返回 nil
+func accessLogFromContext(ctx context.Context) *accessLogEntry {
+	entry, _ := ctx.Value(accessLogContextKey).(*accessLogEntry)
+	return entry
+}
@@ -1,7 +1,17 @@
 package http
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"openai-forward/audit"
+	"openai-forward/batch"
+	"openai-forward/cache"
+	"openai-forward/jobs"
+	"openai-forward/metrics"
+	"openai-forward/notify"
+	"openai-forward/ownership"
+	"openai-forward/usage"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -12,8 +22,73 @@ type IStorage interface {
 	// API密钥相关操作
 	SaveAPIKey(apiKey *APIKey) error
 	GetAPIKey(key string) (*APIKey, error)
+	DeleteAPIKey(key string) error
 	DeleteExpiredAPIKeys() (int64, error)
+	TouchAPIKey(key string, lastUsedAt time.Time, userAgent string) error
+	ListAPIKeysBySubject(subject string) ([]*APIKey, error)
+	DeleteAPIKeysByUser(user string) (int64, error)
+	DeleteAPIKeysBySessionID(sessionID string) (int64, error)
+	CountAPIKeys() (*KeyCounts, error)
 
+	// 团队相关操作
+	SaveTeam(team *Team) error
+	GetTeam(id string) (*Team, error)
+	ListTeams() ([]*Team, error)
+	DeleteTeam(id string) error
+
+	// 审计日志相关操作
+	SaveAuditRecord(record *audit.Record) error
+	ListAuditRecords(filter *AuditFilter) ([]*audit.Record, error)
+	DeleteAuditRecordsBefore(before time.Time) (int64, error)
+
+	// 响应缓存相关操作
+	GetCacheEntry(key string) (*cache.Entry, error)
+	SaveCacheEntry(key string, entry *cache.Entry) error
+	DeleteExpiredCacheEntries() (int64, error)
+
+	// 上游资源归属相关操作
+	SaveResource(resource *ownership.Resource) error
+	GetResources(ids []string) ([]*ownership.Resource, error)
+	MarkResourceDeleted(id string, deletedAt time.Time) error
+	ListResources(filter *ResourceFilter) ([]*ownership.Resource, error)
+
+	// 批处理相关操作
+	SaveBatchInput(fileID string, summary *batch.InputSummary) error
+	GetBatchInput(fileID string) (*batch.InputSummary, error)
+	SaveBatchJob(job *batch.Job) error
+	GetBatchJob(id string) (*batch.Job, error)
+	ListPendingBatchJobs() ([]*batch.Job, error)
+	ClaimBatchAccounting(id string) (bool, error)
+	ListBatchJobs(filter *BatchFilter) ([]*batch.Job, error)
+
+	// 任务队列相关操作
+	SaveJob(job *jobs.Job, items []*jobs.Item) error
+	GetJob(id string) (*jobs.Job, error)
+	ClaimJob(staleBefore time.Time) (*jobs.Job, error)
+	ListPendingJobItems(jobID string, limit int) ([]*jobs.Item, error)
+	ListJobResults(jobID string, after int, limit int) ([]*jobs.Item, error)
+	SaveJobItemResult(item *jobs.Item) error
+	RefreshJobProgress(id string) error
+	FinishJob(id string, status string) error
+	CancelJob(id string) (bool, error)
+	CancelUserJobs(user string) (int64, error)
+	ListJobs(filter *JobFilter) ([]*jobs.Job, error)
+	DeleteJobsBefore(before time.Time) (int64, error)
+
+	// 通知发件箱相关操作
+	SaveDeliveries(deliveries []*notify.Delivery) error
+	ClaimDeliveries(now time.Time, leaseUntil time.Time, limit int) ([]*notify.Delivery, error)
+	UpdateDelivery(delivery *notify.Delivery) error
+	ListDeliveries(filter *notify.DeliveryFilter) ([]*notify.Delivery, error)
+	DeleteDeliveriesBefore(before time.Time) (int64, error)
+
+	// 用量统计相关操作
+	SaveUsageRecords(records []*usage.Record) error
+	QueryUsage(filter *usage.Filter) ([]*usage.Row, error)
+	DeleteUsageRecordsBefore(before time.Time) (int64, error)
+
+	// 检查存储连接
+	Ping(ctx context.Context) error
 	// 关闭存储连接
 	Close() error
 }
@@ -57,38 +132,189 @@ CREATE TABLE IF NOT EXISTS api_keys (
 		return err
 	}
 
-	return nil
+	// 为旧版本创建的表补充新增字段
+	apiKeyColumns := []struct {
+		name       string
+		definition string
+	}{
+		{"subject", "VARCHAR(255) NOT NULL DEFAULT ''"},
+		{"email", "VARCHAR(255) NOT NULL DEFAULT ''"},
+		{"name", "VARCHAR(255) NOT NULL DEFAULT ''"},
+		{"refresh_token", "TEXT NULL"},
+		{"key_id", "VARCHAR(64) NOT NULL DEFAULT ''"},
+		{"sid", "VARCHAR(255) NOT NULL DEFAULT ''"},
+		{"last_used_at", "DATETIME NULL"},
+		{"user_agent", "VARCHAR(512) NOT NULL DEFAULT ''"},
+		{"team_id", "VARCHAR(64) NOT NULL DEFAULT ''"},
+		{"allowed_ips", "TEXT NULL"},
+		{"priority", "VARCHAR(16) NOT NULL DEFAULT ''"},
+		{"label", "VARCHAR(128) NOT NULL DEFAULT ''"},
+	}
+	for _, column := range apiKeyColumns {
+		err = db.ensureColumn("api_keys", column.name, column.definition)
+		if err != nil {
+			return err
+		}
+	}
+
+	apiKeyIndexes := map[string]string{
+		"idx_api_keys_subject": "subject",
+		"idx_api_keys_email":   "email",
+		"idx_api_keys_sid":     "sid",
+	}
+	for index, columns := range apiKeyIndexes {
+		err = db.ensureIndex("api_keys", index, columns)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = db.initTeamTables()
+	if err != nil {
+		return err
+	}
+
+	err = db.initAuditTables()
+	if err != nil {
+		return err
+	}
+
+	err = db.initCacheTables()
+	if err != nil {
+		return err
+	}
+
+	err = db.initOwnershipTables()
+	if err != nil {
+		return err
+	}
+
+	err = db.initBatchTables()
+	if err != nil {
+		return err
+	}
+
+	err = db.initJobTables()
+	if err != nil {
+		return err
+	}
+
+	if err := db.initNotifyTables(); err != nil {
+		return err
+	}
+	return db.initUsageTables()
+}
+
+// ensureIndex 如果表中不存在指定索引则添加
+func (db *DB) ensureIndex(table string, index string, columns string) error {
+	var count int
+	err := db.db.QueryRow(`
+	SELECT COUNT(*) FROM information_schema.STATISTICS
+	WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = ?
+	`, table, index).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	_, err = db.db.Exec(fmt.Sprintf("CREATE INDEX %s ON %s (%s)", index, table, columns))
+	return err
+}
+
+// ensureColumn 如果表中不存在指定字段则添加
+func (db *DB) ensureColumn(table string, column string, definition string) error {
+	var count int
+	err := db.db.QueryRow(`
+	SELECT COUNT(*) FROM information_schema.COLUMNS
+	WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?
+	`, table, column).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	_, err = db.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
 }
 
 // SaveAPIKey 保存API密钥到数据库
 func (db *DB) SaveAPIKey(apiKey *APIKey) error {
+	defer metrics.ObserveStorage("SaveAPIKey", time.Now())
+
 	sqlStmt := `
-	INSERT INTO api_keys (api_key, api_type, created_at, expire_at)
-	VALUES (?, ?, ?, ?)
+	INSERT INTO api_keys (api_key, api_type, created_at, expire_at, subject, email, name, refresh_token,
+		key_id, sid, last_used_at, user_agent, team_id, allowed_ips, priority, label)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	ON DUPLICATE KEY UPDATE
 	api_type = VALUES(api_type),
 	created_at = VALUES(created_at),
-	expire_at = VALUES(expire_at)
+	expire_at = VALUES(expire_at),
+	subject = VALUES(subject),
+	email = VALUES(email),
+	name = VALUES(name),
+	refresh_token = VALUES(refresh_token),
+	key_id = VALUES(key_id),
+	sid = VALUES(sid),
+	last_used_at = VALUES(last_used_at),
+	user_agent = VALUES(user_agent),
+	team_id = VALUES(team_id),
+	allowed_ips = VALUES(allowed_ips),
+	priority = VALUES(priority),
+	label = VALUES(label)
 	`
 
-	_, err := db.db.Exec(sqlStmt, apiKey.Key, string(apiKey.Type), apiKey.CreatedAt, apiKey.ExpireAt)
+	_, err := db.db.Exec(sqlStmt, apiKey.Key, string(apiKey.Type), apiKey.CreatedAt, apiKey.ExpireAt,
+		apiKey.Subject, apiKey.Email, apiKey.Name, apiKey.RefreshToken,
+		apiKey.ID, apiKey.SessionID, apiKey.LastUsedAt, apiKey.UserAgent, apiKey.TeamID, apiKey.AllowedIPs,
+		apiKey.Priority, apiKey.Label)
 	return err
 }
 
+// apiKeyColumns 查询API密钥时使用的字段列表，与 scanAPIKey 顺序一致
+const apiKeyColumns = `api_key, api_type, created_at, expire_at, subject, email, name, refresh_token,
+	key_id, sid, last_used_at, user_agent, team_id, allowed_ips, priority, label`
+
+// scanAPIKey 从查询结果中读取API密钥
+func scanAPIKey(scanner interface{ Scan(dest ...any) error }) (*APIKey, error) {
+	var apiKey APIKey
+	var apiKeyType string
+	var refreshToken sql.NullString
+	var lastUsedAt sql.NullTime
+	var allowedIPs sql.NullString
+
+	err := scanner.Scan(&apiKey.Key, &apiKeyType, &apiKey.CreatedAt, &apiKey.ExpireAt,
+		&apiKey.Subject, &apiKey.Email, &apiKey.Name, &refreshToken,
+		&apiKey.ID, &apiKey.SessionID, &lastUsedAt, &apiKey.UserAgent, &apiKey.TeamID, &allowedIPs,
+		&apiKey.Priority, &apiKey.Label)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKey.Type = APIKeyType(apiKeyType)
+	apiKey.RefreshToken = refreshToken.String
+	apiKey.AllowedIPs = allowedIPs.String
+	if lastUsedAt.Valid {
+		apiKey.LastUsedAt = &lastUsedAt.Time
+	}
+
+	return &apiKey, nil
+}
+
 // GetAPIKey 从数据库获取API密钥
 func (db *DB) GetAPIKey(key string) (*APIKey, error) {
+	defer metrics.ObserveStorage("GetAPIKey", time.Now())
+
 	sqlStmt := `
-	SELECT api_key, api_type, created_at, expire_at
+	SELECT ` + apiKeyColumns + `
 	FROM api_keys
 	WHERE api_key = ?
 	`
 
-	row := db.db.QueryRow(sqlStmt, key)
-
-	var apiKey APIKey
-	var apiKeyType string
-
-	err := row.Scan(&apiKey.Key, &apiKeyType, &apiKey.CreatedAt, &apiKey.ExpireAt)
+	apiKey, err := scanAPIKey(db.db.QueryRow(sqlStmt, key))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -96,13 +322,26 @@ func (db *DB) GetAPIKey(key string) (*APIKey, error) {
 		return nil, err
 	}
 
-	apiKey.Type = APIKeyType(apiKeyType)
+	return apiKey, nil
+}
 
-	return &apiKey, nil
+// DeleteAPIKey 删除指定API密钥
+func (db *DB) DeleteAPIKey(key string) error {
+	defer metrics.ObserveStorage("DeleteAPIKey", time.Now())
+
+	sqlStmt := `
+	DELETE FROM api_keys
+	WHERE api_key = ?
+	`
+
+	_, err := db.db.Exec(sqlStmt, key)
+	return err
 }
 
 // DeleteExpiredAPIKeys 删除过期的API密钥
 func (db *DB) DeleteExpiredAPIKeys() (int64, error) {
+	defer metrics.ObserveStorage("DeleteExpiredAPIKeys", time.Now())
+
 	sqlStmt := `
 	DELETE FROM api_keys
 	WHERE expire_at < ?
@@ -116,7 +355,110 @@ func (db *DB) DeleteExpiredAPIKeys() (int64, error) {
 	return result.RowsAffected()
 }
 
+// TouchAPIKey 更新API密钥的最后使用时间与 User-Agent
+func (db *DB) TouchAPIKey(key string, lastUsedAt time.Time, userAgent string) error {
+	defer metrics.ObserveStorage("TouchAPIKey", time.Now())
+
+	sqlStmt := `
+	UPDATE api_keys
+	SET last_used_at = ?, user_agent = ?
+	WHERE api_key = ?
+	`
+
+	_, err := db.db.Exec(sqlStmt, lastUsedAt, userAgent, key)
+	return err
+}
+
+// ListAPIKeysBySubject 列出用户所有未过期的API密钥
+func (db *DB) ListAPIKeysBySubject(subject string) ([]*APIKey, error) {
+	defer metrics.ObserveStorage("ListAPIKeysBySubject", time.Now())
+
+	sqlStmt := `
+	SELECT ` + apiKeyColumns + `
+	FROM api_keys
+	WHERE subject = ? AND expire_at >= ?
+	ORDER BY created_at DESC
+	`
+
+	rows, err := db.db.Query(sqlStmt, subject, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		apiKey, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, apiKey)
+	}
+
+	return keys, rows.Err()
+}
+
+// DeleteAPIKeysByUser 删除用户（subject 或邮箱匹配）的所有API密钥
+func (db *DB) DeleteAPIKeysByUser(user string) (int64, error) {
+	defer metrics.ObserveStorage("DeleteAPIKeysByUser", time.Now())
+
+	sqlStmt := `
+	DELETE FROM api_keys
+	WHERE subject = ? OR email = ?
+	`
+
+	result, err := db.db.Exec(sqlStmt, user, user)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// DeleteAPIKeysBySessionID 删除 IdP 会话关联的所有API密钥
+func (db *DB) DeleteAPIKeysBySessionID(sessionID string) (int64, error) {
+	defer metrics.ObserveStorage("DeleteAPIKeysBySessionID", time.Now())
+
+	sqlStmt := `
+	DELETE FROM api_keys
+	WHERE sid = ?
+	`
+
+	result, err := db.db.Exec(sqlStmt, sessionID)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
 // Close 关闭数据库连接
+// CountAPIKeys 统计API密钥数量
+func (db *DB) CountAPIKeys() (*KeyCounts, error) {
+	defer metrics.ObserveStorage("CountAPIKeys", time.Now())
+
+	sqlStmt := `
+	SELECT COUNT(*), COALESCE(SUM(expire_at >= ?), 0), COUNT(DISTINCT CASE WHEN expire_at >= ? AND subject != '' THEN subject END)
+	FROM api_keys
+	`
+
+	now := time.Now()
+	counts := &KeyCounts{}
+	err := db.db.QueryRow(sqlStmt, now, now).Scan(&counts.Total, &counts.Active, &counts.Users)
+	if err != nil {
+		return nil, err
+	}
+	counts.Expired = counts.Total - counts.Active
+	return counts, nil
+}
+
+// Ping 检查数据库连接
+func (db *DB) Ping(ctx context.Context) error {
+	defer metrics.ObserveStorage("Ping", time.Now())
+
+	return db.db.PingContext(ctx)
+}
+
 func (db *DB) Close() error {
 	return db.db.Close()
 }
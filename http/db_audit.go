@@ -0,0 +1,175 @@
+package http
+
+import (
+	"database/sql"
+	"encoding/json"
+	"openai-forward/audit"
+	"openai-forward/metrics"
+	"strings"
+	"time"
+)
+
+// AuditFilter 审计记录查询条件，零值字段不参与过滤
+type AuditFilter struct {
+	// User 用户 subject 或邮箱
+	User   string
+	TeamID string
+	Model  string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// initAuditTables 初始化审计日志数据表
+func (db *DB) initAuditTables() error {
+	auditTableSQL := `
+CREATE TABLE IF NOT EXISTS audit_records (
+	record_id VARCHAR(64) PRIMARY KEY,
+	request_id VARCHAR(128) NOT NULL DEFAULT '',
+	created_at DATETIME(3) NOT NULL,
+	subject VARCHAR(255) NOT NULL DEFAULT '',
+	email VARCHAR(255) NOT NULL DEFAULT '',
+	team_id VARCHAR(64) NOT NULL DEFAULT '',
+	key_prefix VARCHAR(16) NOT NULL DEFAULT '',
+	client_ip VARCHAR(64) NOT NULL DEFAULT '',
+	upstream VARCHAR(32) NOT NULL DEFAULT '',
+	method VARCHAR(16) NOT NULL DEFAULT '',
+	path VARCHAR(512) NOT NULL DEFAULT '',
+	model VARCHAR(128) NOT NULL DEFAULT '',
+	status INT NOT NULL DEFAULT 0,
+	latency_ms BIGINT NOT NULL DEFAULT 0,
+	prompt_tokens INT NOT NULL DEFAULT 0,
+	completion_tokens INT NOT NULL DEFAULT 0,
+	request_body MEDIUMTEXT NULL,
+	response_body MEDIUMTEXT NULL,
+	streamed TINYINT(1) NOT NULL DEFAULT 0,
+	request_truncated TINYINT(1) NOT NULL DEFAULT 0,
+	response_truncated TINYINT(1) NOT NULL DEFAULT 0,
+	redactions TEXT NULL,
+	INDEX idx_audit_records_created_at (created_at),
+	INDEX idx_audit_records_subject (subject),
+	INDEX idx_audit_records_team_id (team_id)
+);`
+
+	_, err := db.db.Exec(auditTableSQL)
+	return err
+}
+
+// SaveAuditRecord 保存审计记录
+func (db *DB) SaveAuditRecord(record *audit.Record) error {
+	defer metrics.ObserveStorage("SaveAuditRecord", time.Now())
+
+	sqlStmt := `
+	INSERT INTO audit_records (record_id, request_id, created_at, subject, email, team_id, key_prefix, client_ip,
+		upstream, method, path, model, status, latency_ms, prompt_tokens, completion_tokens,
+		request_body, response_body, streamed, request_truncated, response_truncated, redactions)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	redactions, _ := json.Marshal(record.Redactions)
+
+	_, err := db.db.Exec(sqlStmt, record.ID, record.RequestID, record.Time, record.Subject, record.Email,
+		record.TeamID, record.KeyPrefix, record.ClientIP, record.Upstream, record.Method, record.Path,
+		record.Model, record.Status, record.LatencyMs, record.PromptTokens, record.CompletionTokens,
+		record.RequestBody, record.ResponseBody, record.Streamed, record.RequestTruncated,
+		record.ResponseTruncated, string(redactions))
+	return err
+}
+
+// ListAuditRecords 按条件查询审计记录，按时间倒序
+func (db *DB) ListAuditRecords(filter *AuditFilter) ([]*audit.Record, error) {
+	defer metrics.ObserveStorage("ListAuditRecords", time.Now())
+
+	conditions := []string{"1 = 1"}
+	args := []any{}
+	if filter.User != "" {
+		conditions = append(conditions, "(subject = ? OR email = ?)")
+		args = append(args, filter.User, filter.User)
+	}
+	if filter.TeamID != "" {
+		conditions = append(conditions, "team_id = ?")
+		args = append(args, filter.TeamID)
+	}
+	if filter.Model != "" {
+		conditions = append(conditions, "model = ?")
+		args = append(args, filter.Model)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, filter.Until)
+	}
+	limit := filter.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	args = append(args, limit)
+
+	sqlStmt := `
+	SELECT record_id, request_id, created_at, subject, email, team_id, key_prefix, client_ip,
+		upstream, method, path, model, status, latency_ms, prompt_tokens, completion_tokens,
+		request_body, response_body, streamed, request_truncated, response_truncated, redactions
+	FROM audit_records
+	WHERE ` + strings.Join(conditions, " AND ") + `
+	ORDER BY created_at DESC
+	LIMIT ?
+	`
+
+	rows, err := db.db.Query(sqlStmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []*audit.Record{}
+	for rows.Next() {
+		var record audit.Record
+		var requestBody, responseBody, redactions sql.NullString
+		err = rows.Scan(&record.ID, &record.RequestID, &record.Time, &record.Subject, &record.Email,
+			&record.TeamID, &record.KeyPrefix, &record.ClientIP, &record.Upstream, &record.Method, &record.Path,
+			&record.Model, &record.Status, &record.LatencyMs, &record.PromptTokens, &record.CompletionTokens,
+			&requestBody, &responseBody, &record.Streamed, &record.RequestTruncated,
+			&record.ResponseTruncated, &redactions)
+		if err != nil {
+			return nil, err
+		}
+		record.RequestBody = requestBody.String
+		record.ResponseBody = responseBody.String
+		if redactions.Valid {
+			_ = json.Unmarshal([]byte(redactions.String), &record.Redactions)
+		}
+		records = append(records, &record)
+	}
+
+	return records, rows.Err()
+}
+
+// DeleteAuditRecordsBefore 删除早于指定时间的审计记录
+func (db *DB) DeleteAuditRecordsBefore(before time.Time) (int64, error) {
+	defer metrics.ObserveStorage("DeleteAuditRecordsBefore", time.Now())
+
+	result, err := db.db.Exec(`DELETE FROM audit_records WHERE created_at < ?`, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// storageAuditSink 将审计记录写入存储
+type storageAuditSink struct {
+	storage IStorage
+}
+
+// Write 实现 audit.Sink
+func (s *storageAuditSink) Write(record *audit.Record) error {
+	return s.storage.SaveAuditRecord(record)
+}
+
+// Purge 实现 audit.Purger
+func (s *storageAuditSink) Purge(before time.Time) (int64, error) {
+	return s.storage.DeleteAuditRecordsBefore(before)
+}
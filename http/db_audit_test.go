@@ -0,0 +1,52 @@
+package http
+
+import (
+	"openai-forward/audit"
+	"testing"
+	"time"
+)
+
+func TestDB_SaveAndListAuditRecords(t *testing.T) {
+	// 测试保存、查询和清理审计记录
+	db := GetTestDB()
+	defer db.Close()
+
+	now := time.Now()
+	record := &audit.Record{
+		ID:           "test-audit-record",
+		RequestID:    "test-request",
+		Time:         now,
+		Subject:      "test-audit-subject",
+		Model:        "gpt-4o",
+		Status:       200,
+		RequestBody:  `{"model":"gpt-4o"}`,
+		ResponseBody: "Hello",
+		Streamed:     true,
+		Redactions:   map[string]int{"email": 1},
+	}
+
+	err := db.SaveAuditRecord(record)
+	if err != nil {
+		t.Fatalf("Failed to save audit record: %v", err)
+	}
+
+	records, err := db.ListAuditRecords(&AuditFilter{User: "test-audit-subject"})
+	if err != nil {
+		t.Fatalf("Failed to list audit records: %v", err)
+	}
+	if len(records) != 1 || records[0].ResponseBody != "Hello" || records[0].Redactions["email"] != 1 {
+		t.Errorf("Unexpected audit records: %+v", records)
+	}
+
+	_, err = db.DeleteAuditRecordsBefore(now.Add(time.Second))
+	if err != nil {
+		t.Fatalf("Failed to purge audit records: %v", err)
+	}
+	records, err = db.ListAuditRecords(&AuditFilter{User: "test-audit-subject"})
+	if err != nil {
+		t.Fatalf("Failed to list audit records: %v", err)
+	}
+	if len(records) != 0 {
+		t.Errorf("Expected audit records to be purged, got %d", len(records))
+	}
+}
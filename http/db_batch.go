@@ -0,0 +1,260 @@
+package http
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"openai-forward/batch"
+	"openai-forward/metrics"
+	"strings"
+	"time"
+)
+
+// BatchFilter 批处理任务查询条件，零值字段不参与过滤
+type BatchFilter struct {
+	// User 用户 subject 或邮箱
+	User   string
+	TeamID string
+	Status string
+	Limit  int
+}
+
+// initBatchTables 初始化批处理数据表
+func (db *DB) initBatchTables() error {
+	batchInputTableSQL := `
+CREATE TABLE IF NOT EXISTS batch_inputs (
+	file_id VARCHAR(255) PRIMARY KEY,
+	summary TEXT NOT NULL,
+	created_at DATETIME(3) NOT NULL
+);`
+
+	_, err := db.db.Exec(batchInputTableSQL)
+	if err != nil {
+		return err
+	}
+
+	batchJobTableSQL := `
+CREATE TABLE IF NOT EXISTS batch_jobs (
+	batch_id VARCHAR(255) PRIMARY KEY,
+	upstream VARCHAR(32) NOT NULL DEFAULT '',
+	subject VARCHAR(255) NOT NULL DEFAULT '',
+	email VARCHAR(255) NOT NULL DEFAULT '',
+	team_id VARCHAR(64) NOT NULL DEFAULT '',
+	endpoint VARCHAR(64) NOT NULL DEFAULT '',
+	status VARCHAR(32) NOT NULL,
+	upstream_key BOOLEAN NOT NULL DEFAULT FALSE,
+	input_file_id VARCHAR(255) NOT NULL DEFAULT '',
+	output_file_id VARCHAR(255) NOT NULL DEFAULT '',
+	error_file_id VARCHAR(255) NOT NULL DEFAULT '',
+	requests INT NOT NULL DEFAULT 0,
+	completed_requests INT NOT NULL DEFAULT 0,
+	failed_requests INT NOT NULL DEFAULT 0,
+	models TEXT NULL,
+	estimated_cost DOUBLE NOT NULL DEFAULT 0,
+	prompt_tokens BIGINT NOT NULL DEFAULT 0,
+	completion_tokens BIGINT NOT NULL DEFAULT 0,
+	cost DOUBLE NOT NULL DEFAULT 0,
+	accounted BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at DATETIME(3) NOT NULL,
+	updated_at DATETIME(3) NOT NULL,
+	completed_at DATETIME(3) NULL,
+	INDEX idx_batch_jobs_subject (subject),
+	INDEX idx_batch_jobs_team_id (team_id),
+	INDEX idx_batch_jobs_accounted (accounted)
+);`
+
+	_, err = db.db.Exec(batchJobTableSQL)
+	return err
+}
+
+// SaveBatchInput 保存批处理输入文件的摘要
+func (db *DB) SaveBatchInput(fileID string, summary *batch.InputSummary) error {
+	defer metrics.ObserveStorage("SaveBatchInput", time.Now())
+
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+
+	sqlStmt := `
+	INSERT INTO batch_inputs (file_id, summary, created_at)
+	VALUES (?, ?, ?)
+	ON DUPLICATE KEY UPDATE summary = VALUES(summary)
+	`
+
+	_, err = db.db.Exec(sqlStmt, fileID, string(data), time.Now())
+	return err
+}
+
+// GetBatchInput 获取批处理输入文件的摘要，不存在时返回 nil
+func (db *DB) GetBatchInput(fileID string) (*batch.InputSummary, error) {
+	defer metrics.ObserveStorage("GetBatchInput", time.Now())
+
+	var data string
+	err := db.db.QueryRow(`SELECT summary FROM batch_inputs WHERE file_id = ?`, fileID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var summary batch.InputSummary
+	if err := json.Unmarshal([]byte(data), &summary); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// SaveBatchJob 保存批处理任务，已存在时更新状态及用量，不修改提交者
+func (db *DB) SaveBatchJob(job *batch.Job) error {
+	defer metrics.ObserveStorage("SaveBatchJob", time.Now())
+
+	models, _ := json.Marshal(job.Models)
+
+	sqlStmt := `
+	INSERT INTO batch_jobs (batch_id, upstream, subject, email, team_id, endpoint, status, upstream_key, input_file_id,
+		output_file_id, error_file_id, requests, completed_requests, failed_requests, models, estimated_cost,
+		prompt_tokens, completion_tokens, cost, accounted, created_at, updated_at, completed_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON DUPLICATE KEY UPDATE
+		endpoint = VALUES(endpoint),
+		status = VALUES(status),
+		output_file_id = VALUES(output_file_id),
+		error_file_id = VALUES(error_file_id),
+		requests = VALUES(requests),
+		completed_requests = VALUES(completed_requests),
+		failed_requests = VALUES(failed_requests),
+		prompt_tokens = VALUES(prompt_tokens),
+		completion_tokens = VALUES(completion_tokens),
+		cost = VALUES(cost),
+		updated_at = VALUES(updated_at),
+		completed_at = VALUES(completed_at)
+	`
+
+	_, err := db.db.Exec(sqlStmt, job.ID, job.Upstream, job.Subject, job.Email, job.TeamID, job.Endpoint, job.Status,
+		job.UpstreamKey, job.InputFileID, job.OutputFileID, job.ErrorFileID, job.Requests, job.CompletedRequests,
+		job.FailedRequests, string(models), job.EstimatedCost, job.PromptTokens, job.CompletionTokens, job.Cost,
+		job.Accounted, job.CreatedAt, job.UpdatedAt, job.CompletedAt)
+	return err
+}
+
+// batchJobColumns 查询批处理任务时使用的字段列表，与 scanBatchJob 顺序一致
+const batchJobColumns = `batch_id, upstream, subject, email, team_id, endpoint, status, upstream_key, input_file_id,
+	output_file_id, error_file_id, requests, completed_requests, failed_requests, models, estimated_cost,
+	prompt_tokens, completion_tokens, cost, accounted, created_at, updated_at, completed_at`
+
+// scanBatchJob 从查询结果中读取批处理任务
+func scanBatchJob(scanner interface{ Scan(dest ...any) error }) (*batch.Job, error) {
+	var job batch.Job
+	var models sql.NullString
+	var completedAt sql.NullTime
+
+	err := scanner.Scan(&job.ID, &job.Upstream, &job.Subject, &job.Email, &job.TeamID, &job.Endpoint, &job.Status,
+		&job.UpstreamKey, &job.InputFileID, &job.OutputFileID, &job.ErrorFileID, &job.Requests, &job.CompletedRequests,
+		&job.FailedRequests, &models, &job.EstimatedCost, &job.PromptTokens, &job.CompletionTokens, &job.Cost,
+		&job.Accounted, &job.CreatedAt, &job.UpdatedAt, &completedAt)
+	if err != nil {
+		return nil, err
+	}
+	if models.Valid && models.String != "" {
+		_ = json.Unmarshal([]byte(models.String), &job.Models)
+	}
+	if completedAt.Valid {
+		job.CompletedAt = &completedAt.Time
+	}
+
+	return &job, nil
+}
+
+// queryBatchJobs 执行查询并读取所有批处理任务
+func (db *DB) queryBatchJobs(sqlStmt string, args ...any) ([]*batch.Job, error) {
+	rows, err := db.db.Query(sqlStmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []*batch.Job{}
+	for rows.Next() {
+		job, err := scanBatchJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// GetBatchJob 获取批处理任务，不存在时返回 nil
+func (db *DB) GetBatchJob(id string) (*batch.Job, error) {
+	defer metrics.ObserveStorage("GetBatchJob", time.Now())
+
+	job, err := scanBatchJob(db.db.QueryRow(`SELECT `+batchJobColumns+` FROM batch_jobs WHERE batch_id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return job, err
+}
+
+// ListPendingBatchJobs 列出网关可轮询且用量尚未统计的批处理任务
+func (db *DB) ListPendingBatchJobs() ([]*batch.Job, error) {
+	defer metrics.ObserveStorage("ListPendingBatchJobs", time.Now())
+
+	sqlStmt := `
+	SELECT ` + batchJobColumns + `
+	FROM batch_jobs
+	WHERE accounted = FALSE AND upstream_key = FALSE
+	ORDER BY created_at
+	`
+
+	return db.queryBatchJobs(sqlStmt)
+}
+
+// ClaimBatchAccounting 标记批处理任务的用量已统计，多个实例同时统计时仅有一个返回 true
+func (db *DB) ClaimBatchAccounting(id string) (bool, error) {
+	defer metrics.ObserveStorage("ClaimBatchAccounting", time.Now())
+
+	result, err := db.db.Exec(`UPDATE batch_jobs SET accounted = TRUE WHERE batch_id = ? AND accounted = FALSE`, id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// ListBatchJobs 按条件列出批处理任务
+func (db *DB) ListBatchJobs(filter *BatchFilter) ([]*batch.Job, error) {
+	defer metrics.ObserveStorage("ListBatchJobs", time.Now())
+
+	conditions := []string{"1 = 1"}
+	args := []any{}
+	if filter.User != "" {
+		conditions = append(conditions, "(subject = ? OR email = ?)")
+		args = append(args, filter.User, filter.User)
+	}
+	if filter.TeamID != "" {
+		conditions = append(conditions, "team_id = ?")
+		args = append(args, filter.TeamID)
+	}
+	if filter.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, filter.Status)
+	}
+	limit := filter.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	args = append(args, limit)
+
+	sqlStmt := `
+	SELECT ` + batchJobColumns + `
+	FROM batch_jobs
+	WHERE ` + strings.Join(conditions, " AND ") + `
+	ORDER BY created_at DESC
+	LIMIT ?
+	`
+
+	return db.queryBatchJobs(sqlStmt, args...)
+}
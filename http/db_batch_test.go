@@ -0,0 +1,47 @@
+package http
+
+import (
+	"openai-forward/batch"
+	"testing"
+	"time"
+)
+
+func TestDB_SaveAndClaimBatchJob(t *testing.T) {
+	// 测试记录批处理任务、统计用量时的抢占及按用户查询
+	db := GetTestDB()
+	defer db.Close()
+
+	summary := &batch.InputSummary{Requests: 1, Endpoint: "/v1/chat/completions",
+		Models: map[string]*batch.ModelEstimate{"gpt-4o": {Requests: 1, PromptTokens: 10}}}
+	if err := db.SaveBatchInput("file-test-batch-input", summary); err != nil {
+		t.Fatalf("Failed to save batch input: %v", err)
+	}
+	input, err := db.GetBatchInput("file-test-batch-input")
+	if err != nil || input == nil || input.Models["gpt-4o"].PromptTokens != 10 {
+		t.Fatalf("Unexpected batch input: %+v, %v", input, err)
+	}
+
+	now := time.Now()
+	job := &batch.Job{ID: "batch_test", Upstream: "openai", Subject: "test-batch-subject", Status: batch.STATUS_VALIDATING,
+		InputFileID: "file-test-batch-input", Models: map[string]int{"gpt-4o": 1}, CreatedAt: now, UpdatedAt: now}
+	if err := db.SaveBatchJob(job); err != nil {
+		t.Fatalf("Failed to save batch job: %v", err)
+	}
+
+	claimed, err := db.ClaimBatchAccounting("batch_test")
+	if err != nil || !claimed {
+		t.Fatalf("Expected first claim to succeed: %v", err)
+	}
+	claimed, err = db.ClaimBatchAccounting("batch_test")
+	if err != nil || claimed {
+		t.Fatalf("Expected second claim to fail: %v", err)
+	}
+
+	jobs, err := db.ListBatchJobs(&BatchFilter{User: "test-batch-subject"})
+	if err != nil {
+		t.Fatalf("Failed to list batch jobs: %v", err)
+	}
+	if len(jobs) != 1 || !jobs[0].Accounted || jobs[0].Models["gpt-4o"] != 1 {
+		t.Errorf("Unexpected jobs: %+v", jobs)
+	}
+}
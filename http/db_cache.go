@@ -0,0 +1,89 @@
+package http
+
+import (
+	"database/sql"
+	"openai-forward/cache"
+	"openai-forward/metrics"
+	"time"
+)
+
+// initCacheTables 初始化响应缓存数据表
+func (db *DB) initCacheTables() error {
+	cacheTableSQL := `
+CREATE TABLE IF NOT EXISTS response_cache (
+	cache_key CHAR(64) PRIMARY KEY,
+	status INT NOT NULL,
+	content_type VARCHAR(255) NOT NULL DEFAULT '',
+	body MEDIUMBLOB NOT NULL,
+	expires_at DATETIME(3) NOT NULL,
+	INDEX idx_response_cache_expires_at (expires_at)
+);`
+
+	_, err := db.db.Exec(cacheTableSQL)
+	return err
+}
+
+// GetCacheEntry 获取未过期的缓存响应，不存在时返回 nil
+func (db *DB) GetCacheEntry(key string) (*cache.Entry, error) {
+	defer metrics.ObserveStorage("GetCacheEntry", time.Now())
+
+	var entry cache.Entry
+	err := db.db.QueryRow(`
+	SELECT status, content_type, body, expires_at
+	FROM response_cache
+	WHERE cache_key = ? AND expires_at > ?
+	`, key, time.Now()).Scan(&entry.Status, &entry.ContentType, &entry.Body, &entry.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// SaveCacheEntry 保存缓存响应
+func (db *DB) SaveCacheEntry(key string, entry *cache.Entry) error {
+	defer metrics.ObserveStorage("SaveCacheEntry", time.Now())
+
+	sqlStmt := `
+	INSERT INTO response_cache (cache_key, status, content_type, body, expires_at)
+	VALUES (?, ?, ?, ?, ?)
+	ON DUPLICATE KEY UPDATE
+	status = VALUES(status),
+	content_type = VALUES(content_type),
+	body = VALUES(body),
+	expires_at = VALUES(expires_at)
+	`
+
+	_, err := db.db.Exec(sqlStmt, key, entry.Status, entry.ContentType, entry.Body, entry.ExpiresAt)
+	return err
+}
+
+// DeleteExpiredCacheEntries 删除过期的缓存响应
+func (db *DB) DeleteExpiredCacheEntries() (int64, error) {
+	defer metrics.ObserveStorage("DeleteExpiredCacheEntries", time.Now())
+
+	result, err := db.db.Exec(`DELETE FROM response_cache WHERE expires_at <= ?`, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// storageCacheStore 使用存储保存缓存响应
+type storageCacheStore struct {
+	storage IStorage
+}
+
+// Get 实现 cache.Store
+func (s *storageCacheStore) Get(key string) (*cache.Entry, error) {
+	return s.storage.GetCacheEntry(key)
+}
+
+// Set 实现 cache.Store
+func (s *storageCacheStore) Set(key string, entry *cache.Entry) error {
+	return s.storage.SaveCacheEntry(key, entry)
+}
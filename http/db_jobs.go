@@ -0,0 +1,412 @@
+package http
+
+import (
+	"database/sql"
+	"errors"
+	"openai-forward/jobs"
+	"openai-forward/metrics"
+	"strings"
+	"time"
+)
+
+// JobFilter 任务查询条件，零值字段不参与过滤
+type JobFilter struct {
+	// User 用户 subject 或邮箱
+	User   string
+	TeamID string
+	Status string
+	Limit  int
+}
+
+// jobItemInsertBatch 保存任务请求时每条 INSERT 语句包含的行数
+const jobItemInsertBatch = 500
+
+// initJobTables 初始化任务队列数据表
+func (db *DB) initJobTables() error {
+	jobTableSQL := `
+CREATE TABLE IF NOT EXISTS jobs (
+	job_id VARCHAR(64) PRIMARY KEY,
+	subject VARCHAR(255) NOT NULL DEFAULT '',
+	email VARCHAR(255) NOT NULL DEFAULT '',
+	team_id VARCHAR(64) NOT NULL DEFAULT '',
+	upstream VARCHAR(32) NOT NULL,
+	endpoint VARCHAR(64) NOT NULL DEFAULT '',
+	status VARCHAR(32) NOT NULL,
+	total INT NOT NULL DEFAULT 0,
+	succeeded INT NOT NULL DEFAULT 0,
+	failed INT NOT NULL DEFAULT 0,
+	prompt_tokens BIGINT NOT NULL DEFAULT 0,
+	completion_tokens BIGINT NOT NULL DEFAULT 0,
+	created_at DATETIME(3) NOT NULL,
+	updated_at DATETIME(3) NOT NULL,
+	started_at DATETIME(3) NULL,
+	completed_at DATETIME(3) NULL,
+	INDEX idx_jobs_status (status, created_at),
+	INDEX idx_jobs_subject (subject),
+	INDEX idx_jobs_team_id (team_id)
+);`
+
+	_, err := db.db.Exec(jobTableSQL)
+	if err != nil {
+		return err
+	}
+
+	jobItemTableSQL := `
+CREATE TABLE IF NOT EXISTS job_items (
+	job_id VARCHAR(64) NOT NULL,
+	item_index INT NOT NULL,
+	custom_id VARCHAR(255) NOT NULL,
+	url VARCHAR(64) NOT NULL,
+	model VARCHAR(255) NOT NULL DEFAULT '',
+	body MEDIUMTEXT NOT NULL,
+	status VARCHAR(32) NOT NULL,
+	status_code INT NOT NULL DEFAULT 0,
+	response MEDIUMTEXT NULL,
+	prompt_tokens INT NOT NULL DEFAULT 0,
+	completion_tokens INT NOT NULL DEFAULT 0,
+	updated_at DATETIME(3) NULL,
+	PRIMARY KEY (job_id, item_index),
+	INDEX idx_job_items_status (job_id, status)
+);`
+
+	_, err = db.db.Exec(jobItemTableSQL)
+	return err
+}
+
+// SaveJob 在事务中保存新提交的任务及其请求
+func (db *DB) SaveJob(job *jobs.Job, items []*jobs.Item) error {
+	defer metrics.ObserveStorage("SaveJob", time.Now())
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	sqlStmt := `
+	INSERT INTO jobs (job_id, subject, email, team_id, upstream, endpoint, status, total, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = tx.Exec(sqlStmt, job.ID, job.Subject, job.Email, job.TeamID, job.Upstream, job.Endpoint, job.Status,
+		job.Total, job.CreatedAt, job.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(items); start += jobItemInsertBatch {
+		chunk := items[start:min(start+jobItemInsertBatch, len(items))]
+		args := make([]any, 0, len(chunk)*7)
+		for _, item := range chunk {
+			args = append(args, job.ID, item.Index, item.CustomID, item.URL, item.Model, string(item.Body), item.Status)
+		}
+		sqlStmt := `
+		INSERT INTO job_items (job_id, item_index, custom_id, url, model, body, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?)` + strings.Repeat(", (?, ?, ?, ?, ?, ?, ?)", len(chunk)-1)
+		if _, err := tx.Exec(sqlStmt, args...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// jobColumns 查询任务时使用的字段列表，与 scanJob 顺序一致
+const jobColumns = `job_id, subject, email, team_id, upstream, endpoint, status, total, succeeded, failed,
+	prompt_tokens, completion_tokens, created_at, updated_at, started_at, completed_at`
+
+// scanJob 从查询结果中读取任务
+func scanJob(scanner interface{ Scan(dest ...any) error }) (*jobs.Job, error) {
+	var job jobs.Job
+	var startedAt, completedAt sql.NullTime
+
+	err := scanner.Scan(&job.ID, &job.Subject, &job.Email, &job.TeamID, &job.Upstream, &job.Endpoint, &job.Status,
+		&job.Total, &job.Succeeded, &job.Failed, &job.PromptTokens, &job.CompletionTokens, &job.CreatedAt,
+		&job.UpdatedAt, &startedAt, &completedAt)
+	if err != nil {
+		return nil, err
+	}
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if completedAt.Valid {
+		job.CompletedAt = &completedAt.Time
+	}
+
+	return &job, nil
+}
+
+// GetJob 获取任务，不存在时返回 nil
+func (db *DB) GetJob(id string) (*jobs.Job, error) {
+	defer metrics.ObserveStorage("GetJob", time.Now())
+
+	job, err := scanJob(db.db.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE job_id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return job, err
+}
+
+// ClaimJob 领取最早排队的任务或心跳超时的执行中任务，多个实例同时领取时仅有一个成功
+func (db *DB) ClaimJob(staleBefore time.Time) (*jobs.Job, error) {
+	defer metrics.ObserveStorage("ClaimJob", time.Now())
+
+	condition := `(status = ? OR (status = ? AND updated_at < ?))`
+	rows, err := db.db.Query(`SELECT job_id FROM jobs WHERE `+condition+` ORDER BY created_at LIMIT 5`,
+		jobs.STATUS_QUEUED, jobs.STATUS_RUNNING, staleBefore)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, id := range ids {
+		result, err := db.db.Exec(`
+		UPDATE jobs SET status = ?, started_at = COALESCE(started_at, ?), updated_at = ?
+		WHERE job_id = ? AND `+condition,
+			jobs.STATUS_RUNNING, now, now, id, jobs.STATUS_QUEUED, jobs.STATUS_RUNNING, staleBefore)
+		if err != nil {
+			return nil, err
+		}
+		if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+			continue
+		}
+		return db.GetJob(id)
+	}
+	return nil, nil
+}
+
+// jobItemColumns 查询任务请求时使用的字段列表，与 scanJobItem 顺序一致
+const jobItemColumns = `job_id, item_index, custom_id, url, model, body, status, status_code, response,
+	prompt_tokens, completion_tokens`
+
+// scanJobItem 从查询结果中读取任务请求
+func scanJobItem(scanner interface{ Scan(dest ...any) error }) (*jobs.Item, error) {
+	var item jobs.Item
+	var body string
+	var response sql.NullString
+
+	err := scanner.Scan(&item.JobID, &item.Index, &item.CustomID, &item.URL, &item.Model, &body, &item.Status,
+		&item.StatusCode, &response, &item.PromptTokens, &item.CompletionTokens)
+	if err != nil {
+		return nil, err
+	}
+	item.Body = []byte(body)
+	if response.Valid {
+		item.Response = []byte(response.String)
+	}
+
+	return &item, nil
+}
+
+// queryJobItems 执行查询并读取所有任务请求
+func (db *DB) queryJobItems(sqlStmt string, args ...any) ([]*jobs.Item, error) {
+	rows, err := db.db.Query(sqlStmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*jobs.Item{}
+	for rows.Next() {
+		item, err := scanJobItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// ListPendingJobItems 按顺序列出任务中尚未执行的请求
+func (db *DB) ListPendingJobItems(jobID string, limit int) ([]*jobs.Item, error) {
+	defer metrics.ObserveStorage("ListPendingJobItems", time.Now())
+
+	sqlStmt := `
+	SELECT ` + jobItemColumns + `
+	FROM job_items
+	WHERE job_id = ? AND status = ?
+	ORDER BY item_index
+	LIMIT ?
+	`
+
+	return db.queryJobItems(sqlStmt, jobID, jobs.ITEM_PENDING, limit)
+}
+
+// ListJobResults 按顺序列出任务中 index 大于 after 的已执行请求，用于分页导出结果
+func (db *DB) ListJobResults(jobID string, after int, limit int) ([]*jobs.Item, error) {
+	defer metrics.ObserveStorage("ListJobResults", time.Now())
+
+	sqlStmt := `
+	SELECT ` + jobItemColumns + `
+	FROM job_items
+	WHERE job_id = ? AND item_index > ? AND status != ?
+	ORDER BY item_index
+	LIMIT ?
+	`
+
+	return db.queryJobItems(sqlStmt, jobID, after, jobs.ITEM_PENDING, limit)
+}
+
+// SaveJobItemResult 保存请求的执行结果
+func (db *DB) SaveJobItemResult(item *jobs.Item) error {
+	defer metrics.ObserveStorage("SaveJobItemResult", time.Now())
+
+	sqlStmt := `
+	UPDATE job_items
+	SET status = ?, status_code = ?, response = ?, prompt_tokens = ?, completion_tokens = ?, updated_at = ?
+	WHERE job_id = ? AND item_index = ?
+	`
+
+	_, err := db.db.Exec(sqlStmt, item.Status, item.StatusCode, string(item.Response), item.PromptTokens,
+		item.CompletionTokens, time.Now(), item.JobID, item.Index)
+	return err
+}
+
+// RefreshJobProgress 按请求结果汇总任务进度及用量，并更新心跳时间
+func (db *DB) RefreshJobProgress(id string) error {
+	defer metrics.ObserveStorage("RefreshJobProgress", time.Now())
+
+	sqlStmt := `
+	UPDATE jobs j
+	JOIN (
+		SELECT
+			SUM(status = ?) AS succeeded,
+			SUM(status = ?) AS failed,
+			SUM(prompt_tokens) AS prompt_tokens,
+			SUM(completion_tokens) AS completion_tokens
+		FROM job_items
+		WHERE job_id = ?
+	) p
+	SET j.succeeded = COALESCE(p.succeeded, 0),
+		j.failed = COALESCE(p.failed, 0),
+		j.prompt_tokens = COALESCE(p.prompt_tokens, 0),
+		j.completion_tokens = COALESCE(p.completion_tokens, 0),
+		j.updated_at = ?
+	WHERE j.job_id = ?
+	`
+
+	_, err := db.db.Exec(sqlStmt, jobs.ITEM_SUCCEEDED, jobs.ITEM_FAILED, id, time.Now(), id)
+	return err
+}
+
+// FinishJob 将执行中的任务标记为结束，任务已被取消时不修改
+func (db *DB) FinishJob(id string, status string) error {
+	defer metrics.ObserveStorage("FinishJob", time.Now())
+
+	now := time.Now()
+	_, err := db.db.Exec(`UPDATE jobs SET status = ?, completed_at = ?, updated_at = ? WHERE job_id = ? AND status = ?`,
+		status, now, now, id, jobs.STATUS_RUNNING)
+	return err
+}
+
+// CancelJob 取消排队或执行中的任务，任务已结束时返回 false
+func (db *DB) CancelJob(id string) (bool, error) {
+	defer metrics.ObserveStorage("CancelJob", time.Now())
+
+	now := time.Now()
+	result, err := db.db.Exec(`
+	UPDATE jobs SET status = ?, completed_at = ?, updated_at = ?
+	WHERE job_id = ? AND status IN (?, ?)
+	`, jobs.STATUS_CANCELLED, now, now, id, jobs.STATUS_QUEUED, jobs.STATUS_RUNNING)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// CancelUserJobs 取消用户（subject 或邮箱）排队或执行中的所有任务，返回取消的数量
+func (db *DB) CancelUserJobs(user string) (int64, error) {
+	defer metrics.ObserveStorage("CancelUserJobs", time.Now())
+
+	now := time.Now()
+	result, err := db.db.Exec(`
+	UPDATE jobs SET status = ?, completed_at = ?, updated_at = ?
+	WHERE (subject = ? OR email = ?) AND status IN (?, ?)
+	`, jobs.STATUS_CANCELLED, now, now, user, user, jobs.STATUS_QUEUED, jobs.STATUS_RUNNING)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ListJobs 按条件列出任务
+func (db *DB) ListJobs(filter *JobFilter) ([]*jobs.Job, error) {
+	defer metrics.ObserveStorage("ListJobs", time.Now())
+
+	conditions := []string{"1 = 1"}
+	args := []any{}
+	if filter.User != "" {
+		conditions = append(conditions, "(subject = ? OR email = ?)")
+		args = append(args, filter.User, filter.User)
+	}
+	if filter.TeamID != "" {
+		conditions = append(conditions, "team_id = ?")
+		args = append(args, filter.TeamID)
+	}
+	if filter.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, filter.Status)
+	}
+	limit := filter.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	args = append(args, limit)
+
+	sqlStmt := `
+	SELECT ` + jobColumns + `
+	FROM jobs
+	WHERE ` + strings.Join(conditions, " AND ") + `
+	ORDER BY created_at DESC
+	LIMIT ?
+	`
+
+	rows, err := db.db.Query(sqlStmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []*jobs.Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, job)
+	}
+
+	return list, rows.Err()
+}
+
+// DeleteJobsBefore 删除结束时间早于指定时间的任务及其请求
+func (db *DB) DeleteJobsBefore(before time.Time) (int64, error) {
+	defer metrics.ObserveStorage("DeleteJobsBefore", time.Now())
+
+	finished := `status IN (?, ?) AND completed_at < ?`
+	_, err := db.db.Exec(`DELETE FROM job_items WHERE job_id IN (SELECT job_id FROM jobs WHERE `+finished+`)`,
+		jobs.STATUS_COMPLETED, jobs.STATUS_CANCELLED, before)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := db.db.Exec(`DELETE FROM jobs WHERE `+finished, jobs.STATUS_COMPLETED, jobs.STATUS_CANCELLED, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,73 @@
+package http
+
+import (
+	"openai-forward/batch"
+	"openai-forward/jobs"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDB_JobLifecycle(t *testing.T) {
+	// 测试保存任务、领取、保存结果、汇总进度及取消
+	db := GetTestDB()
+	defer db.Close()
+
+	input := `{"custom_id":"a","method":"POST","url":"/v1/embeddings","body":{"model":"text-embedding-3-small","input":"a"}}
+{"custom_id":"b","method":"POST","url":"/v1/embeddings","body":{"model":"text-embedding-3-small","input":"b"}}`
+	requests, summary, err := batch.ParseRequests(strings.NewReader(input), nil)
+	if err != nil {
+		t.Fatalf("Failed to parse requests: %v", err)
+	}
+	job, items := jobs.New("openai", requests, summary)
+	job.Subject = "test-job-subject"
+	if err := db.SaveJob(job, items); err != nil {
+		t.Fatalf("Failed to save job: %v", err)
+	}
+
+	claimed, err := db.ClaimJob(time.Now().Add(-time.Minute))
+	if err != nil || claimed == nil || claimed.Status != jobs.STATUS_RUNNING {
+		t.Fatalf("Expected to claim a job: %+v, %v", claimed, err)
+	}
+
+	pending, err := db.ListPendingJobItems(job.ID, 10)
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("Expected 2 pending items: %v", err)
+	}
+	pending[0].Complete(200, []byte(`{"usage":{"prompt_tokens":4,"total_tokens":4}}`))
+	if err := db.SaveJobItemResult(pending[0]); err != nil {
+		t.Fatalf("Failed to save result: %v", err)
+	}
+	if err := db.RefreshJobProgress(job.ID); err != nil {
+		t.Fatalf("Failed to refresh progress: %v", err)
+	}
+
+	results, err := db.ListJobResults(job.ID, -1, 10)
+	if err != nil || len(results) != 1 || results[0].CustomID != "a" {
+		t.Fatalf("Unexpected results: %+v, %v", results, err)
+	}
+
+	cancelled, err := db.CancelJob(job.ID)
+	if err != nil || !cancelled {
+		t.Fatalf("Expected job to be cancelled: %v", err)
+	}
+	stored, err := db.GetJob(job.ID)
+	if err != nil || stored.Status != jobs.STATUS_CANCELLED || stored.Succeeded != 1 || stored.PromptTokens != 4 {
+		t.Errorf("Unexpected job: %+v, %v", stored, err)
+	}
+
+	// 吊销用户时取消其排队中的任务
+	other, otherItems := jobs.New("openai", requests, summary)
+	other.Subject = "test-job-revoked-" + other.ID
+	if err := db.SaveJob(other, otherItems); err != nil {
+		t.Fatalf("Failed to save job: %v", err)
+	}
+	if n, err := db.CancelUserJobs(other.Subject); err != nil || n != 1 {
+		t.Errorf("Expected 1 job of the user to be cancelled, got %d: %v", n, err)
+	}
+
+	list, err := db.ListJobs(&JobFilter{User: "test-job-subject"})
+	if err != nil || len(list) == 0 {
+		t.Errorf("Expected to list jobs: %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+package http
+
+import (
+	"database/sql"
+	"encoding/json"
+	"openai-forward/metrics"
+	"openai-forward/notify"
+	"strings"
+	"time"
+)
+
+// initNotifyTables 初始化通知发件箱数据表
+func (db *DB) initNotifyTables() error {
+	outboxTableSQL := `
+CREATE TABLE IF NOT EXISTS notification_outbox (
+	delivery_id VARCHAR(64) PRIMARY KEY,
+	event_id VARCHAR(64) NOT NULL,
+	event_type VARCHAR(64) NOT NULL,
+	target VARCHAR(128) NOT NULL,
+	event TEXT NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	last_status INT NOT NULL DEFAULT 0,
+	last_error VARCHAR(512) NOT NULL DEFAULT '',
+	next_attempt_at DATETIME(3) NOT NULL,
+	created_at DATETIME(3) NOT NULL,
+	updated_at DATETIME(3) NOT NULL,
+	delivered_at DATETIME(3) NULL,
+	INDEX idx_notification_outbox_due (status, next_attempt_at),
+	INDEX idx_notification_outbox_created_at (created_at)
+);`
+
+	_, err := db.db.Exec(outboxTableSQL)
+	return err
+}
+
+// SaveDeliveries 保存新的通知
+func (db *DB) SaveDeliveries(deliveries []*notify.Delivery) error {
+	defer metrics.ObserveStorage("SaveDeliveries", time.Now())
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	args := make([]any, 0, len(deliveries)*10)
+	for _, delivery := range deliveries {
+		event, err := json.Marshal(delivery.Event)
+		if err != nil {
+			return err
+		}
+		args = append(args, delivery.ID, delivery.Event.ID, delivery.Event.Type, delivery.Target, string(event),
+			delivery.Status, delivery.Attempts, delivery.NextAttemptAt, delivery.CreatedAt, delivery.UpdatedAt)
+	}
+	sqlStmt := `
+	INSERT INTO notification_outbox (delivery_id, event_id, event_type, target, event, status, attempts,
+		next_attempt_at, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)` + strings.Repeat(", (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", len(deliveries)-1)
+	_, err := db.db.Exec(sqlStmt, args...)
+	return err
+}
+
+// deliveryColumns 查询通知时使用的字段列表，与 scanDelivery 顺序一致
+const deliveryColumns = `delivery_id, target, event, status, attempts, last_status, last_error, next_attempt_at,
+	created_at, updated_at, delivered_at`
+
+// scanDelivery 从查询结果中读取通知
+func scanDelivery(scanner interface{ Scan(dest ...any) error }) (*notify.Delivery, error) {
+	var delivery notify.Delivery
+	var event string
+	var deliveredAt sql.NullTime
+
+	err := scanner.Scan(&delivery.ID, &delivery.Target, &event, &delivery.Status, &delivery.Attempts,
+		&delivery.LastStatus, &delivery.LastError, &delivery.NextAttemptAt, &delivery.CreatedAt, &delivery.UpdatedAt,
+		&deliveredAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(event), &delivery.Event); err != nil {
+		return nil, err
+	}
+	if deliveredAt.Valid {
+		delivery.DeliveredAt = &deliveredAt.Time
+	}
+	return &delivery, nil
+}
+
+// ClaimDeliveries 领取到期的待发送通知，多个实例同时领取时仅有一个成功
+func (db *DB) ClaimDeliveries(now time.Time, leaseUntil time.Time, limit int) ([]*notify.Delivery, error) {
+	defer metrics.ObserveStorage("ClaimDeliveries", time.Now())
+
+	rows, err := db.db.Query(`
+	SELECT `+deliveryColumns+` FROM notification_outbox
+	WHERE status = ? AND next_attempt_at <= ?
+	ORDER BY next_attempt_at
+	LIMIT ?`, notify.STATUS_PENDING, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	due := []*notify.Delivery{}
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		due = append(due, delivery)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	claimed := []*notify.Delivery{}
+	for _, delivery := range due {
+		result, err := db.db.Exec(`
+		UPDATE notification_outbox SET next_attempt_at = ?
+		WHERE delivery_id = ? AND status = ? AND next_attempt_at = ?`,
+			leaseUntil, delivery.ID, notify.STATUS_PENDING, delivery.NextAttemptAt)
+		if err != nil {
+			return nil, err
+		}
+		if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+			continue
+		}
+		delivery.NextAttemptAt = leaseUntil
+		claimed = append(claimed, delivery)
+	}
+	return claimed, nil
+}
+
+// UpdateDelivery 保存发送结果
+func (db *DB) UpdateDelivery(delivery *notify.Delivery) error {
+	defer metrics.ObserveStorage("UpdateDelivery", time.Now())
+
+	_, err := db.db.Exec(`
+	UPDATE notification_outbox
+	SET status = ?, attempts = ?, last_status = ?, last_error = ?, next_attempt_at = ?, updated_at = ?, delivered_at = ?
+	WHERE delivery_id = ?`,
+		delivery.Status, delivery.Attempts, delivery.LastStatus, delivery.LastError, delivery.NextAttemptAt,
+		delivery.UpdatedAt, delivery.DeliveredAt, delivery.ID)
+	return err
+}
+
+// ListDeliveries 按条件查询通知，按创建时间倒序
+func (db *DB) ListDeliveries(filter *notify.DeliveryFilter) ([]*notify.Delivery, error) {
+	defer metrics.ObserveStorage("ListDeliveries", time.Now())
+
+	conditions := []string{"1 = 1"}
+	args := []any{}
+	if filter.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, filter.Status)
+	}
+	if filter.EventType != "" {
+		conditions = append(conditions, "event_type = ?")
+		args = append(args, filter.EventType)
+	}
+	if filter.Target != "" {
+		conditions = append(conditions, "target = ?")
+		args = append(args, filter.Target)
+	}
+	limit := filter.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	args = append(args, limit)
+
+	rows, err := db.db.Query(`
+	SELECT `+deliveryColumns+`
+	FROM notification_outbox
+	WHERE `+strings.Join(conditions, " AND ")+`
+	ORDER BY created_at DESC
+	LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []*notify.Delivery{}
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, delivery)
+	}
+	return list, rows.Err()
+}
+
+// DeleteDeliveriesBefore 删除指定时间前创建且已结束的通知
+func (db *DB) DeleteDeliveriesBefore(before time.Time) (int64, error) {
+	defer metrics.ObserveStorage("DeleteDeliveriesBefore", time.Now())
+
+	result, err := db.db.Exec(`DELETE FROM notification_outbox WHERE status <> ? AND created_at < ?`,
+		notify.STATUS_PENDING, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
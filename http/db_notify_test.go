@@ -0,0 +1,62 @@
+package http
+
+import (
+	"openai-forward/notify"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDB_NotificationOutbox(t *testing.T) {
+	// 测试保存通知、领取、保存结果、查询及清理
+	db := GetTestDB()
+	defer db.Close()
+
+	now := time.Now().Truncate(time.Millisecond)
+	event := &notify.Event{ID: uuid.New().String(), Type: notify.EVENT_QUOTA_EXHAUSTED, Severity: notify.SEVERITY_CRITICAL,
+		Time: now, Summary: "quota", Attributes: map[string]string{"upstream": "openai"}}
+	delivery := &notify.Delivery{ID: uuid.New().String(), Target: "test-outbox", Event: event, Status: notify.STATUS_PENDING,
+		NextAttemptAt: now, CreatedAt: now, UpdatedAt: now}
+	if err := db.SaveDeliveries([]*notify.Delivery{delivery}); err != nil {
+		t.Fatalf("Failed to save delivery: %v", err)
+	}
+
+	claimed, err := db.ClaimDeliveries(now, now.Add(time.Minute), 100)
+	if err != nil {
+		t.Fatalf("Failed to claim deliveries: %v", err)
+	}
+	var found *notify.Delivery
+	for _, d := range claimed {
+		if d.ID == delivery.ID {
+			found = d
+		}
+	}
+	if found == nil || found.Event.Attributes["upstream"] != "openai" {
+		t.Fatalf("Expected delivery to be claimed: %+v", claimed)
+	}
+	// 租约期间不会被再次领取
+	again, _ := db.ClaimDeliveries(now, now.Add(time.Minute), 100)
+	for _, d := range again {
+		if d.ID == delivery.ID {
+			t.Error("Expected claimed delivery to be leased")
+		}
+	}
+
+	found.Status = notify.STATUS_DELIVERED
+	found.Attempts = 1
+	found.LastStatus = 200
+	found.DeliveredAt = &now
+	if err := db.UpdateDelivery(found); err != nil {
+		t.Fatalf("Failed to update delivery: %v", err)
+	}
+	list, err := db.ListDeliveries(&notify.DeliveryFilter{Target: "test-outbox", Status: notify.STATUS_DELIVERED})
+	if err != nil || len(list) == 0 || list[0].Attempts != 1 || list[0].DeliveredAt == nil {
+		t.Errorf("Unexpected deliveries: %+v, %v", list, err)
+	}
+
+	deleted, err := db.DeleteDeliveriesBefore(now.Add(time.Second))
+	if err != nil || deleted == 0 {
+		t.Errorf("Expected delivered notification to be purged: %d, %v", deleted, err)
+	}
+}
@@ -0,0 +1,162 @@
+package http
+
+import (
+	"database/sql"
+	"openai-forward/metrics"
+	"openai-forward/ownership"
+	"strings"
+	"time"
+)
+
+// ResourceFilter 资源归属记录查询条件，零值字段不参与过滤
+type ResourceFilter struct {
+	// User 用户 subject 或邮箱
+	User   string
+	TeamID string
+	Type   string
+	// IncludeDeleted 是否包含已通过网关删除的资源
+	IncludeDeleted bool
+	Limit          int
+}
+
+// initOwnershipTables 初始化资源归属数据表
+func (db *DB) initOwnershipTables() error {
+	resourceTableSQL := `
+CREATE TABLE IF NOT EXISTS resource_owners (
+	resource_id VARCHAR(255) PRIMARY KEY,
+	resource_type VARCHAR(32) NOT NULL,
+	upstream VARCHAR(32) NOT NULL DEFAULT '',
+	subject VARCHAR(255) NOT NULL DEFAULT '',
+	email VARCHAR(255) NOT NULL DEFAULT '',
+	team_id VARCHAR(64) NOT NULL DEFAULT '',
+	created_at DATETIME(3) NOT NULL,
+	deleted_at DATETIME(3) NULL,
+	INDEX idx_resource_owners_subject (subject),
+	INDEX idx_resource_owners_team_id (team_id)
+);`
+
+	_, err := db.db.Exec(resourceTableSQL)
+	return err
+}
+
+// SaveResource 记录资源归属，已记录的资源保留原有归属
+func (db *DB) SaveResource(resource *ownership.Resource) error {
+	defer metrics.ObserveStorage("SaveResource", time.Now())
+
+	sqlStmt := `
+	INSERT INTO resource_owners (resource_id, resource_type, upstream, subject, email, team_id, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	ON DUPLICATE KEY UPDATE resource_id = resource_id
+	`
+
+	_, err := db.db.Exec(sqlStmt, resource.ID, resource.Type, resource.Upstream, resource.Subject, resource.Email,
+		resource.TeamID, resource.CreatedAt)
+	return err
+}
+
+// resourceColumns 查询资源归属时使用的字段列表，与 scanResource 顺序一致
+const resourceColumns = `resource_id, resource_type, upstream, subject, email, team_id, created_at, deleted_at`
+
+// scanResource 从查询结果中读取资源归属
+func scanResource(scanner interface{ Scan(dest ...any) error }) (*ownership.Resource, error) {
+	var resource ownership.Resource
+	var deletedAt sql.NullTime
+
+	err := scanner.Scan(&resource.ID, &resource.Type, &resource.Upstream, &resource.Subject, &resource.Email,
+		&resource.TeamID, &resource.CreatedAt, &deletedAt)
+	if err != nil {
+		return nil, err
+	}
+	if deletedAt.Valid {
+		resource.DeletedAt = &deletedAt.Time
+	}
+
+	return &resource, nil
+}
+
+// queryResources 执行查询并读取所有资源归属
+func (db *DB) queryResources(sqlStmt string, args ...any) ([]*ownership.Resource, error) {
+	rows, err := db.db.Query(sqlStmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	resources := []*ownership.Resource{}
+	for rows.Next() {
+		resource, err := scanResource(rows)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, resource)
+	}
+
+	return resources, rows.Err()
+}
+
+// GetResources 批量获取资源归属，未记录的资源不在结果中
+func (db *DB) GetResources(ids []string) ([]*ownership.Resource, error) {
+	defer metrics.ObserveStorage("GetResources", time.Now())
+
+	if len(ids) == 0 {
+		return []*ownership.Resource{}, nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	sqlStmt := `
+	SELECT ` + resourceColumns + `
+	FROM resource_owners
+	WHERE resource_id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)
+	`
+
+	return db.queryResources(sqlStmt, args...)
+}
+
+// MarkResourceDeleted 记录资源已通过网关删除
+func (db *DB) MarkResourceDeleted(id string, deletedAt time.Time) error {
+	defer metrics.ObserveStorage("MarkResourceDeleted", time.Now())
+
+	_, err := db.db.Exec(`UPDATE resource_owners SET deleted_at = ? WHERE resource_id = ?`, deletedAt, id)
+	return err
+}
+
+// ListResources 按条件列出资源归属记录
+func (db *DB) ListResources(filter *ResourceFilter) ([]*ownership.Resource, error) {
+	defer metrics.ObserveStorage("ListResources", time.Now())
+
+	conditions := []string{"1 = 1"}
+	args := []any{}
+	if filter.User != "" {
+		conditions = append(conditions, "(subject = ? OR email = ?)")
+		args = append(args, filter.User, filter.User)
+	}
+	if filter.TeamID != "" {
+		conditions = append(conditions, "team_id = ?")
+		args = append(args, filter.TeamID)
+	}
+	if filter.Type != "" {
+		conditions = append(conditions, "resource_type = ?")
+		args = append(args, filter.Type)
+	}
+	if !filter.IncludeDeleted {
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
+	limit := filter.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	args = append(args, limit)
+
+	sqlStmt := `
+	SELECT ` + resourceColumns + `
+	FROM resource_owners
+	WHERE ` + strings.Join(conditions, " AND ") + `
+	ORDER BY created_at DESC
+	LIMIT ?
+	`
+
+	return db.queryResources(sqlStmt, args...)
+}
@@ -0,0 +1,51 @@
+package http
+
+import (
+	"openai-forward/ownership"
+	"testing"
+	"time"
+)
+
+func TestDB_SaveAndListResources(t *testing.T) {
+	// 测试记录资源归属、标记删除及按用户查询
+	db := GetTestDB()
+	defer db.Close()
+
+	owner := &ownership.Owner{Subject: "test-resource-subject", TeamID: "test-team"}
+	err := db.SaveResource(ownership.NewResource("file-test-resource", ownership.TYPE_FILE, "openai", owner))
+	if err != nil {
+		t.Fatalf("Failed to save resource: %v", err)
+	}
+	// 重复记录时保留原有归属
+	err = db.SaveResource(ownership.NewResource("file-test-resource", ownership.TYPE_FILE, "openai", &ownership.Owner{Subject: "other"}))
+	if err != nil {
+		t.Fatalf("Failed to save resource: %v", err)
+	}
+
+	resources, err := db.GetResources([]string{"file-test-resource", "file-untracked"})
+	if err != nil {
+		t.Fatalf("Failed to get resources: %v", err)
+	}
+	if len(resources) != 1 || resources[0].Subject != "test-resource-subject" {
+		t.Errorf("Unexpected resources: %+v", resources)
+	}
+
+	err = db.MarkResourceDeleted("file-test-resource", time.Now())
+	if err != nil {
+		t.Fatalf("Failed to mark resource deleted: %v", err)
+	}
+	resources, err = db.ListResources(&ResourceFilter{User: "test-resource-subject"})
+	if err != nil {
+		t.Fatalf("Failed to list resources: %v", err)
+	}
+	if len(resources) != 0 {
+		t.Errorf("Expected deleted resources to be excluded, got %d", len(resources))
+	}
+	resources, err = db.ListResources(&ResourceFilter{User: "test-resource-subject", IncludeDeleted: true})
+	if err != nil {
+		t.Fatalf("Failed to list resources: %v", err)
+	}
+	if len(resources) != 1 || resources[0].DeletedAt == nil {
+		t.Errorf("Unexpected resources: %+v", resources)
+	}
+}
@@ -0,0 +1,169 @@
+package http
+
+import (
+	"database/sql"
+	"encoding/json"
+	"openai-forward/metrics"
+	"time"
+)
+
+// initTeamTables 初始化团队相关数据表
+func (db *DB) initTeamTables() error {
+	teamTableSQL := `
+CREATE TABLE IF NOT EXISTS teams (
+	team_id VARCHAR(64) PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	claim_values TEXT NULL,
+	openai_api_key TEXT NULL,
+	openai_org_id VARCHAR(255) NOT NULL DEFAULT '',
+	openai_project_id VARCHAR(255) NOT NULL DEFAULT '',
+	azure_endpoint VARCHAR(512) NOT NULL DEFAULT '',
+	azure_api_key TEXT NULL,
+	azure_api_version VARCHAR(64) NOT NULL DEFAULT '',
+	azure_model_mappings TEXT NULL,
+	models TEXT NULL,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);`
+
+	_, err := db.db.Exec(teamTableSQL)
+	if err != nil {
+		return err
+	}
+
+	// 为旧版本创建的表补充新增字段
+	err = db.ensureColumn("teams", "audit_enabled", "TINYINT(1) NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	return db.ensureColumn("teams", "priority", "VARCHAR(16) NOT NULL DEFAULT ''")
+}
+
+// SaveTeam 保存团队，密钥字段应已加密
+func (db *DB) SaveTeam(team *Team) error {
+	defer metrics.ObserveStorage("SaveTeam", time.Now())
+
+	sqlStmt := `
+	INSERT INTO teams (team_id, name, claim_values, openai_api_key, openai_org_id, openai_project_id,
+		azure_endpoint, azure_api_key, azure_api_version, azure_model_mappings, models, created_at, updated_at,
+		audit_enabled, priority)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON DUPLICATE KEY UPDATE
+	name = VALUES(name),
+	claim_values = VALUES(claim_values),
+	openai_api_key = VALUES(openai_api_key),
+	openai_org_id = VALUES(openai_org_id),
+	openai_project_id = VALUES(openai_project_id),
+	azure_endpoint = VALUES(azure_endpoint),
+	azure_api_key = VALUES(azure_api_key),
+	azure_api_version = VALUES(azure_api_version),
+	azure_model_mappings = VALUES(azure_model_mappings),
+	models = VALUES(models),
+	updated_at = VALUES(updated_at),
+	audit_enabled = VALUES(audit_enabled),
+	priority = VALUES(priority)
+	`
+
+	claimValues, _ := json.Marshal(team.ClaimValues)
+	mappings, _ := json.Marshal(team.AzureModelMappings)
+	models, _ := json.Marshal(team.Models)
+
+	_, err := db.db.Exec(sqlStmt, team.ID, team.Name, string(claimValues), team.OpenAIAPIKey, team.OpenAIOrgID,
+		team.OpenAIProjectID, team.AzureEndpoint, team.AzureAPIKey, team.AzureAPIVersion, string(mappings),
+		string(models), team.CreatedAt, team.UpdatedAt, team.AuditEnabled, team.Priority)
+	return err
+}
+
+// teamColumns 查询团队时使用的字段列表，与 scanTeam 顺序一致
+const teamColumns = `team_id, name, claim_values, openai_api_key, openai_org_id, openai_project_id,
+	azure_endpoint, azure_api_key, azure_api_version, azure_model_mappings, models, created_at, updated_at,
+	audit_enabled, priority`
+
+// scanTeam 从查询结果中读取团队
+func scanTeam(scanner interface{ Scan(dest ...any) error }) (*Team, error) {
+	var team Team
+	var claimValues, openaiAPIKey, azureAPIKey, mappings, models sql.NullString
+
+	err := scanner.Scan(&team.ID, &team.Name, &claimValues, &openaiAPIKey, &team.OpenAIOrgID, &team.OpenAIProjectID,
+		&team.AzureEndpoint, &azureAPIKey, &team.AzureAPIVersion, &mappings, &models, &team.CreatedAt, &team.UpdatedAt,
+		&team.AuditEnabled, &team.Priority)
+	if err != nil {
+		return nil, err
+	}
+
+	team.OpenAIAPIKey = openaiAPIKey.String
+	team.AzureAPIKey = azureAPIKey.String
+	if claimValues.Valid {
+		_ = json.Unmarshal([]byte(claimValues.String), &team.ClaimValues)
+	}
+	if mappings.Valid {
+		_ = json.Unmarshal([]byte(mappings.String), &team.AzureModelMappings)
+	}
+	if models.Valid {
+		_ = json.Unmarshal([]byte(models.String), &team.Models)
+	}
+
+	return &team, nil
+}
+
+// GetTeam 获取团队，不存在时返回 nil
+func (db *DB) GetTeam(id string) (*Team, error) {
+	defer metrics.ObserveStorage("GetTeam", time.Now())
+
+	sqlStmt := `
+	SELECT ` + teamColumns + `
+	FROM teams
+	WHERE team_id = ?
+	`
+
+	team, err := scanTeam(db.db.QueryRow(sqlStmt, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return team, nil
+}
+
+// ListTeams 列出所有团队
+func (db *DB) ListTeams() ([]*Team, error) {
+	defer metrics.ObserveStorage("ListTeams", time.Now())
+
+	sqlStmt := `
+	SELECT ` + teamColumns + `
+	FROM teams
+	ORDER BY name
+	`
+
+	rows, err := db.db.Query(sqlStmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	teams := []*Team{}
+	for rows.Next() {
+		team, err := scanTeam(rows)
+		if err != nil {
+			return nil, err
+		}
+		teams = append(teams, team)
+	}
+
+	return teams, rows.Err()
+}
+
+// DeleteTeam 删除团队，并解除密钥与该团队的关联
+func (db *DB) DeleteTeam(id string) error {
+	defer metrics.ObserveStorage("DeleteTeam", time.Now())
+
+	_, err := db.db.Exec(`DELETE FROM teams WHERE team_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.db.Exec(`UPDATE api_keys SET team_id = '' WHERE team_id = ?`, id)
+	return err
+}
@@ -0,0 +1,59 @@
+package http
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDB_SaveAndRetrieveTeam(t *testing.T) {
+	// 测试保存和获取团队
+	db := GetTestDB()
+	defer db.Close()
+
+	now := time.Now()
+	team := &Team{
+		ID:                 "test-team",
+		Name:               "Test Team",
+		ClaimValues:        []string{"engineering"},
+		OpenAIProjectID:    "proj-test",
+		AzureModelMappings: map[string]string{"gpt-4o": "gpt-4o-deployment"},
+		Models:             []string{"gpt-4o"},
+		CreatedAt:          now,
+		UpdatedAt:          now,
+	}
+
+	err := db.SaveTeam(team)
+	if err != nil {
+		t.Fatalf("Failed to save team: %v", err)
+	}
+
+	retrieved, err := db.GetTeam("test-team")
+	if err != nil {
+		t.Fatalf("Failed to retrieve team: %v", err)
+	}
+	if retrieved == nil {
+		t.Fatal("Retrieved team should not be nil")
+	}
+	if retrieved.Name != team.Name || retrieved.OpenAIProjectID != team.OpenAIProjectID {
+		t.Errorf("Unexpected team: %+v", retrieved)
+	}
+	if len(retrieved.ClaimValues) != 1 || retrieved.ClaimValues[0] != "engineering" {
+		t.Errorf("Unexpected claim values: %v", retrieved.ClaimValues)
+	}
+	if retrieved.AzureModelMappings["gpt-4o"] != "gpt-4o-deployment" {
+		t.Errorf("Unexpected model mappings: %v", retrieved.AzureModelMappings)
+	}
+
+	err = db.DeleteTeam("test-team")
+	if err != nil {
+		t.Fatalf("Failed to delete team: %v", err)
+	}
+
+	deleted, err := db.GetTeam("test-team")
+	if err != nil {
+		t.Fatalf("Failed to retrieve team: %v", err)
+	}
+	if deleted != nil {
+		t.Error("Team should have been deleted")
+	}
+}
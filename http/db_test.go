@@ -224,3 +224,36 @@ func TestDB_SaveAPIKeyReplace(t *testing.T) {
 		t.Errorf("ExpireAt was not updated: expected %v, got %v", updatedKey.ExpireAt, retrievedKey.ExpireAt)
 	}
 }
+
+func TestDB_DeleteAPIKey(t *testing.T) {
+	// 测试删除指定API密钥
+	db := GetTestDB()
+	defer db.Close()
+
+	apiKey := &APIKey{
+		Key:       "delete-key",
+		Type:      TEMPORARY_KEY,
+		CreatedAt: time.Now(),
+		ExpireAt:  time.Now().Add(time.Hour),
+		Subject:   "user-1",
+		Email:     "user@example.com",
+	}
+
+	err := db.SaveAPIKey(apiKey)
+	if err != nil {
+		t.Fatalf("Failed to save key: %v", err)
+	}
+
+	err = db.DeleteAPIKey("delete-key")
+	if err != nil {
+		t.Fatalf("Failed to delete key: %v", err)
+	}
+
+	deletedKey, err := db.GetAPIKey("delete-key")
+	if err != nil {
+		t.Fatalf("Error retrieving key: %v", err)
+	}
+	if deletedKey != nil {
+		t.Error("Key should have been deleted")
+	}
+}
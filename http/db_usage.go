@@ -0,0 +1,198 @@
+package http
+
+import (
+	"openai-forward/metrics"
+	"openai-forward/usage"
+	"strings"
+	"time"
+)
+
+// usageColumns 各分组维度在汇总表中对应的字段
+var usageColumns = map[string]string{
+	usage.GROUP_DAY:      "DATE_FORMAT(day, '%Y-%m-%d')",
+	usage.GROUP_TEAM:     "team_id",
+	usage.GROUP_USER:     "user_name",
+	usage.GROUP_KEY:      "key_prefix",
+	usage.GROUP_UPSTREAM: "upstream",
+	usage.GROUP_MODEL:    "model",
+}
+
+// initUsageTables 初始化用量记录及按日汇总数据表
+func (db *DB) initUsageTables() error {
+	recordTableSQL := `
+CREATE TABLE IF NOT EXISTS usage_records (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	request_id VARCHAR(64) NOT NULL DEFAULT '',
+	time DATETIME(3) NOT NULL,
+	subject VARCHAR(255) NOT NULL DEFAULT '',
+	email VARCHAR(255) NOT NULL DEFAULT '',
+	team_id VARCHAR(64) NOT NULL DEFAULT '',
+	key_prefix VARCHAR(32) NOT NULL DEFAULT '',
+	upstream VARCHAR(32) NOT NULL DEFAULT '',
+	route VARCHAR(64) NOT NULL DEFAULT '',
+	model VARCHAR(128) NOT NULL DEFAULT '',
+	status INT NOT NULL DEFAULT 0,
+	prompt_tokens INT NOT NULL DEFAULT 0,
+	completion_tokens INT NOT NULL DEFAULT 0,
+	cached BOOLEAN NOT NULL DEFAULT FALSE,
+	cost DOUBLE NOT NULL DEFAULT 0,
+	INDEX idx_usage_records_time (time)
+);`
+
+	_, err := db.db.Exec(recordTableSQL)
+	if err != nil {
+		return err
+	}
+
+	dailyTableSQL := `
+CREATE TABLE IF NOT EXISTS usage_daily (
+	day DATE NOT NULL,
+	team_id VARCHAR(64) NOT NULL DEFAULT '',
+	user_name VARCHAR(255) NOT NULL DEFAULT '',
+	key_prefix VARCHAR(32) NOT NULL DEFAULT '',
+	upstream VARCHAR(32) NOT NULL DEFAULT '',
+	model VARCHAR(128) NOT NULL DEFAULT '',
+	requests BIGINT NOT NULL DEFAULT 0,
+	cached_requests BIGINT NOT NULL DEFAULT 0,
+	prompt_tokens BIGINT NOT NULL DEFAULT 0,
+	completion_tokens BIGINT NOT NULL DEFAULT 0,
+	cost DOUBLE NOT NULL DEFAULT 0,
+	PRIMARY KEY (day, team_id, user_name, key_prefix, upstream, model),
+	INDEX idx_usage_daily_user (user_name, day),
+	INDEX idx_usage_daily_team (team_id, day)
+);`
+
+	_, err = db.db.Exec(dailyTableSQL)
+	return err
+}
+
+// SaveUsageRecords 保存用量记录，并在同一事务中累加到按日汇总数据
+func (db *DB) SaveUsageRecords(records []*usage.Record) error {
+	defer metrics.ObserveStorage("SaveUsageRecords", time.Now())
+	if len(records) == 0 {
+		return nil
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	args := make([]any, 0, len(records)*15)
+	for _, record := range records {
+		args = append(args, record.RequestID, record.Time, record.Subject, record.Email, record.TeamID, record.KeyPrefix,
+			record.Upstream, record.Route, record.Model, record.Status, record.PromptTokens, record.CompletionTokens,
+			record.Cached, record.Cost)
+	}
+	sqlStmt := `
+	INSERT INTO usage_records (request_id, time, subject, email, team_id, key_prefix, upstream, route, model, status,
+		prompt_tokens, completion_tokens, cached, cost)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)` + strings.Repeat(", (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", len(records)-1)
+	if _, err := tx.Exec(sqlStmt, args...); err != nil {
+		return err
+	}
+
+	// 先在内存中按全部维度合并，减少汇总表的更新行数
+	rows := make([]*usage.Row, 0, len(records))
+	for _, record := range records {
+		rows = append(rows, record.Row())
+	}
+	rows = usage.Aggregate(rows, usage.Groups)
+	args = make([]any, 0, len(rows)*11)
+	for _, row := range rows {
+		args = append(args, row.Day, row.Team, row.User, row.Key, row.Upstream, row.Model, row.Requests, row.CachedRequests,
+			row.PromptTokens, row.CompletionTokens, row.Cost)
+	}
+	sqlStmt = `
+	INSERT INTO usage_daily (day, team_id, user_name, key_prefix, upstream, model, requests, cached_requests,
+		prompt_tokens, completion_tokens, cost)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)` + strings.Repeat(", (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", len(rows)-1) + `
+	ON DUPLICATE KEY UPDATE
+		requests = requests + VALUES(requests),
+		cached_requests = cached_requests + VALUES(cached_requests),
+		prompt_tokens = prompt_tokens + VALUES(prompt_tokens),
+		completion_tokens = completion_tokens + VALUES(completion_tokens),
+		cost = cost + VALUES(cost)`
+	if _, err := tx.Exec(sqlStmt, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// QueryUsage 按条件查询按日汇总数据
+func (db *DB) QueryUsage(filter *usage.Filter) ([]*usage.Row, error) {
+	defer metrics.ObserveStorage("QueryUsage", time.Now())
+
+	conditions := []string{"1 = 1"}
+	args := []any{}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "day >= ?")
+		args = append(args, filter.Since.UTC().Format(usage.DAY_FORMAT))
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "day < ?")
+		args = append(args, filter.Until.UTC().Format(usage.DAY_FORMAT))
+	}
+	for column, value := range map[string]string{
+		"user_name":  filter.User,
+		"team_id":    filter.Team,
+		"key_prefix": filter.Key,
+		"model":      filter.Model,
+		"upstream":   filter.Upstream,
+	} {
+		if value != "" {
+			conditions = append(conditions, column+" = ?")
+			args = append(args, value)
+		}
+	}
+
+	columns := make([]string, len(filter.GroupBy))
+	for i, group := range filter.GroupBy {
+		columns[i] = usageColumns[group]
+	}
+	selected := append(append([]string{}, columns...),
+		"COALESCE(SUM(requests), 0)", "COALESCE(SUM(cached_requests), 0)", "COALESCE(SUM(prompt_tokens), 0)",
+		"COALESCE(SUM(completion_tokens), 0)", "COALESCE(SUM(cost), 0)")
+	sqlStmt := `SELECT ` + strings.Join(selected, ", ") + ` FROM usage_daily WHERE ` + strings.Join(conditions, " AND ")
+	if len(columns) > 0 {
+		sqlStmt += ` GROUP BY ` + strings.Join(columns, ", ") + ` ORDER BY ` + strings.Join(columns, ", ")
+	}
+
+	rows, err := db.db.Query(sqlStmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []*usage.Row{}
+	for rows.Next() {
+		row := &usage.Row{}
+		dimensions := make([]string, len(filter.GroupBy))
+		dest := make([]any, 0, len(selected))
+		for i := range dimensions {
+			dest = append(dest, &dimensions[i])
+		}
+		dest = append(dest, &row.Requests, &row.CachedRequests, &row.PromptTokens, &row.CompletionTokens, &row.Cost)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		for i, group := range filter.GroupBy {
+			row.SetDimension(group, dimensions[i])
+		}
+		row.TotalTokens = row.PromptTokens + row.CompletionTokens
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// DeleteUsageRecordsBefore 删除指定时间前的用量记录，按日汇总数据保留
+func (db *DB) DeleteUsageRecordsBefore(before time.Time) (int64, error) {
+	defer metrics.ObserveStorage("DeleteUsageRecordsBefore", time.Now())
+
+	result, err := db.db.Exec(`DELETE FROM usage_records WHERE time < ?`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,56 @@
+package http
+
+import (
+	"openai-forward/usage"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDB_Usage(t *testing.T) {
+	// 测试保存用量记录、按日汇总查询及清理逐请求记录
+	db := GetTestDB()
+	defer db.Close()
+
+	// 使用唯一的团队避免与其他数据冲突
+	team := "test-" + uuid.New().String()[:8]
+	day := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	records := []*usage.Record{
+		{RequestID: uuid.New().String(), Time: day, Email: "alice@example.com", TeamID: team, Upstream: "openai", Model: "gpt-4o", PromptTokens: 100, CompletionTokens: 50, Cost: 0.75},
+		{RequestID: uuid.New().String(), Time: day, Email: "alice@example.com", TeamID: team, Upstream: "openai", Model: "gpt-4o", PromptTokens: 100, Cached: true},
+		{RequestID: uuid.New().String(), Time: day.AddDate(0, 0, 1), Subject: "bob", TeamID: team, Upstream: "azure", Model: "gpt-4o-mini", PromptTokens: 1000, Cost: 0.15},
+	}
+	if err := db.SaveUsageRecords(records); err != nil {
+		t.Fatalf("Failed to save usage records: %v", err)
+	}
+	// 再次保存时累加到同一汇总行
+	if err := db.SaveUsageRecords(records[:1]); err != nil {
+		t.Fatalf("Failed to save usage records: %v", err)
+	}
+
+	rows, err := db.QueryUsage(&usage.Filter{Team: team, GroupBy: []string{usage.GROUP_DAY, usage.GROUP_USER}})
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("Unexpected usage rows: %+v, %v", rows, err)
+	}
+	if rows[0].Day != "2024-05-01" || rows[0].User != "alice@example.com" || rows[0].Requests != 3 || rows[0].CachedRequests != 1 ||
+		rows[0].TotalTokens != 300 || rows[0].Cost < 1.4999 || rows[0].Cost > 1.5001 {
+		t.Errorf("Unexpected usage for alice: %+v", rows[0])
+	}
+
+	since, _ := usage.ParseDay("2024-05-02")
+	rows, err = db.QueryUsage(&usage.Filter{Team: team, Since: since})
+	if err != nil || len(rows) != 1 || rows[0].Requests != 1 || rows[0].PromptTokens != 1000 {
+		t.Errorf("Unexpected usage since %s: %+v, %v", since, rows, err)
+	}
+
+	deleted, err := db.DeleteUsageRecordsBefore(day.AddDate(0, 0, 2))
+	if err != nil || deleted < int64(len(records)) {
+		t.Errorf("Expected usage records to be purged: %d, %v", deleted, err)
+	}
+	// 汇总数据保留
+	rows, _ = db.QueryUsage(&usage.Filter{Team: team})
+	if len(rows) != 1 || rows[0].Requests != 4 {
+		t.Errorf("Expected daily rollups to be kept: %+v", rows)
+	}
+}
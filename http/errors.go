@@ -0,0 +1,42 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"openai-forward/apierror"
+	"strings"
+)
+
+// toAPIError 将处理过程中的错误转换为带状态码的错误，未识别的错误视为 500
+func toAPIError(err error) *apierror.Error {
+	if errors.Is(err, ErrStorageUnavailable) {
+		return apierror.New(http.StatusServiceUnavailable, "storage_unavailable", err.Error())
+	}
+	return apierror.From(err)
+}
+
+// isProxyPath 判断是否为转发到上游的接口，这些接口需返回 OpenAI SDK 可解析的错误格式
+func isProxyPath(path string) bool {
+	return strings.HasPrefix(path, "/openai/") || strings.HasPrefix(path, "/azure/")
+}
+
+// writeError 按请求路径选择错误格式：代理接口使用 OpenAI 格式，其余使用 /api/v1 的统一格式
+func writeError(w http.ResponseWriter, r *http.Request, err *apierror.Error) {
+	if isProxyPath(r.URL.Path) {
+		apierror.Write(w, err)
+		return
+	}
+	writeEnvelopeError(w, err)
+}
+
+// writeEnvelopeError 以 /api/v1 的统一格式返回错误，code 未指定时使用错误类型
+func writeEnvelopeError(w http.ResponseWriter, err *apierror.Error) {
+	code := err.Code
+	if code == "" {
+		code = err.Type
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.Status)
+	_ = json.NewEncoder(w).Encode(ErrorResponse{Error: err.Message, Code: code, StdAPIResponse: StdAPIResponse{Status: false}})
+}
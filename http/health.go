@@ -0,0 +1,158 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"openai-forward/audit"
+	"openai-forward/cache"
+	"openai-forward/config"
+	"openai-forward/health"
+	"openai-forward/proxy"
+	"openai-forward/service"
+	"openai-forward/tracing"
+	"strings"
+	"time"
+)
+
+// newHealthChecker 注册就绪检查项：存储连接、已配置的上游及 OIDC 发现文档
+func newHealthChecker(conf *HTTPConfig, storage IStorage) *health.Checker {
+	checker := health.NewChecker(health.LoadConfigFromEnv())
+	client := &http.Client{Transport: tracing.NewTransport(nil, "health")}
+
+	checker.Register("storage", false, func(ctx context.Context) error {
+		if conf.DSN == "" {
+			return health.ErrSkipped
+		}
+		if storage == nil {
+			return errors.New("database is not initialized")
+		}
+		return storage.Ping(ctx)
+	})
+	checker.Register("openai", true, func(ctx context.Context) error {
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			return err
+		}
+		if cfg.TargetBaseURL == "" {
+			return health.ErrSkipped
+		}
+		return health.HTTPProbe(client, strings.TrimRight(cfg.TargetBaseURL, "/")+"/v1/models", false)(ctx)
+	})
+	checker.Register("azure", true, func(ctx context.Context) error {
+		cfg := proxy.NewAzureConfigFromENV()
+		if cfg.Endpoint == "" {
+			return health.ErrSkipped
+		}
+		return health.HTTPProbe(client, cfg.Endpoint, false)(ctx)
+	})
+	checker.Register("oidc", true, func(ctx context.Context) error {
+		cfg := service.LoadOIDCConfigFromEnv()
+		if cfg.IssuerURL == "" {
+			return health.ErrSkipped
+		}
+		return health.HTTPProbe(client, strings.TrimRight(cfg.IssuerURL, "/")+"/.well-known/openid-configuration", true)(ctx)
+	})
+	return checker
+}
+
+// handleHealthz 存活探针，进程能处理请求即返回 200，不检查外部依赖
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": health.STATUS_OK})
+}
+
+// handleReadyz 就绪探针，任一必需检查项失败时返回 503
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	report := s.health.Run(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != health.STATUS_OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	// 探针无需认证，错误详情只在 /api/v1/status 中返回
+	_ = json.NewEncoder(w).Encode(report.Summary())
+}
+
+// StatusInfo 服务状态
+type StatusInfo struct {
+	Build     health.BuildInfo `json:"build"`
+	StartedAt time.Time        `json:"started_at"`
+	Uptime    string           `json:"uptime"`
+	Config    *ConfigSummary   `json:"config"`
+	Health    *health.Report   `json:"health"`
+	// Keys 密钥数量，存储不可用时为空
+	Keys *KeyCounts `json:"keys,omitempty"`
+}
+
+// ConfigSummary 配置摘要，密钥类配置仅显示前缀
+type ConfigSummary struct {
+	ListenAddr      string   `json:"listen_addr"`
+	EnableAuth      bool     `json:"enable_auth"`
+	Storage         bool     `json:"storage"`
+	KeyTTL          string   `json:"key_ttl"`
+	RotateOnRefresh bool     `json:"rotate_on_refresh"`
+	TrustedProxies  string   `json:"trusted_proxies,omitempty"`
+	OpenAIBaseURL   string   `json:"openai_base_url"`
+	OpenAIAPIKey    string   `json:"openai_api_key"`
+	OpenAIKeyMode   string   `json:"openai_upstream_key_mode"`
+	Models          []string `json:"models"`
+	AzureEndpoint   string   `json:"azure_endpoint,omitempty"`
+	AzureAPIKey     string   `json:"azure_api_key,omitempty"`
+	AzureAPIVersion string   `json:"azure_api_version,omitempty"`
+	OIDCIssuer      string   `json:"oidc_issuer,omitempty"`
+	OIDCClientID    string   `json:"oidc_client_id,omitempty"`
+	Audit           string   `json:"audit"`
+	Cache           string   `json:"cache"`
+	Retries         string   `json:"retries"`
+	Validation      bool     `json:"validation"`
+}
+
+// configSummary 汇总当前生效的配置
+func (s *Server) configSummary() *ConfigSummary {
+	summary := &ConfigSummary{
+		ListenAddr:      s.conf.ListenAddr,
+		EnableAuth:      s.authMiddleware.EnableAuth,
+		Storage:         s.db != nil,
+		KeyTTL:          s.conf.KeyTTL.String(),
+		RotateOnRefresh: s.conf.RotateOnRefresh,
+		TrustedProxies:  s.conf.TrustedProxies,
+		Cache:           cache.LoadConfigFromEnv().String(),
+		Validation:      s.validator != nil,
+	}
+	if s.retries != nil {
+		summary.Retries = s.retries.String()
+	}
+	if cfg, err := audit.LoadConfigFromEnv(); err == nil {
+		summary.Audit = cfg.String()
+	}
+	if cfg, err := config.LoadConfig(); err == nil {
+		summary.OpenAIBaseURL = cfg.TargetBaseURL
+		summary.OpenAIAPIKey = maskKey(cfg.APIKey)
+		summary.OpenAIKeyMode = cfg.UpstreamKeyMode
+		summary.Models = cfg.ModelsWhiteList
+	}
+	azure := proxy.NewAzureConfigFromENV()
+	summary.AzureEndpoint = azure.Endpoint
+	summary.AzureAPIKey = maskKey(azure.APIKey)
+	summary.AzureAPIVersion = azure.APIVersion
+	oidc := service.LoadOIDCConfigFromEnv()
+	summary.OIDCIssuer = oidc.IssuerURL
+	summary.OIDCClientID = oidc.ClientID
+	return summary
+}
+
+// handleStatus 返回版本、构建信息、配置摘要、依赖健康状况及密钥数量
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := &StatusInfo{
+		Build:     health.Build(),
+		StartedAt: s.startedAt,
+		Uptime:    time.Since(s.startedAt).Round(time.Second).String(),
+		Config:    s.configSummary(),
+		Health:    s.health.Run(r.Context()),
+	}
+	if counts, err := s.apiKeyManager.CountKeys(); err == nil {
+		status.Keys = counts
+	}
+	s.ResponseJSON(status, w)
+}
@@ -0,0 +1,65 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"openai-forward/health"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleReadyz(t *testing.T) {
+	checker := health.NewChecker(&health.Config{Timeout: time.Second})
+	failing := false
+	checker.Register("storage", false, func(ctx context.Context) error {
+		if failing {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	s := &Server{health: checker}
+
+	rec := httptest.NewRecorder()
+	s.handleReadyz(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", rec.Code)
+	}
+
+	failing = true
+	rec = httptest.NewRecorder()
+	s.handleReadyz(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503, got %d", rec.Code)
+	}
+	var report health.Report
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil || report.Checks[0].Name != "storage" || report.Checks[0].Status != health.STATUS_ERROR {
+		t.Errorf("Unexpected readiness report: %s", rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "connection refused") {
+		t.Errorf("Expected error details to be hidden from the public probe: %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleHealthz(rec, httptest.NewRequest("GET", "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Liveness should not depend on checks, got %d", rec.Code)
+	}
+}
+
+func TestConfigSummary_MasksSecrets(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "sk-abcdefghijklmnopqrstuvwxyz")
+	t.Setenv("AZURE_OPENAI_API_KEY", "azure-secret-key-value")
+	authMiddleware, _ := NewAuthMiddleware(NewAPIKeyManager(nil))
+	s := &Server{conf: &HTTPConfig{}, authMiddleware: authMiddleware}
+
+	data, _ := json.Marshal(s.configSummary())
+	for _, secret := range []string{"sk-abcdefghijklmnopqrstuvwxyz", "azure-secret-key-value"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("Config summary leaks secret %q: %s", secret, data)
+		}
+	}
+}
@@ -8,12 +8,31 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	"net/http"
+	"openai-forward/apierror"
+	"openai-forward/audit"
+	"openai-forward/batch"
+	"openai-forward/cache"
+	"openai-forward/catalog"
 	"openai-forward/config"
+	"openai-forward/health"
+	"openai-forward/jobs"
 	"openai-forward/logging"
+	"openai-forward/metrics"
+	"openai-forward/moderation"
+	"openai-forward/notify"
+	"openai-forward/ownership"
 	"openai-forward/proxy"
+	"openai-forward/realtime"
+	"openai-forward/retry"
+	"openai-forward/scheduler"
+	"openai-forward/scrub"
 	"openai-forward/service"
+	"openai-forward/usage"
+	"openai-forward/validate"
+	"openai-forward/webroot"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -23,12 +42,26 @@ import (
 type HTTPConfig struct {
 	// ListenAddr 监听地址，默认为":3005"
 	ListenAddr string `json:"listen_addr"`
-	// StaticDir 静态文件目录，默认为"./webroot"
+	// StaticDir 静态文件目录，为空时使用编译时内嵌的 webroot
 	StaticDir string `json:"static_dir"`
 	// EnableAuth 是否启用认证
 	EnableAuth bool `json:"enable_auth"`
 	// DSN 数据库连接字符串
 	DSN string `json:"dsn"`
+	// KeyTTL 临时密钥有效期，默认为10小时
+	KeyTTL time.Duration `json:"key_ttl"`
+	// ServiceKeyTTL 服务密钥的最长有效期，默认为90天
+	ServiceKeyTTL time.Duration `json:"service_key_ttl"`
+	// RotateOnRefresh 续期时是否轮换密钥，默认为true
+	RotateOnRefresh bool `json:"rotate_on_refresh"`
+	// SecretKey 用于加密 refresh token 的密钥
+	SecretKey string `json:"-"`
+	// TrustedProxies 可信反向代理的 CIDR 列表，逗号分隔，仅信任来自这些地址的 X-Forwarded-* 头
+	TrustedProxies string `json:"trusted_proxies"`
+	// MetricsListenAddr Prometheus 指标独立监听地址，为空时在主服务上提供 /metrics
+	MetricsListenAddr string `json:"metrics_listen_addr"`
+	// MetricsToken 访问 /metrics 所需的 Bearer token，为空时不校验
+	MetricsToken string `json:"-"`
 }
 
 func (this *HTTPConfig) MarginWithENV() {
@@ -46,6 +79,40 @@ func (this *HTTPConfig) MarginWithENV() {
 	if dsn != "" {
 		this.DSN = dsn
 	}
+
+	this.KeyTTL = 10 * time.Hour
+	keyTTL, err := time.ParseDuration(os.Getenv("HTTP_KEY_TTL"))
+	if err == nil && keyTTL > 0 {
+		this.KeyTTL = keyTTL
+	}
+
+	this.ServiceKeyTTL = 90 * 24 * time.Hour
+	serviceKeyTTL, err := time.ParseDuration(os.Getenv("HTTP_SERVICE_KEY_TTL"))
+	if err == nil && serviceKeyTTL > 0 {
+		this.ServiceKeyTTL = serviceKeyTTL
+	}
+
+	this.RotateOnRefresh = os.Getenv("HTTP_KEY_ROTATE_ON_REFRESH") != "false"
+
+	secretKey := os.Getenv("HTTP_SECRET_KEY")
+	if secretKey != "" {
+		this.SecretKey = secretKey
+	}
+
+	trustedProxies := os.Getenv("HTTP_TRUSTED_PROXIES")
+	if trustedProxies != "" {
+		this.TrustedProxies = trustedProxies
+	}
+
+	metricsListenAddr := os.Getenv("HTTP_METRICS_LISTEN_ADDR")
+	if metricsListenAddr != "" {
+		this.MetricsListenAddr = metricsListenAddr
+	}
+
+	metricsToken := os.Getenv("HTTP_METRICS_TOKEN")
+	if metricsToken != "" {
+		this.MetricsToken = metricsToken
+	}
 }
 
 func (c *HTTPConfig) ToJSON() (string, error) {
@@ -61,9 +128,28 @@ func (c *HTTPConfig) ToJSON() (string, error) {
 // Server HTTP服务结构体
 type Server struct {
 	server         *http.Server
+	metricsServer  *http.Server
 	conf           *HTTPConfig
 	apiKeyManager  *APIKeyManager
+	teamManager    *TeamManager
 	authMiddleware *AuthMiddleware
+	auditor        *audit.Auditor
+	cache          *responseCache
+	resources      *resourceGuard
+	batches        *batchTracker
+	jobQueue       *jobQueue
+	scheduler      *upstreamScheduler
+	moderation     *contentModeration
+	scrubber       *requestScrubber
+	notifier       *notify.Notifier
+	usage          *usage.Recorder
+	models         *ModelCatalogs
+	validator      *validate.Validator
+	retries        *retry.Config
+	realtime       *realtime.Relay
+	health         *health.Checker
+	background     *backgroundTasks
+	startedAt      time.Time
 	db             IStorage
 }
 
@@ -75,10 +161,12 @@ type StdAPIResponse struct {
 type ErrorResponse struct {
 	StdAPIResponse
 	Error string `json:"error"`
+	// Code 机器可读的错误码
+	Code string `json:"code,omitempty"`
 }
 
 // NewServer 创建HTTP服务实例
-func NewServer(config *HTTPConfig) *Server {
+func NewServer(config *HTTPConfig) (*Server, error) {
 	// 创建数据库连接
 	storage, err := NewDB(config.DSN)
 	if err != nil {
@@ -89,35 +177,195 @@ func NewServer(config *HTTPConfig) *Server {
 
 	// 创建API密钥管理器
 	apiKeyManager := NewAPIKeyManager(storage)
+	var secretBox *service.SecretBox
+	if config.SecretKey != "" {
+		secretBox, err = service.NewSecretBox(config.SecretKey)
+		if err != nil {
+			logging.Logger.Errorf("Failed to initialize secret box: %v", err)
+		} else {
+			apiKeyManager.SetSecretBox(secretBox)
+		}
+	} else {
+		logging.Logger.Warn("HTTP_SECRET_KEY is not set, refresh tokens and team credentials will not be persisted")
+	}
+
+	// 创建团队管理器
+	teamManager := NewTeamManager(storage, secretBox)
 
 	// 创建认证中间件
-	authMiddleware := NewAuthMiddleware(apiKeyManager)
+	authMiddleware, err := NewAuthMiddleware(apiKeyManager)
+	if err != nil {
+		return nil, err
+	}
 
-	// 启动定时清理任务
-	go func() {
-		ticker := time.NewTicker(1 * time.Hour)
-		defer ticker.Stop()
+	// 创建审计器，未启用时为 nil；已启用但无法记录时拒绝启动，避免请求在没有审计的情况下被转发
+	auditConfig, err := audit.LoadConfigFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load audit config: %w", err)
+	}
+	auditor, err := newAuditor(auditConfig, storage)
+	if err != nil {
+		return nil, err
+	}
 
-		for {
-			<-ticker.C
-			// 清理过期的API密钥
-			apiKeyManager.CleanupExpiredKeys()
-		}
-	}()
+	// 创建响应缓存，未启用时为 nil
+	responseCache := newResponseCache(cache.LoadConfigFromEnv(), storage)
 
-	return &Server{
+	// 创建通知器，未配置通知目标时为 nil
+	// 通知目标配置无效时拒绝启动，避免安全与依赖告警被静默关闭
+	notifyConfig, err := notify.LoadConfigFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load notification config: %w", err)
+	}
+	notifier := newNotifier(notifyConfig, storage)
+	if notifier != nil {
+		authMiddleware.SetNotifier(notifier)
+	}
+
+	// 创建用量记录器，未启用时为 nil
+	usageRecorder := newUsageRecorder(usage.LoadConfigFromEnv(), storage)
+
+	// 上游重试策略
+	retries := retry.LoadConfigFromEnv()
+	logging.Logger.Infof("Upstream retries: %s", retries)
+
+	s := &Server{
 		conf:           config,
 		apiKeyManager:  apiKeyManager,
+		teamManager:    teamManager,
 		authMiddleware: authMiddleware,
+		auditor:        auditor,
+		cache:          responseCache,
+		resources:      newResourceGuard(ownership.LoadConfigFromEnv(), storage, authMiddleware),
+		models:         newModelCatalogsFromEnv(),
+		validator:      validate.NewValidator(validate.LoadConfigFromEnv()),
+		retries:        retries,
+		realtime:       realtime.NewRelay(realtime.LoadConfigFromEnv()),
+		notifier:       notifier,
+		usage:          usageRecorder,
+		health:         newHealthChecker(config, storage),
+		background:     newBackgroundTasks(),
+		startedAt:      time.Now(),
 		db:             storage,
 	}
+
+	// 上游调度需要读取团队配置，批处理跟踪与任务队列需要读取模型目录、团队配置及代理，在服务创建后初始化
+	schedulerConfig, err := scheduler.LoadConfigFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load upstream scheduler config: %w", err)
+	}
+	s.scheduler = s.newUpstreamScheduler(schedulerConfig)
+
+	// 内容审核配置无效时拒绝启动，避免未经审核的请求被转发
+	moderationConfig, err := moderation.LoadConfigFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load moderation config: %w", err)
+	}
+	if s.moderation, err = newContentModeration(moderationConfig, notifier); err != nil {
+		return nil, err
+	}
+
+	scrubConfig, err := scrub.LoadConfigFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load scrub config: %w", err)
+	}
+	if s.scrubber, err = newRequestScrubber(scrubConfig, notifier); err != nil {
+		return nil, err
+	}
+	if notifier != nil {
+		s.health.OnChange(notifyHealthChange(notifier))
+	}
+
+	s.batches = s.newBatchTracker(batch.LoadConfigFromEnv(), storage)
+
+	jobsConfig, err := jobs.LoadConfigFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load job queue config: %w", err)
+	}
+	s.jobQueue = s.newJobQueue(jobsConfig, storage)
+
+	// 所有配置加载成功后再启动后台任务，拒绝启动时不会遗留任务
+	s.background.Go(s.cleanup)
+	if notifier != nil {
+		s.background.Go(notifier.Run)
+		// 定期执行健康检查，上游或存储故障时无需外部探针也会发送通知
+		if notifyConfig.HealthInterval > 0 {
+			s.background.Go(func(ctx context.Context) {
+				s.health.Watch(ctx, notifyConfig.HealthInterval)
+			})
+		}
+	}
+	if s.batches != nil {
+		s.background.Go(s.batches.run)
+	}
+	if s.jobQueue != nil {
+		s.background.Go(func(ctx context.Context) {
+			s.jobQueue.run(ctx, storage)
+		})
+	}
+	return s, nil
+}
+
+// backgroundTasks 服务的后台任务，共享一个可取消的上下文
+type backgroundTasks struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newBackgroundTasks() *backgroundTasks {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &backgroundTasks{ctx: ctx, cancel: cancel}
+}
+
+// Go 启动后台任务，任务需在上下文取消后退出
+func (b *backgroundTasks) Go(task func(ctx context.Context)) {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		task(b.ctx)
+	}()
+}
+
+// Stop 取消所有后台任务并等待退出
+func (b *backgroundTasks) Stop() {
+	b.cancel()
+	b.wg.Wait()
+}
+
+// cleanup 定时清理过期的数据
+func (s *Server) cleanup(ctx context.Context) {
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// 清理过期的API密钥
+		s.apiKeyManager.CleanupExpiredKeys()
+		// 清理超出保留期的审计记录
+		if s.auditor != nil {
+			s.auditor.Purge()
+		}
+		// 清理过期的响应缓存
+		if s.cache != nil {
+			s.cache.purge()
+		}
+		// 清理超出保留期的用量记录
+		if s.usage != nil {
+			s.usage.Purge()
+		}
+	}
 }
 
 // requireJSON is a middleware that ensures the request Content-Type is application/json.
 func (s *Server) requireJSON(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "POST" && r.ContentLength > 0 && r.Header.Get("Content-Type") != "application/json" {
-			s.ResponseError(errors.New("Unsupported Content-Type"), w)
+			s.ResponseError(apierror.New(http.StatusUnsupportedMediaType, "unsupported_media_type", "Unsupported Content-Type"), w)
 			return
 		}
 		next.ServeHTTP(w, r)
@@ -129,35 +377,52 @@ func (s *Server) RedirectUI(writer http.ResponseWriter, request *http.Request) {
 }
 
 func (s *Server) NotFoundHandle(writer http.ResponseWriter, request *http.Request) {
-	s.ResponseError(fmt.Errorf("404 Not Found"), writer)
+	writeError(writer, request, apierror.NotFound("404 Not Found"))
 }
 
 func (s *Server) HandleOpenAIProxy(w http.ResponseWriter, r *http.Request) {
-	logging.Logger.Debugf("Received request to openai proxy")
+	logging.Logger.Debugf("Received request to openai proxy from %s", ClientIP(r))
 	defer logging.Logger.Debugf("Finished request to openai proxy")
 
 	porxyConf, err := config.LoadConfig()
 	if err != nil {
 		logging.Logger.Errorf("Failed to load config: %v", err)
-		s.ResponseError(err, w)
+		apierror.Write(w, apierror.New(http.StatusInternalServerError, "invalid_configuration", "Upstream is not configured"))
+		return
+	}
+	team, denied := s.teamFromRequest(r)
+	if denied != nil {
+		apierror.Write(w, denied)
 		return
 	}
+	if team != nil {
+		team.ApplyOpenAI(porxyConf)
+	}
 	proxyHandle := proxy.NewOpenAIProxy(porxyConf)
 
 	proxyHandle.ServeHTTP(w, r)
 }
 
 func (s *Server) HandleAzureOpenAIProxy(w http.ResponseWriter, r *http.Request) {
-	logging.Logger.Debugf("Received request to azure openai proxy")
+	logging.Logger.Debugf("Received request to azure openai proxy from %s", ClientIP(r))
 	defer logging.Logger.Debugf("Finished request to azure openai proxy")
 
 	//logging.Logger.Infof("request path: %s", r.URL.Path)
 
 	cfg := proxy.NewAzureConfigFromENV()
+	team, denied := s.teamFromRequest(r)
+	if denied != nil {
+		apierror.Write(w, denied)
+		return
+	}
+	if team != nil {
+		team.ApplyAzure(cfg)
+	}
 	azureProxy, err := proxy.NewAzureProxy(cfg)
 	if err != nil {
 		logging.Logger.Errorf("Failed to load azure config: %v", err)
-		s.ResponseError(err, w)
+		apierror.Write(w, apierror.New(http.StatusInternalServerError, "invalid_configuration", "Upstream is not configured"))
+		return
 	}
 	azureProxy.ProxyRequest(w, r)
 }
@@ -165,7 +430,9 @@ func (s *Server) HandleAzureOpenAIProxy(w http.ResponseWriter, r *http.Request)
 type TokenInfo struct {
 	EndPoint string   `json:"endpoint"`
 	Model    []string `json:"model"`
-	Version  string   `json:"version"`
+	// Models 模型目录，包含别名、能力、上下文长度及价格等元数据
+	Models  []*catalog.ModelInfo `json:"models"`
+	Version string               `json:"version"`
 }
 
 func (s *Server) HandleOpenAITokenInfo(w http.ResponseWriter, r *http.Request) {
@@ -175,26 +442,46 @@ func (s *Server) HandleOpenAITokenInfo(w http.ResponseWriter, r *http.Request) {
 		s.ResponseError(err, w)
 		return
 	}
-	proxyHandle := proxy.NewOpenAIProxy(porxyConf)
+	team, denied := s.teamFromRequest(r)
+	if denied != nil {
+		s.ResponseError(denied, w)
+		return
+	}
+	if team != nil {
+		team.ApplyOpenAI(porxyConf)
+	}
+	models := s.models.OpenAI(r.Context(), porxyConf)
 
 	result := TokenInfo{
 		EndPoint: GetRequestWithPath(r, "/openai"),
-		Model:    proxyHandle.ListAvailableModels(),
+		Model:    catalog.IDs(models),
+		Models:   models,
 	}
 
 	s.ResponseJSON(result, w)
 }
 
 func (s *Server) handleAzureOpenAITokenInfo(w http.ResponseWriter, r *http.Request) {
-	azureProxy, err := proxy.NewAzureProxy(proxy.NewAzureConfigFromENV())
+	cfg := proxy.NewAzureConfigFromENV()
+	team, denied := s.teamFromRequest(r)
+	if denied != nil {
+		s.ResponseError(denied, w)
+		return
+	}
+	if team != nil {
+		team.ApplyAzure(cfg)
+	}
+	azureProxy, err := proxy.NewAzureProxy(cfg)
 	if err != nil {
 		logging.Logger.Errorf("Failed to load azure config: %v", err)
 		s.ResponseError(err, w)
 		return
 	}
+	models := s.models.Azure(azureProxy.GetConfig())
 	result := TokenInfo{
 		EndPoint: GetRequestWithPath(r, "/azure"),
-		Model:    azureProxy.ListModels(),
+		Model:    catalog.IDs(models),
+		Models:   models,
 		Version:  azureProxy.GetConfig().APIVersion,
 	}
 	s.ResponseJSON(result, w)
@@ -202,30 +489,93 @@ func (s *Server) handleAzureOpenAITokenInfo(w http.ResponseWriter, r *http.Reque
 
 // Start 启动HTTP服务
 func (s *Server) Start() error {
-	// 配置静态文件服务
-	staticDir := s.conf.StaticDir
-	if staticDir == "" {
-		staticDir = "./webroot"
-	}
-
 	// 设置路由
 	r := mux.NewRouter()
 
+	// 解析客户端真实 IP，仅信任来自可信代理的转发头
+	ipResolver, err := NewClientIPResolver(s.conf.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("invalid HTTP_TRUSTED_PROXIES: %w", err)
+	}
+	r.Use(ipResolver.Handler)
+	r.Use(traceRequest)
+	r.Use(s.logRequest)
+
 	apiRouter := r.PathPrefix("/api/v1").Subrouter()
 
-	r.PathPrefix("/openai/").Handler(s.authMiddleware.AuthRequired(s.HandleOpenAIProxy))
-	r.PathPrefix("/azure/").Handler(s.authMiddleware.AuthRequired(s.HandleAzureOpenAIProxy))
+	// 模型列表由网关的模型目录提供，不转发到上游
+	r.HandleFunc("/openai/v1/models", s.authMiddleware.AuthRequired(s.handleProxyModels("openai"))).Methods("GET")
+	r.HandleFunc("/openai/v1/models/{model}", s.authMiddleware.AuthRequired(s.handleProxyModels("openai"))).Methods("GET")
+	r.HandleFunc("/azure/openai/models", s.authMiddleware.AuthRequired(s.handleProxyModels("azure"))).Methods("GET")
+	r.HandleFunc("/azure/openai/models/{model}", s.authMiddleware.AuthRequired(s.handleProxyModels("azure"))).Methods("GET")
+	// Realtime API 的 WebSocket 连接，其余 realtime 请求按普通接口转发
+	r.HandleFunc("/openai/v1/realtime", s.authMiddleware.AuthRequired(s.handleRealtime("openai"))).MatcherFunc(isWebSocketUpgrade)
+	r.HandleFunc("/azure/openai/realtime", s.authMiddleware.AuthRequired(s.handleRealtime("azure"))).MatcherFunc(isWebSocketUpgrade)
+	r.PathPrefix("/openai/").Handler(s.authMiddleware.AuthRequired(s.observeProxy("openai", s.HandleOpenAIProxy)))
+	r.PathPrefix("/azure/").Handler(s.authMiddleware.AuthRequired(s.observeProxy("azure", s.HandleAzureOpenAIProxy)))
+
+	// 存活与就绪探针，不需要认证
+	r.HandleFunc("/healthz", s.handleHealthz).Methods("GET")
+	r.HandleFunc("/readyz", s.handleReadyz).Methods("GET")
+
+	// Prometheus 指标，配置独立监听地址时不在主服务上暴露
+	metricsHandler := metrics.Handler(s.conf.MetricsToken)
+	if s.conf.MetricsListenAddr == "" {
+		r.Handle("/metrics", metricsHandler).Methods("GET")
+	} else {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metricsHandler)
+		s.metricsServer = &http.Server{
+			Addr:    s.conf.MetricsListenAddr,
+			Handler: metricsMux,
+		}
+		go func() {
+			logging.Logger.Infof("Starting metrics server on http://%s/metrics", s.conf.MetricsListenAddr)
+			err := s.metricsServer.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				logging.Logger.Errorf("Failed to start metrics server: %v", err)
+			}
+		}()
+	}
 
 	// API路由组
 	// 任务查询接口，需要临时API密钥认证
+	apiRouter.HandleFunc("/status", s.authMiddleware.KeyRequired(s.handleStatus)).Methods("GET")
 	apiRouter.HandleFunc("/auth", s.handleOAuth).Methods("GET")
 	apiRouter.HandleFunc("/auth/callback", s.handleOAuthCallback).Methods("GET")
-	apiRouter.HandleFunc("/openai/models", s.HandleOpenAITokenInfo).Methods("GET")
-	apiRouter.HandleFunc("/azure/models", s.handleAzureOpenAITokenInfo).Methods("GET")
+	apiRouter.HandleFunc("/auth/refresh", s.handleRefresh).Methods("POST")
+	apiRouter.HandleFunc("/auth/logout", s.authMiddleware.KeyRequired(s.handleLogout)).Methods("POST")
+	apiRouter.HandleFunc("/auth/me", s.authMiddleware.KeyRequired(s.handleCurrentUser)).Methods("GET")
+	apiRouter.HandleFunc("/auth/sessions", s.authMiddleware.KeyRequired(s.handleListSessions)).Methods("GET")
+	apiRouter.HandleFunc("/auth/sessions", s.authMiddleware.KeyRequired(s.handleCreateServiceKey)).Methods("POST")
+	apiRouter.HandleFunc("/auth/sessions/{id}", s.authMiddleware.KeyRequired(s.handleRevokeSession)).Methods("DELETE")
+	apiRouter.HandleFunc("/auth/sessions/{id}/ips", s.authMiddleware.KeyRequired(s.handleSetSessionIPs)).Methods("PUT")
+	apiRouter.HandleFunc("/auth/sessions/{id}/priority", s.authMiddleware.KeyRequired(s.handleSetSessionPriority)).Methods("PUT")
+	apiRouter.HandleFunc("/usage", s.authMiddleware.KeyRequired(s.handleUsage)).Methods("GET")
+	apiRouter.HandleFunc("/resources", s.authMiddleware.KeyRequired(s.handleListResources)).Methods("GET")
+	apiRouter.HandleFunc("/batches", s.authMiddleware.KeyRequired(s.handleListBatches)).Methods("GET")
+	apiRouter.HandleFunc("/jobs", s.authMiddleware.KeyRequired(s.handleSubmitJob)).Methods("POST")
+	apiRouter.HandleFunc("/jobs", s.authMiddleware.KeyRequired(s.handleListJobs)).Methods("GET")
+	apiRouter.HandleFunc("/jobs/{id}", s.authMiddleware.KeyRequired(s.handleGetJob)).Methods("GET")
+	apiRouter.HandleFunc("/jobs/{id}/results", s.authMiddleware.KeyRequired(s.handleJobResults)).Methods("GET")
+	apiRouter.HandleFunc("/jobs/{id}/cancel", s.authMiddleware.KeyRequired(s.handleCancelJob)).Methods("POST")
+	apiRouter.HandleFunc("/auth/backchannel-logout", s.handleBackChannelLogout).Methods("POST")
+	apiRouter.HandleFunc("/admin/users/{user}/keys", s.authMiddleware.AdminRequired(s.handleRevokeUserKeys)).Methods("DELETE")
+	apiRouter.HandleFunc("/admin/log-level", s.authMiddleware.AdminRequired(s.handleGetLogLevel)).Methods("GET")
+	apiRouter.HandleFunc("/admin/log-level", s.authMiddleware.AdminRequired(s.handleSetLogLevel)).Methods("PUT")
+	apiRouter.HandleFunc("/admin/resources", s.authMiddleware.AdminRequired(s.handleAdminListResources)).Methods("GET")
+	apiRouter.HandleFunc("/admin/audit", s.authMiddleware.AdminRequired(s.handleListAuditRecords)).Methods("GET")
+	apiRouter.HandleFunc("/admin/notifications", s.authMiddleware.AdminRequired(s.handleListNotifications)).Methods("GET")
+	apiRouter.HandleFunc("/admin/notifications/test", s.authMiddleware.AdminRequired(s.handleTestNotification)).Methods("POST")
+	apiRouter.HandleFunc("/admin/teams", s.authMiddleware.AdminRequired(s.handleListTeams)).Methods("GET")
+	apiRouter.HandleFunc("/admin/teams", s.authMiddleware.AdminRequired(s.handleSaveTeam)).Methods("POST")
+	apiRouter.HandleFunc("/admin/teams/{id}", s.authMiddleware.AdminRequired(s.handleSaveTeam)).Methods("PUT")
+	apiRouter.HandleFunc("/admin/teams/{id}", s.authMiddleware.AdminRequired(s.handleDeleteTeam)).Methods("DELETE")
+	apiRouter.HandleFunc("/openai/models", s.authMiddleware.OptionalKey(s.HandleOpenAITokenInfo)).Methods("GET")
+	apiRouter.HandleFunc("/azure/models", s.authMiddleware.OptionalKey(s.handleAzureOpenAITokenInfo)).Methods("GET")
 
 	r.HandleFunc("/", s.RedirectUI)
-	r.PathPrefix("/").Handler(http.StripPrefix("/",
-		http.FileServer(http.Dir(fmt.Sprintf("%s", s.conf.StaticDir)))))
+	r.PathPrefix("/").Handler(http.FileServer(s.staticFiles()))
 	r.NotFoundHandler = http.HandlerFunc(s.NotFoundHandle)
 
 	// 创建HTTP服务
@@ -240,28 +590,56 @@ func (s *Server) Start() error {
 	}
 
 	logging.Logger.Infof("Starting HTTP server on http://%s", listenAddr)
-	logging.Logger.Infof("Static files served from %s", staticDir)
+	if s.conf.StaticDir != "" {
+		logging.Logger.Infof("Static files served from %s", s.conf.StaticDir)
+	} else {
+		logging.Logger.Info("Static files served from embedded webroot")
+	}
 
 	// 启动服务
 	return s.server.ListenAndServe()
 }
 
+// staticFiles 静态文件，配置 HTTP_STATIC_DIR 时从该目录读取（便于开发时修改），否则使用内嵌的文件
+func (s *Server) staticFiles() http.FileSystem {
+	if s.conf.StaticDir != "" {
+		return http.Dir(s.conf.StaticDir)
+	}
+	return http.FS(webroot.FS)
+}
+
 // Stop 停止HTTP服务
 func (s *Server) Stop() error {
 	if s.server == nil {
 		return nil
 	}
 
-	// 关闭数据库连接
-	if s.db != nil {
-		_ = s.db.Close()
-	}
-
 	logging.Logger.Info("Shutting down HTTP server...")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	return s.server.Shutdown(ctx)
+	if s.metricsServer != nil {
+		_ = s.metricsServer.Shutdown(ctx)
+	}
+
+	err := s.server.Shutdown(ctx)
+
+	// 停止后台任务，其中任务队列执行的请求会写入审计及用量记录
+	if s.background != nil {
+		s.background.Stop()
+	}
+	// 等待审计及用量记录写入完毕后再关闭数据库连接
+	if s.auditor != nil {
+		s.auditor.Close()
+	}
+	if s.usage != nil {
+		s.usage.Close()
+	}
+	if s.db != nil {
+		_ = s.db.Close()
+	}
+
+	return err
 }
 
 type AnalyzePDFURLRequest struct {
@@ -269,24 +647,25 @@ type AnalyzePDFURLRequest struct {
 }
 
 func GetFullURL(r *http.Request) string {
-	scheme := "http"
-	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
-		scheme = "https"
-	}
-	return fmt.Sprintf("%s://%s%s", scheme, r.Host, r.RequestURI)
+	return fmt.Sprintf("%s://%s%s", requestScheme(r), r.Host, r.RequestURI)
 }
 
 func GetRequestWithPath(r *http.Request, rawPath string) string {
-	scheme := "http"
-	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
-		scheme = "https"
-	}
 	hostname := r.Host
-	if r.Header.Get("X-Forwarded-Host") != "" {
+	// 仅在请求来自可信代理时采信 X-Forwarded-Host
+	if isTrustedProxyRequest(r) && r.Header.Get("X-Forwarded-Host") != "" {
 		hostname = r.Header.Get("X-Forwarded-Host")
 	}
 	reqPath := strings.TrimPrefix(rawPath, "/")
-	return fmt.Sprintf("%s://%s/%s", scheme, hostname, reqPath)
+	return fmt.Sprintf("%s://%s/%s", requestScheme(r), hostname, reqPath)
+}
+
+// requestScheme 获取请求协议，仅在请求来自可信代理时采信 X-Forwarded-Proto
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil || (isTrustedProxyRequest(r) && r.Header.Get("X-Forwarded-Proto") == "https") {
+		return "https"
+	}
+	return "http"
 }
 
 func (s *Server) handleOAuth(w http.ResponseWriter, r *http.Request) {
@@ -304,7 +683,7 @@ func (s *Server) handleOAuth(w http.ResponseWriter, r *http.Request) {
 
 	//logging.Logger.Infof("OIDC Config: %+v", cfg)
 
-	service, err := service.NewOIDCService(cfg)
+	service, err := service.NewOIDCServiceWithContext(r.Context(), cfg)
 	if err != nil {
 		logging.Logger.Errorf("Failed to create OIDC service: %v", err)
 		s.ResponseError(err, w)
@@ -320,17 +699,40 @@ func (s *Server) handleOAuthCallback(w http.ResponseWriter, r *http.Request) {
 	defer logging.Logger.Debugf("Finished request to callback")
 
 	code := r.URL.Query().Get("code")
-	service, err := service.NewOIDCService(service.LoadOIDCConfigFromEnv())
+	cfg := service.LoadOIDCConfigFromEnv()
+	// 与 handleOAuth 中使用的 redirect_uri 保持一致，否则 IdP 会拒绝换取 token
+	redirect := r.URL.Query().Get("redirect")
+	if redirect != "" {
+		cfg.RedirectURL = redirect
+	}
+	service, err := service.NewOIDCServiceWithContext(r.Context(), cfg)
 	if err != nil {
 		logging.Logger.Errorf("Failed to create OIDC service: %v", err)
 		s.ResponseError(err, w)
 		return
 	}
-	_, err = service.Exchange(r.Context(), code)
+	token, err := service.Exchange(r.Context(), code)
 	if err != nil {
 		logging.Logger.Errorf("Failed to exchange code for token: %v", err)
+		s.notifyDomainDenied(r, err)
+		s.ResponseErrorWithStatus(err, http.StatusBadRequest, w)
+		return
 	}
-	apikey, err := s.apiKeyManager.GenerateTemporaryKey(10 * time.Hour)
+	// 根据 OIDC 团队声明确定密钥所属团队，可通过 team 参数在多个所属团队中选择
+	team, err := s.teamManager.ResolveTeam(token.UserInfo.Groups, r.URL.Query().Get("team"))
+	if err != nil {
+		logging.Logger.Errorf("Failed to resolve team: %v", err)
+	}
+	teamID := ""
+	if team != nil {
+		teamID = team.ID
+	}
+	apikey, err := s.apiKeyManager.GenerateUserKey(UserKeyOptions{
+		User:         token.UserInfo,
+		RefreshToken: token.OAuth2Token.RefreshToken,
+		UserAgent:    r.UserAgent(),
+		TeamID:       teamID,
+	}, s.conf.KeyTTL)
 	if err != nil {
 		logging.Logger.Errorf("Failed to generate API key: %v", err)
 		s.ResponseError(err, w)
@@ -339,10 +741,93 @@ func (s *Server) handleOAuthCallback(w http.ResponseWriter, r *http.Request) {
 	s.ResponseJSON(apikey, w)
 }
 
+// handleRefresh 使用保存的 refresh token 续期当前密钥，IdP 拒绝时吊销密钥
+func (s *Server) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	logging.Logger.Debugf("Received request to refresh")
+	defer logging.Logger.Debugf("Finished request to refresh")
+
+	// 过期的密钥也可以续期，因此不经过认证中间件，但同样需要校验全局及密钥级别的 IP 限制
+	if !s.authMiddleware.checkIP(w, r) {
+		return
+	}
+	apiKey, err := s.apiKeyManager.GetAPIKey(ExtractAPIKey(r))
+	if err != nil {
+		logging.Logger.Errorf("Failed to get API key: %v", err)
+		s.ResponseError(err, w)
+		return
+	}
+	if apiKey == nil {
+		s.authMiddleware.ResponseError(fmt.Errorf("unauthorized"), w)
+		return
+	}
+	if !s.authMiddleware.checkKeyIP(w, r, apiKey) {
+		return
+	}
+
+	refreshToken, err := s.apiKeyManager.GetRefreshToken(apiKey)
+	if err != nil {
+		s.ResponseError(err, w)
+		return
+	}
+
+	service, err := service.NewOIDCServiceWithContext(r.Context(), service.LoadOIDCConfigFromEnv())
+	if err != nil {
+		logging.Logger.Errorf("Failed to create OIDC service: %v", err)
+		s.ResponseError(err, w)
+		return
+	}
+	token, err := service.Refresh(r.Context(), refreshToken)
+	if err != nil {
+		logging.Logger.Warnf("Failed to refresh token for %s: %v", apiKey.Email, err)
+		s.notifyDomainDenied(r, err)
+		if isRefreshRejected(err) {
+			// IdP 已拒绝该会话（如用户被停用），同时吊销当前密钥
+			if revokeErr := s.apiKeyManager.RevokeKey(apiKey.Key); revokeErr != nil {
+				logging.Logger.Errorf("Failed to revoke API key: %v", revokeErr)
+			}
+			s.authMiddleware.ResponseError(fmt.Errorf("session expired, please login again"), w)
+			return
+		}
+		s.ResponseError(err, w)
+		return
+	}
+
+	// IdP 返回了团队声明时重新确定所属团队，成员关系变化后密钥随之变化
+	if token.UserInfo.Groups != nil {
+		team, err := s.teamManager.ResolveTeam(token.UserInfo.Groups, apiKey.TeamID)
+		if err != nil {
+			logging.Logger.Errorf("Failed to resolve team: %v", err)
+		} else {
+			apiKey.TeamID = ""
+			if team != nil {
+				apiKey.TeamID = team.ID
+			}
+		}
+	}
+
+	// IdP 未轮换 refresh token 时沿用原有的
+	newRefreshToken := token.OAuth2Token.RefreshToken
+	if newRefreshToken == "" {
+		newRefreshToken = refreshToken
+	}
+	renewed, err := s.apiKeyManager.RenewKey(apiKey, newRefreshToken, s.conf.KeyTTL, s.conf.RotateOnRefresh)
+	if err != nil {
+		logging.Logger.Errorf("Failed to renew API key: %v", err)
+		s.ResponseError(err, w)
+		return
+	}
+	s.ResponseJSON(renewed, w)
+}
+
+// isRefreshRejected 判断刷新失败是否由 IdP 拒绝引起（而非网络等临时错误）
+func isRefreshRejected(err error) bool {
+	return service.IsInvalidGrant(err) || errors.Is(err, service.ErrEmailDomainNotAllowed)
+}
+
 func (s *Server) handleGetApiKeyWithCode(w http.ResponseWriter, r *http.Request) {
 	code := r.URL.Query().Get("code")
 
-	service, err := service.NewOIDCService(service.LoadOIDCConfigFromEnv())
+	service, err := service.NewOIDCServiceWithContext(r.Context(), service.LoadOIDCConfigFromEnv())
 	if err != nil {
 		logging.Logger.Errorf("Failed to create OIDC service: %v", err)
 		s.ResponseError(err, w)
@@ -366,11 +851,17 @@ func (s *Server) ResponseJSON(data interface{}, w http.ResponseWriter) {
 	_ = json.NewEncoder(w).Encode(StdAPIResponse{Status: true, Data: data})
 }
 
+// ResponseError 返回错误，状态码由错误决定（*apierror.Error 使用其状态码，其余为 500），err 为 nil 时返回成功
 func (s *Server) ResponseError(err error, w http.ResponseWriter) {
-	w.Header().Set("Content-Type", "application/json")
-	if err != nil {
-		_ = json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error(), StdAPIResponse: StdAPIResponse{Status: false}})
-	} else {
+	if err == nil {
+		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(StdAPIResponse{Status: true})
+		return
 	}
+	writeEnvelopeError(w, toAPIError(err))
+}
+
+// ResponseErrorWithStatus 以指定的 HTTP 状态码返回错误
+func (s *Server) ResponseErrorWithStatus(err error, status int, w http.ResponseWriter) {
+	writeEnvelopeError(w, apierror.New(status, "", err.Error()))
 }
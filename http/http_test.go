@@ -1,7 +1,12 @@
 package http
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	_ "openai-forward/test"
 )
@@ -10,9 +15,64 @@ func TestServer_Start(t *testing.T) {
 	cfg := &HTTPConfig{}
 	cfg.MarginWithENV()
 
-	server := NewServer(cfg)
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP server: %v", err)
+	}
 
 	if err := server.Start(); err != nil {
 		t.Errorf("Failed to start HTTP server: %v", err)
 	}
 }
+
+func TestNewServer_InvalidConfig(t *testing.T) {
+	// 通知、调度及任务队列配置无效时拒绝启动
+	for env, value := range map[string]string{
+		"NOTIFY_TARGETS":        "not json",
+		"SCHEDULER_CONCURRENCY": "not json",
+		"JOBS_RATE_LIMITS":      "not json",
+	} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, value)
+			cfg := &HTTPConfig{DSN: "invalid"}
+			if _, err := NewServer(cfg); err == nil {
+				t.Errorf("Expected invalid %s to be rejected", env)
+			}
+		})
+	}
+}
+
+func TestBackgroundTasks_Stop(t *testing.T) {
+	// 停止时等待任务退出，之后才能关闭数据库
+	tasks := newBackgroundTasks()
+	var stopped atomic.Bool
+	tasks.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		stopped.Store(true)
+	})
+	tasks.Stop()
+	if !stopped.Load() {
+		t.Error("Expected Stop to wait for background tasks")
+	}
+}
+
+func TestServer_StaticFiles(t *testing.T) {
+	// 未配置静态文件目录时使用内嵌的文件
+	server := &Server{conf: &HTTPConfig{}}
+	handler := http.FileServer(server.staticFiles())
+	for _, path := range []string{"/ui/", "/ui/main.min.js", "/swagger/swagger.json", "/"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("Expected embedded %s, got %d", path, rec.Code)
+		}
+	}
+
+	server = &Server{conf: &HTTPConfig{StaticDir: t.TempDir()}}
+	rec := httptest.NewRecorder()
+	http.FileServer(server.staticFiles()).ServeHTTP(rec, httptest.NewRequest("GET", "/ui/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected files to be served from HTTP_STATIC_DIR, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,291 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"openai-forward/apierror"
+	"openai-forward/batch"
+	"openai-forward/catalog"
+	"openai-forward/jobs"
+	"openai-forward/logging"
+	"openai-forward/metrics"
+	"openai-forward/proxy"
+	"openai-forward/scheduler"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// jobResultsPageSize 导出任务结果时每次读取的请求数
+const jobResultsPageSize = 1000
+
+// jobQueue 网关任务队列：接收 JSONL 请求文件，由网关按并发与上游限流逐个执行
+type jobQueue struct {
+	config *jobs.Config
+	runner *jobs.Runner
+}
+
+// newJobQueue 根据配置创建任务队列，未启用或没有数据库时返回 nil
+func (s *Server) newJobQueue(cfg *jobs.Config, storage IStorage) *jobQueue {
+	if !cfg.Enabled {
+		return nil
+	}
+	if storage == nil {
+		logging.Logger.Warn("Job queue requires a database, job queue disabled")
+		return nil
+	}
+
+	logging.Logger.Infof("Job queue enabled: %s", cfg)
+	return &jobQueue{config: cfg, runner: jobs.NewRunner(cfg, storage, s.executeJobItem)}
+}
+
+// run 在后台执行排队的任务，并定期清理超出保留期的任务
+func (q *jobQueue) run(ctx context.Context, storage IStorage) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q.runner.Run(ctx)
+	}()
+	// 返回前等待执行器退出，停止服务时执行中的请求结束后才关闭数据库
+	defer func() { <-done }()
+
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		deleted, err := storage.DeleteJobsBefore(time.Now().Add(-q.config.Retention))
+		if err != nil {
+			logging.Logger.Errorf("Failed to purge jobs: %v", err)
+			continue
+		}
+		if deleted > 0 {
+			logging.Logger.Infof("Purged %d finished jobs", deleted)
+		}
+	}
+}
+
+// executeJobItem 以任务提交者的身份经代理执行一个请求，与客户端直接请求一样经过校验、团队配置、缓存及用量统计
+func (s *Server) executeJobItem(ctx context.Context, job *jobs.Job, item *jobs.Item) (int, []byte) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, jobs.ProxyPath(job.Upstream, item.URL, item.Model, s.azureDeployments(job.TeamID)),
+		bytes.NewReader(item.Body))
+	if err != nil {
+		return http.StatusInternalServerError, []byte(err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(RequestIDHeader, fmt.Sprintf("%s-%d", job.ID, item.Index))
+	// 后台任务不应挤占交互请求的上游并发
+	req.Header.Set(PriorityHeader, scheduler.PRIORITY_LOW)
+	key := &APIKey{Subject: job.Subject, Email: job.Email, TeamID: job.TeamID}
+	req = req.WithContext(withAPIKey(req.Context(), key))
+
+	handler := s.observeProxy("openai", s.HandleOpenAIProxy)
+	if job.Upstream == "azure" {
+		handler = s.observeProxy("azure", s.HandleAzureOpenAIProxy)
+	}
+	response := &bufferedResponse{header: http.Header{}}
+	handler(response, req)
+	return response.Status(), response.body.Bytes()
+}
+
+// azureDeployments 团队生效的 Azure 模型映射，团队未配置时使用全局映射
+func (s *Server) azureDeployments(teamID string) map[string]string {
+	cfg := proxy.NewAzureConfigFromENV()
+	if team, err := s.teamManager.GetTeam(teamID); err == nil && team != nil {
+		team.ApplyAzure(cfg)
+	}
+	return cfg.ModelMappings
+}
+
+// handleSubmitJob 提交 JSONL 格式的请求文件（与 Batch API 输入文件格式一致），由网关在后台执行
+func (s *Server) handleSubmitJob(w http.ResponseWriter, r *http.Request) {
+	if s.jobQueue == nil {
+		s.ResponseError(apierror.New(http.StatusNotImplemented, "jobs_disabled", "Job queue is not enabled"), w)
+		return
+	}
+	upstream := r.URL.Query().Get("upstream")
+	if upstream == "" {
+		upstream = "openai"
+	}
+	if upstream != "openai" && upstream != "azure" {
+		s.ResponseError(apierror.InvalidRequest("upstream", "invalid_upstream", "Upstream must be openai or azure"), w)
+		return
+	}
+
+	cfg := s.jobQueue.config
+	body := http.MaxBytesReader(w, r.Body, cfg.MaxInputBytes)
+	requests, summary, err := batch.ParseRequests(body, s.jobModelFilter(r, upstream))
+	var maxBytesErr *http.MaxBytesError
+	var inputErr *batch.InputError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		s.ResponseError(apierror.New(http.StatusRequestEntityTooLarge, "request_too_large", "Job input is too large"), w)
+		return
+	case errors.As(err, &inputErr):
+		s.ResponseError(apierror.InvalidRequest("", "invalid_job_input", "Invalid job input: "+err.Error()), w)
+		return
+	case err != nil:
+		s.ResponseError(err, w)
+		return
+	}
+	if len(requests) > cfg.MaxRequests {
+		s.ResponseError(apierror.InvalidRequest("", "too_many_requests",
+			fmt.Sprintf("Job contains %d requests, the limit is %d", len(requests), cfg.MaxRequests)), w)
+		return
+	}
+
+	job, items := jobs.New(upstream, requests, summary)
+	key := APIKeyFromContext(r.Context())
+	job.Subject, job.Email, job.TeamID = key.Subject, key.Email, key.TeamID
+	if err := s.db.SaveJob(job, items); err != nil {
+		logging.Logger.Errorf("Failed to save job: %v", err)
+		s.ResponseError(err, w)
+		return
+	}
+	metrics.Jobs.WithLabelValues("submitted").Inc()
+	logging.Logger.Infof("Job %s submitted by %s with %d requests to %s", job.ID, job.Subject, job.Total, upstream)
+	s.ResponseJSON(job, w)
+}
+
+// jobModelFilter 返回校验请求模型的函数，模型目录为空时不校验
+func (s *Server) jobModelFilter(r *http.Request, upstream string) func(model string) bool {
+	models, err := s.modelsForRequest(r, upstream)
+	if err != nil {
+		logging.Logger.Errorf("Failed to load models for job validation: %v", err)
+		return nil
+	}
+	if len(models) == 0 {
+		return nil
+	}
+	return func(model string) bool {
+		return catalog.Find(models, model) != nil
+	}
+}
+
+// handleListJobs 列出任务，普通用户仅能查看自己提交的任务，管理员可按用户或团队查询
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	if s.db == nil {
+		s.ResponseError(ErrStorageUnavailable, w)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := &JobFilter{
+		User:   query.Get("user"),
+		TeamID: query.Get("team"),
+		Status: query.Get("status"),
+	}
+	filter.Limit, _ = strconv.Atoi(query.Get("limit"))
+
+	key := APIKeyFromContext(r.Context())
+	if !s.authMiddleware.IsAdmin(key) {
+		filter.User = key.Subject
+		if filter.User == "" {
+			filter.User = key.Email
+		}
+		filter.TeamID = ""
+		if filter.User == "" {
+			s.ResponseError(apierror.Forbidden("API key has no owner"), w)
+			return
+		}
+	}
+
+	list, err := s.db.ListJobs(filter)
+	if err != nil {
+		logging.Logger.Errorf("Failed to list jobs: %v", err)
+		s.ResponseError(err, w)
+		return
+	}
+	s.ResponseJSON(list, w)
+}
+
+// jobFromRequest 读取路径中的任务，任务不存在或不属于当前用户（管理员除外）时返回错误并返回 nil
+func (s *Server) jobFromRequest(w http.ResponseWriter, r *http.Request) *jobs.Job {
+	if s.db == nil {
+		s.ResponseError(ErrStorageUnavailable, w)
+		return nil
+	}
+	id := mux.Vars(r)["id"]
+	job, err := s.db.GetJob(id)
+	if err != nil {
+		logging.Logger.Errorf("Failed to load job %s: %v", id, err)
+		s.ResponseError(err, w)
+		return nil
+	}
+
+	key := APIKeyFromContext(r.Context())
+	owned := job != nil && ((key.Subject != "" && job.Subject == key.Subject) || (key.Email != "" && job.Email == key.Email))
+	if job == nil || (!owned && !s.authMiddleware.IsAdmin(key)) {
+		s.ResponseError(apierror.NotFound("No such job: "+id), w)
+		return nil
+	}
+	return job
+}
+
+// handleGetJob 查询任务状态及进度
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	if job := s.jobFromRequest(w, r); job != nil {
+		s.ResponseJSON(job, w)
+	}
+}
+
+// handleCancelJob 取消排队或执行中的任务，已执行的请求结果保留
+func (s *Server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	job := s.jobFromRequest(w, r)
+	if job == nil {
+		return
+	}
+	cancelled, err := s.db.CancelJob(job.ID)
+	if err != nil {
+		logging.Logger.Errorf("Failed to cancel job %s: %v", job.ID, err)
+		s.ResponseError(err, w)
+		return
+	}
+	if !cancelled {
+		s.ResponseError(apierror.New(http.StatusConflict, "job_finished", "Job has already finished"), w)
+		return
+	}
+	metrics.Jobs.WithLabelValues(jobs.STATUS_CANCELLED).Inc()
+	logging.Logger.Infof("Job %s cancelled", job.ID)
+
+	job, err = s.db.GetJob(job.ID)
+	if err != nil {
+		s.ResponseError(err, w)
+		return
+	}
+	s.ResponseJSON(job, w)
+}
+
+// handleJobResults 以 JSONL 格式（与 Batch API 输出文件一致）下载已执行请求的结果，任务执行中时返回已完成的部分
+func (s *Server) handleJobResults(w http.ResponseWriter, r *http.Request) {
+	job := s.jobFromRequest(w, r)
+	if job == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/jsonl")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.jsonl"`, job.ID))
+	after := -1
+	for {
+		items, err := s.db.ListJobResults(job.ID, after, jobResultsPageSize)
+		if err != nil {
+			// 响应已开始写入，只能中断
+			logging.Logger.Errorf("Failed to read results of job %s: %v", job.ID, err)
+			return
+		}
+		for _, item := range items {
+			_, _ = w.Write(append(item.Result(), '\n'))
+			after = item.Index
+		}
+		if len(items) < jobResultsPageSize {
+			return
+		}
+	}
+}
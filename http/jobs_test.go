@@ -0,0 +1,40 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"openai-forward/jobs"
+	"strings"
+	"testing"
+)
+
+func TestExecuteJobItem(t *testing.T) {
+	paths := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.Method + " " + r.URL.Path + " " + r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"object":"list","model":"text-embedding-3-small","usage":{"prompt_tokens":3,"total_tokens":3}}`))
+	}))
+	defer upstream.Close()
+
+	t.Setenv("OPENAI_TARGET_BASE_URL", upstream.URL)
+	t.Setenv("OPENAI_API_KEY", "sk-upstream")
+	s := &Server{teamManager: NewTeamManager(nil, nil)}
+
+	job := &jobs.Job{ID: "job_test", Subject: "alice", Upstream: "openai"}
+	item := &jobs.Item{JobID: job.ID, Index: 0, CustomID: "a", URL: "/v1/embeddings", Model: "text-embedding-3-small",
+		Body: []byte(`{"model":"text-embedding-3-small","input":"hello"}`)}
+	status, body := s.executeJobItem(context.Background(), job, item)
+
+	if got := <-paths; got != "POST /openai/v1/embeddings Bearer sk-upstream" {
+		t.Errorf("Unexpected upstream request: %s", got)
+	}
+	if status != http.StatusOK || !strings.Contains(string(body), `"prompt_tokens":3`) {
+		t.Errorf("Unexpected response %d: %s", status, body)
+	}
+	item.Complete(status, body)
+	if item.Status != jobs.ITEM_SUCCEEDED || item.PromptTokens != 3 {
+		t.Errorf("Unexpected item: %+v", item)
+	}
+}
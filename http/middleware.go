@@ -1,22 +1,34 @@
 package http
 
 import (
-	"encoding/json"
 	"fmt"
 	"net/http"
+	"openai-forward/apierror"
 	"openai-forward/logging"
+	"openai-forward/metrics"
+	"openai-forward/notify"
+	"openai-forward/realtime"
+	"openai-forward/tracing"
 	"os"
 	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // AuthMiddleware 认证中间件结构体
 type AuthMiddleware struct {
-	EnableAuth    bool
+	EnableAuth bool
+	// AdminEmails 管理员邮箱列表
+	AdminEmails []string
+	// IPRules 全局 IP 允许/拒绝列表
+	IPRules       *IPRules
 	apiKeyManager *APIKeyManager
+	notifier      *notify.Notifier
 }
 
 // NewAuthMiddleware 创建认证中间件
-func NewAuthMiddleware(apiKeyManager *APIKeyManager) *AuthMiddleware {
+func NewAuthMiddleware(apiKeyManager *APIKeyManager) (*AuthMiddleware, error) {
 	instance := &AuthMiddleware{
 		apiKeyManager: apiKeyManager,
 	}
@@ -28,52 +40,222 @@ func NewAuthMiddleware(apiKeyManager *APIKeyManager) *AuthMiddleware {
 		instance.EnableAuth = false
 	}
 
-	return instance
+	for _, email := range strings.Split(os.Getenv("HTTP_ADMIN_EMAILS"), ",") {
+		email = strings.TrimSpace(email)
+		if email != "" {
+			instance.AdminEmails = append(instance.AdminEmails, email)
+		}
+	}
+
+	// IP 规则无效时返回错误，不能退化为允许所有地址
+	ipRules, err := NewIPRules(os.Getenv("HTTP_IP_ALLOW"), os.Getenv("HTTP_IP_DENY"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP_IP_ALLOW or HTTP_IP_DENY: %w", err)
+	}
+	instance.IPRules = ipRules
+
+	return instance, nil
 }
 
+// SetNotifier 设置通知器，IP 限制拒绝请求时发送通知
+func (m *AuthMiddleware) SetNotifier(notifier *notify.Notifier) {
+	m.notifier = notifier
+}
+
+// IsAdmin 判断密钥所属用户是否为管理员
+func (m *AuthMiddleware) IsAdmin(key *APIKey) bool {
+	if key == nil || key.Email == "" {
+		return false
+	}
+	for _, email := range m.AdminEmails {
+		if strings.EqualFold(email, key.Email) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResponseError 返回 401 错误
 func (m *AuthMiddleware) ResponseError(err error, w http.ResponseWriter) {
-	w.WriteHeader(http.StatusUnauthorized)
-	if err != nil {
-		_ = json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error(), StdAPIResponse: StdAPIResponse{Status: false}})
-	} else {
-		_ = json.NewEncoder(w).Encode(StdAPIResponse{Status: true})
+	writeEnvelopeError(w, apierror.Unauthorized(err.Error()))
+}
+
+// ResponseForbidden 返回 403 错误
+func (m *AuthMiddleware) ResponseForbidden(err error, w http.ResponseWriter) {
+	writeEnvelopeError(w, apierror.Forbidden(err.Error()))
+}
+
+// checkIP 校验全局 IP 规则，拒绝时写入 403 响应并返回 false
+func (m *AuthMiddleware) checkIP(w http.ResponseWriter, r *http.Request) bool {
+	ip := ClientIP(r)
+	if !m.IPRules.Allowed(ip) {
+		recordKeyValidation(r, "ip_denied")
+		logging.Logger.Warningf("Rejected request from %s to %s by IP rules", ip, r.URL.Path)
+		m.notifyIPDenied(r, nil)
+		writeError(w, r, apierror.Forbidden("ip address not allowed"))
+		return false
+	}
+	return true
+}
+
+// checkKeyIP 校验密钥级别的 IP 限制，拒绝时写入 403 响应并返回 false
+func (m *AuthMiddleware) checkKeyIP(w http.ResponseWriter, r *http.Request, key *APIKey) bool {
+	if key.AllowedIPs == "" {
+		return true
+	}
+	keyRules, err := NewIPRules(key.AllowedIPs, "")
+	if err != nil || !keyRules.Allowed(ClientIP(r)) {
+		recordKeyValidation(r, "key_ip_denied")
+		logging.Logger.Warningf("Rejected API key %s used from %s", key.Prefix(), ClientIP(r))
+		m.notifyIPDenied(r, key)
+		writeError(w, r, apierror.Forbidden("ip address not allowed for this key"))
+		return false
 	}
+	return true
 }
 
 // AuthRequired 临时API密钥认证中间件
 func (m *AuthMiddleware) AuthRequired(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if !m.EnableAuth {
+			if !m.checkIP(w, r) {
+				return
+			}
 			next(w, r)
 			return
 		}
 
-		// 从请求头获取API密钥
-		apiKey := ""
-		apiKeyHeader := r.Header.Get("Api-Key")
-		if apiKeyHeader != "" {
-			apiKey = apiKeyHeader
+		m.KeyRequired(next)(w, r)
+	}
+}
+
+// KeyRequired 无论是否启用认证，都要求有效的API密钥，用于依赖用户身份的接口
+func (m *AuthMiddleware) KeyRequired(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := m.authenticate(w, r)
+		if key == nil {
+			return
 		}
 
-		// 如果请求头中没有，则从Authorization头获取
-		authHeader := r.Header.Get("Authorization")
-		if authHeader != "" {
-			// 支持 "Bearer <api_key>" 格式
-			if strings.HasPrefix(authHeader, "Bearer ") {
-				apiKey = strings.TrimPrefix(authHeader, "Bearer ")
-			} else {
-				apiKey = authHeader
+		// 继续处理请求
+		next(w, r.WithContext(withAPIKey(r.Context(), key)))
+	}
+}
+
+// OptionalKey 请求携带有效API密钥时将其写入上下文，否则按匿名请求处理
+func (m *AuthMiddleware) OptionalKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !m.checkIP(w, r) {
+			return
+		}
+		apiKey := ExtractAPIKey(r)
+		if apiKey != "" {
+			if key := m.apiKeyManager.GetValidKey(apiKey); key != nil {
+				r = r.WithContext(withAPIKey(r.Context(), key))
 			}
 		}
+		next(w, r)
+	}
+}
 
-		// 验证临时API密钥
-		if apiKey == "" || !m.apiKeyManager.ValidateTemporaryKey(apiKey) {
-			logging.Logger.Warningf("Unauthorized access attempt with API key: %s", apiKey)
-			m.ResponseError(fmt.Errorf("unauthorized"), w)
+// AdminRequired 要求API密钥属于管理员
+func (m *AuthMiddleware) AdminRequired(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := m.authenticate(w, r)
+		if key == nil {
 			return
 		}
 
-		// 继续处理请求
-		next(w, r)
+		if !m.IsAdmin(key) {
+			logging.Logger.Warningf("Forbidden admin access attempt by %s from %s", key.Email, ClientIP(r))
+			writeError(w, r, apierror.Forbidden("forbidden"))
+			return
+		}
+
+		next(w, r.WithContext(withAPIKey(r.Context(), key)))
+	}
+}
+
+// authenticate 校验 IP 规则并验证请求中的API密钥，失败时写入错误响应并返回 nil
+func (m *AuthMiddleware) authenticate(w http.ResponseWriter, r *http.Request) *APIKey {
+	ctx, span := tracing.Start(r.Context(), "auth.authenticate")
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	if !m.checkIP(w, r) {
+		return nil
+	}
+
+	apiKey := ExtractAPIKey(r)
+
+	// 验证临时API密钥
+	var key *APIKey
+	result := "missing"
+	if apiKey != "" {
+		_, storageSpan := tracing.Start(ctx, "storage.GetAPIKey")
+		key = m.apiKeyManager.GetValidKey(apiKey)
+		storageSpan.End()
+		result = "invalid"
+	}
+	if key == nil {
+		recordKeyValidation(r, result)
+		logging.Logger.Warningf("Unauthorized access attempt from %s with API key: %s", ClientIP(r), maskKey(apiKey))
+		writeError(w, r, apierror.Unauthorized("unauthorized"))
+		return nil
+	}
+
+	if !m.checkKeyIP(w, r, key) {
+		return nil
+	}
+
+	recordKeyValidation(r, "valid")
+	span.SetAttributes(attribute.String("auth.key_prefix", key.Prefix()), attribute.String("auth.team", key.TeamID))
+
+	_, storageSpan := tracing.Start(ctx, "storage.TouchAPIKey")
+	m.apiKeyManager.TouchKey(key, r.UserAgent())
+	storageSpan.End()
+
+	return key
+}
+
+// recordKeyValidation 记录密钥校验结果到指标及当前 span
+func recordKeyValidation(r *http.Request, result string) {
+	metrics.KeyValidations.WithLabelValues(result).Inc()
+	trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("auth.result", result))
+}
+
+// maskKey 隐藏密钥主体，仅保留前缀用于排查
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return strings.Repeat("*", len(key))
 	}
+	return key[:8] + "..."
+}
+
+// ExtractAPIKey 从请求头中提取API密钥
+func ExtractAPIKey(r *http.Request) string {
+	// 从请求头获取API密钥
+	apiKey := ""
+	apiKeyHeader := r.Header.Get("Api-Key")
+	if apiKeyHeader != "" {
+		apiKey = apiKeyHeader
+	}
+
+	// 如果请求头中没有，则从Authorization头获取
+	authHeader := r.Header.Get("Authorization")
+	if authHeader != "" {
+		// 支持 "Bearer <api_key>" 格式
+		if strings.HasPrefix(authHeader, "Bearer ") {
+			apiKey = strings.TrimPrefix(authHeader, "Bearer ")
+		} else {
+			apiKey = authHeader
+		}
+	}
+
+	// 浏览器无法为 WebSocket 设置请求头，Realtime 连接可通过子协议或查询参数传递密钥
+	if apiKey == "" {
+		apiKey = realtime.TokenFromRequest(r)
+	}
+
+	return apiKey
 }
@@ -0,0 +1,84 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthMiddleware_IsAdmin(t *testing.T) {
+	m := &AuthMiddleware{AdminEmails: []string{"admin@example.com"}}
+
+	if !m.IsAdmin(&APIKey{Email: "Admin@Example.com"}) {
+		t.Error("Expected admin email to match case-insensitively")
+	}
+	if m.IsAdmin(&APIKey{Email: "user@example.com"}) {
+		t.Error("Expected non-admin email to be rejected")
+	}
+	if m.IsAdmin(nil) {
+		t.Error("Expected nil key to be rejected")
+	}
+}
+
+func TestExtractAPIKey(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer test-key")
+	if key := ExtractAPIKey(req); key != "test-key" {
+		t.Errorf("Expected 'test-key', got '%s'", key)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Api-Key", "azure-key")
+	if key := ExtractAPIKey(req); key != "azure-key" {
+		t.Errorf("Expected 'azure-key', got '%s'", key)
+	}
+}
+
+func TestAuthMiddleware_KeyRequired(t *testing.T) {
+	m, _ := NewAuthMiddleware(NewAPIKeyManager(nil))
+
+	called := false
+	handler := m.KeyRequired(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/api/v1/auth/sessions", nil))
+
+	if called {
+		t.Error("Handler should not be called without a valid key")
+	}
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestAuthMiddleware_ErrorFormat(t *testing.T) {
+	m, _ := NewAuthMiddleware(NewAPIKeyManager(nil))
+	handler := m.KeyRequired(func(w http.ResponseWriter, r *http.Request) {})
+
+	// 代理接口返回 OpenAI 格式
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", "/openai/v1/chat/completions", nil))
+	var openAIBody struct {
+		Error struct {
+			Type string `json:"type"`
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &openAIBody); err != nil || openAIBody.Error.Type != "authentication_error" {
+		t.Errorf("Expected OpenAI error format, got %s", w.Body.String())
+	}
+
+	// 管理接口返回统一格式
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/api/v1/auth/sessions", nil))
+	var envelope ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil || envelope.Status || envelope.Error != "unauthorized" || envelope.Code != "invalid_api_key" {
+		t.Errorf("Expected envelope error format, got %s", w.Body.String())
+	}
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
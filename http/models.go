@@ -0,0 +1,201 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"openai-forward/apierror"
+	"openai-forward/catalog"
+	"openai-forward/config"
+	"openai-forward/logging"
+	"openai-forward/proxy"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ModelCatalogs 按上游凭证缓存的模型目录，不同团队的密钥、组织与项目可见的模型可能不同
+type ModelCatalogs struct {
+	ttl      time.Duration
+	metadata catalog.MetadataTable
+	mutex    sync.Mutex
+	catalogs map[string]*catalog.Catalog
+	// known 白名单及 Azure 模型映射中的模型，与元数据中的模型一起作为指标的模型标签
+	known map[string]bool
+}
+
+// NewModelCatalogs 创建模型目录管理器
+func NewModelCatalogs(ttl time.Duration, metadata catalog.MetadataTable) *ModelCatalogs {
+	return &ModelCatalogs{
+		ttl:      ttl,
+		metadata: metadata,
+		catalogs: make(map[string]*catalog.Catalog),
+		known:    make(map[string]bool),
+	}
+}
+
+// newModelCatalogsFromEnv 根据环境变量创建模型目录管理器
+func newModelCatalogsFromEnv() *ModelCatalogs {
+	metadata, err := catalog.LoadMetadataFromEnv()
+	if err != nil {
+		logging.Logger.Errorf("Failed to parse MODEL_METADATA: %v", err)
+	}
+	m := NewModelCatalogs(catalog.TTLFromEnv(), metadata)
+	if cfg, err := config.LoadConfig(); err == nil {
+		m.AddKnown(cfg.ModelsWhiteList...)
+	}
+	for id, deployment := range proxy.NewAzureConfigFromENV().ModelMappings {
+		m.AddKnown(id, deployment)
+	}
+	return m
+}
+
+// AddKnown 将模型加入指标标签使用的已知模型
+func (m *ModelCatalogs) AddKnown(ids ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, id := range ids {
+		if id = strings.TrimSpace(id); id != "" {
+			m.known[id] = true
+		}
+	}
+}
+
+// MetricLabel 返回模型在指标中的标签：已知模型使用原名称，其快照版本使用基础名称，其余归为 other，
+// 避免客户端传入任意模型名称导致标签基数无限增长
+func (m *ModelCatalogs) MetricLabel(model string) string {
+	if model == "" {
+		return "unknown"
+	}
+	if m == nil {
+		return modelLabel(model)
+	}
+	if m.isKnown(model) {
+		return model
+	}
+	if base := catalog.SnapshotBase(model); base != "" && m.isKnown(base) {
+		return base
+	}
+	return "other"
+}
+
+func (m *ModelCatalogs) isKnown(model string) bool {
+	if _, ok := m.metadata[model]; ok {
+		return true
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.known[model]
+}
+
+// OpenAI 返回 OpenAI 配置下白名单中的模型，上游模型列表从未拉取成功时按白名单返回
+func (m *ModelCatalogs) OpenAI(ctx context.Context, cfg *config.Config) []*catalog.ModelInfo {
+	upstream, err := m.openAICatalog(cfg).Models(ctx)
+	if err != nil {
+		return catalog.FromWhiteList(cfg.ModelsWhiteList, m.metadata)
+	}
+	return catalog.MergeOpenAI(upstream, cfg.ModelsWhiteList, m.metadata)
+}
+
+// Azure 返回 Azure 模型映射对应的模型
+func (m *ModelCatalogs) Azure(cfg *proxy.AzureConfig) []*catalog.ModelInfo {
+	return catalog.FromAzure(cfg.ModelMappings, m.metadata)
+}
+
+// openAICatalog 获取上游凭证对应的模型目录，不存在时创建
+func (m *ModelCatalogs) openAICatalog(cfg *config.Config) *catalog.Catalog {
+	hash := sha256.Sum256([]byte(cfg.TargetBaseURL + "\x00" + cfg.APIKey + "\x00" + cfg.OrgID + "\x00" + cfg.ProjectID))
+	key := hex.EncodeToString(hash[:])
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if c, ok := m.catalogs[key]; ok {
+		return c
+	}
+
+	openAIProxy := proxy.NewOpenAIProxy(cfg)
+	c := catalog.New(func(ctx context.Context) ([]*catalog.ModelInfo, error) {
+		models, err := openAIProxy.FetchModels(ctx)
+		if err != nil {
+			return nil, err
+		}
+		result := make([]*catalog.ModelInfo, 0, len(models))
+		for _, model := range models {
+			result = append(result, &catalog.ModelInfo{
+				ID:       model.ID,
+				Object:   "model",
+				Created:  model.Created,
+				OwnedBy:  model.OwnedBy,
+				Upstream: "openai",
+			})
+		}
+		return result, nil
+	}, m.ttl)
+	m.catalogs[key] = c
+	return c
+}
+
+// modelsForRequest 返回请求者（含所属团队配置）可用的模型
+func (s *Server) modelsForRequest(r *http.Request, upstream string) ([]*catalog.ModelInfo, error) {
+	team, denied := s.teamFromRequest(r)
+	if denied != nil {
+		return nil, denied
+	}
+	if upstream == "azure" {
+		cfg := proxy.NewAzureConfigFromENV()
+		if team != nil {
+			team.ApplyAzure(cfg)
+		}
+		return s.models.Azure(cfg), nil
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if team != nil {
+		team.ApplyOpenAI(cfg)
+	}
+	return s.models.OpenAI(r.Context(), cfg), nil
+}
+
+// modelListResponse OpenAI /v1/models 格式的模型列表
+type modelListResponse struct {
+	Object string               `json:"object"`
+	Data   []*catalog.ModelInfo `json:"data"`
+}
+
+// handleProxyModels 以 OpenAI /v1/models 格式返回模型目录，替代转发到上游
+func (s *Server) handleProxyModels(upstream string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		models, err := s.modelsForRequest(r, upstream)
+		if err != nil {
+			logging.Logger.Errorf("Failed to load models: %v", err)
+			apierror.Write(w, apierror.New(http.StatusInternalServerError, "internal_error", "Failed to load models"))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		id, ok := mux.Vars(r)["model"]
+		if !ok {
+			_ = json.NewEncoder(w).Encode(modelListResponse{Object: "list", Data: models})
+			return
+		}
+
+		model := catalog.Find(models, id)
+		if model == nil {
+			apierror.Write(w, &apierror.Error{
+				Status:  http.StatusNotFound,
+				Message: "The model '" + id + "' does not exist",
+				Type:    apierror.TYPE_INVALID_REQUEST,
+				Code:    "model_not_found",
+			})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(model)
+	}
+}
@@ -0,0 +1,93 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"openai-forward/catalog"
+	"openai-forward/config"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestHandleProxyModels(t *testing.T) {
+	calls := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"object":"list","data":[{"id":"gpt-4o","object":"model","owned_by":"system"},{"id":"dall-e-3","object":"model","owned_by":"system"}]}`))
+	}))
+	defer upstream.Close()
+
+	t.Setenv("OPENAI_TARGET_BASE_URL", upstream.URL)
+	t.Setenv("OPENAI_MODELS_WHITE_LIST", "gpt-4o")
+	s := &Server{
+		teamManager: NewTeamManager(nil, nil),
+		models:      NewModelCatalogs(time.Hour, catalog.MetadataTable{}),
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/openai/v1/models", s.handleProxyModels("openai"))
+	r.HandleFunc("/openai/v1/models/{model}", s.handleProxyModels("openai"))
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", "/openai/v1/models", nil))
+
+		var resp modelListResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &resp)
+		if err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if resp.Object != "list" || len(resp.Data) != 1 || resp.Data[0].ID != "gpt-4o" {
+			t.Errorf("Unexpected models: %s", rec.Body.String())
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected upstream to be called once, got %d", calls)
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/openai/v1/models/dall-e-3", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for model outside the white list, got %d", rec.Code)
+	}
+}
+
+func TestModelCatalogs_FallbackToWhiteList(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer upstream.Close()
+
+	catalogs := NewModelCatalogs(time.Hour, catalog.MetadataTable{})
+	models := catalogs.OpenAI(context.Background(), &config.Config{
+		TargetBaseURL:   upstream.URL,
+		ModelsWhiteList: []string{"gpt-4o-mini", "gpt-4o"},
+	})
+	if ids := catalog.IDs(models); len(ids) != 2 || ids[0] != "gpt-4o" {
+		t.Errorf("Expected white list fallback, got %v", ids)
+	}
+}
+
+func TestModelCatalogs_MetricLabel(t *testing.T) {
+	m := NewModelCatalogs(time.Hour, catalog.MetadataTable{"gpt-4o": {}})
+	m.AddKnown("gpt-4.1-mini", " my-deployment ")
+
+	for model, expected := range map[string]string{
+		"":                  "unknown",
+		"gpt-4o":            "gpt-4o",
+		"gpt-4o-2024-08-06": "gpt-4o",
+		"gpt-4.1-mini":      "gpt-4.1-mini",
+		"my-deployment":     "my-deployment",
+		"gpt-4o-random":     "other",
+		"made-up-model-123": "other",
+	} {
+		if label := m.MetricLabel(model); label != expected {
+			t.Errorf("Expected label %q for %q, got %q", expected, model, label)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"openai-forward/apierror"
+	"openai-forward/config"
+	"openai-forward/logging"
+	"openai-forward/moderation"
+	"openai-forward/notify"
+	"openai-forward/proxy"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// moderationBodyLimit 审核的请求体及非流式响应大小上限，超出时无法完整审核，拒绝请求
+const moderationBodyLimit = 4 << 20
+
+// moderatedRoutes 审核提示词的接口，embeddings 没有模型输出，仅审核请求
+var moderatedRoutes = map[string]bool{
+	"chat/completions": true,
+	"completions":      true,
+	"responses":        true,
+	"embeddings":       true,
+}
+
+// contentModeration 按团队与模型的审核策略过滤提示词及模型输出
+type contentModeration struct {
+	engine   *moderation.Engine
+	notifier *notify.Notifier
+}
+
+// newContentModeration 根据配置创建内容审核，未启用时返回 nil；策略无效时返回错误，
+// 不能在审核失效的情况下继续提供服务
+func newContentModeration(cfg *moderation.Config, notifier *notify.Notifier) (*contentModeration, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	// 审核接口使用服务端配置的 OpenAI 凭证，启动时创建一次
+	upstreamConfig, err := config.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load moderation upstream config: %w", err)
+	}
+	engine, err := moderation.New(cfg, proxy.NewOpenAIProxy(upstreamConfig))
+	if err != nil {
+		return nil, fmt.Errorf("invalid moderation policies: %w", err)
+	}
+	logging.Logger.Infof("Content moderation enabled: %s", cfg)
+	return &contentModeration{engine: engine, notifier: notifier}, nil
+}
+
+// moderatedRequest 一次经过审核的请求，记录输出阶段使用的过滤器链
+type moderatedRequest struct {
+	r        *http.Request
+	route    string
+	input    moderation.Input
+	output   *moderation.Chain
+	window   int
+	response *moderatedWriter
+	notifier *notify.Notifier
+}
+
+// begin 审核请求中的提示词：拦截时返回 400 错误，脱敏时改写请求体；无需审核输出时返回 nil
+func (m *contentModeration) begin(r *http.Request, upstream string, route string) (*moderatedRequest, *apierror.Error) {
+	if m == nil || r.Method != http.MethodPost || !moderatedRoutes[route] {
+		return nil, nil
+	}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return nil, nil
+	}
+
+	team := ""
+	if key := APIKeyFromContext(r.Context()); key != nil {
+		team = key.TeamID
+	}
+	// 请求体已由校验限制大小
+	model := proxy.PeekModel(r, 0)
+	if upstream == "azure" {
+		model = azureDeployment(r.URL.Path)
+	}
+	mr := &moderatedRequest{
+		r:        r,
+		route:    route,
+		input:    moderation.Input{RequestID: RequestIDFromContext(r.Context()), Team: team, Model: model},
+		window:   m.engine.StreamWindow(),
+		notifier: m.notifier,
+	}
+	if route != "embeddings" {
+		mr.output = m.engine.Chain(team, model, moderation.STAGE_OUTPUT)
+	}
+
+	if chain := m.engine.Chain(team, model, moderation.STAGE_INPUT); chain != nil {
+		if err := mr.screenRequest(chain); err != nil {
+			return nil, err
+		}
+	}
+	if mr.output == nil {
+		return nil, nil
+	}
+	return mr, nil
+}
+
+// screenRequest 审核请求体中的提示词
+func (mr *moderatedRequest) screenRequest(chain *moderation.Chain) *apierror.Error {
+	body, complete := peekBody(mr.r, moderationBodyLimit)
+	if !complete {
+		return apierror.New(http.StatusRequestEntityTooLarge, "request_too_large", "Request body is too large to be screened by the content policy")
+	}
+	doc, err := moderation.ParseRequest(mr.route, body)
+	if err != nil || doc == nil {
+		// 无效的 JSON 由上游返回错误
+		return nil
+	}
+
+	input := mr.input
+	input.Stage = moderation.STAGE_INPUT
+	input.Texts = doc.Texts()
+	result := chain.Screen(mr.r.Context(), &input)
+	mr.log(moderation.STAGE_INPUT, result)
+	if result.Blocked() {
+		return apierror.New(http.StatusBadRequest, "content_filtered", "The request was blocked by the content policy")
+	}
+	if !result.Redacted() || !doc.Replace(result.Texts) {
+		return nil
+	}
+
+	redacted, err := doc.Marshal()
+	if err != nil {
+		logging.Logger.Errorf("Failed to encode redacted request %s: %v", input.RequestID, err)
+		return apierror.New(http.StatusInternalServerError, "internal_error", "Failed to apply the content policy")
+	}
+	mr.r.Body = io.NopCloser(bytes.NewReader(redacted))
+	mr.r.ContentLength = int64(len(redacted))
+	mr.r.Header.Set("Content-Length", strconv.Itoa(len(redacted)))
+	return nil
+}
+
+// log 记录过滤器的审核决定，并在访问日志中标记审核结果
+func (mr *moderatedRequest) log(stage string, result *moderation.Result) {
+	if result == nil || len(result.Decisions) == 0 {
+		return
+	}
+	for _, decision := range result.Decisions {
+		logging.Logger.WithFields(logrus.Fields{
+			"request_id": mr.input.RequestID,
+			"stage":      stage,
+			"policy":     result.Policy,
+			"filter":     decision.Filter,
+			"action":     decision.Action,
+			"reason":     decision.Reason,
+			"team":       mr.input.Team,
+			"model":      mr.input.Model,
+		}).Info("moderation")
+	}
+	if entry := accessLogFromContext(mr.r.Context()); entry != nil {
+		entry.moderation = append(entry.moderation, stage+":"+result.Action)
+	}
+	if result.Blocked() {
+		mr.notifier.Notify(&notify.Event{
+			Type:    notify.EVENT_CONTENT_BLOCKED,
+			Summary: "Content blocked by moderation policy " + result.Policy + " at " + stage + " stage",
+			Attributes: map[string]string{
+				"request_id": mr.input.RequestID,
+				"stage":      stage,
+				"policy":     result.Policy,
+				"team":       mr.input.Team,
+				"model":      mr.input.Model,
+			},
+			Key: mr.input.Team + "/" + result.Policy + "/" + stage,
+		})
+	}
+}
+
+// writer 审核模型的输出：流式响应按窗口增量审核，其他成功响应缓冲后整体审核
+func (mr *moderatedRequest) writer(w http.ResponseWriter) http.ResponseWriter {
+	mr.response = &moderatedWriter{ResponseWriter: w, request: mr}
+	return mr.response
+}
+
+// finish 写出审核后的剩余输出
+func (mr *moderatedRequest) finish() {
+	mw := mr.response
+	switch {
+	case mw == nil:
+	case mw.stream != nil:
+		_ = mw.stream.Close()
+	case mw.tooLarge:
+		logging.Logger.Warnf("Response %s exceeds %d bytes and cannot be screened", mr.input.RequestID, moderationBodyLimit)
+		apierror.Write(mw.ResponseWriter, apierror.New(http.StatusBadGateway, "response_too_large", "Response body is too large to be screened by the content policy"))
+	case mw.buffer != nil:
+		mr.writeBuffered(mw.ResponseWriter, mw.buffer)
+	}
+}
+
+// writeBuffered 审核非流式响应后写入客户端
+func (mr *moderatedRequest) writeBuffered(w http.ResponseWriter, buffered *bufferedResponse) {
+	body := buffered.body.Bytes()
+	if doc, err := moderation.ParseResponse(mr.route, body); err == nil && doc != nil {
+		input := mr.input
+		input.Stage = moderation.STAGE_OUTPUT
+		input.Texts = doc.Texts()
+		result := mr.output.Screen(mr.r.Context(), &input)
+		mr.log(moderation.STAGE_OUTPUT, result)
+		if result.Blocked() {
+			apierror.Write(w, apierror.New(http.StatusBadRequest, "content_filtered", "The response was blocked by the content policy"))
+			return
+		}
+		if result.Redacted() && doc.Replace(result.Texts) {
+			if redacted, err := doc.Marshal(); err == nil {
+				body = redacted
+			}
+		}
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(buffered.Status())
+	_, _ = w.Write(body)
+}
+
+// moderatedWriter 在写出响应头时根据状态码与类型决定审核方式，失败响应原样写出
+type moderatedWriter struct {
+	http.ResponseWriter
+	request *moderatedRequest
+	decided bool
+	stream  *moderation.Stream
+	buffer  *bufferedResponse
+	// tooLarge 缓冲的响应超出审核大小上限，丢弃剩余输出并在结束时返回错误
+	tooLarge bool
+}
+
+func (mw *moderatedWriter) WriteHeader(code int) {
+	if mw.decided {
+		return
+	}
+	mw.decided = true
+	mr := mw.request
+	switch {
+	case code != http.StatusOK:
+	case strings.HasPrefix(mw.Header().Get("Content-Type"), "text/event-stream"):
+		mw.Header().Del("Content-Length")
+		mw.stream = moderation.NewStream(mr.r.Context(), mr.output, mr.input, mr.route, mr.window, mw.ResponseWriter)
+		mw.stream.OnResult = func(result *moderation.Result) {
+			mr.log(moderation.STAGE_OUTPUT, result)
+		}
+	default:
+		mw.buffer = &bufferedResponse{header: mw.Header()}
+		mw.buffer.WriteHeader(code)
+		return
+	}
+	mw.ResponseWriter.WriteHeader(code)
+}
+
+func (mw *moderatedWriter) Write(p []byte) (int, error) {
+	if !mw.decided {
+		mw.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case mw.stream != nil:
+		return mw.stream.Write(p)
+	case mw.tooLarge:
+		return len(p), nil
+	case mw.buffer != nil:
+		// 超出上限后继续读取但不缓冲，写入出错时代理会中断处理，无法返回错误响应
+		if mw.buffer.body.Len()+len(p) > moderationBodyLimit {
+			mw.tooLarge = true
+			mw.buffer = nil
+			return len(p), nil
+		}
+		return mw.buffer.Write(p)
+	}
+	return mw.ResponseWriter.Write(p)
+}
+
+// Flush 缓冲的响应在审核后一次写出，无需刷新；流式响应由 Stream 在审核通过后刷新
+func (mw *moderatedWriter) Flush() {
+	if mw.buffer != nil || mw.stream != nil || mw.tooLarge {
+		return
+	}
+	if flusher, ok := mw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// azureDeployment 从 Azure 请求路径中提取部署名称，Azure 以部署区分模型
+func azureDeployment(path string) string {
+	_, rest, ok := strings.Cut(path, "/deployments/")
+	if !ok {
+		return ""
+	}
+	deployment, _, _ := strings.Cut(rest, "/")
+	return deployment
+}
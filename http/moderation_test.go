@@ -0,0 +1,143 @@
+package http
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"openai-forward/moderation"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestModeration(t *testing.T, policies ...*moderation.Policy) *contentModeration {
+	engine, err := moderation.New(&moderation.Config{Enabled: true, Policies: policies, Timeout: time.Second, StreamWindow: 10}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create moderation engine: %v", err)
+	}
+	return &contentModeration{engine: engine}
+}
+
+func TestObserveProxy_ModerationInput(t *testing.T) {
+	s := &Server{moderation: newTestModeration(t, &moderation.Policy{Name: "default", Models: []string{"gpt-4o*"}, Filters: []*moderation.FilterConfig{
+		{Type: moderation.TYPE_REGEX, Action: moderation.ACTION_REDACT, Patterns: []string{`EMP-\d+`}},
+		{Type: moderation.TYPE_KEYWORDS, Keywords: []string{"project falcon"}},
+	}})}
+
+	var forwarded string
+	handler := s.observeProxy("openai", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		forwarded = string(body)
+		if r.ContentLength != int64(len(body)) {
+			t.Errorf("Expected content length %d, got %d", len(body), r.ContentLength)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices":[]}`))
+	})
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	rec := send(`{"model":"gpt-4o","messages":[{"role":"user","content":"Tell me about Project Falcon"}]}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "content_filtered") || forwarded != "" {
+		t.Fatalf("Expected request to be blocked, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = send(`{"model":"gpt-4o","messages":[{"role":"user","content":"Who is EMP-1234?"}]}`)
+	if rec.Code != http.StatusOK || !strings.Contains(forwarded, "Who is [REDACTED:pattern_1]?") {
+		t.Errorf("Expected redacted prompt to be forwarded, got %d: %s", rec.Code, forwarded)
+	}
+
+	// 策略不适用的模型不审核
+	rec = send(`{"model":"o3-mini","messages":[{"role":"user","content":"Project Falcon"}]}`)
+	if rec.Code != http.StatusOK || !strings.Contains(forwarded, "Project Falcon") {
+		t.Errorf("Expected request to pass, got %d: %s", rec.Code, forwarded)
+	}
+}
+
+func TestObserveProxy_ModerationOutput(t *testing.T) {
+	s := &Server{moderation: newTestModeration(t, &moderation.Policy{Filters: []*moderation.FilterConfig{
+		{Type: moderation.TYPE_KEYWORDS, Stage: moderation.STAGE_OUTPUT, Action: moderation.ACTION_REDACT, Keywords: []string{"hunter2"}},
+		{Type: moderation.TYPE_KEYWORDS, Stage: moderation.STAGE_OUTPUT, Keywords: []string{"forbidden"}},
+	}})}
+
+	var response, contentType string
+	status := http.StatusOK
+	handler := s.observeProxy("openai", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(response)))
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(response))
+	})
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[]}`))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	contentType = "application/json"
+	response = `{"choices":[{"index":0,"message":{"role":"assistant","content":"The password is hunter2"}}]}`
+	rec := send()
+	if !strings.Contains(rec.Body.String(), "The password is [REDACTED:pattern_1]") || rec.Header().Get("Content-Length") != strconv.Itoa(rec.Body.Len()) {
+		t.Errorf("Unexpected redacted response: %v %s", rec.Header(), rec.Body.String())
+	}
+
+	response = `{"choices":[{"index":0,"message":{"role":"assistant","content":"a forbidden answer"}}]}`
+	if rec := send(); rec.Code != http.StatusBadRequest || strings.Contains(rec.Body.String(), "forbidden answer") {
+		t.Errorf("Expected response to be blocked, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// 超出审核大小上限的响应不会未经审核写出
+	response = `{"choices":[{"index":0,"message":{"role":"assistant","content":"` + strings.Repeat("a", moderationBodyLimit) + `"}}]}`
+	if rec := send(); rec.Code != http.StatusBadGateway || !strings.Contains(rec.Body.String(), "response_too_large") {
+		t.Errorf("Expected oversized response to be rejected, got %d: %.100s", rec.Code, rec.Body.String())
+	}
+
+	// 上游错误原样返回
+	status = http.StatusTooManyRequests
+	response = `{"error":{"message":"forbidden"}}`
+	if rec := send(); rec.Code != http.StatusTooManyRequests || rec.Body.String() != response {
+		t.Errorf("Expected error to pass through, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	status = http.StatusOK
+	contentType = "text/event-stream"
+	response = `data: {"choices":[{"index":0,"delta":{"content":"Sure, "}}]}` + "\n\n" +
+		`data: {"choices":[{"index":0,"delta":{"content":"the forbidden part."}}]}` + "\n\n" + "data: [DONE]\n\n"
+	rec = send()
+	if !strings.Contains(rec.Body.String(), "content_filtered") || strings.Contains(rec.Body.String(), "forbidden part") {
+		t.Errorf("Expected stream to be blocked: %s", rec.Body.String())
+	}
+	if rec.Header().Get("Content-Length") != "" {
+		t.Errorf("Expected Content-Length to be removed from stream")
+	}
+}
+
+func TestAzureDeployment(t *testing.T) {
+	if got := azureDeployment("/azure/openai/deployments/gpt-4o/chat/completions"); got != "gpt-4o" {
+		t.Errorf("Unexpected deployment: %s", got)
+	}
+	if got := azureDeployment("/azure/openai/models"); got != "" {
+		t.Errorf("Unexpected deployment: %s", got)
+	}
+}
+
+func TestNewContentModeration_InvalidPolicy(t *testing.T) {
+	// 策略无效时返回错误，由 NewServer 拒绝启动，而不是关闭审核继续服务
+	cfg := &moderation.Config{Enabled: true, Timeout: time.Second, Policies: []*moderation.Policy{
+		{Name: "default", Filters: []*moderation.FilterConfig{{Type: moderation.TYPE_REGEX, Patterns: []string{`(`}}}},
+	}}
+	if m, err := newContentModeration(cfg, nil); err == nil || m != nil {
+		t.Errorf("Expected invalid policy to be rejected, got %v", err)
+	}
+	if m, err := newContentModeration(&moderation.Config{}, nil); err != nil || m != nil {
+		t.Errorf("Expected disabled moderation, got %v", err)
+	}
+}
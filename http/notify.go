@@ -0,0 +1,157 @@
+package http
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"openai-forward/apierror"
+	"openai-forward/health"
+	"openai-forward/logging"
+	"openai-forward/notify"
+	"openai-forward/service"
+	"strconv"
+)
+
+// newNotifier 根据配置创建通知器，未配置通知目标时返回 nil；未配置数据库时发件箱保存在内存中
+func newNotifier(cfg *notify.Config, storage IStorage) *notify.Notifier {
+	if !cfg.Enabled {
+		return nil
+	}
+	var store notify.Store = notify.NewMemoryStore()
+	if storage != nil {
+		store = storage
+	} else {
+		logging.Logger.Warn("Notification outbox requires a database, pending notifications will be lost on restart")
+	}
+	logging.Logger.Infof("Notifications enabled: %s", cfg)
+	return notify.New(cfg, store)
+}
+
+// notifyIPDenied 请求因 IP 限制被拒绝时发送通知，同一 IP 在冷却时间内只通知一次
+func (m *AuthMiddleware) notifyIPDenied(r *http.Request, key *APIKey) {
+	ip := ClientIP(r)
+	event := &notify.Event{
+		Type:       notify.EVENT_IP_DENIED,
+		Summary:    "Request from " + ip + " rejected by IP rules",
+		Attributes: map[string]string{"client_ip": ip, "path": r.URL.Path},
+		Key:        ip,
+	}
+	if key != nil {
+		event.Summary = "API key " + key.Prefix() + " used from disallowed address " + ip
+		event.Attributes["key_prefix"] = key.Prefix()
+		event.Attributes["user"] = key.Email
+		event.Key = ip + "/" + key.Prefix()
+	}
+	m.notifier.Notify(event)
+}
+
+// notifyDomainDenied 登录或续期因邮箱域名不在允许列表中被拒绝时发送通知
+func (s *Server) notifyDomainDenied(r *http.Request, err error) {
+	if !errors.Is(err, service.ErrEmailDomainNotAllowed) {
+		return
+	}
+	s.notifier.Notify(&notify.Event{
+		Type:       notify.EVENT_DOMAIN_DENIED,
+		Summary:    "Sign-in rejected: " + err.Error(),
+		Attributes: map[string]string{"client_ip": ClientIP(r), "path": r.URL.Path, "error": err.Error()},
+		Key:        err.Error(),
+	})
+}
+
+// notifyQuotaExhausted 上游返回 insufficient_quota 时发送通知，同一上游与团队在冷却时间内只通知一次
+func (s *Server) notifyQuotaExhausted(r *http.Request, upstream string, model string, recorder *responseRecorder) {
+	if s.notifier == nil || recorder.Status() != http.StatusTooManyRequests || !bytes.Contains(recorder.tail, []byte("insufficient_quota")) {
+		return
+	}
+	team := ""
+	if key := APIKeyFromContext(r.Context()); key != nil {
+		team = key.TeamID
+	}
+	s.notifier.Notify(&notify.Event{
+		Type:    notify.EVENT_QUOTA_EXHAUSTED,
+		Summary: "Upstream " + upstream + " quota exhausted",
+		Attributes: map[string]string{
+			"upstream":   upstream,
+			"model":      model,
+			"team":       team,
+			"request_id": RequestIDFromContext(r.Context()),
+		},
+		Key: upstream + "/" + team,
+	})
+}
+
+// notifyHealthChange 返回健康检查状态变化的回调，依赖失败与恢复时发送通知
+func notifyHealthChange(notifier *notify.Notifier) func(previous *health.Result, current *health.Result) {
+	return func(previous *health.Result, current *health.Result) {
+		event := &notify.Event{
+			Type:       notify.EVENT_DEPENDENCY_RECOVERED,
+			Summary:    "Dependency " + current.Name + " recovered",
+			Attributes: map[string]string{"check": current.Name},
+		}
+		if current.Status == health.STATUS_ERROR {
+			event.Type = notify.EVENT_DEPENDENCY_UNHEALTHY
+			event.Summary = "Dependency " + current.Name + " is unhealthy: " + current.Error
+			event.Attributes["error"] = current.Error
+			// 可选依赖失败不影响就绪状态，降低级别
+			if current.Optional {
+				event.Severity = notify.SEVERITY_WARNING
+			}
+		}
+		notifier.Notify(event)
+	}
+}
+
+// handleListNotifications 查询发件箱中的通知，支持按状态、事件类型及目标过滤
+func (s *Server) handleListNotifications(w http.ResponseWriter, r *http.Request) {
+	if s.notifier == nil {
+		s.ResponseError(apierror.New(http.StatusNotImplemented, "notifications_disabled", "Notifications are not enabled"), w)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := &notify.DeliveryFilter{
+		Status:    query.Get("status"),
+		EventType: query.Get("event"),
+		Target:    query.Get("target"),
+	}
+	filter.Limit, _ = strconv.Atoi(query.Get("limit"))
+	if filter.Limit <= 0 || filter.Limit > 1000 {
+		filter.Limit = 100
+	}
+
+	deliveries, err := s.notifier.Store().ListDeliveries(filter)
+	if err != nil {
+		logging.Logger.Errorf("Failed to list notifications: %v", err)
+		s.ResponseError(err, w)
+		return
+	}
+	s.ResponseJSON(deliveries, w)
+}
+
+// handleTestNotification 向全部目标或 target 参数指定的目标发送测试通知，返回新建的通知
+func (s *Server) handleTestNotification(w http.ResponseWriter, r *http.Request) {
+	if s.notifier == nil {
+		s.ResponseError(apierror.New(http.StatusNotImplemented, "notifications_disabled", "Notifications are not enabled"), w)
+		return
+	}
+
+	attributes := map[string]string{}
+	if key := APIKeyFromContext(r.Context()); key != nil {
+		attributes["requested_by"] = key.Email
+	}
+	deliveries, err := s.notifier.Enqueue(&notify.Event{
+		Type:       notify.EVENT_TEST,
+		Summary:    "Test notification from openai-forward",
+		Attributes: attributes,
+	}, r.URL.Query().Get("target"))
+	if errors.Is(err, notify.ErrUnknownTarget) {
+		s.ResponseError(apierror.InvalidRequest("target", "unknown_target", "Unknown notification target"), w)
+		return
+	}
+	if err != nil {
+		logging.Logger.Errorf("Failed to enqueue test notification: %v", err)
+		s.ResponseError(err, w)
+		return
+	}
+	s.ResponseJSON(deliveries, w)
+}
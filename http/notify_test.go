@@ -0,0 +1,123 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"openai-forward/health"
+	"openai-forward/notify"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestNotifier 创建使用内存发件箱、接收全部事件的通知器，不启动后台发送
+func newTestNotifier() *notify.Notifier {
+	cfg := &notify.Config{Enabled: true, Targets: []*notify.Target{{Name: "ops", URL: "http://127.0.0.1:1"}},
+		MaxAttempts: 1, Backoff: time.Second, MaxBackoff: time.Second, Timeout: time.Second, Cooldown: time.Minute,
+		PollInterval: time.Second, Retention: time.Hour}
+	_ = cfg.Validate()
+	return notify.New(cfg, notify.NewMemoryStore())
+}
+
+func listNotifications(notifier *notify.Notifier, eventType string) []*notify.Delivery {
+	deliveries, _ := notifier.Store().ListDeliveries(&notify.DeliveryFilter{EventType: eventType})
+	return deliveries
+}
+
+func TestObserveProxy_QuotaExhaustedNotification(t *testing.T) {
+	notifier := newTestNotifier()
+	s := &Server{notifier: notifier}
+	handler := s.observeProxy("openai", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"error":{"message":"You exceeded your current quota","type":"insufficient_quota","code":"insufficient_quota"}}`))
+	})
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`)))
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("Expected upstream status to be passed through, got %d", rec.Code)
+		}
+	}
+	deliveries := listNotifications(notifier, notify.EVENT_QUOTA_EXHAUSTED)
+	if len(deliveries) != 1 || deliveries[0].Event.Attributes["upstream"] != "openai" || deliveries[0].Event.Severity != notify.SEVERITY_CRITICAL {
+		t.Errorf("Expected one quota notification within cooldown: %+v", deliveries)
+	}
+}
+
+func TestAuthMiddleware_IPDeniedNotification(t *testing.T) {
+	notifier := newTestNotifier()
+	m, _ := NewAuthMiddleware(NewAPIKeyManager(nil))
+	m.IPRules, _ = NewIPRules("", "192.0.2.0/24")
+	m.SetNotifier(notifier)
+
+	handler := m.OptionalKey(func(w http.ResponseWriter, r *http.Request) {})
+	req := httptest.NewRequest("GET", "/api/v1/openai/models", nil)
+	req.RemoteAddr = "192.0.2.10:1234"
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403, got %d", rec.Code)
+	}
+	deliveries := listNotifications(notifier, notify.EVENT_IP_DENIED)
+	if len(deliveries) != 1 || deliveries[0].Event.Attributes["client_ip"] != "192.0.2.10" {
+		t.Errorf("Unexpected notifications: %+v", deliveries)
+	}
+}
+
+func TestHealthChangeNotification(t *testing.T) {
+	notifier := newTestNotifier()
+	checker := health.NewChecker(&health.Config{Timeout: time.Second})
+	failing := true
+	checker.Register("storage", false, func(ctx context.Context) error {
+		if failing {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	checker.OnChange(notifyHealthChange(notifier))
+
+	checker.Run(context.Background())
+	failing = false
+	checker.Run(context.Background())
+	unhealthy := listNotifications(notifier, notify.EVENT_DEPENDENCY_UNHEALTHY)
+	recovered := listNotifications(notifier, notify.EVENT_DEPENDENCY_RECOVERED)
+	if len(unhealthy) != 1 || unhealthy[0].Event.Attributes["error"] != "connection refused" || len(recovered) != 1 {
+		t.Errorf("Expected failure and recovery notifications: %+v %+v", unhealthy, recovered)
+	}
+}
+
+func TestHandleTestNotification(t *testing.T) {
+	s := &Server{}
+	rec := httptest.NewRecorder()
+	s.handleTestNotification(rec, httptest.NewRequest("POST", "/api/v1/admin/notifications/test", nil))
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("Expected 501 when notifications are disabled, got %d", rec.Code)
+	}
+
+	s.notifier = newTestNotifier()
+	rec = httptest.NewRecorder()
+	s.handleTestNotification(rec, httptest.NewRequest("POST", "/api/v1/admin/notifications/test?target=unknown", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown target, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleTestNotification(rec, httptest.NewRequest("POST", "/api/v1/admin/notifications/test", nil))
+	var response struct {
+		Data []*notify.Delivery `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || len(response.Data) != 1 || response.Data[0].Target != "ops" {
+		t.Fatalf("Unexpected response: %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleListNotifications(rec, httptest.NewRequest("GET", "/api/v1/admin/notifications?event=notification.test", nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || len(response.Data) != 1 || response.Data[0].Status != notify.STATUS_PENDING {
+		t.Errorf("Unexpected notifications: %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,330 @@
+package http
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"openai-forward/apierror"
+	"openai-forward/metrics"
+	"openai-forward/proxy"
+	"openai-forward/retry"
+	"openai-forward/scheduler"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// usageTailSize 保留的响应尾部大小，usage 位于 JSON 响应末尾或流式响应的最后一个分片
+const usageTailSize = 64 << 10
+
+// knownRoutes 已知的上游接口，用于生成低基数的 route 标签及选择请求体上限，按路径段匹配
+var knownRoutes = []string{
+	"chat/completions",
+	"completions",
+	"embeddings",
+	"responses",
+	"images/generations",
+	"images/edits",
+	"images/variations",
+	"audio/transcriptions",
+	"audio/translations",
+	"audio/speech",
+	"moderations",
+	"models",
+	"files",
+	"batches",
+	"assistants",
+	"threads",
+	"vector_stores",
+	"fine_tuning",
+	"uploads",
+	"realtime",
+}
+
+// routeLabel 将请求路径归一化为已知接口名称，未知接口返回 other
+//
+// 取路径中最先出现的已知接口，嵌套的资源归属于外层接口，如 /v1/vector_stores/{id}/files 为 vector_stores；
+// Azure 的部署名称可能与接口同名，不参与匹配。
+func routeLabel(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 0; i < len(segments); i++ {
+		if segments[i] == "deployments" {
+			i++
+			continue
+		}
+		for _, route := range knownRoutes {
+			n := strings.Count(route, "/") + 1
+			if i+n <= len(segments) && strings.Join(segments[i:i+n], "/") == route {
+				return route
+			}
+		}
+	}
+	return "other"
+}
+
+// modelLabel 模型标签，为空时返回 unknown
+func modelLabel(model string) string {
+	if model == "" {
+		return "unknown"
+	}
+	if len(model) > 64 {
+		return model[:64]
+	}
+	return model
+}
+
+// responseRecorder 记录响应状态码、字节数及首个分片时间，并保留响应尾部用于解析用量
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	firstByteAt time.Time
+	captureTail bool
+	tail        []byte
+	// mirror 同步接收响应体的副本（如审计捕获、响应缓存），为空时不复制
+	mirror io.Writer
+	// requestID 网关的请求 ID，响应头中没有 X-Request-ID 时写入，为空时不写入
+	requestID string
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w, captureTail: true}
+}
+
+// newStatusRecorder 仅记录状态码与字节数，不保留响应内容
+func newStatusRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w}
+}
+
+// writeRequestID 上游未返回 X-Request-ID 时写入网关的请求 ID，须在写入响应头之前调用
+func (r *responseRecorder) writeRequestID() {
+	if r.requestID != "" && r.status == 0 && r.Header().Get(RequestIDHeader) == "" {
+		r.Header().Set(RequestIDHeader, r.requestID)
+	}
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	r.writeRequestID()
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.writeRequestID()
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if r.firstByteAt.IsZero() && len(b) > 0 {
+		r.firstByteAt = time.Now()
+	}
+
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+
+	if r.mirror != nil {
+		_, _ = r.mirror.Write(b[:n])
+	}
+	if r.captureTail {
+		r.tail = append(r.tail, b[:n]...)
+		if len(r.tail) > 2*usageTailSize {
+			r.tail = append(r.tail[:0], r.tail[len(r.tail)-usageTailSize:]...)
+		}
+	}
+	return n, err
+}
+
+// addMirror 增加一个接收响应体副本的 Writer
+func (r *responseRecorder) addMirror(w io.Writer) {
+	if r.mirror == nil {
+		r.mirror = w
+		return
+	}
+	r.mirror = io.MultiWriter(r.mirror, w)
+}
+
+// Flush 支持流式响应
+func (r *responseRecorder) Flush() {
+	r.writeRequestID()
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack 支持 WebSocket 协议升级
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	if r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap 供 http.ResponseController 访问底层 ResponseWriter
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Status 响应状态码，未写入响应时视为 200
+func (r *responseRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// Streamed 是否为 SSE 流式响应
+func (r *responseRecorder) Streamed() bool {
+	return strings.HasPrefix(r.Header().Get("Content-Type"), "text/event-stream")
+}
+
+// Usage 解析响应中的 token 用量，仅成功响应有效
+func (r *responseRecorder) Usage() *proxy.Usage {
+	if r.Status() >= 300 {
+		return nil
+	}
+	return proxy.ParseUsage(r.tail)
+}
+
+// observeProxy 记录代理请求的指标，需在认证中间件内部使用以获取密钥所属团队
+func (s *Server) observeProxy(upstream string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		route := routeLabel(r.URL.Path)
+		// 只读取接口请求体上限内的内容，超出的请求随后由校验拒绝
+		requested := proxy.PeekModel(r, s.validator.BodyLimit(route))
+		model := modelLabel(requested)
+		// 指标只使用已知模型作为标签，日志与用量记录保留请求中的模型
+		metricModel := s.models.MetricLabel(requested)
+
+		entry := accessLogFromContext(r.Context())
+		if entry != nil {
+			entry.upstream = upstream
+			entry.model = model
+		}
+
+		span := trace.SpanFromContext(r.Context())
+		span.SetAttributes(
+			attribute.String("upstream", upstream),
+			attribute.String("gen_ai.request.model", model),
+		)
+
+		inFlight := metrics.InFlightRequests.WithLabelValues(upstream)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		recorder := newResponseRecorder(w)
+		// 在开始审计捕获前清除请求中的密钥与个人信息，审计记录中不会出现原文
+		scrubDenied := s.scrubber.apply(recorder, r, route)
+		var capture *auditCapture
+		if s.shouldAudit(r) {
+			capture = s.startAudit(r, recorder)
+		}
+
+		if s.retries != nil {
+			r = r.WithContext(retry.WithPolicy(r.Context(), s.retries.Policy(r.Method, upstream, route)))
+		}
+
+		// 包含需拒绝的敏感信息、团队不可用或模型不在团队可用列表中、校验失败、被内容审核拦截、访问其他用户资源、批处理输入文件无效或排队超时的请求不会转发到上游
+		var moderated *moderatedRequest
+		var lookup *cacheLookup
+		var resource *resourceRequest
+		var batchReq *batchRequest
+		var ticket *scheduler.Ticket
+		var denied *apierror.Error
+		if scrubDenied != nil {
+			apierror.Write(recorder, scrubDenied)
+		} else if _, denied = s.checkTeam(r, requested); denied != nil {
+			apierror.Write(recorder, denied)
+		} else if err := s.validator.Check(recorder, r, upstream, route); err != nil {
+			writeValidationError(recorder, err)
+		} else if moderated, denied = s.moderation.begin(r, upstream, route); denied != nil {
+			apierror.Write(recorder, denied)
+		} else if resource, denied = s.resources.begin(r, upstream); denied != nil {
+			apierror.Write(recorder, denied)
+		} else if batchReq, denied = s.batches.begin(r, upstream); denied != nil {
+			apierror.Write(recorder, denied)
+		} else if lookup = s.cache.lookup(r, upstream, route); lookup.hit() {
+			span.SetAttributes(attribute.Bool("cache.hit", true))
+			lookup.serve(recorder)
+		} else if ticket, denied = s.scheduler.acquire(recorder, r, upstream); denied != nil {
+			apierror.Write(recorder, denied)
+		} else {
+			if lookup != nil {
+				s.cache.capture(lookup, recorder)
+			}
+			if batchReq != nil {
+				batchReq.capture(recorder)
+			}
+			var writer http.ResponseWriter = recorder
+			if resource != nil {
+				writer = resource.writer(recorder)
+			}
+			if moderated != nil {
+				writer = moderated.writer(writer)
+			}
+			// 处理出现 panic 时也要归还槽位，正常结束后尽早归还以免等待缓存写入等后续处理
+			defer ticket.Release()
+			next(writer, r)
+			ticket.Release()
+			if moderated != nil {
+				moderated.finish()
+			}
+			if resource != nil {
+				resource.finish(recorder)
+			}
+			if batchReq != nil {
+				batchReq.finish(recorder)
+			}
+			if lookup != nil {
+				s.cache.save(lookup, recorder)
+			}
+		}
+		if entry != nil && lookup != nil {
+			entry.cache = recorder.Header().Get(CacheHeader)
+		}
+
+		status := strconv.Itoa(recorder.Status())
+		metrics.RequestsTotal.WithLabelValues(route, upstream, metricModel, status).Inc()
+		metrics.RequestDuration.WithLabelValues(route, upstream, metricModel, status).Observe(time.Since(start).Seconds())
+		if recorder.Streamed() && !recorder.firstByteAt.IsZero() {
+			metrics.TimeToFirstToken.WithLabelValues(route, upstream, metricModel).Observe(recorder.firstByteAt.Sub(start).Seconds())
+		}
+		s.notifyQuotaExhausted(r, upstream, model, recorder)
+
+		usage := recorder.Usage()
+		if entry != nil {
+			entry.usage = usage
+		}
+		if capture != nil {
+			s.finishAudit(r, capture, recorder, entry, start)
+		}
+		if usage == nil {
+			return
+		}
+		s.recordUsage(r, upstream, route, model, start, recorder.Status(), usage, lookup.hit())
+		team := "none"
+		if key := APIKeyFromContext(r.Context()); key != nil && key.TeamID != "" {
+			team = key.TeamID
+		}
+		// 命中缓存的请求未消耗上游用量，单独统计
+		tokens := metrics.TokensTotal
+		if lookup.hit() {
+			tokens = metrics.CachedTokensTotal
+		}
+		tokens.WithLabelValues(metricModel, team, "prompt").Add(float64(usage.Prompt()))
+		tokens.WithLabelValues(metricModel, team, "completion").Add(float64(usage.Completion()))
+		span.SetAttributes(
+			attribute.Int("gen_ai.usage.input_tokens", usage.Prompt()),
+			attribute.Int("gen_ai.usage.output_tokens", usage.Completion()),
+		)
+	}
+}
@@ -0,0 +1,141 @@
+package http
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"openai-forward/cache"
+	"openai-forward/proxy"
+	"openai-forward/validate"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouteLabel(t *testing.T) {
+	testCases := map[string]string{
+		"/openai/v1/chat/completions":                       "chat/completions",
+		"/openai/v1/completions":                            "completions",
+		"/azure/openai/deployments/gpt-4o/chat/completions": "chat/completions",
+		"/openai/v1/embeddings":                             "embeddings",
+		"/openai/v1/unknown":                                "other",
+		"/openai/v1/vector_stores/vs_1/files":               "vector_stores",
+		"/openai/v1/files/file-1/content":                   "files",
+		"/openai/v1/threads/thread_1/runs":                  "threads",
+		"/azure/openai/deployments/files/embeddings":        "embeddings",
+		"/openai/v1/chat/completionsx":                      "other",
+	}
+
+	for path, expected := range testCases {
+		if route := routeLabel(path); route != expected {
+			t.Errorf("Expected route '%s' for '%s', got '%s'", expected, path, route)
+		}
+	}
+}
+
+func TestResponseRecorder(t *testing.T) {
+	rec := httptest.NewRecorder()
+	recorder := newResponseRecorder(rec)
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: {\"usage\":null}\n\n"))
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte("data: {\"usage\":{\"prompt_tokens\":1,\"completion_tokens\":2,\"total_tokens\":3}}\n\n"))
+	}
+	handler(recorder, httptest.NewRequest("POST", "/openai/v1/chat/completions", nil))
+
+	if recorder.Status() != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", recorder.Status())
+	}
+	if !recorder.Streamed() {
+		t.Error("Expected response to be streamed")
+	}
+	if !rec.Flushed {
+		t.Error("Expected flush to reach the underlying writer")
+	}
+	usage := recorder.Usage()
+	if usage == nil || usage.Total() != 3 {
+		t.Errorf("Unexpected usage: %+v", usage)
+	}
+}
+
+func TestObserveProxy_Cache(t *testing.T) {
+	s := &Server{cache: &responseCache{
+		config: &cache.Config{MaxEntryBytes: 1 << 20, TTLs: map[string]time.Duration{"embeddings": time.Hour}},
+		store:  cache.NewMemoryStore(1 << 20),
+	}}
+
+	calls := 0
+	handler := s.observeProxy("openai", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		_, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":[],"usage":{"prompt_tokens":2,"total_tokens":2}}`))
+	})
+
+	send := func(body string, cacheControl string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/openai/v1/embeddings", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if cacheControl != "" {
+			req.Header.Set("Cache-Control", cacheControl)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	body := `{"model":"text-embedding-3-small","input":"hello"}`
+	if rec := send(body, ""); rec.Header().Get(CacheHeader) != "MISS" {
+		t.Errorf("Expected first request to miss, got '%s'", rec.Header().Get(CacheHeader))
+	}
+	rec := send(`{"input":"hello","model":"text-embedding-3-small"}`, "")
+	if rec.Header().Get(CacheHeader) != "HIT" {
+		t.Errorf("Expected second request to hit, got '%s'", rec.Header().Get(CacheHeader))
+	}
+	if !strings.Contains(rec.Body.String(), `"prompt_tokens":2`) {
+		t.Errorf("Unexpected cached body: %s", rec.Body.String())
+	}
+	if rec := send(body, "no-cache"); rec.Header().Get(CacheHeader) != "MISS" {
+		t.Errorf("Expected no-cache request to miss, got '%s'", rec.Header().Get(CacheHeader))
+	}
+	if calls != 2 {
+		t.Errorf("Expected 2 upstream calls, got %d", calls)
+	}
+
+	// 自带上游密钥的请求不使用缓存
+	req := httptest.NewRequest("POST", "/openai/v1/embeddings", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(proxy.UpstreamKeyHeader, "sk-client")
+	rec = httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Header().Get(CacheHeader) != "" || calls != 3 {
+		t.Errorf("Expected BYOK request to bypass the cache, got '%s' after %d calls", rec.Header().Get(CacheHeader), calls)
+	}
+
+	// 要求客户端提供上游密钥时，未提供密钥的请求也不能命中他人付费的缓存
+	t.Setenv("OPENAI_UPSTREAM_KEY_MODE", "require")
+	if rec := send(body, ""); rec.Header().Get(CacheHeader) != "" || calls != 4 {
+		t.Errorf("Expected cache to be skipped when upstream keys are required, got '%s' after %d calls", rec.Header().Get(CacheHeader), calls)
+	}
+}
+
+func TestObserveProxy_Validation(t *testing.T) {
+	s := &Server{validator: validate.NewValidator(&validate.Config{Enabled: true, MaxBodyBytes: 1 << 20})}
+
+	handler := s.observeProxy("openai", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Invalid request should not be forwarded")
+	})
+
+	req := httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"type":"invalid_request_error"`) || !strings.Contains(rec.Body.String(), `"param":"messages"`) {
+		t.Errorf("Unexpected error body: %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,303 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"openai-forward/apierror"
+	"openai-forward/audit"
+	"openai-forward/logging"
+	"openai-forward/metrics"
+	"openai-forward/ownership"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// resourceBodyLimit 解析创建响应时读取的最大字节数
+const resourceBodyLimit = 1 << 20
+
+// referenceBodyLimit 解析请求体引用的资源时读取的最大字节数，与 REQUEST_MAX_BODY_BYTES 的默认值一致；
+// 超过时无法校验引用，拒绝请求
+const referenceBodyLimit = 32 << 20
+
+// resourceGuard 按用户或团队隔离共享上游账号下的文件、批处理、向量库、助手与线程
+type resourceGuard struct {
+	config  *ownership.Config
+	store   ownership.Store
+	isAdmin func(key *APIKey) bool
+}
+
+// newResourceGuard 根据配置创建资源归属隔离，未启用时返回 nil
+func newResourceGuard(cfg *ownership.Config, storage IStorage, auth *AuthMiddleware) *resourceGuard {
+	if cfg.Scope == ownership.SCOPE_OFF {
+		return nil
+	}
+	if storage == nil {
+		logging.Logger.Error("Resource ownership isolation requires a database, isolation disabled")
+		return nil
+	}
+
+	logging.Logger.Infof("Resource ownership isolation enabled: %s", cfg)
+	return &resourceGuard{config: cfg, store: storage, isAdmin: auth.IsAdmin}
+}
+
+// resourceRequest 一次访问上游资源的请求
+type resourceRequest struct {
+	guard    *resourceGuard
+	target   *ownership.Target
+	owner    *ownership.Owner
+	upstream string
+	method   string
+	// list 列表请求的缓冲响应，过滤后再写入客户端
+	list *bufferedResponse
+	// body 创建资源或查询批处理时捕获的响应体
+	body *audit.CappedBuffer
+}
+
+// resourceOwner 密钥对应的资源归属
+func resourceOwner(key *APIKey) *ownership.Owner {
+	return &ownership.Owner{Subject: key.Subject, Email: key.Email, TeamID: key.TeamID}
+}
+
+// begin 校验请求访问及引用的资源是否属于当前用户，无需隔离时返回 nil
+//
+// 未认证的请求（未启用认证）无法确定归属，管理员可访问所有资源，均不做隔离。
+func (g *resourceGuard) begin(r *http.Request, upstream string) (*resourceRequest, *apierror.Error) {
+	if g == nil {
+		return nil, nil
+	}
+	key := APIKeyFromContext(r.Context())
+	if key == nil || g.isAdmin(key) {
+		return nil, nil
+	}
+	owner := resourceOwner(key)
+
+	// 请求体引用其他用户的资源（如批处理的输入文件、运行使用的助手）同样视为不存在
+	if r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		body, complete := peekBody(r, referenceBodyLimit)
+		if !complete {
+			return nil, apierror.New(http.StatusRequestEntityTooLarge, "request_too_large",
+				fmt.Sprintf("Request body is too large to verify resource ownership, the limit is %d bytes.", referenceBodyLimit))
+		}
+		references := ownership.References(body)
+		ids := make([]string, len(references))
+		for i, reference := range references {
+			ids[i] = reference.ID
+		}
+		if denied := g.firstDenied(owner, ids, func(i int) string { return references[i].Type }); denied != nil {
+			return nil, denied
+		}
+	}
+
+	target := ownership.ParseTarget(r.URL.Path)
+	if target == nil {
+		return nil, nil
+	}
+	if target.ID != "" {
+		if denied := g.firstDenied(owner, []string{target.ID}, func(int) string { return target.Type }); denied != nil {
+			return nil, denied
+		}
+	}
+	return &resourceRequest{guard: g, target: target, owner: owner, upstream: upstream, method: r.Method}, nil
+}
+
+// firstDenied 返回第一个无权访问的资源对应的 404 错误，均可访问时返回 nil
+func (g *resourceGuard) firstDenied(owner *ownership.Owner, ids []string, resourceType func(i int) string) *apierror.Error {
+	if len(ids) == 0 {
+		return nil
+	}
+	resources, err := g.resources(ids)
+	if err != nil {
+		logging.Logger.Errorf("Failed to load resource owners: %v", err)
+		return errOwnershipUnavailable()
+	}
+	for i, id := range ids {
+		if !g.config.Allowed(owner, resources[id]) {
+			metrics.ResourceDenials.WithLabelValues(resourceType(i)).Inc()
+			logging.Logger.Warnf("Denied access to %s %s by %s", resourceType(i), id, owner.Subject)
+			// 与资源不存在时一致，不暴露资源的存在
+			return apierror.NotFound("No such " + resourceType(i) + " object: " + id)
+		}
+	}
+	return nil
+}
+
+// errOwnershipUnavailable 无法读取资源归属时返回的错误，此时拒绝请求而不是放行
+func errOwnershipUnavailable() *apierror.Error {
+	return apierror.New(http.StatusServiceUnavailable, "storage_unavailable", "Failed to verify resource ownership")
+}
+
+// resources 批量读取资源归属，按 ID 索引
+func (g *resourceGuard) resources(ids []string) (map[string]*ownership.Resource, error) {
+	records, err := g.store.GetResources(ids)
+	if err != nil {
+		return nil, err
+	}
+	resources := make(map[string]*ownership.Resource, len(records))
+	for _, record := range records {
+		resources[record.ID] = record
+	}
+	return resources, nil
+}
+
+// writer 返回转发请求使用的 ResponseWriter，列表请求的响应先缓冲以便过滤
+func (rr *resourceRequest) writer(recorder *responseRecorder) http.ResponseWriter {
+	switch {
+	case rr.target.IsList(rr.method):
+		rr.list = &bufferedResponse{header: recorder.Header()}
+		return rr.list
+	case rr.target.IsCreate(rr.method), rr.target.Type == ownership.TYPE_BATCH && rr.target.ID != "":
+		rr.body = audit.NewCappedBuffer(resourceBodyLimit)
+		recorder.addMirror(rr.body)
+	}
+	return recorder
+}
+
+// finish 过滤列表响应，并记录新建、删除的资源及批处理生成的文件
+func (rr *resourceRequest) finish(recorder *responseRecorder) {
+	if rr.list != nil {
+		rr.writeList(recorder)
+		return
+	}
+	if recorder.Status() >= http.StatusMultipleChoices {
+		return
+	}
+
+	switch {
+	case rr.target.IsDelete(rr.method):
+		err := rr.guard.store.MarkResourceDeleted(rr.target.ID, time.Now())
+		if err != nil {
+			logging.Logger.Errorf("Failed to mark %s %s as deleted: %v", rr.target.Type, rr.target.ID, err)
+		}
+	case rr.target.IsCreate(rr.method):
+		if id := ownership.Created(rr.target, []byte(rr.body.String())); id != "" {
+			rr.save(id, rr.target.Type)
+		}
+	case rr.body != nil:
+		// 批处理完成后由上游生成的输出文件归属于批处理的创建者
+		for _, id := range ownership.BatchFiles([]byte(rr.body.String())) {
+			rr.save(id, ownership.TYPE_FILE)
+		}
+	}
+}
+
+// save 记录资源归属于当前用户
+func (rr *resourceRequest) save(id string, resourceType string) {
+	err := rr.guard.store.SaveResource(ownership.NewResource(id, resourceType, rr.upstream, rr.owner))
+	if err != nil {
+		logging.Logger.Errorf("Failed to save owner of %s %s: %v", resourceType, id, err)
+	}
+}
+
+// writeList 仅返回当前用户可访问的列表项，无法过滤（响应无法解析或读取归属失败）时返回错误，不返回未过滤的列表
+func (rr *resourceRequest) writeList(recorder *responseRecorder) {
+	body := rr.list.body.Bytes()
+	if rr.list.Status() == http.StatusOK {
+		filtered, err := rr.filter(body)
+		if err != nil {
+			logging.Logger.Errorf("Failed to filter %s list: %v", rr.target.Type, err)
+			apierror.Write(recorder, errOwnershipUnavailable())
+			return
+		}
+		body = filtered
+	}
+	recorder.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	recorder.WriteHeader(rr.list.Status())
+	_, _ = recorder.Write(body)
+}
+
+// filter 过滤列表响应
+func (rr *resourceRequest) filter(body []byte) ([]byte, error) {
+	ids, err := ownership.ListIDs(body)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := rr.guard.resources(ids)
+	if err != nil {
+		return nil, err
+	}
+	return ownership.FilterList(body, func(id string) bool {
+		return rr.guard.config.Allowed(rr.owner, resources[id])
+	})
+}
+
+// bufferedResponse 缓冲上游响应，由调用方处理后再写入客户端
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(code int) {
+	if b.status == 0 {
+		b.status = code
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// Flush 缓冲的响应无需刷新
+func (b *bufferedResponse) Flush() {}
+
+// Status 响应状态码，未写入响应时视为 200
+func (b *bufferedResponse) Status() int {
+	if b.status == 0 {
+		return http.StatusOK
+	}
+	return b.status
+}
+
+// handleListResources 列出当前用户通过网关创建且未删除的上游资源
+func (s *Server) handleListResources(w http.ResponseWriter, r *http.Request) {
+	key := APIKeyFromContext(r.Context())
+	filter := resourceFilterFromQuery(r)
+	filter.User = key.Subject
+	if filter.User == "" {
+		filter.User = key.Email
+	}
+	filter.TeamID = ""
+	s.listResources(filter, w)
+}
+
+// handleAdminListResources 管理员按用户、团队或类型列出资源归属，用于按用户清理上游资源
+func (s *Server) handleAdminListResources(w http.ResponseWriter, r *http.Request) {
+	s.listResources(resourceFilterFromQuery(r), w)
+}
+
+// resourceFilterFromQuery 读取查询参数中的资源过滤条件
+func resourceFilterFromQuery(r *http.Request) *ResourceFilter {
+	query := r.URL.Query()
+	filter := &ResourceFilter{
+		User:           query.Get("user"),
+		TeamID:         query.Get("team"),
+		Type:           query.Get("type"),
+		IncludeDeleted: query.Get("include_deleted") == "true",
+	}
+	filter.Limit, _ = strconv.Atoi(query.Get("limit"))
+	return filter
+}
+
+// listResources 查询资源归属记录
+func (s *Server) listResources(filter *ResourceFilter, w http.ResponseWriter) {
+	if s.db == nil {
+		s.ResponseError(ErrStorageUnavailable, w)
+		return
+	}
+	resources, err := s.db.ListResources(filter)
+	if err != nil {
+		logging.Logger.Errorf("Failed to list resources: %v", err)
+		s.ResponseError(err, w)
+		return
+	}
+	s.ResponseJSON(resources, w)
+}
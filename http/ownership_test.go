@@ -0,0 +1,133 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"openai-forward/ownership"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryResourceStore 测试用的资源归属存储
+type memoryResourceStore struct {
+	mu        sync.Mutex
+	resources map[string]*ownership.Resource
+	// err 不为空时读取归属返回该错误，模拟存储不可用
+	err error
+}
+
+func (m *memoryResourceStore) SaveResource(resource *ownership.Resource) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.resources[resource.ID]; !ok {
+		m.resources[resource.ID] = resource
+	}
+	return nil
+}
+
+func (m *memoryResourceStore) GetResources(ids []string) ([]*ownership.Resource, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+	resources := []*ownership.Resource{}
+	for _, id := range ids {
+		if resource, ok := m.resources[id]; ok {
+			resources = append(resources, resource)
+		}
+	}
+	return resources, nil
+}
+
+func (m *memoryResourceStore) MarkResourceDeleted(id string, deletedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if resource, ok := m.resources[id]; ok {
+		resource.DeletedAt = &deletedAt
+	}
+	return nil
+}
+
+func TestObserveProxy_ResourceOwnership(t *testing.T) {
+	store := &memoryResourceStore{resources: map[string]*ownership.Resource{
+		"file-b": {ID: "file-b", Type: ownership.TYPE_FILE, Subject: "bob"},
+	}}
+	s := &Server{resources: &resourceGuard{
+		config:  &ownership.Config{Scope: ownership.SCOPE_USER},
+		store:   store,
+		isAdmin: func(key *APIKey) bool { return false },
+	}}
+
+	handler := s.observeProxy("openai", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/openai/v1/files":
+			_, _ = w.Write([]byte(`{"id":"file-a","object":"file"}`))
+		case r.Method == "GET" && r.URL.Path == "/openai/v1/files":
+			w.Header().Set("Content-Length", "100")
+			_, _ = w.Write([]byte(`{"object":"list","data":[{"id":"file-a"},{"id":"file-b"},{"id":"file-c"}],"has_more":false}`))
+		default:
+			_, _ = w.Write([]byte(`{"id":"file-a","deleted":true}`))
+		}
+	})
+
+	send := func(user string, method string, path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req = req.WithContext(withAPIKey(context.Background(), &APIKey{Subject: user}))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	send("alice", "POST", "/openai/v1/files", "")
+	if store.resources["file-a"] == nil || store.resources["file-a"].Subject != "alice" {
+		t.Fatalf("Expected file-a to be owned by alice, got %+v", store.resources["file-a"])
+	}
+
+	if rec := send("bob", "GET", "/openai/v1/files/file-a", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another user's file, got %d", rec.Code)
+	}
+	if rec := send("alice", "GET", "/openai/v1/files/file-a/content", ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected owner to access file, got %d", rec.Code)
+	}
+	if rec := send("bob", "POST", "/openai/v1/batches", `{"input_file_id":"file-a","endpoint":"/v1/chat/completions"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 when referencing another user's file, got %d", rec.Code)
+	}
+
+	rec := send("bob", "GET", "/openai/v1/files", "")
+	body := rec.Body.String()
+	if !strings.Contains(body, `"file-b"`) || strings.Contains(body, `"file-a"`) || strings.Contains(body, `"file-c"`) {
+		t.Errorf("Expected list to contain only bob's files, got %s", body)
+	}
+	if rec.Header().Get("Content-Length") == "100" {
+		t.Error("Expected Content-Length to match the filtered list")
+	}
+
+	// 超出解析上限的请求体无法校验引用，直接拒绝
+	padded := `{"input_file_id":"file-a","endpoint":"/v1/chat/completions","metadata":{"pad":"` +
+		strings.Repeat("x", referenceBodyLimit) + `"}}`
+	if rec := send("bob", "POST", "/openai/v1/batches", padded); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for oversized body, got %d", rec.Code)
+	}
+
+	// 存储不可用时不返回未过滤的列表
+	store.err = errors.New("connection refused")
+	rec = send("bob", "GET", "/openai/v1/files", "")
+	if rec.Code != http.StatusServiceUnavailable || strings.Contains(rec.Body.String(), "file-a") {
+		t.Errorf("Expected list to fail closed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	store.err = nil
+
+	send("alice", "DELETE", "/openai/v1/files/file-a", "")
+	if store.resources["file-a"].DeletedAt == nil {
+		t.Error("Expected file-a to be marked as deleted")
+	}
+}
@@ -0,0 +1,132 @@
+package http
+
+import (
+	"net/http"
+	"openai-forward/apierror"
+	"openai-forward/config"
+	"openai-forward/logging"
+	"openai-forward/metrics"
+	"openai-forward/proxy"
+	"openai-forward/realtime"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// isWebSocketUpgrade 路由匹配器，仅匹配 WebSocket 升级请求
+func isWebSocketUpgrade(r *http.Request, _ *mux.RouteMatch) bool {
+	return websocket.IsWebSocketUpgrade(r)
+}
+
+// handleRealtime 代理 Realtime API 的 WebSocket 连接，会话结束后按 response.done 事件记录用量
+func (s *Server) handleRealtime(upstream string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := newStatusRecorder(w)
+		team, denied := s.checkTeam(r, r.URL.Query().Get("model"))
+		if denied != nil {
+			apierror.Write(recorder, denied)
+			return
+		}
+
+		inFlight := metrics.InFlightRequests.WithLabelValues(upstream)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		var session *realtime.Session
+		model := r.URL.Query().Get("model")
+		if upstream == "azure" {
+			cfg := proxy.NewAzureConfigFromENV()
+			if team != nil {
+				team.ApplyAzure(cfg)
+			}
+			azureProxy, err := proxy.NewAzureProxy(cfg)
+			if err != nil {
+				logging.Logger.Errorf("Failed to load azure config: %v", err)
+				apierror.Write(recorder, apierror.New(http.StatusInternalServerError, "invalid_configuration", "Upstream is not configured"))
+				return
+			}
+			model = azureProxy.RealtimeDeployment(r)
+			s.observeRealtimeStart(r, upstream, model)
+			session = azureProxy.ServeRealtime(recorder, r, s.realtime)
+		} else {
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				logging.Logger.Errorf("Failed to load config: %v", err)
+				apierror.Write(recorder, apierror.New(http.StatusInternalServerError, "invalid_configuration", "Upstream is not configured"))
+				return
+			}
+			if team != nil {
+				team.ApplyOpenAI(cfg)
+			}
+			s.observeRealtimeStart(r, upstream, model)
+			session = proxy.NewOpenAIProxy(cfg).ServeRealtime(recorder, r, s.realtime)
+		}
+
+		if session != nil && session.Model != "" {
+			model = session.Model
+		}
+		metricModel := s.models.MetricLabel(model)
+		model = modelLabel(model)
+		status := strconv.Itoa(recorder.Status())
+		metrics.RequestsTotal.WithLabelValues("realtime", upstream, metricModel, status).Inc()
+		metrics.RequestDuration.WithLabelValues("realtime", upstream, metricModel, status).Observe(time.Since(start).Seconds())
+		if session == nil {
+			return
+		}
+		s.observeRealtimeEnd(r, upstream, model, metricModel, session)
+	}
+}
+
+// observeRealtimeStart 记录会话的上游与模型
+func (s *Server) observeRealtimeStart(r *http.Request, upstream string, model string) {
+	if entry := accessLogFromContext(r.Context()); entry != nil {
+		entry.upstream = upstream
+		entry.model = modelLabel(model)
+	}
+	trace.SpanFromContext(r.Context()).SetAttributes(
+		attribute.String("upstream", upstream),
+		attribute.String("gen_ai.request.model", modelLabel(model)),
+	)
+}
+
+// observeRealtimeEnd 记录会话时长、结束原因及按模态统计的用量，metricModel 为指标中的模型标签
+func (s *Server) observeRealtimeEnd(r *http.Request, upstream string, model string, metricModel string, session *realtime.Session) {
+	usage := session.Usage
+	metrics.RealtimeSessions.WithLabelValues(upstream, session.CloseReason).Inc()
+	metrics.RealtimeSessionDuration.WithLabelValues(upstream).Observe(session.Duration().Seconds())
+
+	team := "none"
+	if key := APIKeyFromContext(r.Context()); key != nil && key.TeamID != "" {
+		team = key.TeamID
+	}
+	metrics.TokensTotal.WithLabelValues(metricModel, team, "prompt").Add(float64(usage.InputTokens))
+	metrics.TokensTotal.WithLabelValues(metricModel, team, "completion").Add(float64(usage.OutputTokens))
+	for kind, tokens := range map[string]int{
+		"input_text":   usage.InputTextTokens,
+		"input_audio":  usage.InputAudioTokens,
+		"input_cached": usage.CachedTokens,
+		"output_text":  usage.OutputTextTokens,
+		"output_audio": usage.OutputAudioTokens,
+	} {
+		metrics.RealtimeTokens.WithLabelValues(metricModel, team, kind).Add(float64(tokens))
+	}
+
+	used := &proxy.Usage{InputTokens: usage.InputTokens, OutputTokens: usage.OutputTokens}
+	if entry := accessLogFromContext(r.Context()); entry != nil {
+		entry.model = model
+		entry.usage = used
+	}
+	s.recordUsage(r, upstream, "realtime", model, session.StartedAt, http.StatusSwitchingProtocols, used, false)
+	trace.SpanFromContext(r.Context()).SetAttributes(
+		attribute.Int("gen_ai.usage.input_tokens", usage.InputTokens),
+		attribute.Int("gen_ai.usage.output_tokens", usage.OutputTokens),
+	)
+	logging.Logger.Infof("Realtime session to %s closed (%s) after %s: %d responses, %d input tokens (%d audio), %d output tokens (%d audio)",
+		upstream, session.CloseReason, session.Duration().Round(time.Second), usage.Responses,
+		usage.InputTokens, usage.InputAudioTokens, usage.OutputTokens, usage.OutputAudioTokens)
+}
@@ -0,0 +1,72 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"openai-forward/realtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+func TestHandleRealtime(t *testing.T) {
+	paths := make(chan string, 1)
+	upgrader := websocket.Upgrader{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.RequestURI() + " " + r.Header.Get("Authorization")
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"response.done","response":{"usage":{"input_tokens":5,"output_tokens":2}}}`))
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer upstream.Close()
+
+	t.Setenv("OPENAI_TARGET_BASE_URL", upstream.URL)
+	t.Setenv("OPENAI_API_KEY", "sk-upstream")
+	s := &Server{
+		teamManager: NewTeamManager(nil, nil),
+		realtime:    realtime.NewRelay(&realtime.Config{MaxDuration: time.Minute, MaxMessageBytes: 1 << 20, HandshakeTimeout: time.Second}),
+	}
+
+	r := mux.NewRouter()
+	r.Use(s.logRequest)
+	r.HandleFunc("/openai/v1/realtime", s.handleRealtime("openai")).MatcherFunc(isWebSocketUpgrade)
+	gateway := httptest.NewServer(r)
+	defer gateway.Close()
+
+	url := "ws" + strings.TrimPrefix(gateway.URL, "http") + "/openai/v1/realtime?model=gpt-4o-realtime-preview&api_key=gateway-key"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial through gateway failed: %v", err)
+	}
+	defer conn.Close()
+
+	if got := <-paths; got != "/v1/realtime?model=gpt-4o-realtime-preview Bearer sk-upstream" {
+		t.Errorf("Unexpected upstream request: %s", got)
+	}
+	_, message, err := conn.ReadMessage()
+	if err != nil || !strings.Contains(string(message), "response.done") {
+		t.Errorf("Expected relayed event, got %s (%v)", message, err)
+	}
+}
+
+func TestExtractAPIKey_WebSocket(t *testing.T) {
+	r := httptest.NewRequest("GET", "/openai/v1/realtime", nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Sec-WebSocket-Protocol", "realtime, "+realtime.SUBPROTOCOL_API_KEY_PREFIX+"gateway-key")
+
+	if key := ExtractAPIKey(r); key != "gateway-key" {
+		t.Errorf("Expected key from subprotocol, got %q", key)
+	}
+	r.Header.Set("Authorization", "Bearer header-key")
+	if key := ExtractAPIKey(r); key != "header-key" {
+		t.Errorf("Header key should take precedence, got %q", key)
+	}
+}
@@ -0,0 +1,101 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"openai-forward/apierror"
+	"openai-forward/logging"
+	"openai-forward/scheduler"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PriorityHeader 请求优先级头，客户端只能借此降低密钥或团队分配的优先级；响应中返回实际使用的优先级
+	PriorityHeader = "X-Request-Priority"
+	// QueueWaitHeader 请求在上游调度队列中等待的毫秒数
+	QueueWaitHeader = "X-Queue-Wait-Ms"
+)
+
+// upstreamScheduler 在转发到上游前按优先级与用户公平地分配并发槽位
+type upstreamScheduler struct {
+	config    *scheduler.Config
+	scheduler *scheduler.Scheduler
+	// fallback 密钥未指定优先级时使用的优先级
+	fallback func(r *http.Request) string
+}
+
+// newUpstreamScheduler 根据配置创建上游调度，未启用时返回 nil
+func (s *Server) newUpstreamScheduler(cfg *scheduler.Config) *upstreamScheduler {
+	if !cfg.Enabled {
+		return nil
+	}
+	logging.Logger.Infof("Upstream scheduler enabled: %s", cfg)
+	return &upstreamScheduler{config: cfg, scheduler: scheduler.New(cfg), fallback: s.defaultPriority}
+}
+
+// priority 返回请求的优先级：密钥指定的优先级优先于团队，均未指定时使用默认值，请求头只能在此基础上降低
+func (u *upstreamScheduler) priority(r *http.Request) string {
+	var assigned string
+	if key := APIKeyFromContext(r.Context()); key != nil && key.Priority != "" {
+		assigned = key.Priority
+	} else {
+		assigned = u.fallback(r)
+	}
+	return scheduler.Lower(assigned, strings.ToLower(r.Header.Get(PriorityHeader)))
+}
+
+// defaultPriority 返回密钥未指定优先级时请求使用的优先级：团队的优先级，团队未指定时为默认优先级
+func (s *Server) defaultPriority(r *http.Request) string {
+	if team, _ := s.teamFromRequest(r); team != nil && team.Priority != "" {
+		return team.Priority
+	}
+	if s.scheduler != nil {
+		return s.scheduler.config.DefaultPriority
+	}
+	return scheduler.PRIORITY_NORMAL
+}
+
+// acquire 为请求申请上游并发槽位并在响应头中返回优先级及排队时间，排队超时或队列已满时返回 503 错误。
+// 未启用时返回 nil Ticket，调用 Release 是安全的。
+func (u *upstreamScheduler) acquire(w http.ResponseWriter, r *http.Request, upstream string) (*scheduler.Ticket, *apierror.Error) {
+	if u == nil {
+		return nil, nil
+	}
+	priority := u.priority(r)
+	ticket, err := u.scheduler.Acquire(r.Context(), upstream, priority, requestOwner(r))
+	if errors.Is(err, scheduler.ErrQueueFull) || errors.Is(err, scheduler.ErrQueueTimeout) {
+		w.Header().Set("Retry-After", "1")
+	}
+	switch {
+	case errors.Is(err, scheduler.ErrQueueFull):
+		logging.Logger.Warnf("Upstream %s queue is full, rejected %s request", upstream, priority)
+		return nil, apierror.New(http.StatusServiceUnavailable, "queue_full", "Upstream is busy, please retry later")
+	case errors.Is(err, scheduler.ErrQueueTimeout):
+		logging.Logger.Warnf("Request waited more than %s for upstream %s, rejected %s request", u.config.MaxWait, upstream, priority)
+		return nil, apierror.New(http.StatusServiceUnavailable, "queue_timeout", "Upstream is busy, please retry later")
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		// 客户端已断开，响应不会被读取
+		return nil, apierror.New(http.StatusServiceUnavailable, "request_cancelled", "Request cancelled while queued")
+	case err != nil:
+		return nil, apierror.New(http.StatusServiceUnavailable, "queue_error", err.Error())
+	}
+	w.Header().Set(PriorityHeader, ticket.Priority)
+	w.Header().Set(QueueWaitHeader, strconv.FormatInt(ticket.Wait.Milliseconds(), 10))
+	return ticket, nil
+}
+
+// requestOwner 返回用于公平调度的请求所属用户，未关联用户的密钥按密钥区分
+func requestOwner(r *http.Request) string {
+	key := APIKeyFromContext(r.Context())
+	switch {
+	case key == nil:
+		return ""
+	case key.Subject != "":
+		return key.Subject
+	case key.Email != "":
+		return key.Email
+	}
+	return key.ID
+}
@@ -0,0 +1,101 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"openai-forward/scheduler"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestScheduler 为测试服务启用单并发槽位的上游调度
+func newTestScheduler(s *Server, maxWait time.Duration) {
+	s.teamManager = NewTeamManager(nil, nil)
+	s.scheduler = s.newUpstreamScheduler(&scheduler.Config{
+		Enabled:         true,
+		MaxConcurrency:  1,
+		MaxQueue:        4,
+		MaxWait:         maxWait,
+		DefaultPriority: scheduler.PRIORITY_NORMAL,
+		Weights:         map[string]int{scheduler.PRIORITY_HIGH: 8, scheduler.PRIORITY_NORMAL: 4, scheduler.PRIORITY_LOW: 1},
+	})
+}
+
+func TestUpstreamScheduler_Priority(t *testing.T) {
+	s := &Server{}
+	newTestScheduler(s, time.Second)
+
+	tests := []struct {
+		name     string
+		key      *APIKey
+		header   string
+		expected string
+	}{
+		{"default", &APIKey{Subject: "alice"}, "", scheduler.PRIORITY_NORMAL},
+		{"key", &APIKey{Subject: "alice", Priority: scheduler.PRIORITY_HIGH}, "", scheduler.PRIORITY_HIGH},
+		{"header lowers", &APIKey{Subject: "alice", Priority: scheduler.PRIORITY_HIGH}, "LOW", scheduler.PRIORITY_LOW},
+		{"header cannot raise", &APIKey{Subject: "alice"}, "high", scheduler.PRIORITY_NORMAL},
+		{"invalid header", &APIKey{Subject: "alice"}, "urgent", scheduler.PRIORITY_NORMAL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+			req.Header.Set(PriorityHeader, tt.header)
+			req = req.WithContext(withAPIKey(req.Context(), tt.key))
+			if priority := s.scheduler.priority(req); priority != tt.expected {
+				t.Errorf("Expected priority %s, got %s", tt.expected, priority)
+			}
+		})
+	}
+}
+
+func TestObserveProxy_Scheduler(t *testing.T) {
+	s := &Server{}
+	newTestScheduler(s, 20*time.Millisecond)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := s.observeProxy("openai", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`))
+		return req.WithContext(withAPIKey(req.Context(), &APIKey{Subject: "alice"}))
+	}
+
+	first := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		handler(first, newRequest())
+		close(done)
+	}()
+	<-started
+
+	// 唯一的槽位被占用，排队超过最长等待时间后被拒绝
+	shed := httptest.NewRecorder()
+	handler(shed, newRequest())
+	if shed.Code != http.StatusServiceUnavailable || !strings.Contains(shed.Body.String(), `"code":"queue_timeout"`) {
+		t.Errorf("Expected 503 queue_timeout, got %d: %s", shed.Code, shed.Body.String())
+	}
+	if shed.Header().Get("Retry-After") == "" {
+		t.Error("Expected Retry-After header on shed request")
+	}
+
+	close(release)
+	<-done
+	if first.Code != http.StatusOK || first.Header().Get(QueueWaitHeader) != "0" || first.Header().Get(PriorityHeader) != scheduler.PRIORITY_NORMAL {
+		t.Errorf("Unexpected response %d with headers %v", first.Code, first.Header())
+	}
+
+	// 槽位已归还
+	next := httptest.NewRecorder()
+	s.observeProxy("openai", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})(next, newRequest())
+	if next.Code != http.StatusOK {
+		t.Errorf("Expected status 200 after slot release, got %d", next.Code)
+	}
+}
@@ -0,0 +1,130 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"openai-forward/apierror"
+	"openai-forward/logging"
+	"openai-forward/metrics"
+	"openai-forward/notify"
+	"openai-forward/scrub"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ScrubbedCountHeader 从请求中清除或检测到的敏感信息数量
+const ScrubbedCountHeader = "X-Scrubbed-Count"
+
+// requestScrubber 转发前清除提示词中的密钥与个人信息
+type requestScrubber struct {
+	scrubber *scrub.Scrubber
+	notifier *notify.Notifier
+}
+
+// newRequestScrubber 根据配置创建请求清除，未启用时返回 nil；配置无效时返回错误，
+// 不能在清除失效的情况下转发敏感信息
+func newRequestScrubber(cfg *scrub.Config, notifier *notify.Notifier) (*requestScrubber, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	scrubber, err := scrub.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scrub config: %w", err)
+	}
+	logging.Logger.Infof("Request scrubbing enabled: %s", cfg)
+	return &requestScrubber{scrubber: scrubber, notifier: notifier}, nil
+}
+
+// apply 检查请求体并在响应头中返回命中数量：需要拒绝时返回 400 错误，否则改写请求体。
+// 事件只记录命中的类型与次数，不记录原文。
+func (rs *requestScrubber) apply(w http.ResponseWriter, r *http.Request, route string) *apierror.Error {
+	if rs == nil || r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return nil
+	}
+	if !moderatedRoutes[route] {
+		return nil
+	}
+
+	body, complete := peekBody(r, rs.scrubber.Config().MaxBodyBytes)
+	if !complete {
+		if body == nil {
+			return nil
+		}
+		return apierror.New(http.StatusRequestEntityTooLarge, "request_too_large", "Request body is too large to be checked for sensitive data")
+	}
+	result, err := rs.scrubber.Scrub(route, body)
+	if err != nil || result == nil {
+		// 无效的 JSON 由请求校验或上游返回错误
+		return nil
+	}
+	w.Header().Set(ScrubbedCountHeader, strconv.Itoa(result.Total()))
+	if result.Total() == 0 {
+		return nil
+	}
+	rs.record(r, route, result)
+
+	if len(result.Rejected) > 0 {
+		return apierror.New(http.StatusBadRequest, "sensitive_data_detected",
+			"Request contains sensitive data ("+strings.Join(result.Rejected, ", ")+"), remove it and retry")
+	}
+	r.Body = io.NopCloser(bytes.NewReader(result.Body))
+	r.ContentLength = int64(len(result.Body))
+	r.Header.Set("Content-Length", strconv.Itoa(len(result.Body)))
+	return nil
+}
+
+// record 记录清除事件及指标
+func (rs *requestScrubber) record(r *http.Request, route string, result *scrub.Result) {
+	action := result.Action()
+	for detector, n := range result.Counts {
+		metrics.ScrubbedItems.WithLabelValues(route, detector, action).Add(float64(n))
+	}
+
+	fields := logrus.Fields{
+		"request_id": RequestIDFromContext(r.Context()),
+		"route":      route,
+		"action":     action,
+		"count":      result.Total(),
+		"detectors":  result.Detectors(),
+	}
+	if key := APIKeyFromContext(r.Context()); key != nil {
+		fields["user"] = key.Email
+		fields["key_prefix"] = key.Prefix()
+		if key.TeamID != "" {
+			fields["team"] = key.TeamID
+		}
+	}
+	logging.Logger.WithFields(fields).Warn("scrub")
+
+	// 拒绝的请求通知安全负责人，事件与日志一样只包含命中的类型
+	if len(result.Rejected) > 0 {
+		attributes := map[string]string{
+			"request_id": RequestIDFromContext(r.Context()),
+			"route":      route,
+			"detectors":  strings.Join(result.Rejected, ","),
+		}
+		key := ClientIP(r)
+		for _, name := range []string{"user", "key_prefix", "team"} {
+			if value, ok := fields[name].(string); ok {
+				attributes[name] = value
+			}
+		}
+		if user := attributes["user"]; user != "" {
+			key = user
+		}
+		rs.notifier.Notify(&notify.Event{
+			Type:       notify.EVENT_SENSITIVE_DATA,
+			Summary:    "Request rejected for containing sensitive data (" + attributes["detectors"] + ")",
+			Attributes: attributes,
+			Key:        key,
+		})
+	}
+
+	if entry := accessLogFromContext(r.Context()); entry != nil {
+		entry.scrubbed = result.Total()
+	}
+}
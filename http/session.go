@@ -0,0 +1,440 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"openai-forward/apierror"
+	"openai-forward/jobs"
+	"openai-forward/logging"
+	"openai-forward/metrics"
+	"openai-forward/scheduler"
+	"openai-forward/service"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// SessionInfo 会话（已签发的密钥）信息，不包含密钥本身
+type SessionInfo struct {
+	ID         string     `json:"id"`
+	KeyPrefix  string     `json:"key_prefix"`
+	Type       APIKeyType `json:"type"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpireAt   time.Time  `json:"expire_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	UserAgent  string     `json:"user_agent,omitempty"`
+	AllowedIPs []string   `json:"allowed_ips,omitempty"`
+	Priority   string     `json:"priority,omitempty"`
+	Label      string     `json:"label,omitempty"`
+	Current    bool       `json:"current"`
+}
+
+// ServiceKeyRequest 签发服务密钥的参数
+type ServiceKeyRequest struct {
+	// Label 密钥用途说明
+	Label string `json:"label"`
+	// ExpiresIn 有效期，如 720h，为空时使用 HTTP_SERVICE_KEY_TTL
+	ExpiresIn  string   `json:"expires_in"`
+	AllowedIPs []string `json:"allowed_ips"`
+}
+
+// ServiceKeyResponse 新签发的服务密钥，密钥本身只在签发时返回一次
+type ServiceKeyResponse struct {
+	*SessionInfo
+	Key string `json:"key"`
+}
+
+// CurrentUser 当前密钥所属的用户
+type CurrentUser struct {
+	Subject  string     `json:"subject,omitempty"`
+	Email    string     `json:"email,omitempty"`
+	Name     string     `json:"name,omitempty"`
+	TeamID   string     `json:"team_id,omitempty"`
+	KeyType  APIKeyType `json:"key_type"`
+	ExpireAt time.Time  `json:"expire_at"`
+	Admin    bool       `json:"admin"`
+}
+
+// SessionIPsRequest 设置会话密钥允许使用的 IP 或 CIDR 列表，为空时不限制
+type SessionIPsRequest struct {
+	AllowedIPs []string `json:"allowed_ips"`
+}
+
+// SessionPriorityRequest 设置会话密钥的调度优先级，为空时使用团队或默认优先级
+type SessionPriorityRequest struct {
+	Priority string `json:"priority"`
+}
+
+// handleLogout 吊销当前密钥，all=true 时吊销当前用户的所有密钥
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	key := APIKeyFromContext(r.Context())
+
+	if r.URL.Query().Get("all") == "true" && key.Subject != "" {
+		n, err := s.apiKeyManager.RevokeUserKeys(key.Subject)
+		if err != nil {
+			logging.Logger.Errorf("Failed to revoke API keys: %v", err)
+			s.ResponseError(err, w)
+			return
+		}
+		logging.Logger.Infof("User %s logged out from %d sessions", key.Email, n)
+		s.ResponseJSON(map[string]int64{"revoked": n}, w)
+		return
+	}
+
+	err := s.apiKeyManager.RevokeKey(key.Key)
+	if err != nil {
+		logging.Logger.Errorf("Failed to revoke API key: %v", err)
+		s.ResponseError(err, w)
+		return
+	}
+	logging.Logger.Infof("User %s logged out, key %s revoked", key.Email, key.Prefix())
+	s.ResponseJSON(map[string]int64{"revoked": 1}, w)
+}
+
+// handleListSessions 列出当前用户所有未过期的密钥
+func (s *Server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	current := APIKeyFromContext(r.Context())
+
+	sessions := []*SessionInfo{}
+	if current.Subject == "" {
+		// 匿名签发的密钥无法关联其他会话
+		sessions = append(sessions, newSessionInfo(current, current))
+		s.ResponseJSON(sessions, w)
+		return
+	}
+
+	keys, err := s.apiKeyManager.ListUserKeys(current.Subject)
+	if err != nil {
+		logging.Logger.Errorf("Failed to list API keys: %v", err)
+		s.ResponseError(err, w)
+		return
+	}
+	for _, key := range keys {
+		sessions = append(sessions, newSessionInfo(key, current))
+	}
+	s.ResponseJSON(sessions, w)
+}
+
+// handleCurrentUser 返回当前密钥所属的用户及是否为管理员
+func (s *Server) handleCurrentUser(w http.ResponseWriter, r *http.Request) {
+	key := APIKeyFromContext(r.Context())
+	s.ResponseJSON(&CurrentUser{
+		Subject:  key.Subject,
+		Email:    key.Email,
+		Name:     key.Name,
+		TeamID:   key.TeamID,
+		KeyType:  key.Type,
+		ExpireAt: key.ExpireAt,
+		Admin:    s.authMiddleware.IsAdmin(key),
+	}, w)
+}
+
+// handleCreateServiceKey 为当前用户签发服务密钥，供脚本或服务使用；服务密钥不能再签发服务密钥
+func (s *Server) handleCreateServiceKey(w http.ResponseWriter, r *http.Request) {
+	current := APIKeyFromContext(r.Context())
+	if current.Subject == "" {
+		s.ResponseError(apierror.Forbidden("service keys require a signed-in user"), w)
+		return
+	}
+	if current.Type == SERVICE_KEY {
+		s.ResponseError(apierror.Forbidden("service keys cannot create other service keys"), w)
+		return
+	}
+
+	var req ServiceKeyRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		s.ResponseErrorWithStatus(err, http.StatusBadRequest, w)
+		return
+	}
+	req.Label = strings.TrimSpace(req.Label)
+	if req.Label == "" || len(req.Label) > 128 {
+		s.ResponseError(apierror.InvalidRequest("label", "invalid_label", "label is required and must be at most 128 characters"), w)
+		return
+	}
+	expireIn := s.conf.ServiceKeyTTL
+	if req.ExpiresIn != "" {
+		expireIn, err = time.ParseDuration(req.ExpiresIn)
+		if err != nil || expireIn <= 0 || expireIn > s.conf.ServiceKeyTTL {
+			s.ResponseError(apierror.InvalidRequest("expires_in", "invalid_expires_in",
+				"expires_in must be a positive duration not longer than "+s.conf.ServiceKeyTTL.String()), w)
+			return
+		}
+	}
+	allowedIPs := strings.Join(req.AllowedIPs, ",")
+	_, err = ParseCIDRList(allowedIPs)
+	if err != nil {
+		s.ResponseErrorWithStatus(err, http.StatusBadRequest, w)
+		return
+	}
+
+	keys, err := s.apiKeyManager.ListUserKeys(current.Subject)
+	if err != nil {
+		logging.Logger.Errorf("Failed to list API keys: %v", err)
+		s.ResponseError(err, w)
+		return
+	}
+	count := 0
+	for _, key := range keys {
+		if key.Type == SERVICE_KEY {
+			count++
+		}
+	}
+	if count >= MAX_SERVICE_KEYS {
+		s.ResponseError(apierror.Forbidden(fmt.Sprintf("at most %d service keys are allowed, revoke unused keys first", MAX_SERVICE_KEYS)), w)
+		return
+	}
+
+	key, err := s.apiKeyManager.GenerateServiceKey(current, req.Label, allowedIPs, expireIn)
+	if err != nil {
+		logging.Logger.Errorf("Failed to generate service key: %v", err)
+		s.ResponseError(err, w)
+		return
+	}
+	logging.Logger.Infof("User %s created service key %s (%s)", current.Email, key.Prefix(), key.Label)
+	s.ResponseJSON(&ServiceKeyResponse{SessionInfo: newSessionInfo(key, current), Key: key.Key}, w)
+}
+
+// handleRevokeSession 吊销当前用户的指定会话
+func (s *Server) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	current := APIKeyFromContext(r.Context())
+	id := mux.Vars(r)["id"]
+
+	if current.ID == id {
+		s.handleLogout(w, r)
+		return
+	}
+	if current.Subject == "" {
+		s.ResponseError(apierror.NotFound("session not found"), w)
+		return
+	}
+
+	keys, err := s.apiKeyManager.ListUserKeys(current.Subject)
+	if err != nil {
+		logging.Logger.Errorf("Failed to list API keys: %v", err)
+		s.ResponseError(err, w)
+		return
+	}
+	for _, key := range keys {
+		if key.ID != id {
+			continue
+		}
+		err = s.apiKeyManager.RevokeKey(key.Key)
+		if err != nil {
+			logging.Logger.Errorf("Failed to revoke API key: %v", err)
+			s.ResponseError(err, w)
+			return
+		}
+		s.ResponseJSON(map[string]int64{"revoked": 1}, w)
+		return
+	}
+
+	s.ResponseError(apierror.NotFound("session not found"), w)
+}
+
+// handleSetSessionIPs 设置当前用户指定会话密钥的 IP 白名单；已有白名单时普通用户只能收窄，放开或清除需要管理员
+func (s *Server) handleSetSessionIPs(w http.ResponseWriter, r *http.Request) {
+	current := APIKeyFromContext(r.Context())
+	id := mux.Vars(r)["id"]
+
+	var req SessionIPsRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		s.ResponseErrorWithStatus(err, http.StatusBadRequest, w)
+		return
+	}
+	allowedIPs := strings.Join(req.AllowedIPs, ",")
+	nets, err := ParseCIDRList(allowedIPs)
+	if err != nil {
+		s.ResponseErrorWithStatus(err, http.StatusBadRequest, w)
+		return
+	}
+
+	key := s.findSession(current, id)
+	if key == nil {
+		s.ResponseError(apierror.NotFound("session not found"), w)
+		return
+	}
+	// 普通用户只能收窄已有的限制，避免泄露的密钥为自己或同一用户的其他密钥放开 IP 限制
+	if key.AllowedIPs != "" && !s.authMiddleware.IsAdmin(current) {
+		existing, _ := ParseCIDRList(key.AllowedIPs)
+		if len(nets) == 0 || !WithinCIDRList(nets, existing) {
+			s.ResponseError(apierror.Forbidden("allowed IPs can only be narrowed, contact an administrator to widen them"), w)
+			return
+		}
+	}
+	key.AllowedIPs = allowedIPs
+	err = s.apiKeyManager.SaveKey(key)
+	if err != nil {
+		logging.Logger.Errorf("Failed to save API key: %v", err)
+		s.ResponseError(err, w)
+		return
+	}
+	logging.Logger.Infof("User %s set allowed IPs of key %s to %q", current.Email, key.Prefix(), allowedIPs)
+	s.ResponseJSON(newSessionInfo(key, current), w)
+}
+
+// handleSetSessionPriority 设置当前用户指定会话密钥的调度优先级，如将批量任务使用的密钥设为 low；
+// 普通用户不能将优先级设置得高于团队或默认优先级
+func (s *Server) handleSetSessionPriority(w http.ResponseWriter, r *http.Request) {
+	current := APIKeyFromContext(r.Context())
+	id := mux.Vars(r)["id"]
+
+	var req SessionPriorityRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		s.ResponseErrorWithStatus(err, http.StatusBadRequest, w)
+		return
+	}
+	priority := strings.ToLower(req.Priority)
+	if priority != "" && !scheduler.Valid(priority) {
+		s.ResponseError(apierror.InvalidRequest("priority", "invalid_priority", "priority must be high, normal or low"), w)
+		return
+	}
+	if priority != "" && !s.authMiddleware.IsAdmin(current) {
+		if limit := s.defaultPriority(r); scheduler.Lower(priority, limit) != priority {
+			s.ResponseError(apierror.Forbidden("priority cannot be higher than "+limit), w)
+			return
+		}
+	}
+
+	key := s.findSession(current, id)
+	if key == nil {
+		s.ResponseError(apierror.NotFound("session not found"), w)
+		return
+	}
+	key.Priority = priority
+	err = s.apiKeyManager.SaveKey(key)
+	if err != nil {
+		logging.Logger.Errorf("Failed to save API key: %v", err)
+		s.ResponseError(err, w)
+		return
+	}
+	logging.Logger.Infof("User %s set priority of key %s to %q", current.Email, key.Prefix(), priority)
+	s.ResponseJSON(newSessionInfo(key, current), w)
+}
+
+// findSession 在当前用户的会话中查找指定 ID 的密钥
+func (s *Server) findSession(current *APIKey, id string) *APIKey {
+	if current.ID == id {
+		return current
+	}
+	if current.Subject == "" {
+		return nil
+	}
+
+	keys, err := s.apiKeyManager.ListUserKeys(current.Subject)
+	if err != nil {
+		logging.Logger.Errorf("Failed to list API keys: %v", err)
+		return nil
+	}
+	for _, key := range keys {
+		if key.ID == id {
+			return key
+		}
+	}
+	return nil
+}
+
+// handleRevokeUserKeys 管理员吊销指定用户（subject 或邮箱）的所有密钥
+func (s *Server) handleRevokeUserKeys(w http.ResponseWriter, r *http.Request) {
+	admin := APIKeyFromContext(r.Context())
+	user := mux.Vars(r)["user"]
+	if user == "" {
+		s.ResponseError(apierror.InvalidRequest("user", "missing_required_parameter", "user is required"), w)
+		return
+	}
+
+	n, err := s.apiKeyManager.RevokeUserKeys(user)
+	if err != nil {
+		logging.Logger.Errorf("Failed to revoke API keys: %v", err)
+		s.ResponseError(err, w)
+		return
+	}
+	// 吊销后不再以该用户的身份执行后台任务，执行中的任务在下一批请求前停止
+	var cancelled int64
+	if s.jobQueue != nil {
+		cancelled, err = s.db.CancelUserJobs(user)
+		if err != nil {
+			logging.Logger.Errorf("Failed to cancel jobs of user %s: %v", user, err)
+			s.ResponseError(err, w)
+			return
+		}
+		metrics.Jobs.WithLabelValues(jobs.STATUS_CANCELLED).Add(float64(cancelled))
+	}
+	logging.Logger.Infof("Admin %s revoked %d keys and cancelled %d jobs of user %s", admin.Email, n, cancelled, user)
+	s.ResponseJSON(map[string]int64{"revoked": n, "cancelled_jobs": cancelled}, w)
+}
+
+// handleBackChannelLogout 处理 IdP 的 OIDC back-channel logout 通知，吊销对应会话或用户的密钥
+func (s *Server) handleBackChannelLogout(w http.ResponseWriter, r *http.Request) {
+	logging.Logger.Debugf("Received back-channel logout")
+	defer logging.Logger.Debugf("Finished back-channel logout")
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	rawToken := r.PostFormValue("logout_token")
+	if rawToken == "" {
+		s.ResponseErrorWithStatus(errors.New("logout_token is required"), http.StatusBadRequest, w)
+		return
+	}
+
+	oidcService, err := service.NewOIDCServiceWithContext(r.Context(), service.LoadOIDCConfigFromEnv())
+	if err != nil {
+		logging.Logger.Errorf("Failed to create OIDC service: %v", err)
+		s.ResponseErrorWithStatus(err, http.StatusInternalServerError, w)
+		return
+	}
+	claims, err := oidcService.VerifyLogoutToken(r.Context(), rawToken)
+	if err != nil {
+		logging.Logger.Warnf("Invalid logout token: %v", err)
+		s.ResponseErrorWithStatus(err, http.StatusBadRequest, w)
+		return
+	}
+
+	var n int64
+	if claims.SessionID != "" {
+		n, err = s.apiKeyManager.RevokeSessionKeys(claims.SessionID)
+	} else {
+		n, err = s.apiKeyManager.RevokeUserKeys(claims.Subject)
+	}
+	if err != nil {
+		logging.Logger.Errorf("Failed to revoke API keys: %v", err)
+		s.ResponseErrorWithStatus(err, http.StatusInternalServerError, w)
+		return
+	}
+
+	logging.Logger.Infof("Back-channel logout revoked %d keys (sub=%s, sid=%s)", n, claims.Subject, claims.SessionID)
+	s.ResponseJSON(map[string]int64{"revoked": n}, w)
+}
+
+func newSessionInfo(key *APIKey, current *APIKey) *SessionInfo {
+	return &SessionInfo{
+		ID:         key.ID,
+		KeyPrefix:  key.Prefix(),
+		Type:       key.Type,
+		CreatedAt:  key.CreatedAt,
+		ExpireAt:   key.ExpireAt,
+		LastUsedAt: key.LastUsedAt,
+		UserAgent:  key.UserAgent,
+		AllowedIPs: splitList(key.AllowedIPs),
+		Priority:   key.Priority,
+		Label:      key.Label,
+		Current:    key.Key == current.Key,
+	}
+}
+
+// splitList 拆分逗号分隔的列表并去除空项
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
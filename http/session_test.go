@@ -0,0 +1,204 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// memoryKeyStorage 只实现密钥相关方法的内存存储
+type memoryKeyStorage struct {
+	IStorage
+	keys map[string]*APIKey
+}
+
+func (m *memoryKeyStorage) SaveAPIKey(key *APIKey) error {
+	m.keys[key.Key] = key
+	return nil
+}
+
+func (m *memoryKeyStorage) GetAPIKey(key string) (*APIKey, error) {
+	return m.keys[key], nil
+}
+
+func (m *memoryKeyStorage) ListAPIKeysBySubject(subject string) ([]*APIKey, error) {
+	keys := []*APIKey{}
+	for _, key := range m.keys {
+		if key.Subject == subject {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func createServiceKey(s *Server, current *APIKey, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/api/v1/auth/sessions", strings.NewReader(body))
+	req = req.WithContext(withAPIKey(context.Background(), current))
+	rec := httptest.NewRecorder()
+	s.handleCreateServiceKey(rec, req)
+	return rec
+}
+
+func TestHandleCreateServiceKey(t *testing.T) {
+	storage := &memoryKeyStorage{keys: map[string]*APIKey{}}
+	s := &Server{
+		conf:           &HTTPConfig{ServiceKeyTTL: 90 * 24 * time.Hour},
+		apiKeyManager:  NewAPIKeyManager(storage),
+		authMiddleware: &AuthMiddleware{},
+	}
+	current := &APIKey{Key: "login-key", Type: TEMPORARY_KEY, Subject: "alice", Email: "alice@example.com", TeamID: "ml", Priority: "low"}
+
+	rec := createServiceKey(s, current, `{"label":"ci","expires_in":"720h","allowed_ips":["10.0.0.0/8"]}`)
+	var response struct {
+		Data ServiceKeyResponse `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Expected service key, got %d: %s", rec.Code, rec.Body.String())
+	}
+	key := storage.keys[response.Data.Key]
+	if key == nil || key.Type != SERVICE_KEY || key.Label != "ci" || key.Subject != "alice" || key.TeamID != "ml" ||
+		key.Priority != "low" || key.AllowedIPs != "10.0.0.0/8" || key.RefreshToken != "" {
+		t.Fatalf("Unexpected service key: %+v", key)
+	}
+	if ttl := time.Until(key.ExpireAt); ttl < 719*time.Hour || ttl > 720*time.Hour {
+		t.Errorf("Unexpected expiry: %s", ttl)
+	}
+	if response.Data.SessionInfo == nil || response.Data.Label != "ci" || response.Data.Type != SERVICE_KEY {
+		t.Errorf("Unexpected response: %s", rec.Body.String())
+	}
+
+	for name, body := range map[string]string{
+		"missing label":  `{"expires_in":"24h"}`,
+		"too long":       `{"label":"ci","expires_in":"8760h"}`,
+		"invalid expiry": `{"label":"ci","expires_in":"30d"}`,
+		"invalid ip":     `{"label":"ci","allowed_ips":["not-an-ip"]}`,
+	} {
+		if rec := createServiceKey(s, current, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", name, rec.Code)
+		}
+	}
+
+	// 服务密钥及匿名密钥不能签发服务密钥
+	if rec := createServiceKey(s, key, `{"label":"nested"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for service key, got %d", rec.Code)
+	}
+	if rec := createServiceKey(s, &APIKey{Key: "anonymous"}, `{"label":"anonymous"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for anonymous key, got %d", rec.Code)
+	}
+
+	for len(storage.keys) < MAX_SERVICE_KEYS {
+		createServiceKey(s, current, `{"label":"bulk"}`)
+	}
+	if rec := createServiceKey(s, current, `{"label":"one more"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 after %d service keys, got %d", MAX_SERVICE_KEYS, rec.Code)
+	}
+}
+
+func TestHandleCurrentUser(t *testing.T) {
+	s := &Server{authMiddleware: &AuthMiddleware{AdminEmails: []string{"admin@example.com"}}}
+	req := httptest.NewRequest("GET", "/api/v1/auth/me", nil)
+	req = req.WithContext(withAPIKey(context.Background(), &APIKey{Key: "k", Type: SERVICE_KEY, Email: "admin@example.com", TeamID: "ml"}))
+	rec := httptest.NewRecorder()
+	s.handleCurrentUser(rec, req)
+
+	var response struct {
+		Data CurrentUser `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || !response.Data.Admin ||
+		response.Data.KeyType != SERVICE_KEY || response.Data.TeamID != "ml" {
+		t.Errorf("Unexpected current user: %s", rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), `"k"`) {
+		t.Errorf("Current user should not include the key: %s", rec.Body.String())
+	}
+}
+
+func TestHandleSetSessionIPs(t *testing.T) {
+	storage := &memoryKeyStorage{keys: map[string]*APIKey{}}
+	s := &Server{
+		apiKeyManager:  NewAPIKeyManager(storage),
+		authMiddleware: &AuthMiddleware{AdminEmails: []string{"admin@example.com"}},
+	}
+	current := &APIKey{ID: "login", Key: "login-key", Subject: "alice", Email: "alice@example.com", AllowedIPs: "10.0.0.0/8"}
+	storage.keys[current.Key] = current
+
+	setIPs := func(caller *APIKey, body string) int {
+		req := httptest.NewRequest("PUT", "/api/v1/auth/sessions/login/ips", strings.NewReader(body))
+		req = mux.SetURLVars(req.WithContext(withAPIKey(context.Background(), caller)), map[string]string{"id": "login"})
+		rec := httptest.NewRecorder()
+		s.handleSetSessionIPs(rec, req)
+		return rec.Code
+	}
+
+	// 已有白名单时只能收窄
+	for name, body := range map[string]string{
+		"clear":   `{"allowed_ips":[]}`,
+		"widen":   `{"allowed_ips":["0.0.0.0/0"]}`,
+		"outside": `{"allowed_ips":["10.0.0.0/8","192.168.1.1"]}`,
+		"ipv6":    `{"allowed_ips":["::/0"]}`,
+	} {
+		if code := setIPs(current, body); code != http.StatusForbidden || current.AllowedIPs != "10.0.0.0/8" {
+			t.Errorf("Expected %s to be forbidden, got %d (%s)", name, code, current.AllowedIPs)
+		}
+	}
+	if code := setIPs(current, `{"allowed_ips":["10.1.0.0/16","10.2.3.4"]}`); code != http.StatusOK || current.AllowedIPs != "10.1.0.0/16,10.2.3.4" {
+		t.Errorf("Expected narrowing to succeed, got %d (%s)", code, current.AllowedIPs)
+	}
+
+	// 管理员可以放开或清除
+	admin := &APIKey{ID: "login", Key: "admin-key", Subject: "alice", Email: "admin@example.com"}
+	if code := setIPs(admin, `{"allowed_ips":[]}`); code != http.StatusOK || admin.AllowedIPs != "" {
+		t.Errorf("Expected admin to clear allowed IPs, got %d", code)
+	}
+}
+
+// revokingStorage 记录吊销密钥及取消任务的用户
+type revokingStorage struct {
+	memoryKeyStorage
+	cancelled []string
+}
+
+func (m *revokingStorage) DeleteAPIKeysByUser(user string) (int64, error) {
+	var n int64
+	for k, key := range m.keys {
+		if key.Subject == user || key.Email == user {
+			delete(m.keys, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *revokingStorage) CancelUserJobs(user string) (int64, error) {
+	m.cancelled = append(m.cancelled, user)
+	return 2, nil
+}
+
+func TestHandleRevokeUserKeys_CancelsJobs(t *testing.T) {
+	storage := &revokingStorage{memoryKeyStorage: memoryKeyStorage{keys: map[string]*APIKey{
+		"k1": {Key: "k1", Subject: "bob"},
+		"k2": {Key: "k2", Subject: "bob", Type: SERVICE_KEY},
+	}}}
+	s := &Server{apiKeyManager: NewAPIKeyManager(storage), db: storage, jobQueue: &jobQueue{}}
+
+	req := httptest.NewRequest("DELETE", "/api/v1/admin/users/bob/keys", nil)
+	req = mux.SetURLVars(req.WithContext(withAPIKey(context.Background(), &APIKey{Email: "admin@example.com"})), map[string]string{"user": "bob"})
+	rec := httptest.NewRecorder()
+	s.handleRevokeUserKeys(rec, req)
+
+	var response struct {
+		Data map[string]int64 `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || response.Data["revoked"] != 2 || response.Data["cancelled_jobs"] != 2 {
+		t.Fatalf("Unexpected response %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(storage.cancelled) != 1 || storage.cancelled[0] != "bob" {
+		t.Errorf("Expected bob's jobs to be cancelled, got %v", storage.cancelled)
+	}
+}
@@ -0,0 +1,392 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"openai-forward/apierror"
+	"openai-forward/catalog"
+	"openai-forward/config"
+	"openai-forward/logging"
+	"openai-forward/proxy"
+	"openai-forward/scheduler"
+	"openai-forward/service"
+	"openai-forward/tracing"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// teamCacheTTL 团队配置缓存时间
+const teamCacheTTL = time.Minute
+
+// ErrSecretRequired 保存团队上游密钥需要配置 HTTP_SECRET_KEY
+var ErrSecretRequired = errors.New("HTTP_SECRET_KEY is required to store team credentials")
+
+// Team 团队（租户），拥有独立的上游凭证、OpenAI 组织/项目、Azure 资源及可用模型
+type Team struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// ClaimValues OIDC 团队声明中匹配该团队的取值，如 groups 中的组名
+	ClaimValues []string `json:"claim_values"`
+	// OpenAIAPIKey 团队的 OpenAI 密钥，存储时加密
+	OpenAIAPIKey    string `json:"openai_api_key,omitempty"`
+	OpenAIOrgID     string `json:"openai_org_id,omitempty"`
+	OpenAIProjectID string `json:"openai_project_id,omitempty"`
+	AzureEndpoint   string `json:"azure_endpoint,omitempty"`
+	// AzureAPIKey 团队的 Azure 密钥，存储时加密
+	AzureAPIKey        string            `json:"azure_api_key,omitempty"`
+	AzureAPIVersion    string            `json:"azure_api_version,omitempty"`
+	AzureModelMappings map[string]string `json:"azure_model_mappings,omitempty"`
+	// Models 团队可用模型，为空时使用全局白名单
+	Models []string `json:"models,omitempty"`
+	// AuditEnabled 是否记录团队请求的审计日志（AUDIT_SCOPE=teams 时生效）
+	AuditEnabled bool `json:"audit_enabled"`
+	// Priority 团队请求的调度优先级，为空时使用默认优先级
+	Priority  string    `json:"priority,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Masked 返回隐藏上游密钥的副本，用于接口输出
+func (t *Team) Masked() *Team {
+	masked := *t
+	masked.OpenAIAPIKey = maskKey(t.OpenAIAPIKey)
+	masked.AzureAPIKey = maskKey(t.AzureAPIKey)
+	return &masked
+}
+
+// MatchGroups 判断用户的团队声明是否匹配该团队
+func (t *Team) MatchGroups(groups []string) bool {
+	for _, value := range t.ClaimValues {
+		for _, group := range groups {
+			if strings.EqualFold(value, group) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ApplyOpenAI 使用团队配置覆盖 OpenAI 代理配置
+func (t *Team) ApplyOpenAI(cfg *config.Config) {
+	if t.OpenAIAPIKey != "" {
+		cfg.APIKey = t.OpenAIAPIKey
+		// 团队密钥所属组织与项目可能不同于全局配置
+		cfg.OrgID = ""
+		cfg.ProjectID = ""
+	}
+	if t.OpenAIOrgID != "" {
+		cfg.OrgID = t.OpenAIOrgID
+	}
+	if t.OpenAIProjectID != "" {
+		cfg.ProjectID = t.OpenAIProjectID
+	}
+	if len(t.Models) > 0 {
+		cfg.ModelsWhiteList = t.Models
+	}
+}
+
+// ApplyAzure 使用团队配置覆盖 Azure 代理配置
+func (t *Team) ApplyAzure(cfg *proxy.AzureConfig) {
+	if t.AzureEndpoint != "" {
+		cfg.Endpoint = t.AzureEndpoint
+	}
+	if t.AzureAPIKey != "" {
+		cfg.APIKey = t.AzureAPIKey
+	}
+	if t.AzureAPIVersion != "" {
+		cfg.APIVersion = t.AzureAPIVersion
+	}
+	if len(t.AzureModelMappings) > 0 {
+		cfg.ModelMappings = t.AzureModelMappings
+	}
+}
+
+type cachedTeam struct {
+	team     *Team
+	expireAt time.Time
+}
+
+// TeamManager 团队管理器，负责上游密钥加解密及团队配置缓存
+type TeamManager struct {
+	storage   IStorage
+	secretBox *service.SecretBox
+	mutex     sync.Mutex
+	cache     map[string]*cachedTeam
+}
+
+// NewTeamManager 创建团队管理器实例
+func NewTeamManager(storage IStorage, secretBox *service.SecretBox) *TeamManager {
+	return &TeamManager{
+		storage:   storage,
+		secretBox: secretBox,
+		cache:     make(map[string]*cachedTeam),
+	}
+}
+
+// GetTeam 获取团队（上游密钥已解密），不存在时返回 nil
+func (m *TeamManager) GetTeam(id string) (*Team, error) {
+	if id == "" {
+		return nil, nil
+	}
+	if m.storage == nil {
+		return nil, ErrStorageUnavailable
+	}
+
+	m.mutex.Lock()
+	cached, ok := m.cache[id]
+	m.mutex.Unlock()
+	if ok && time.Now().Before(cached.expireAt) {
+		return cached.team, nil
+	}
+
+	team, err := m.storage.GetTeam(id)
+	if err != nil {
+		return nil, err
+	}
+	if team != nil {
+		err = m.openSecrets(team)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	m.mutex.Lock()
+	m.cache[id] = &cachedTeam{team: team, expireAt: time.Now().Add(teamCacheTTL)}
+	m.mutex.Unlock()
+
+	return team, nil
+}
+
+// ListTeams 列出所有团队（上游密钥未解密）
+func (m *TeamManager) ListTeams() ([]*Team, error) {
+	if m.storage == nil {
+		return nil, ErrStorageUnavailable
+	}
+	return m.storage.ListTeams()
+}
+
+// SaveTeam 加密上游密钥后保存团队
+func (m *TeamManager) SaveTeam(team *Team) error {
+	if m.storage == nil {
+		return ErrStorageUnavailable
+	}
+
+	sealed := *team
+	for _, secret := range []*string{&sealed.OpenAIAPIKey, &sealed.AzureAPIKey} {
+		if *secret == "" {
+			continue
+		}
+		if m.secretBox == nil {
+			return ErrSecretRequired
+		}
+		value, err := m.secretBox.Seal(*secret)
+		if err != nil {
+			return err
+		}
+		*secret = value
+	}
+
+	err := m.storage.SaveTeam(&sealed)
+	if err != nil {
+		return err
+	}
+
+	m.invalidate(team.ID)
+	return nil
+}
+
+// DeleteTeam 删除团队
+func (m *TeamManager) DeleteTeam(id string) error {
+	if m.storage == nil {
+		return ErrStorageUnavailable
+	}
+
+	err := m.storage.DeleteTeam(id)
+	if err != nil {
+		return err
+	}
+
+	m.invalidate(id)
+	return nil
+}
+
+// ResolveTeam 根据用户的团队声明确定所属团队，preferred 为用户指定的团队 ID（须为其所属团队之一）
+func (m *TeamManager) ResolveTeam(groups []string, preferred string) (*Team, error) {
+	if len(groups) == 0 || m.storage == nil {
+		return nil, nil
+	}
+
+	teams, err := m.storage.ListTeams()
+	if err != nil {
+		return nil, err
+	}
+
+	var matched *Team
+	for _, team := range teams {
+		if !team.MatchGroups(groups) {
+			continue
+		}
+		if preferred == "" || team.ID == preferred {
+			return team, nil
+		}
+		if matched == nil {
+			matched = team
+		}
+	}
+
+	return matched, nil
+}
+
+// openSecrets 解密团队的上游密钥
+func (m *TeamManager) openSecrets(team *Team) error {
+	for _, secret := range []*string{&team.OpenAIAPIKey, &team.AzureAPIKey} {
+		if *secret == "" {
+			continue
+		}
+		if m.secretBox == nil {
+			return ErrSecretRequired
+		}
+		value, err := m.secretBox.Open(*secret)
+		if err != nil {
+			return err
+		}
+		*secret = value
+	}
+	return nil
+}
+
+func (m *TeamManager) invalidate(id string) {
+	m.mutex.Lock()
+	delete(m.cache, id)
+	m.mutex.Unlock()
+}
+
+// teamFromRequest 获取请求中密钥所属的团队，未认证或无团队时返回 nil。
+// 团队配置无法读取时返回 503、团队已删除时返回 403，不能改用全局凭证转发，以免由其他租户付费。
+func (s *Server) teamFromRequest(r *http.Request) (*Team, *apierror.Error) {
+	key := APIKeyFromContext(r.Context())
+	if key == nil || key.TeamID == "" {
+		return nil, nil
+	}
+
+	_, span := tracing.Start(r.Context(), "storage.GetTeam", attribute.String("team.id", key.TeamID))
+	team, err := s.teamManager.GetTeam(key.TeamID)
+	span.End()
+	if err != nil {
+		logging.Logger.Errorf("Failed to load team %s: %v", key.TeamID, err)
+		return nil, apierror.New(http.StatusServiceUnavailable, "team_unavailable", "Team configuration is temporarily unavailable")
+	}
+	if team == nil {
+		return nil, apierror.New(http.StatusForbidden, "team_not_found", "The team of this API key no longer exists")
+	}
+	return team, nil
+}
+
+// AllowsModel 团队是否可以使用指定模型，未限制模型或请求未指定模型时允许，带日期的快照版本按模型名称匹配
+func (t *Team) AllowsModel(model string) bool {
+	if len(t.Models) == 0 || model == "" {
+		return true
+	}
+	base := catalog.SnapshotBase(model)
+	for _, allowed := range t.Models {
+		if allowed == model || (base != "" && allowed == base) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTeam 校验请求所属团队可用且允许使用请求的模型，返回请求所属的团队
+func (s *Server) checkTeam(r *http.Request, model string) (*Team, *apierror.Error) {
+	team, denied := s.teamFromRequest(r)
+	if denied != nil {
+		return nil, denied
+	}
+	if team != nil && !team.AllowsModel(model) {
+		return nil, apierror.New(http.StatusForbidden, "model_not_allowed", "Model "+model+" is not available to your team")
+	}
+	return team, nil
+}
+
+// handleListTeams 管理员列出所有团队
+func (s *Server) handleListTeams(w http.ResponseWriter, r *http.Request) {
+	teams, err := s.teamManager.ListTeams()
+	if err != nil {
+		logging.Logger.Errorf("Failed to list teams: %v", err)
+		s.ResponseError(err, w)
+		return
+	}
+
+	result := make([]*Team, 0, len(teams))
+	for _, team := range teams {
+		result = append(result, team.Masked())
+	}
+	s.ResponseJSON(result, w)
+}
+
+// handleSaveTeam 管理员创建或更新团队，更新时未提供的上游密钥保持不变
+func (s *Server) handleSaveTeam(w http.ResponseWriter, r *http.Request) {
+	var team Team
+	err := json.NewDecoder(r.Body).Decode(&team)
+	if err != nil {
+		s.ResponseErrorWithStatus(err, http.StatusBadRequest, w)
+		return
+	}
+	if strings.TrimSpace(team.Name) == "" {
+		s.ResponseError(apierror.InvalidRequest("name", "missing_required_parameter", "name is required"), w)
+		return
+	}
+	if team.Priority != "" && !scheduler.Valid(team.Priority) {
+		s.ResponseError(apierror.InvalidRequest("priority", "invalid_priority", "priority must be high, normal or low"), w)
+		return
+	}
+
+	team.ID = mux.Vars(r)["id"]
+	team.UpdatedAt = time.Now()
+	if team.ID == "" {
+		team.ID = uuid.New().String()
+		team.CreatedAt = team.UpdatedAt
+	} else {
+		existing, err := s.teamManager.GetTeam(team.ID)
+		if err != nil {
+			s.ResponseError(err, w)
+			return
+		}
+		if existing == nil {
+			s.ResponseError(apierror.NotFound("team not found"), w)
+			return
+		}
+		team.CreatedAt = existing.CreatedAt
+		if team.OpenAIAPIKey == "" {
+			team.OpenAIAPIKey = existing.OpenAIAPIKey
+		}
+		if team.AzureAPIKey == "" {
+			team.AzureAPIKey = existing.AzureAPIKey
+		}
+	}
+
+	err = s.teamManager.SaveTeam(&team)
+	if err != nil {
+		logging.Logger.Errorf("Failed to save team: %v", err)
+		s.ResponseError(err, w)
+		return
+	}
+	s.ResponseJSON(team.Masked(), w)
+}
+
+// handleDeleteTeam 管理员删除团队
+func (s *Server) handleDeleteTeam(w http.ResponseWriter, r *http.Request) {
+	err := s.teamManager.DeleteTeam(mux.Vars(r)["id"])
+	if err != nil {
+		logging.Logger.Errorf("Failed to delete team: %v", err)
+		s.ResponseError(err, w)
+		return
+	}
+	s.ResponseError(nil, w)
+}
@@ -0,0 +1,162 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"openai-forward/config"
+	"openai-forward/proxy"
+	"testing"
+	"time"
+)
+
+// failingTeamStorage 读取团队时返回错误
+type failingTeamStorage struct {
+	IStorage
+}
+
+func (failingTeamStorage) GetTeam(id string) (*Team, error) {
+	return nil, errors.New("connection refused")
+}
+
+// newTestTeamManager 创建已缓存指定团队的团队管理器，读取其他团队时返回错误
+func newTestTeamManager(teams ...*Team) *TeamManager {
+	manager := NewTeamManager(failingTeamStorage{}, nil)
+	for _, team := range teams {
+		manager.cache[team.ID] = &cachedTeam{team: team, expireAt: time.Now().Add(time.Hour)}
+	}
+	return manager
+}
+
+func TestTeam_MatchGroups(t *testing.T) {
+	team := &Team{ID: "team-a", ClaimValues: []string{"engineering", "data"}}
+
+	if !team.MatchGroups([]string{"sales", "Engineering"}) {
+		t.Error("Expected groups to match team claim values")
+	}
+	if team.MatchGroups([]string{"sales"}) {
+		t.Error("Expected unrelated groups not to match")
+	}
+	if team.MatchGroups(nil) {
+		t.Error("Expected empty groups not to match")
+	}
+}
+
+func TestTeam_ApplyOpenAI(t *testing.T) {
+	cfg := &config.Config{
+		APIKey:          "sk-global",
+		OrgID:           "org-global",
+		ProjectID:       "proj-global",
+		ModelsWhiteList: []string{"gpt-4o"},
+	}
+
+	team := &Team{
+		OpenAIAPIKey:    "sk-team",
+		OpenAIProjectID: "proj-team",
+		Models:          []string{"gpt-4o-mini"},
+	}
+	team.ApplyOpenAI(cfg)
+
+	if cfg.APIKey != "sk-team" {
+		t.Errorf("Expected team API key, got '%s'", cfg.APIKey)
+	}
+	if cfg.OrgID != "" {
+		t.Errorf("Expected global organization to be cleared, got '%s'", cfg.OrgID)
+	}
+	if cfg.ProjectID != "proj-team" {
+		t.Errorf("Expected team project, got '%s'", cfg.ProjectID)
+	}
+	if len(cfg.ModelsWhiteList) != 1 || cfg.ModelsWhiteList[0] != "gpt-4o-mini" {
+		t.Errorf("Expected team models, got %v", cfg.ModelsWhiteList)
+	}
+
+	// 仅设置项目的团队沿用全局密钥
+	cfg = &config.Config{APIKey: "sk-global", OrgID: "org-global"}
+	(&Team{OpenAIProjectID: "proj-team"}).ApplyOpenAI(cfg)
+	if cfg.APIKey != "sk-global" || cfg.OrgID != "org-global" || cfg.ProjectID != "proj-team" {
+		t.Errorf("Unexpected config: %+v", cfg)
+	}
+}
+
+func TestTeam_ApplyAzure(t *testing.T) {
+	cfg := &proxy.AzureConfig{
+		Endpoint:      "https://global.openai.azure.com/",
+		APIKey:        "global-key",
+		ModelMappings: map[string]string{"gpt-4o": "gpt-4o"},
+	}
+
+	team := &Team{
+		AzureEndpoint:      "https://team.openai.azure.com/",
+		AzureAPIKey:        "team-key",
+		AzureModelMappings: map[string]string{"gpt-4o-mini": "team-mini"},
+	}
+	team.ApplyAzure(cfg)
+
+	if cfg.Endpoint != team.AzureEndpoint || cfg.APIKey != "team-key" {
+		t.Errorf("Unexpected azure config: %+v", cfg)
+	}
+	if cfg.ModelMappings["gpt-4o-mini"] != "team-mini" {
+		t.Errorf("Expected team model mappings, got %v", cfg.ModelMappings)
+	}
+}
+
+func TestTeam_Masked(t *testing.T) {
+	team := &Team{OpenAIAPIKey: "sk-team-secret-value"}
+	masked := team.Masked()
+
+	if masked.OpenAIAPIKey == team.OpenAIAPIKey {
+		t.Error("Masked team should not expose the API key")
+	}
+	if team.OpenAIAPIKey != "sk-team-secret-value" {
+		t.Error("Masked should not modify the original team")
+	}
+}
+
+func TestTeam_AllowsModel(t *testing.T) {
+	team := &Team{Models: []string{"gpt-4o-mini"}}
+	for model, expected := range map[string]bool{
+		"gpt-4o-mini":            true,
+		"gpt-4o-mini-2024-07-18": true,
+		"gpt-4o":                 false,
+		"":                       true,
+	} {
+		if team.AllowsModel(model) != expected {
+			t.Errorf("Expected AllowsModel(%q) to be %v", model, expected)
+		}
+	}
+	if !(&Team{}).AllowsModel("gpt-4o") {
+		t.Error("Expected teams without a model list to allow any model")
+	}
+}
+
+func TestCheckTeam(t *testing.T) {
+	manager := newTestTeamManager(&Team{ID: "team-a", Models: []string{"gpt-4o-mini"}})
+	manager.cache["deleted"] = &cachedTeam{team: nil, expireAt: time.Now().Add(time.Hour)}
+	s := &Server{teamManager: manager}
+
+	check := func(teamID string, model string) int {
+		req := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+		req = req.WithContext(withAPIKey(req.Context(), &APIKey{Email: "user@example.com", TeamID: teamID}))
+		if _, denied := s.checkTeam(req, model); denied != nil {
+			return denied.Status
+		}
+		return http.StatusOK
+	}
+
+	if status := check("", "gpt-4o"); status != http.StatusOK {
+		t.Errorf("Expected keys without a team to pass, got %d", status)
+	}
+	if status := check("team-a", "gpt-4o-mini"); status != http.StatusOK {
+		t.Errorf("Expected team model to be allowed, got %d", status)
+	}
+	if status := check("team-a", "gpt-4o"); status != http.StatusForbidden {
+		t.Errorf("Expected model outside the team list to be rejected, got %d", status)
+	}
+	if status := check("deleted", "gpt-4o-mini"); status != http.StatusForbidden {
+		t.Errorf("Expected deleted team to be rejected, got %d", status)
+	}
+	// 团队配置无法读取时不能改用全局凭证转发
+	if status := check("team-b", "gpt-4o-mini"); status != http.StatusServiceUnavailable {
+		t.Errorf("Expected unavailable team to be rejected with 503, got %d", status)
+	}
+}
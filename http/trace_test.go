@@ -0,0 +1,36 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"openai-forward/tracing"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceRequest_PropagatesTraceParent(t *testing.T) {
+	_, err := tracing.Init(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to initialize tracing: %v", err)
+	}
+
+	var traceID string
+	handler := traceRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceID = trace.SpanContextFromContext(r.Context()).TraceID().String()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	req := httptest.NewRequest("GET", "/api/v1/openai/models", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if traceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected trace ID to be propagated, got %s", traceID)
+	}
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("Expected status 202, got %d", rec.Code)
+	}
+}
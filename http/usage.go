@@ -0,0 +1,187 @@
+package http
+
+import (
+	"net/http"
+	"openai-forward/apierror"
+	"openai-forward/catalog"
+	"openai-forward/logging"
+	"openai-forward/proxy"
+	"openai-forward/usage"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// newUsageRecorder 根据配置创建用量记录器，未启用时返回 nil；未配置数据库时只在内存中保留汇总数据
+func newUsageRecorder(cfg *usage.Config, storage IStorage) *usage.Recorder {
+	if !cfg.Enabled {
+		return nil
+	}
+	var store usage.Store = usage.NewMemoryStore()
+	if storage != nil {
+		store = storage
+	} else {
+		logging.Logger.Warn("Usage reporting requires a database, usage will be lost on restart")
+	}
+	logging.Logger.Infof("Usage tracking enabled: %s", cfg)
+	return usage.NewRecorder(cfg, store)
+}
+
+// modelPricing 返回模型价格，未知时为 nil
+func (s *Server) modelPricing(model string) *catalog.Pricing {
+	if metadata := s.models.metadata.Lookup(model); metadata != nil {
+		return metadata.Pricing
+	}
+	return nil
+}
+
+// requestCost 按每百万 token 价格计算实时接口的费用（美元），价格未知时为 0
+func requestCost(pricing *catalog.Pricing, promptTokens int, completionTokens int) float64 {
+	if pricing == nil {
+		return 0
+	}
+	return (float64(promptTokens)*pricing.Input + float64(completionTokens)*pricing.Output) / 1e6
+}
+
+// newUsageRecord 根据请求的密钥创建用量记录
+func newUsageRecord(r *http.Request, upstream string, route string, model string, start time.Time) *usage.Record {
+	record := &usage.Record{
+		RequestID: RequestIDFromContext(r.Context()),
+		Time:      start,
+		Upstream:  upstream,
+		Route:     route,
+		Model:     model,
+	}
+	if key := APIKeyFromContext(r.Context()); key != nil {
+		record.Subject = key.Subject
+		record.Email = key.Email
+		record.TeamID = key.TeamID
+		record.KeyPrefix = key.Prefix()
+	}
+	return record
+}
+
+// recordUsage 记录返回了用量的代理请求，命中缓存的请求只计请求数
+func (s *Server) recordUsage(r *http.Request, upstream string, route string, model string, start time.Time,
+	status int, used *proxy.Usage, cached bool) {
+	if s.usage == nil || used == nil {
+		return
+	}
+	record := newUsageRecord(r, upstream, route, model, start)
+	record.Status = status
+	record.Cached = cached
+	record.PromptTokens = used.Prompt()
+	record.CompletionTokens = used.Completion()
+	if !cached {
+		record.Cost = requestCost(s.modelPricing(model), record.PromptTokens, record.CompletionTokens)
+	}
+	s.usage.Submit(record)
+}
+
+// usageUser 密钥所属用户在用量报表中的名称，与 usage.Record.User 一致
+func usageUser(key *APIKey) string {
+	if key.Email != "" {
+		return key.Email
+	}
+	return key.Subject
+}
+
+// handleUsage 查询用量报表，普通用户只能查询自己的用量，管理员可查询全部用量。
+// 支持按时间范围、用户、团队、密钥、模型及上游过滤，按 group_by 分组，按 order 降序排列并以 limit 限制行数，
+// 指定 format 时以 CSV 或 JSON 文件下载。
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if s.usage == nil {
+		s.ResponseError(apierror.New(http.StatusNotImplemented, "usage_disabled", "Usage tracking is not enabled"), w)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := &usage.Filter{
+		User:     query.Get("user"),
+		Team:     query.Get("team"),
+		Key:      query.Get("key"),
+		Model:    query.Get("model"),
+		Upstream: query.Get("upstream"),
+	}
+	format := strings.ToLower(query.Get("format"))
+	if format != "" && format != "csv" && format != "json" {
+		s.ResponseError(apierror.InvalidRequest("format", "invalid_format", "format must be csv or json"), w)
+		return
+	}
+	limit := 0
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			s.ResponseError(apierror.InvalidRequest("limit", "invalid_limit", "limit must be a non-negative integer"), w)
+			return
+		}
+		limit = n
+	}
+	var err error
+	filter.GroupBy, err = usage.ParseGroupBy(query.Get("group_by"))
+	if err != nil {
+		s.ResponseError(apierror.InvalidRequest("group_by", "invalid_group_by", err.Error()), w)
+		return
+	}
+
+	// 默认查询本月，until 不包含在内
+	now := time.Now().UTC()
+	filter.Since = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	filter.Until = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1)
+	for name, target := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		*target, err = usage.ParseDay(value)
+		if err != nil {
+			s.ResponseError(apierror.InvalidRequest(name, "invalid_"+name, name+": "+err.Error()), w)
+			return
+		}
+	}
+	if !filter.Since.Before(filter.Until) {
+		s.ResponseError(apierror.InvalidRequest("until", "invalid_until", "until must be after since"), w)
+		return
+	}
+
+	key := APIKeyFromContext(r.Context())
+	if !s.authMiddleware.IsAdmin(key) {
+		filter.User = usageUser(key)
+		if filter.User == "" {
+			s.ResponseError(apierror.Forbidden("API key has no owner"), w)
+			return
+		}
+	}
+
+	rows, err := s.usage.Store().QueryUsage(filter)
+	if err != nil {
+		logging.Logger.Errorf("Failed to query usage: %v", err)
+		s.ResponseError(err, w)
+		return
+	}
+	report := usage.NewReport(filter, rows)
+	if order := strings.ToLower(query.Get("order")); order != "" {
+		if err := report.Top(order, limit); err != nil {
+			s.ResponseError(apierror.InvalidRequest("order", "invalid_order", err.Error()), w)
+			return
+		}
+	} else if limit > 0 && len(report.Rows) > limit {
+		report.Rows = report.Rows[:limit]
+	}
+
+	// 指定 format 时作为文件下载
+	filename := "usage-" + filter.Since.Format(usage.DAY_FORMAT) + "-" + filter.Until.Format(usage.DAY_FORMAT)
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+		if err := report.WriteCSV(w); err != nil {
+			logging.Logger.Errorf("Failed to write usage CSV: %v", err)
+		}
+	case "json":
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+		s.ResponseJSON(report, w)
+	default:
+		s.ResponseJSON(report, w)
+	}
+}
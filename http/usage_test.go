@@ -0,0 +1,108 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"openai-forward/catalog"
+	"openai-forward/usage"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newUsageTestServer() *Server {
+	return &Server{
+		authMiddleware: &AuthMiddleware{AdminEmails: []string{"admin@example.com"}},
+		models:         NewModelCatalogs(time.Minute, catalog.MetadataTable{"gpt-4o": {Pricing: &catalog.Pricing{Input: 2.5, Output: 10}}}),
+		usage:          usage.NewRecorder(&usage.Config{Enabled: true, FlushInterval: time.Hour, BatchSize: 100}, usage.NewMemoryStore()),
+		teamManager:    newTestTeamManager(&Team{ID: "ml"}, &Team{ID: "web"}),
+	}
+}
+
+func queryUsage(s *Server, key *APIKey, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/api/v1/usage?"+query, nil)
+	req = req.WithContext(withAPIKey(context.Background(), key))
+	rec := httptest.NewRecorder()
+	s.handleUsage(rec, req)
+	return rec
+}
+
+func TestObserveProxy_RecordsUsage(t *testing.T) {
+	s := newUsageTestServer()
+	store := s.usage.Store()
+	handler := s.observeProxy("openai", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"model":"gpt-4o","usage":{"prompt_tokens":1000,"completion_tokens":200,"total_tokens":1200}}`))
+	})
+	for _, key := range []*APIKey{
+		{Key: "sk-alice-1", Email: "alice@example.com", TeamID: "ml"},
+		{Key: "sk-alice-1", Email: "alice@example.com", TeamID: "ml"},
+		{Key: "sk-bob-123", Subject: "bob", TeamID: "web"},
+	} {
+		req := httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`))
+		req.Header.Set("Content-Type", "application/json")
+		handler(httptest.NewRecorder(), req.WithContext(withAPIKey(req.Context(), key)))
+	}
+	s.usage.Close()
+
+	rows, _ := store.QueryUsage(&usage.Filter{GroupBy: []string{usage.GROUP_USER, usage.GROUP_KEY, usage.GROUP_MODEL}})
+	if len(rows) != 2 || rows[0].User != "alice@example.com" || rows[0].Key != "sk-alice" || rows[0].Model != "gpt-4o" {
+		t.Fatalf("Unexpected usage rows: %+v", rows)
+	}
+	// 1000 * 2.5 / 1e6 + 200 * 10 / 1e6
+	if rows[0].Requests != 2 || rows[0].TotalTokens != 2400 || rows[0].Cost < 0.008999 || rows[0].Cost > 0.009001 {
+		t.Errorf("Unexpected usage for alice: %+v", rows[0])
+	}
+}
+
+func TestHandleUsage(t *testing.T) {
+	s := newUsageTestServer()
+	today := time.Now().UTC()
+	_ = s.usage.Store().SaveUsageRecords([]*usage.Record{
+		{Time: today, Email: "alice@example.com", TeamID: "ml", Upstream: "openai", Model: "gpt-4o", PromptTokens: 100, Cost: 0.5},
+		{Time: today, Email: "bob@example.com", TeamID: "web", Upstream: "azure", Model: "gpt-4o", PromptTokens: 300, Cost: 1.5},
+		{Time: today.AddDate(0, -2, 0), Email: "alice@example.com", TeamID: "ml", Upstream: "openai", Model: "gpt-4o", PromptTokens: 100},
+	})
+
+	var response struct {
+		Data usage.Report `json:"data"`
+	}
+	// 管理员查询全部用户，默认为本月
+	rec := queryUsage(s, &APIKey{Email: "admin@example.com"}, "group_by=user")
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || len(response.Data.Rows) != 2 || response.Data.Total.Cost != 2 {
+		t.Fatalf("Unexpected admin report: %s", rec.Body.String())
+	}
+
+	// 普通用户只能查询自己的用量
+	rec = queryUsage(s, &APIKey{Email: "alice@example.com"}, "group_by=user&user=bob@example.com&since=2000-01-01")
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || len(response.Data.Rows) != 1 ||
+		response.Data.Rows[0].User != "alice@example.com" || response.Data.Total.Requests != 2 {
+		t.Errorf("Unexpected user report: %s", rec.Body.String())
+	}
+
+	rec = queryUsage(s, &APIKey{Email: "admin@example.com"}, "group_by=team,upstream&format=csv")
+	if rec.Header().Get("Content-Type") != "text/csv; charset=utf-8" || !strings.Contains(rec.Header().Get("Content-Disposition"), ".csv") {
+		t.Errorf("Unexpected CSV headers: %v", rec.Header())
+	}
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 3 || lines[0] != "team,upstream,requests,cached_requests,prompt_tokens,completion_tokens,total_tokens,cost" ||
+		lines[1] != "ml,openai,1,0,100,0,100,0.500000" {
+		t.Errorf("Unexpected CSV: %s", rec.Body.String())
+	}
+
+	// 按费用取前 1 名，合计仍为全部用户
+	rec = queryUsage(s, &APIKey{Email: "admin@example.com"}, "group_by=user&order=cost&limit=1")
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || len(response.Data.Rows) != 1 ||
+		response.Data.Rows[0].User != "bob@example.com" || response.Data.Total.Cost != 2 {
+		t.Errorf("Unexpected top users: %s", rec.Body.String())
+	}
+
+	for _, query := range []string{"group_by=hour", "since=yesterday", "since=2024-02-01&until=2024-01-01", "format=xml",
+		"order=latency", "limit=-1"} {
+		if rec := queryUsage(s, &APIKey{Email: "admin@example.com"}, query); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", query, rec.Code)
+		}
+	}
+}
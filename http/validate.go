@@ -0,0 +1,16 @@
+package http
+
+import (
+	"net/http"
+	"openai-forward/apierror"
+	"openai-forward/validate"
+)
+
+// writeValidationError 以 OpenAI 的错误格式返回请求校验错误，请求体过大时返回 413
+func writeValidationError(w http.ResponseWriter, err *validate.Error) {
+	apiErr := apierror.InvalidRequest(err.Param, err.Code, err.Message)
+	if err.Code == "request_too_large" {
+		apiErr.Status = http.StatusRequestEntityTooLarge
+	}
+	apierror.Write(w, apiErr)
+}
@@ -0,0 +1,251 @@
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"openai-forward/batch"
+	"openai-forward/proxy"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// 任务状态
+const (
+	STATUS_QUEUED    = "queued"
+	STATUS_RUNNING   = "running"
+	STATUS_COMPLETED = "completed"
+	STATUS_CANCELLED = "cancelled"
+)
+
+// 请求状态
+const (
+	ITEM_PENDING   = "pending"
+	ITEM_SUCCEEDED = "succeeded"
+	ITEM_FAILED    = "failed"
+)
+
+// Terminal 任务是否已结束
+func Terminal(status string) bool {
+	return status == STATUS_COMPLETED || status == STATUS_CANCELLED
+}
+
+// Config 任务队列配置
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// Concurrency 每个实例同时执行的请求数
+	Concurrency int `json:"concurrency"`
+	// RateLimit 每个实例对每个上游每分钟发出的请求数上限，0 表示不限制
+	RateLimit int `json:"rate_limit"`
+	// RateLimits 按上游覆盖 RateLimit
+	RateLimits map[string]int `json:"rate_limits"`
+	// MaxRequests 单个任务的请求数上限
+	MaxRequests int `json:"max_requests"`
+	// MaxInputBytes 提交的输入文件大小上限
+	MaxInputBytes int64 `json:"max_input_bytes"`
+	// PollInterval 检查排队任务的间隔
+	PollInterval time.Duration `json:"poll_interval"`
+	// Retention 结束的任务及结果保留时长
+	Retention time.Duration `json:"retention"`
+}
+
+// LoadConfigFromEnv 从环境变量加载任务队列配置
+func LoadConfigFromEnv() (*Config, error) {
+	cfg := &Config{
+		Enabled:       os.Getenv("JOBS_QUEUE") != "off",
+		Concurrency:   4,
+		RateLimit:     60,
+		RateLimits:    map[string]int{},
+		MaxRequests:   50000,
+		MaxInputBytes: 100 << 20,
+		PollInterval:  5 * time.Second,
+		Retention:     7 * 24 * time.Hour,
+	}
+	if concurrency, err := strconv.Atoi(os.Getenv("JOBS_CONCURRENCY")); err == nil && concurrency > 0 {
+		cfg.Concurrency = concurrency
+	}
+	if limit, err := strconv.Atoi(os.Getenv("JOBS_RATE_LIMIT")); err == nil && limit >= 0 {
+		cfg.RateLimit = limit
+	}
+	if maxRequests, err := strconv.Atoi(os.Getenv("JOBS_MAX_REQUESTS")); err == nil && maxRequests > 0 {
+		cfg.MaxRequests = maxRequests
+	}
+	if maxBytes, err := strconv.ParseInt(os.Getenv("JOBS_MAX_INPUT_BYTES"), 10, 64); err == nil && maxBytes > 0 {
+		cfg.MaxInputBytes = maxBytes
+	}
+	if interval, err := time.ParseDuration(os.Getenv("JOBS_POLL_INTERVAL")); err == nil && interval > 0 {
+		cfg.PollInterval = interval
+	}
+	if retention, err := time.ParseDuration(os.Getenv("JOBS_RETENTION")); err == nil && retention > 0 {
+		cfg.Retention = retention
+	}
+	if value := os.Getenv("JOBS_RATE_LIMITS"); value != "" {
+		if err := json.Unmarshal([]byte(value), &cfg.RateLimits); err != nil {
+			return cfg, fmt.Errorf("invalid JOBS_RATE_LIMITS: %w", err)
+		}
+	}
+	return cfg, nil
+}
+
+// String 返回任务队列配置的简要描述
+func (c *Config) String() string {
+	return fmt.Sprintf("concurrency=%d rate_limit=%d/min rate_limits=%v max_requests=%d retention=%s",
+		c.Concurrency, c.RateLimit, c.RateLimits, c.MaxRequests, c.Retention)
+}
+
+// Limit 上游每分钟的请求数上限，0 表示不限制
+func (c *Config) Limit(upstream string) int {
+	if limit, ok := c.RateLimits[upstream]; ok {
+		return limit
+	}
+	return c.RateLimit
+}
+
+// Job 提交到网关的异步任务
+type Job struct {
+	ID       string `json:"id"`
+	Subject  string `json:"subject,omitempty"`
+	Email    string `json:"email,omitempty"`
+	TeamID   string `json:"team_id,omitempty"`
+	Upstream string `json:"upstream"`
+	Endpoint string `json:"endpoint"`
+	Status   string `json:"status"`
+	// Total / Succeeded / Failed 请求数，由执行进度汇总
+	Total            int        `json:"total"`
+	Succeeded        int        `json:"succeeded"`
+	Failed           int        `json:"failed"`
+	PromptTokens     int        `json:"prompt_tokens"`
+	CompletionTokens int        `json:"completion_tokens"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	StartedAt        *time.Time `json:"started_at,omitempty"`
+	CompletedAt      *time.Time `json:"completed_at,omitempty"`
+}
+
+// Item 任务中的一个请求及其结果
+type Item struct {
+	JobID string `json:"-"`
+	// Index 请求在输入文件中的顺序，从 0 开始
+	Index    int             `json:"index"`
+	CustomID string          `json:"custom_id"`
+	URL      string          `json:"url"`
+	Model    string          `json:"model"`
+	Body     json.RawMessage `json:"-"`
+	Status   string          `json:"status"`
+	// StatusCode / Response 上游返回的状态码及响应体
+	StatusCode       int             `json:"status_code,omitempty"`
+	Response         json.RawMessage `json:"response,omitempty"`
+	PromptTokens     int             `json:"prompt_tokens"`
+	CompletionTokens int             `json:"completion_tokens"`
+}
+
+// New 根据输入文件中的请求创建排队的任务
+func New(upstream string, requests []*batch.Request, summary *batch.InputSummary) (*Job, []*Item) {
+	now := time.Now()
+	job := &Job{
+		ID:        "job_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Upstream:  upstream,
+		Endpoint:  summary.Endpoint,
+		Status:    STATUS_QUEUED,
+		Total:     len(requests),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	items := make([]*Item, len(requests))
+	for i, request := range requests {
+		items[i] = &Item{
+			JobID:    job.ID,
+			Index:    i,
+			CustomID: request.CustomID,
+			URL:      request.URL,
+			Model:    request.Model,
+			Body:     request.Body,
+			Status:   ITEM_PENDING,
+		}
+	}
+	return job, items
+}
+
+// Complete 记录请求的结果，响应体不是 JSON 时以字符串保存
+func (i *Item) Complete(statusCode int, body []byte) {
+	i.StatusCode = statusCode
+	i.Status = ITEM_FAILED
+	if statusCode == 200 {
+		i.Status = ITEM_SUCCEEDED
+	}
+
+	if !json.Valid(body) {
+		body, _ = json.Marshal(string(body))
+	}
+	i.Response = body
+
+	var response struct {
+		Usage *proxy.Usage `json:"usage"`
+	}
+	if json.Unmarshal(body, &response) == nil && response.Usage != nil {
+		i.PromptTokens = response.Usage.Prompt()
+		i.CompletionTokens = response.Usage.Completion()
+	}
+}
+
+// resultLine 结果文件中的一行，与 Batch API 输出文件格式一致
+type resultLine struct {
+	ID       string          `json:"id"`
+	CustomID string          `json:"custom_id"`
+	Response *resultResponse `json:"response"`
+	Error    *resultError    `json:"error"`
+}
+
+type resultResponse struct {
+	StatusCode int             `json:"status_code"`
+	Body       json.RawMessage `json:"body"`
+}
+
+type resultError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Result 返回结果文件中的一行（不含换行符）
+func (i *Item) Result() []byte {
+	line := resultLine{ID: fmt.Sprintf("%s-%d", i.JobID, i.Index), CustomID: i.CustomID}
+	if i.StatusCode > 0 {
+		line.Response = &resultResponse{StatusCode: i.StatusCode, Body: i.Response}
+	} else {
+		line.Error = &resultError{Code: "request_failed", Message: string(i.Response)}
+	}
+	data, _ := json.Marshal(line)
+	return data
+}
+
+// ProxyPath 请求在网关上对应的代理路径，Azure 请求按模型映射（deployments）转换为部署路径，未映射的模型直接作为部署名称
+func ProxyPath(upstream string, url string, model string, deployments map[string]string) string {
+	if upstream == "azure" {
+		deployment := model
+		if mapped, ok := deployments[model]; ok {
+			deployment = mapped
+		}
+		return "/azure/openai/deployments/" + deployment + "/" + strings.TrimPrefix(url, "/v1/")
+	}
+	return "/openai" + url
+}
+
+// Store 任务存储，Get 不存在时返回 nil, nil
+type Store interface {
+	// SaveJob 保存新提交的任务及其请求
+	SaveJob(job *Job, items []*Item) error
+	GetJob(id string) (*Job, error)
+	// ClaimJob 领取最早排队的任务，或心跳早于 staleBefore 的执行中任务（执行实例已退出），没有时返回 nil
+	ClaimJob(staleBefore time.Time) (*Job, error)
+	// ListPendingJobItems 列出任务中尚未执行的请求
+	ListPendingJobItems(jobID string, limit int) ([]*Item, error)
+	// SaveJobItemResult 保存请求的结果
+	SaveJobItemResult(item *Item) error
+	// RefreshJobProgress 汇总任务进度并更新心跳
+	RefreshJobProgress(id string) error
+	// FinishJob 将执行中的任务标记为结束，任务已被取消时不修改
+	FinishJob(id string, status string) error
+}
@@ -0,0 +1,301 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"openai-forward/batch"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStore 测试用的任务存储
+type memoryStore struct {
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	items map[string][]*Item
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{jobs: map[string]*Job{}, items: map[string][]*Item{}}
+}
+
+func (m *memoryStore) SaveJob(job *Job, items []*Item) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[job.ID] = job
+	m.items[job.ID] = items
+	return nil
+}
+
+func (m *memoryStore) GetJob(id string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if job, ok := m.jobs[id]; ok {
+		copied := *job
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (m *memoryStore) ClaimJob(staleBefore time.Time) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := make([]string, 0, len(m.jobs))
+	for id := range m.jobs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		job := m.jobs[id]
+		if job.Status == STATUS_QUEUED || (job.Status == STATUS_RUNNING && job.UpdatedAt.Before(staleBefore)) {
+			job.Status = STATUS_RUNNING
+			job.UpdatedAt = time.Now()
+			copied := *job
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memoryStore) ListPendingJobItems(jobID string, limit int) ([]*Item, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	items := []*Item{}
+	for _, item := range m.items[jobID] {
+		if item.Status == ITEM_PENDING && len(items) < limit {
+			copied := *item
+			items = append(items, &copied)
+		}
+	}
+	return items, nil
+}
+
+func (m *memoryStore) SaveJobItemResult(item *Item) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	copied := *item
+	m.items[item.JobID][item.Index] = &copied
+	return nil
+}
+
+func (m *memoryStore) RefreshJobProgress(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job := m.jobs[id]
+	job.Succeeded, job.Failed, job.PromptTokens = 0, 0, 0
+	for _, item := range m.items[id] {
+		switch item.Status {
+		case ITEM_SUCCEEDED:
+			job.Succeeded++
+		case ITEM_FAILED:
+			job.Failed++
+		}
+		job.PromptTokens += item.PromptTokens
+	}
+	job.UpdatedAt = time.Now()
+	return nil
+}
+
+func (m *memoryStore) FinishJob(id string, status string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if job := m.jobs[id]; job.Status == STATUS_RUNNING {
+		job.Status = status
+	}
+	return nil
+}
+
+// newTestJob 创建包含 n 个请求的任务
+func newTestJob(t *testing.T, upstream string, n int) (*Job, []*Item) {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = `{"custom_id":"req-` + string(rune('a'+i)) + `","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o"}}`
+	}
+	requests, summary, err := batch.ParseRequests(strings.NewReader(strings.Join(lines, "\n")), nil)
+	if err != nil {
+		t.Fatalf("Failed to parse requests: %v", err)
+	}
+	return New(upstream, requests, summary)
+}
+
+func TestRunner_RunPending(t *testing.T) {
+	store := newMemoryStore()
+	job, items := newTestJob(t, "openai", 10)
+	_ = store.SaveJob(job, items)
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	execute := func(ctx context.Context, job *Job, item *Item) (int, []byte) {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		if item.CustomID == "req-c" {
+			return 400, []byte(`{"error":{"message":"bad request"}}`)
+		}
+		return 200, []byte(`{"model":"gpt-4o","usage":{"prompt_tokens":3,"completion_tokens":1}}`)
+	}
+	runner := NewRunner(&Config{Concurrency: 2, PollInterval: time.Second}, store, execute)
+	runner.RunPending(context.Background())
+
+	result, _ := store.GetJob(job.ID)
+	if result.Status != STATUS_COMPLETED || result.Succeeded != 9 || result.Failed != 1 || result.PromptTokens != 27 {
+		t.Errorf("Unexpected job: %+v", result)
+	}
+	if maxInFlight > 2 {
+		t.Errorf("Expected at most 2 concurrent requests, got %d", maxInFlight)
+	}
+
+	var line struct {
+		CustomID string `json:"custom_id"`
+		Response struct {
+			StatusCode int `json:"status_code"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(store.items[job.ID][2].Result(), &line); err != nil {
+		t.Fatalf("Failed to parse result line: %v", err)
+	}
+	if line.CustomID != "req-c" || line.Response.StatusCode != 400 {
+		t.Errorf("Unexpected result line: %+v", line)
+	}
+}
+
+func TestRunner_Cancelled(t *testing.T) {
+	store := newMemoryStore()
+	job, items := newTestJob(t, "openai", 20)
+	_ = store.SaveJob(job, items)
+
+	executed := 0
+	execute := func(ctx context.Context, job *Job, item *Item) (int, []byte) {
+		executed++
+		// 第一批执行时任务被取消
+		store.mu.Lock()
+		store.jobs[job.ID].Status = STATUS_CANCELLED
+		store.mu.Unlock()
+		return 200, []byte(`{}`)
+	}
+	runner := NewRunner(&Config{Concurrency: 1, PollInterval: time.Second}, store, execute)
+	runner.RunPending(context.Background())
+
+	if executed != chunkSize {
+		t.Errorf("Expected only the first chunk of %d requests to run, got %d", chunkSize, executed)
+	}
+	if result, _ := store.GetJob(job.ID); result.Status != STATUS_CANCELLED {
+		t.Errorf("Expected job to stay cancelled, got %s", result.Status)
+	}
+}
+
+func TestRunner_RoundRobinOwners(t *testing.T) {
+	store := newMemoryStore()
+	// alice 先提交两个大任务，bob 后提交一个小任务
+	for _, submit := range []struct {
+		owner string
+		n     int
+	}{{"alice", 20}, {"alice", 20}, {"bob", chunkSize}} {
+		job, items := newTestJob(t, "openai", submit.n)
+		job.Subject = submit.owner
+		_ = store.SaveJob(job, items)
+	}
+
+	order := []string{}
+	execute := func(ctx context.Context, job *Job, item *Item) (int, []byte) {
+		order = append(order, job.Subject)
+		return 200, []byte(`{}`)
+	}
+	runner := NewRunner(&Config{Concurrency: 1, PollInterval: time.Second}, store, execute)
+	runner.RunPending(context.Background())
+
+	if len(order) != 20+20+chunkSize {
+		t.Fatalf("Expected all requests to run, got %d", len(order))
+	}
+	// 按提交者轮流执行，bob 的任务在前两批内完成，不必等待 alice 的任务
+	bob := 0
+	for _, owner := range order[:2*chunkSize] {
+		if owner == "bob" {
+			bob++
+		}
+	}
+	if bob != chunkSize {
+		t.Errorf("Expected bob's job to run within the first two chunks, got %v", order)
+	}
+	for id, job := range store.jobs {
+		if job.Status != STATUS_COMPLETED {
+			t.Errorf("Expected job %s to complete, got %s", id, job.Status)
+		}
+	}
+}
+
+func TestLimiter_Wait(t *testing.T) {
+	limiter := NewLimiter(1200)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	// 每分钟 1200 个请求即间隔 50ms，第三个请求至少等待 100ms
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected requests to be spaced, took %s", elapsed)
+	}
+
+	if NewLimiter(0) != nil {
+		t.Error("Expected no limiter when rate limit is 0")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := NewLimiter(1).Wait(ctx); err == nil {
+		t.Error("Expected error when context is cancelled")
+	}
+}
+
+func TestItem_Complete(t *testing.T) {
+	item := &Item{JobID: "job_1", Index: 0, CustomID: "a"}
+	item.Complete(502, []byte("bad gateway"))
+	if item.Status != ITEM_FAILED || string(item.Response) != `"bad gateway"` {
+		t.Errorf("Unexpected item: %+v", item)
+	}
+
+	item.Complete(200, []byte(`{"usage":{"input_tokens":5,"output_tokens":2}}`))
+	if item.Status != ITEM_SUCCEEDED || item.PromptTokens != 5 || item.CompletionTokens != 2 {
+		t.Errorf("Unexpected item: %+v", item)
+	}
+}
+
+func TestProxyPath(t *testing.T) {
+	deployments := map[string]string{"gpt-4o": "prod-4o"}
+	if path := ProxyPath("openai", "/v1/chat/completions", "gpt-4o", deployments); path != "/openai/v1/chat/completions" {
+		t.Errorf("Unexpected OpenAI path: %s", path)
+	}
+	if path := ProxyPath("azure", "/v1/embeddings", "text-embedding-3-small", deployments); path != "/azure/openai/deployments/text-embedding-3-small/embeddings" {
+		t.Errorf("Unexpected Azure path: %s", path)
+	}
+	// 配置了模型映射时使用部署名称，代理按请求体中的模型替换部署后路径不变
+	if path := ProxyPath("azure", "/v1/chat/completions", "gpt-4o", deployments); path != "/azure/openai/deployments/prod-4o/chat/completions" {
+		t.Errorf("Unexpected mapped Azure path: %s", path)
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	t.Setenv("JOBS_RATE_LIMIT", "120")
+	t.Setenv("JOBS_RATE_LIMITS", `{"azure": 30}`)
+	cfg, err := LoadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.Limit("openai") != 120 || cfg.Limit("azure") != 30 {
+		t.Errorf("Unexpected limits: %+v", cfg)
+	}
+
+	t.Setenv("JOBS_RATE_LIMITS", `invalid`)
+	if _, err := LoadConfigFromEnv(); err == nil {
+		t.Error("Expected error for invalid JOBS_RATE_LIMITS")
+	}
+}
@@ -0,0 +1,259 @@
+package jobs
+
+import (
+	"context"
+	"openai-forward/logging"
+	"openai-forward/metrics"
+	"sync"
+	"time"
+)
+
+// chunkSize 每轮领取的请求数相对于并发数的倍数，每轮结束后汇总进度并检查任务是否被取消
+const chunkSize = 4
+
+// maxActiveJobs 每个实例同时执行的任务数上限
+const maxActiveJobs = 16
+
+// heartbeatInterval 执行中任务的心跳间隔，超过 staleAfter 未更新的任务视为执行实例已退出，可由其他实例接管
+const (
+	heartbeatInterval = 30 * time.Second
+	staleAfter        = 2 * time.Minute
+)
+
+// Executor 以任务提交者的身份执行一个请求，返回上游（或网关）的状态码及响应体
+type Executor func(ctx context.Context, job *Job, item *Item) (int, []byte)
+
+// Runner 在后台执行排队的任务，按上游限流并限制并发
+type Runner struct {
+	config   *Config
+	store    Store
+	execute  Executor
+	mu       sync.Mutex
+	limiters map[string]*Limiter
+}
+
+// NewRunner 创建任务执行器
+func NewRunner(cfg *Config, store Store, execute Executor) *Runner {
+	return &Runner{config: cfg, store: store, execute: execute, limiters: map[string]*Limiter{}}
+}
+
+// Run 定期领取并执行排队的任务，ctx 取消时返回
+func (r *Runner) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.RunPending(ctx)
+		}
+	}
+}
+
+// activeJob 本实例正在执行的任务
+type activeJob struct {
+	job *Job
+	// stop 停止任务的心跳
+	stop context.CancelFunc
+}
+
+// RunPending 执行所有可领取的任务，直到没有待执行的请求。
+//
+// 各任务的请求按批交替执行：按提交者轮流选择任务，同一提交者的多个任务也轮流执行，
+// 避免一个用户的大任务阻塞其他用户；每批结束后领取新排队的任务加入轮询。
+func (r *Runner) RunPending(ctx context.Context) {
+	owners := []string{}
+	byOwner := map[string][]*activeJob{}
+	current := 0
+	active := 0
+	// 返回前停止并等待所有心跳，避免退出后仍在更新存储
+	var heartbeats sync.WaitGroup
+	defer func() {
+		for _, jobs := range byOwner {
+			for _, a := range jobs {
+				a.stop()
+			}
+		}
+		heartbeats.Wait()
+	}()
+
+	for ctx.Err() == nil {
+		// 领取新任务，新的提交者排在轮询末尾
+		for active < maxActiveJobs {
+			job, err := r.store.ClaimJob(time.Now().Add(-staleAfter))
+			if err != nil {
+				logging.Logger.Errorf("Failed to claim job: %v", err)
+				break
+			}
+			if job == nil {
+				break
+			}
+			logging.Logger.Infof("Running job %s (%d requests) for %s", job.ID, job.Total, job.Subject)
+			heartbeatCtx, stop := context.WithCancel(ctx)
+			heartbeats.Add(1)
+			go func(id string) {
+				defer heartbeats.Done()
+				r.heartbeat(heartbeatCtx, id)
+			}(job.ID)
+			owner := ownerOf(job)
+			if len(byOwner[owner]) == 0 {
+				owners = append(owners, owner)
+			}
+			byOwner[owner] = append(byOwner[owner], &activeJob{job: job, stop: stop})
+			active++
+		}
+		if active == 0 {
+			return
+		}
+
+		// 选择当前提交者的第一个任务执行一批，之后将该任务移到队尾
+		current %= len(owners)
+		owner := owners[current]
+		jobs := byOwner[owner]
+		a := jobs[0]
+		if r.step(ctx, a.job) {
+			a.stop()
+			active--
+			jobs = jobs[1:]
+		} else {
+			jobs = append(jobs[1:], a)
+		}
+		if len(jobs) == 0 {
+			delete(byOwner, owner)
+			owners = append(owners[:current], owners[current+1:]...)
+		} else {
+			byOwner[owner] = jobs
+			current++
+		}
+	}
+}
+
+// ownerOf 任务的提交者，用于轮流执行各提交者的任务
+func ownerOf(job *Job) string {
+	if job.Subject != "" {
+		return job.Subject
+	}
+	return job.Email
+}
+
+// limiter 返回上游对应的限流器，各任务共享
+func (r *Runner) limiter(upstream string) *Limiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if limiter, ok := r.limiters[upstream]; ok {
+		return limiter
+	}
+	limiter := NewLimiter(r.config.Limit(upstream))
+	r.limiters[upstream] = limiter
+	return limiter
+}
+
+// step 执行任务中的下一批请求，任务结束、被取消或需中止时返回 true。
+// 存储出错时中止，任务保持执行中，由心跳超时后重新领取
+func (r *Runner) step(ctx context.Context, job *Job) bool {
+	current, err := r.store.GetJob(job.ID)
+	if err != nil {
+		logging.Logger.Errorf("Failed to load job %s: %v", job.ID, err)
+		return true
+	}
+	if current == nil || current.Status != STATUS_RUNNING {
+		logging.Logger.Infof("Job %s stopped with status %v", job.ID, statusOf(current))
+		return true
+	}
+
+	items, err := r.store.ListPendingJobItems(job.ID, r.config.Concurrency*chunkSize)
+	if err != nil {
+		logging.Logger.Errorf("Failed to list pending items of job %s: %v", job.ID, err)
+		return true
+	}
+	if len(items) == 0 {
+		if err := r.store.FinishJob(job.ID, STATUS_COMPLETED); err != nil {
+			logging.Logger.Errorf("Failed to finish job %s: %v", job.ID, err)
+			return true
+		}
+		metrics.Jobs.WithLabelValues(STATUS_COMPLETED).Inc()
+		logging.Logger.Infof("Job %s completed", job.ID)
+		return true
+	}
+	if !r.runItems(ctx, job, items, r.limiter(job.Upstream)) {
+		return true
+	}
+	if err := r.store.RefreshJobProgress(job.ID); err != nil {
+		logging.Logger.Errorf("Failed to refresh progress of job %s: %v", job.ID, err)
+	}
+	return false
+}
+
+// runItems 并发执行一批请求并保存结果，所有结果均保存成功时返回 true
+func (r *Runner) runItems(ctx context.Context, job *Job, items []*Item, limiter *Limiter) bool {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	ok := true
+	slots := make(chan struct{}, r.config.Concurrency)
+
+	for _, item := range items {
+		if limiter.Wait(ctx) != nil {
+			mu.Lock()
+			ok = false
+			mu.Unlock()
+			break
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(item *Item) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			start := time.Now()
+			status, body := r.execute(ctx, job, item)
+			if ctx.Err() != nil {
+				// 实例退出时中断的请求保持未执行，由接管的实例重新执行
+				mu.Lock()
+				ok = false
+				mu.Unlock()
+				return
+			}
+			item.Complete(status, body)
+			metrics.JobRequests.WithLabelValues(job.Upstream, item.Status).Inc()
+			metrics.JobRequestDuration.WithLabelValues(job.Upstream).Observe(time.Since(start).Seconds())
+
+			if err := r.store.SaveJobItemResult(item); err != nil {
+				logging.Logger.Errorf("Failed to save result %d of job %s: %v", item.Index, job.ID, err)
+				mu.Lock()
+				ok = false
+				mu.Unlock()
+			}
+		}(item)
+	}
+	wg.Wait()
+	return ok
+}
+
+// heartbeat 定期更新执行中任务的进度，表明执行实例仍在运行
+func (r *Runner) heartbeat(ctx context.Context, id string) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.store.RefreshJobProgress(id); err != nil {
+				logging.Logger.Errorf("Failed to refresh progress of job %s: %v", id, err)
+			}
+		}
+	}
+}
+
+// statusOf 返回任务状态，任务不存在时为 deleted
+func statusOf(job *Job) string {
+	if job == nil {
+		return "deleted"
+	}
+	return job.Status
+}
@@ -2,7 +2,9 @@ package logging
 
 import (
 	"os"
+	"time"
 
+	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
 )
 
@@ -12,20 +14,60 @@ var Logger *logrus.Logger
 func init() {
 	Logger = logrus.New()
 	Logger.SetOutput(os.Stdout)
+	Configure(os.Getenv("PROXY_LOG_FORMAT"), os.Getenv("PROXY_LOG_LEVEL"))
+}
+
+// Configure 设置日志格式（text 或 json）与级别，无法识别的级别按 info 处理
+func Configure(format string, level string) {
+	SetFormat(format)
+	err := SetLevel(level)
+	if err != nil {
+		Logger.SetLevel(logrus.InfoLevel)
+	}
+}
+
+// SetFormat 设置日志格式，json 输出结构化日志，其余按文本格式输出
+func SetFormat(format string) {
+	if format == "json" {
+		Logger.SetFormatter(&logrus.JSONFormatter{
+			TimestampFormat: time.RFC3339Nano,
+		})
+		return
+	}
 	Logger.SetFormatter(&logrus.TextFormatter{
 		FullTimestamp: true,
 	})
-	levelFromENV := os.Getenv("PROXY_LOG_LEVEL")
-	switch levelFromENV {
-	case "debug":
-		Logger.SetLevel(logrus.DebugLevel)
-	case "warn":
-		Logger.SetLevel(logrus.WarnLevel)
-	case "error":
-		Logger.SetLevel(logrus.ErrorLevel)
-	case "fatal":
-		Logger.SetLevel(logrus.FatalLevel)
-	default:
+}
+
+// SetLevel 设置日志级别，为空时使用 info
+func SetLevel(level string) error {
+	if level == "" {
 		Logger.SetLevel(logrus.InfoLevel)
+		return nil
+	}
+	parsed, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
 	}
+	Logger.SetLevel(parsed)
+	return nil
+}
+
+// Reload 重新读取 .env 文件及环境变量中的日志配置，.env 文件优先，用于运行时调整日志级别
+func Reload() {
+	format := os.Getenv("PROXY_LOG_FORMAT")
+	level := os.Getenv("PROXY_LOG_LEVEL")
+
+	values, err := godotenv.Read()
+	if err == nil {
+		if value, ok := values["PROXY_LOG_FORMAT"]; ok {
+			format = value
+		}
+		if value, ok := values["PROXY_LOG_LEVEL"]; ok {
+			level = value
+		}
+	}
+
+	Configure(format, level)
+	Logger.Infof("Log level reloaded: %s", Logger.GetLevel())
 }
@@ -0,0 +1,40 @@
+package logging
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestSetLevel(t *testing.T) {
+	defer Logger.SetLevel(Logger.GetLevel())
+
+	err := SetLevel("debug")
+	if err != nil || Logger.GetLevel() != logrus.DebugLevel {
+		t.Errorf("Expected debug level, got %s (%v)", Logger.GetLevel(), err)
+	}
+
+	err = SetLevel("verbose")
+	if err == nil {
+		t.Error("Expected error for unknown level")
+	}
+
+	Configure("", "verbose")
+	if Logger.GetLevel() != logrus.InfoLevel {
+		t.Errorf("Expected unknown level to fall back to info, got %s", Logger.GetLevel())
+	}
+}
+
+func TestSetFormat(t *testing.T) {
+	defer SetFormat("")
+
+	SetFormat("json")
+	if _, ok := Logger.Formatter.(*logrus.JSONFormatter); !ok {
+		t.Error("Expected JSON formatter")
+	}
+
+	SetFormat("text")
+	if _, ok := Logger.Formatter.(*logrus.TextFormatter); !ok {
+		t.Error("Expected text formatter")
+	}
+}
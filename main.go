@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	httpService "openai-forward/http"
 	"openai-forward/logging"
+	"openai-forward/tracing"
 	"os"
 	"os/signal"
 	"runtime"
 	"syscall"
+	"time"
 )
 
 func main() {
@@ -19,7 +22,18 @@ func main() {
 	logging.Logger.Debug("show config detail:")
 	logging.Logger.Debug(conf.ToJSON())
 
-	server := httpService.NewServer(conf)
+	// 初始化链路追踪，未配置 OTEL_EXPORTER_OTLP_ENDPOINT 时不导出
+	shutdownTracing, err := tracing.Init(context.Background())
+	if err != nil {
+		logging.Logger.Errorf("Failed to initialize tracing: %v", err)
+		shutdownTracing = func(context.Context) error { return nil }
+	}
+
+	server, err := httpService.NewServer(conf)
+	if err != nil {
+		logging.Logger.Errorf("Failed to create HTTP server: %v", err)
+		os.Exit(1)
+	}
 
 	// 启动服务在goroutine中
 	go func() {
@@ -29,6 +43,15 @@ func main() {
 		}
 	}()
 
+	// 收到 SIGHUP 时重新加载日志级别
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			logging.Reload()
+		}
+	}()
+
 	// 等待中断信号以优雅地关闭服务器
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -39,5 +62,11 @@ func main() {
 		logging.Logger.Errorf("Server forced to shutdown: %v", err)
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		logging.Logger.Errorf("Failed to flush traces: %v", err)
+	}
+
 	logging.Logger.Info("Server exiting")
 }
@@ -0,0 +1,239 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "openai_forward"
+
+// Registry 服务的指标注册表
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	// RequestsTotal 代理请求数
+	RequestsTotal = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Total number of proxied requests.",
+	}, []string{"route", "upstream", "model", "status"})
+
+	// RequestDuration 代理请求耗时
+	RequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Latency of proxied requests.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"route", "upstream", "model", "status"})
+
+	// TimeToFirstToken 流式响应首个分片的耗时
+	TimeToFirstToken = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "time_to_first_token_seconds",
+		Help:      "Time until the first chunk of a streamed response.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2, 4, 8, 16, 32},
+	}, []string{"route", "upstream", "model"})
+
+	// InFlightRequests 正在处理的代理请求数
+	InFlightRequests = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "in_flight_requests",
+		Help:      "Number of proxied requests currently being served.",
+	}, []string{"upstream"})
+
+	// UpstreamErrors 上游错误数，reason 为 transport 或上游返回的状态码
+	UpstreamErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_errors_total",
+		Help:      "Total number of upstream transport failures and error responses.",
+	}, []string{"upstream", "reason"})
+
+	// UpstreamRetries 对上游的重试次数，reason 为触发重试的状态码
+	UpstreamRetries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_retries_total",
+		Help:      "Total number of retried upstream requests.",
+	}, []string{"upstream", "reason"})
+
+	// RealtimeSessions 结束的 Realtime 会话数，reason 为 client_closed、upstream_closed、max_duration 或 error
+	RealtimeSessions = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "realtime_sessions_total",
+		Help:      "Total number of finished realtime sessions.",
+	}, []string{"upstream", "reason"})
+
+	// RealtimeSessionDuration Realtime 会话时长
+	RealtimeSessionDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "realtime_session_duration_seconds",
+		Help:      "Duration of realtime sessions.",
+		Buckets:   []float64{5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600},
+	}, []string{"upstream"})
+
+	// RealtimeTokens Realtime 会话按模态统计的 token 用量，type 为 input_text、input_audio、input_cached、output_text 或 output_audio
+	RealtimeTokens = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "realtime_tokens_total",
+		Help:      "Total number of realtime tokens by modality.",
+	}, []string{"model", "team", "type"})
+
+	// TokensTotal 按模型与团队统计的 token 用量，type 为 prompt 或 completion
+	TokensTotal = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tokens_total",
+		Help:      "Total number of tokens reported by the upstream.",
+	}, []string{"model", "team", "type"})
+
+	// CachedTokensTotal 由响应缓存返回、未计入上游用量的 token 数
+	CachedTokensTotal = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cached_tokens_total",
+		Help:      "Total number of tokens served from the response cache.",
+	}, []string{"model", "team", "type"})
+
+	// CacheRequests 响应缓存查询结果，result 为 hit、miss 或 bypass
+	CacheRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "Outcomes of response cache lookups.",
+	}, []string{"route", "result"})
+
+	// ResourceDenials 因资源归属隔离被拒绝的访问，type 为资源类型
+	ResourceDenials = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "resource_denials_total",
+		Help:      "Requests denied because the upstream resource belongs to another owner.",
+	}, []string{"type"})
+
+	// BatchJobs 经网关提交及结束的批处理任务，status 为 submitted 或结束时的状态
+	BatchJobs = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "batch_jobs_total",
+		Help:      "Batch jobs submitted through the gateway and batch jobs reaching a terminal status.",
+	}, []string{"status"})
+
+	// Jobs 提交到网关任务队列及结束的任务，status 为 submitted 或结束时的状态
+	Jobs = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_total",
+		Help:      "Jobs submitted to the gateway job queue and jobs reaching a terminal status.",
+	}, []string{"status"})
+
+	// JobRequests 任务队列执行的请求，result 为 succeeded 或 failed
+	JobRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "job_requests_total",
+		Help:      "Requests executed by the gateway job queue.",
+	}, []string{"upstream", "result"})
+
+	// JobRequestDuration 任务队列执行单个请求的耗时
+	JobRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_request_duration_seconds",
+		Help:      "Latency of requests executed by the gateway job queue.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"upstream"})
+
+	// QueueWait 请求在上游调度队列中等待并发槽位的时间，包括被拒绝的请求
+	QueueWait = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "queue_wait_seconds",
+		Help:      "Time proxied requests spent waiting for an upstream concurrency slot.",
+		Buckets:   []float64{0.001, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"upstream", "priority"})
+
+	// QueueDepth 上游调度队列中等待的请求数
+	QueueDepth = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "Number of proxied requests waiting for an upstream concurrency slot.",
+	}, []string{"upstream", "priority"})
+
+	// QueueRejected 被调度队列拒绝的请求，reason 为 queue_full 或 queue_timeout
+	QueueRejected = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "queue_rejected_total",
+		Help:      "Proxied requests shed by the upstream scheduler.",
+	}, []string{"upstream", "priority", "reason"})
+
+	// ModerationDecisions 内容审核过滤器的结果，stage 为 input 或 output，action 为 allow、block、redact 或 error
+	ModerationDecisions = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "moderation_decisions_total",
+		Help:      "Content moderation filter decisions.",
+	}, []string{"stage", "filter", "action"})
+
+	// ModerationDuration 内容审核过滤器的耗时
+	ModerationDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "moderation_duration_seconds",
+		Help:      "Latency of content moderation filters.",
+		Buckets:   []float64{0.0001, 0.001, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+	}, []string{"filter"})
+
+	// ScrubbedItems 从请求体中清除（mask）或导致请求被拒绝（reject）的敏感信息数量
+	ScrubbedItems = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scrubbed_items_total",
+		Help:      "Secrets and PII detected in request bodies.",
+	}, []string{"route", "detector", "action"})
+
+	// NotificationDeliveries webhook 通知的发送结果，result 为 delivered、retry 或 failed
+	NotificationDeliveries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notification_deliveries_total",
+		Help:      "Webhook notification delivery attempts.",
+	}, []string{"target", "event", "result"})
+
+	// KeyValidations 密钥校验结果
+	KeyValidations = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "key_validations_total",
+		Help:      "Outcomes of API key validation in the auth middleware.",
+	}, []string{"result"})
+
+	// StorageDuration 存储操作耗时
+	StorageDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "storage_duration_seconds",
+		Help:      "Latency of storage operations.",
+		Buckets:   []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1},
+	}, []string{"operation"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// ObserveStorage 记录存储操作耗时，用法：defer metrics.ObserveStorage("GetAPIKey", time.Now())
+func ObserveStorage(operation string, start time.Time) {
+	StorageDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// Handler 返回 Prometheus 指标接口，token 不为空时要求 Authorization: Bearer <token>
+func Handler(token string) http.Handler {
+	handler := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+	if token == "" {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provided := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(provided, []byte("Bearer "+token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler_Token(t *testing.T) {
+	handler := Handler("secret")
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != 401 {
+		t.Errorf("Expected status 401 without token, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != 200 {
+		t.Errorf("Expected status 200 with token, got %d", rec.Code)
+	}
+}
+
+func TestObserveStorage(t *testing.T) {
+	// 指标注册在全局，每次运行使用不同的操作名称，避免 -count=N 时计数累加
+	operation := fmt.Sprintf("TestOperation%d", time.Now().UnixNano())
+	ObserveStorage(operation, time.Now())
+
+	rec := httptest.NewRecorder()
+	Handler("").ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `openai_forward_storage_duration_seconds_count{operation="`+operation+`"} 1`) {
+		t.Error("Expected storage duration to be exported")
+	}
+}
@@ -0,0 +1,286 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"openai-forward/logging"
+	"openai-forward/metrics"
+	"openai-forward/redact"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultModerationModel OpenAI 审核接口默认使用的模型
+const defaultModerationModel = "omni-moderation-latest"
+
+// maxWebhookResponseBytes webhook 响应大小上限
+const maxWebhookResponseBytes = 4 << 20
+
+// checker 过滤器的检查逻辑，未命中时返回 nil
+type checker interface {
+	check(ctx context.Context, in *Input) (*Decision, error)
+}
+
+// filter 过滤器链中的一个过滤器
+type filter struct {
+	name     string
+	stage    string
+	timeout  time.Duration
+	failOpen bool
+	checker  checker
+}
+
+// newFilter 校验配置并创建过滤器
+func newFilter(cfg *FilterConfig, timeout time.Duration, upstream Upstream) (*filter, error) {
+	f := &filter{name: cfg.Name, stage: cfg.Stage, timeout: timeout, failOpen: cfg.FailOpen}
+	if f.name == "" {
+		f.name = cfg.Type
+	}
+	if f.stage == "" {
+		f.stage = STAGE_INPUT
+	}
+	if f.stage != STAGE_INPUT && f.stage != STAGE_OUTPUT && f.stage != STAGE_BOTH {
+		return nil, fmt.Errorf("filter %s: invalid stage %q", f.name, cfg.Stage)
+	}
+	if cfg.Timeout != "" {
+		d, err := time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("filter %s: invalid timeout: %w", f.name, err)
+		}
+		f.timeout = d
+	}
+	action := cfg.Action
+	if action == "" {
+		action = ACTION_BLOCK
+	}
+	if action != ACTION_ALLOW && action != ACTION_BLOCK && action != ACTION_REDACT {
+		return nil, fmt.Errorf("filter %s: invalid action %q", f.name, cfg.Action)
+	}
+
+	switch cfg.Type {
+	case TYPE_KEYWORDS, TYPE_REGEX:
+		patterns := append([]string{}, cfg.Patterns...)
+		for _, keyword := range cfg.Keywords {
+			if keyword != "" {
+				patterns = append(patterns, "(?i)"+regexp.QuoteMeta(keyword))
+			}
+		}
+		redactor, err := redact.New(cfg.Builtin, patterns)
+		if err != nil {
+			return nil, fmt.Errorf("filter %s: %w", f.name, err)
+		}
+		if redactor.Empty() {
+			return nil, fmt.Errorf("filter %s: no keywords or patterns", f.name)
+		}
+		f.checker = &patternChecker{action: action, redactor: redactor}
+	case TYPE_OPENAI:
+		if action == ACTION_REDACT {
+			return nil, fmt.Errorf("filter %s: openai_moderation does not support redact", f.name)
+		}
+		if upstream == nil {
+			return nil, fmt.Errorf("filter %s: OpenAI upstream is not configured", f.name)
+		}
+		model := cfg.Model
+		if model == "" {
+			model = defaultModerationModel
+		}
+		f.checker = &openaiChecker{action: action, upstream: upstream, model: model,
+			categories: cfg.Categories, threshold: cfg.Threshold}
+	case TYPE_WEBHOOK:
+		if !strings.HasPrefix(cfg.URL, "http://") && !strings.HasPrefix(cfg.URL, "https://") {
+			return nil, fmt.Errorf("filter %s: invalid webhook url %q", f.name, cfg.URL)
+		}
+		f.checker = &webhookChecker{url: cfg.URL, headers: cfg.Headers, client: &http.Client{}}
+	default:
+		return nil, fmt.Errorf("filter %s: unknown type %q", f.name, cfg.Type)
+	}
+	return f, nil
+}
+
+// run 执行过滤器并统计结果，出错时按 fail_open 放行或拦截
+func (f *filter) run(ctx context.Context, in *Input) *Decision {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	start := time.Now()
+	decision, err := f.checker.check(ctx, in)
+	metrics.ModerationDuration.WithLabelValues(f.name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.ModerationDecisions.WithLabelValues(in.Stage, f.name, "error").Inc()
+		logging.Logger.Errorf("Moderation filter %s failed for request %s: %v", f.name, in.RequestID, err)
+		if f.failOpen {
+			return nil
+		}
+		decision = &Decision{Action: ACTION_BLOCK, Reason: "filter unavailable"}
+	}
+	if decision == nil {
+		return nil
+	}
+	decision.Filter = f.name
+	metrics.ModerationDecisions.WithLabelValues(in.Stage, f.name, decision.Action).Inc()
+	return decision
+}
+
+// patternChecker 关键词与正则过滤器，复用审计脱敏的匹配规则
+type patternChecker struct {
+	action   string
+	redactor *redact.Redactor
+}
+
+func (c *patternChecker) check(ctx context.Context, in *Input) (*Decision, error) {
+	texts := make([]string, len(in.Texts))
+	matched := map[string]int{}
+	for i, text := range in.Texts {
+		var hits map[string]int
+		texts[i], hits = c.redactor.Redact(text)
+		for name, n := range hits {
+			matched[name] += n
+		}
+	}
+	if len(matched) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(matched))
+	for name := range matched {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	decision := &Decision{Action: c.action, Reason: "matched " + strings.Join(names, ", ")}
+	if c.action == ACTION_REDACT {
+		decision.Texts = texts
+	}
+	return decision, nil
+}
+
+// openaiChecker 调用 OpenAI 审核接口
+type openaiChecker struct {
+	action     string
+	upstream   Upstream
+	model      string
+	categories []string
+	threshold  float64
+}
+
+// moderationResponse OpenAI 审核接口的响应
+type moderationResponse struct {
+	Results []struct {
+		Flagged        bool               `json:"flagged"`
+		Categories     map[string]bool    `json:"categories"`
+		CategoryScores map[string]float64 `json:"category_scores"`
+	} `json:"results"`
+}
+
+func (c *openaiChecker) check(ctx context.Context, in *Input) (*Decision, error) {
+	texts := make([]string, 0, len(in.Texts))
+	for _, text := range in.Texts {
+		if strings.TrimSpace(text) != "" {
+			texts = append(texts, text)
+		}
+	}
+	if len(texts) == 0 {
+		return nil, nil
+	}
+
+	body, _ := json.Marshal(map[string]any{"model": c.model, "input": texts})
+	reader, err := c.upstream.Post(ctx, "/v1/moderations", body)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	var response moderationResponse
+	if err := json.NewDecoder(reader).Decode(&response); err != nil {
+		return nil, fmt.Errorf("invalid moderation response: %w", err)
+	}
+
+	flagged := map[string]bool{}
+	for _, result := range response.Results {
+		for category, hit := range result.Categories {
+			if c.hit(category, hit, result.CategoryScores[category]) {
+				flagged[category] = true
+			}
+		}
+		if len(c.categories) == 0 && c.threshold == 0 && result.Flagged && len(flagged) == 0 {
+			flagged["flagged"] = true
+		}
+	}
+	if len(flagged) == 0 {
+		return nil, nil
+	}
+	categories := make([]string, 0, len(flagged))
+	for category := range flagged {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	return &Decision{Action: c.action, Reason: "flagged " + strings.Join(categories, ", ")}, nil
+}
+
+// hit 判断类别是否命中：配置了阈值时按分数判断，否则使用上游的判定结果
+func (c *openaiChecker) hit(category string, flagged bool, score float64) bool {
+	if len(c.categories) > 0 && !contains(c.categories, category) {
+		return false
+	}
+	if c.threshold > 0 {
+		return score >= c.threshold
+	}
+	return flagged
+}
+
+// webhookChecker 将待审核内容发送给外部服务，由其返回动作及脱敏后的文本
+type webhookChecker struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+// webhookResponse webhook 返回的审核结果，action 为空表示未命中
+type webhookResponse struct {
+	Action string   `json:"action"`
+	Reason string   `json:"reason"`
+	Texts  []string `json:"texts"`
+}
+
+func (c *webhookChecker) check(ctx context.Context, in *Input) (*Decision, error) {
+	body, _ := json.Marshal(in)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range c.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	var result webhookResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxWebhookResponseBytes)).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid webhook response: %w", err)
+	}
+
+	switch result.Action {
+	case "":
+		return nil, nil
+	case ACTION_ALLOW, ACTION_BLOCK:
+		return &Decision{Action: result.Action, Reason: result.Reason}, nil
+	case ACTION_REDACT:
+		if len(result.Texts) != len(in.Texts) {
+			return nil, errors.New("webhook returned redacted texts that do not match the input")
+		}
+		return &Decision{Action: ACTION_REDACT, Reason: result.Reason, Texts: result.Texts}, nil
+	}
+	return nil, fmt.Errorf("webhook returned unknown action %q", result.Action)
+}
@@ -0,0 +1,282 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"time"
+)
+
+// 审核动作
+const (
+	ACTION_ALLOW  = "allow"
+	ACTION_BLOCK  = "block"
+	ACTION_REDACT = "redact"
+)
+
+// 审核阶段：请求中的提示词（input）、模型的输出（output）或两者（both）
+const (
+	STAGE_INPUT  = "input"
+	STAGE_OUTPUT = "output"
+	STAGE_BOTH   = "both"
+)
+
+// 内置过滤器类型
+const (
+	TYPE_KEYWORDS = "keywords"
+	TYPE_REGEX    = "regex"
+	TYPE_OPENAI   = "openai_moderation"
+	TYPE_WEBHOOK  = "webhook"
+)
+
+// FilterConfig 过滤器配置
+type FilterConfig struct {
+	// Name 过滤器名称，用于日志与指标，默认为类型
+	Name string `json:"name,omitempty"`
+	Type string `json:"type"`
+	// Stage 审核阶段，默认 input
+	Stage string `json:"stage,omitempty"`
+	// Action 命中时的动作，默认 block；webhook 的动作由外部服务返回
+	Action string `json:"action,omitempty"`
+	// Keywords 关键词，不区分大小写
+	Keywords []string `json:"keywords,omitempty"`
+	// Patterns 正则表达式
+	Patterns []string `json:"patterns,omitempty"`
+	// Builtin 审计脱敏的内置检测规则，如 email、api_key，"all" 表示全部
+	Builtin []string `json:"builtin,omitempty"`
+	// Categories 视为命中的 OpenAI 审核类别，为空时以上游的 flagged 为准
+	Categories []string `json:"categories,omitempty"`
+	// Threshold 类别分数阈值，为 0 时使用上游的判定结果
+	Threshold float64 `json:"threshold,omitempty"`
+	// Model OpenAI 审核模型，默认 omni-moderation-latest
+	Model string `json:"model,omitempty"`
+	// URL / Headers webhook 地址及附加请求头（如认证信息）
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Timeout 调用外部服务的超时时间，默认 MODERATION_TIMEOUT
+	Timeout string `json:"timeout,omitempty"`
+	// FailOpen 外部服务出错时放行，默认拦截
+	FailOpen bool `json:"fail_open,omitempty"`
+}
+
+// Policy 审核策略，按团队与模型匹配，请求使用第一个匹配的策略
+type Policy struct {
+	Name string `json:"name"`
+	// Teams 适用的团队 ID，为空时适用于所有请求
+	Teams []string `json:"teams,omitempty"`
+	// Models 适用的模型，支持通配符（如 gpt-4o*），为空时适用于所有模型
+	Models  []string        `json:"models,omitempty"`
+	Filters []*FilterConfig `json:"filters"`
+}
+
+// Match 判断策略是否适用于团队与模型
+func (p *Policy) Match(team string, model string) bool {
+	if len(p.Teams) > 0 && !contains(p.Teams, team) {
+		return false
+	}
+	if len(p.Models) == 0 {
+		return true
+	}
+	for _, pattern := range p.Models {
+		if matched, _ := path.Match(pattern, model); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Config 内容审核配置
+type Config struct {
+	Enabled  bool      `json:"enabled"`
+	Policies []*Policy `json:"policies"`
+	// Timeout 调用外部审核服务的默认超时时间
+	Timeout time.Duration `json:"timeout"`
+	// StreamWindow 流式响应每累计多少个字符的输出审核一次，审核通过前相应的分片不会发送给客户端
+	StreamWindow int `json:"stream_window"`
+}
+
+// LoadConfigFromEnv 从环境变量加载内容审核配置，策略来自 MODERATION_POLICIES_FILE 指定的文件或 MODERATION_POLICIES
+func LoadConfigFromEnv() (*Config, error) {
+	cfg := &Config{Timeout: 5 * time.Second, StreamWindow: 200}
+	if timeout, err := time.ParseDuration(os.Getenv("MODERATION_TIMEOUT")); err == nil && timeout > 0 {
+		cfg.Timeout = timeout
+	}
+	if window, err := strconv.Atoi(os.Getenv("MODERATION_STREAM_WINDOW")); err == nil && window > 0 {
+		cfg.StreamWindow = window
+	}
+
+	policies := []byte(os.Getenv("MODERATION_POLICIES"))
+	if file := os.Getenv("MODERATION_POLICIES_FILE"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to read MODERATION_POLICIES_FILE: %w", err)
+		}
+		policies = data
+	}
+	if len(policies) > 0 {
+		if err := json.Unmarshal(policies, &cfg.Policies); err != nil {
+			return cfg, fmt.Errorf("invalid moderation policies: %w", err)
+		}
+	}
+	cfg.Enabled = len(cfg.Policies) > 0
+	return cfg, nil
+}
+
+// String 返回内容审核配置的简要描述
+func (c *Config) String() string {
+	names := make([]string, len(c.Policies))
+	for i, policy := range c.Policies {
+		names[i] = policy.Name
+	}
+	return fmt.Sprintf("policies=%v timeout=%s stream_window=%d", names, c.Timeout, c.StreamWindow)
+}
+
+// Upstream 调用 OpenAI 审核接口使用的上游
+type Upstream interface {
+	Post(ctx context.Context, path string, body []byte) (io.ReadCloser, error)
+}
+
+// Input 待审核的内容
+type Input struct {
+	Stage     string   `json:"stage"`
+	RequestID string   `json:"request_id"`
+	Team      string   `json:"team,omitempty"`
+	Model     string   `json:"model,omitempty"`
+	Texts     []string `json:"texts"`
+}
+
+// Decision 单个过滤器的审核结果
+type Decision struct {
+	Filter string `json:"filter"`
+	Action string `json:"action"`
+	Reason string `json:"reason,omitempty"`
+	// Texts 脱敏后的文本，与输入一一对应，仅 redact 时有效
+	Texts []string `json:"-"`
+}
+
+// Result 过滤器链的审核结果
+type Result struct {
+	// Policy 使用的策略
+	Policy string
+	// Action 最终动作：block 或 allow 时后续过滤器不再执行，redact 表示文本被改写，为空表示均未命中
+	Action string
+	// Texts 审核后的文本
+	Texts     []string
+	Decisions []*Decision
+}
+
+// Blocked 是否被拦截
+func (r *Result) Blocked() bool {
+	return r != nil && r.Action == ACTION_BLOCK
+}
+
+// Redacted 文本是否被改写
+func (r *Result) Redacted() bool {
+	if r == nil {
+		return false
+	}
+	for _, decision := range r.Decisions {
+		if decision.Action == ACTION_REDACT {
+			return true
+		}
+	}
+	return false
+}
+
+// Engine 按团队与模型选择审核策略
+type Engine struct {
+	config   *Config
+	policies []*policy
+}
+
+// policy 编译后的审核策略
+type policy struct {
+	*Policy
+	filters []*filter
+}
+
+// New 创建审核引擎，校验并编译所有策略的过滤器
+func New(cfg *Config, upstream Upstream) (*Engine, error) {
+	engine := &Engine{config: cfg}
+	for i, p := range cfg.Policies {
+		if p.Name == "" {
+			p.Name = fmt.Sprintf("policy_%d", i+1)
+		}
+		compiled := &policy{Policy: p}
+		for _, fc := range p.Filters {
+			f, err := newFilter(fc, cfg.Timeout, upstream)
+			if err != nil {
+				return nil, fmt.Errorf("policy %s: %w", p.Name, err)
+			}
+			compiled.filters = append(compiled.filters, f)
+		}
+		engine.policies = append(engine.policies, compiled)
+	}
+	return engine, nil
+}
+
+// StreamWindow 流式响应的审核窗口
+func (e *Engine) StreamWindow() int {
+	return e.config.StreamWindow
+}
+
+// Chain 返回团队与模型在指定阶段使用的过滤器链，没有适用的过滤器时返回 nil
+func (e *Engine) Chain(team string, model string, stage string) *Chain {
+	for _, p := range e.policies {
+		if !p.Match(team, model) {
+			continue
+		}
+		chain := &Chain{policy: p.Name}
+		for _, f := range p.filters {
+			if f.stage == stage || f.stage == STAGE_BOTH {
+				chain.filters = append(chain.filters, f)
+			}
+		}
+		if len(chain.filters) == 0 {
+			return nil
+		}
+		return chain
+	}
+	return nil
+}
+
+// Chain 依次执行的过滤器
+type Chain struct {
+	policy  string
+	filters []*filter
+}
+
+// Screen 依次执行过滤器：redact 改写文本后继续，allow 或 block 时停止；外部服务出错时按 fail_open 放行或拦截
+func (c *Chain) Screen(ctx context.Context, in *Input) *Result {
+	result := &Result{Policy: c.policy, Texts: in.Texts}
+	for _, f := range c.filters {
+		current := *in
+		current.Texts = result.Texts
+		decision := f.run(ctx, &current)
+		if decision == nil {
+			continue
+		}
+		result.Decisions = append(result.Decisions, decision)
+		if decision.Action == ACTION_REDACT {
+			result.Action = ACTION_REDACT
+			result.Texts = decision.Texts
+			continue
+		}
+		result.Action = decision.Action
+		return result
+	}
+	return result
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
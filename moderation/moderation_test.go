@@ -0,0 +1,309 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeUpstream 返回固定审核结果的上游
+type fakeUpstream struct {
+	response string
+	err      error
+	requests []string
+}
+
+func (f *fakeUpstream) Post(ctx context.Context, path string, body []byte) (io.ReadCloser, error) {
+	f.requests = append(f.requests, path+" "+string(body))
+	if f.err != nil {
+		return nil, f.err
+	}
+	return io.NopCloser(strings.NewReader(f.response)), nil
+}
+
+func newTestEngine(t *testing.T, upstream Upstream, policies ...*Policy) *Engine {
+	engine, err := New(&Config{Enabled: true, Policies: policies, Timeout: time.Second, StreamWindow: 10}, upstream)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	return engine
+}
+
+func TestPolicy_Match(t *testing.T) {
+	policy := &Policy{Teams: []string{"legal"}, Models: []string{"gpt-4o*"}}
+	if !policy.Match("legal", "gpt-4o-mini") {
+		t.Error("Expected policy to match")
+	}
+	if policy.Match("legal", "o3-mini") || policy.Match("", "gpt-4o") {
+		t.Error("Expected policy not to match other models or teams")
+	}
+	if !(&Policy{}).Match("", "any") {
+		t.Error("Expected empty policy to match all requests")
+	}
+}
+
+func TestChain_Screen(t *testing.T) {
+	engine := newTestEngine(t, nil, &Policy{Name: "default", Filters: []*FilterConfig{
+		{Type: TYPE_KEYWORDS, Name: "allowlist", Action: ACTION_ALLOW, Keywords: []string{"public release"}},
+		{Type: TYPE_REGEX, Name: "ids", Action: ACTION_REDACT, Patterns: []string{`EMP-\d+`}, Builtin: []string{"email"}},
+		{Type: TYPE_KEYWORDS, Name: "blocklist", Keywords: []string{"Project Falcon"}},
+		{Type: TYPE_KEYWORDS, Name: "output", Stage: STAGE_OUTPUT, Keywords: []string{"internal"}},
+	}})
+	chain := engine.Chain("", "gpt-4o", STAGE_INPUT)
+	if chain == nil || len(chain.filters) != 3 {
+		t.Fatalf("Expected 3 input filters, got %+v", chain)
+	}
+
+	result := chain.Screen(context.Background(), &Input{Stage: STAGE_INPUT, Texts: []string{"ask EMP-42", "mail bob@example.com"}})
+	if result.Action != ACTION_REDACT || result.Texts[0] != "ask [REDACTED:pattern_1]" || strings.Contains(result.Texts[1], "bob@") {
+		t.Errorf("Unexpected redaction: %+v", result)
+	}
+
+	result = chain.Screen(context.Background(), &Input{Stage: STAGE_INPUT, Texts: []string{"status of project falcon?"}})
+	if !result.Blocked() || result.Decisions[0].Filter != "blocklist" {
+		t.Errorf("Expected block by blocklist, got %+v", result)
+	}
+
+	result = chain.Screen(context.Background(), &Input{Stage: STAGE_INPUT, Texts: []string{"Project Falcon public release"}})
+	if result.Action != ACTION_ALLOW {
+		t.Errorf("Expected allowlist to stop the chain, got %+v", result)
+	}
+
+	if engine.Chain("", "gpt-4o", STAGE_OUTPUT) == nil {
+		t.Error("Expected output chain")
+	}
+}
+
+func TestNew_InvalidFilter(t *testing.T) {
+	invalid := []*FilterConfig{
+		{Type: "unknown"},
+		{Type: TYPE_KEYWORDS},
+		{Type: TYPE_REGEX, Patterns: []string{"("}},
+		{Type: TYPE_KEYWORDS, Keywords: []string{"a"}, Stage: "before"},
+		{Type: TYPE_OPENAI, Action: ACTION_REDACT},
+		{Type: TYPE_WEBHOOK, URL: "ftp://example.com"},
+	}
+	for _, fc := range invalid {
+		if _, err := New(&Config{Policies: []*Policy{{Filters: []*FilterConfig{fc}}}}, &fakeUpstream{}); err == nil {
+			t.Errorf("Expected error for filter %+v", fc)
+		}
+	}
+}
+
+func TestOpenAIFilter(t *testing.T) {
+	upstream := &fakeUpstream{response: `{"results":[{"flagged":true,"categories":{"violence":true,"harassment":false},"category_scores":{"violence":0.9,"harassment":0.4}}]}`}
+	engine := newTestEngine(t, upstream, &Policy{Filters: []*FilterConfig{{Type: TYPE_OPENAI}}})
+	result := engine.Chain("", "gpt-4o", STAGE_INPUT).Screen(context.Background(), &Input{Stage: STAGE_INPUT, Texts: []string{"text", " "}})
+	if !result.Blocked() || result.Decisions[0].Reason != "flagged violence" {
+		t.Errorf("Unexpected result: %+v", result.Decisions)
+	}
+	if len(upstream.requests) != 1 || !strings.Contains(upstream.requests[0], `"input":["text"]`) || !strings.Contains(upstream.requests[0], defaultModerationModel) {
+		t.Errorf("Unexpected moderation request: %v", upstream.requests)
+	}
+
+	// 指定类别及阈值时按分数判断
+	engine = newTestEngine(t, upstream, &Policy{Filters: []*FilterConfig{{Type: TYPE_OPENAI, Categories: []string{"harassment"}, Threshold: 0.3}}})
+	result = engine.Chain("", "gpt-4o", STAGE_INPUT).Screen(context.Background(), &Input{Texts: []string{"text"}})
+	if !result.Blocked() || result.Decisions[0].Reason != "flagged harassment" {
+		t.Errorf("Unexpected result: %+v", result.Decisions)
+	}
+
+	upstream.err = errors.New("unavailable")
+	result = engine.Chain("", "gpt-4o", STAGE_INPUT).Screen(context.Background(), &Input{Texts: []string{"text"}})
+	if !result.Blocked() {
+		t.Errorf("Expected filter error to block by default, got %+v", result)
+	}
+}
+
+func TestWebhookFilter(t *testing.T) {
+	var received Input
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&received)
+		_, _ = w.Write([]byte(`{"action":"redact","reason":"pii","texts":["[name]"]}`))
+	}))
+	defer server.Close()
+
+	engine := newTestEngine(t, nil, &Policy{Filters: []*FilterConfig{
+		{Type: TYPE_WEBHOOK, URL: server.URL, Headers: map[string]string{"Authorization": "Bearer token"}},
+		{Type: TYPE_WEBHOOK, Name: "broken", URL: server.URL, FailOpen: true},
+	}})
+	result := engine.Chain("legal", "gpt-4o", STAGE_INPUT).Screen(context.Background(),
+		&Input{Stage: STAGE_INPUT, RequestID: "req-1", Team: "legal", Model: "gpt-4o", Texts: []string{"Alice"}})
+	if result.Action != ACTION_REDACT || result.Texts[0] != "[name]" || len(result.Decisions) != 1 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if received.RequestID != "req-1" || received.Team != "legal" || received.Texts[0] != "Alice" {
+		t.Errorf("Unexpected webhook input: %+v", received)
+	}
+}
+
+func TestParseRequest(t *testing.T) {
+	body := `{"model":"gpt-4o","temperature":0.70,"messages":[{"role":"system","content":"be nice"},` +
+		`{"role":"user","content":[{"type":"text","text":"hello <b>"},{"type":"image_url","image_url":{"url":"https://x"}}]}]}`
+	doc, err := ParseRequest("chat/completions", []byte(body))
+	if err != nil {
+		t.Fatalf("Failed to parse request: %v", err)
+	}
+	if texts := doc.Texts(); len(texts) != 2 || texts[1] != "hello <b>" {
+		t.Fatalf("Unexpected texts: %v", texts)
+	}
+	if !doc.Replace([]string{"be nice", "hi <b>"}) {
+		t.Fatal("Expected document to change")
+	}
+	data, _ := doc.Marshal()
+	if !strings.Contains(string(data), `"text":"hi <b>"`) || !strings.Contains(string(data), `"temperature":0.70`) {
+		t.Errorf("Unexpected body: %s", data)
+	}
+
+	doc, _ = ParseRequest("responses", []byte(`{"instructions":"rules","input":[{"role":"user","content":[{"type":"input_text","text":"question"}]}]}`))
+	if texts := doc.Texts(); len(texts) != 2 || texts[1] != "question" {
+		t.Errorf("Unexpected responses texts: %v", texts)
+	}
+	doc, _ = ParseRequest("embeddings", []byte(`{"input":["a","b",[1,2]]}`))
+	if texts := doc.Texts(); len(texts) != 2 {
+		t.Errorf("Unexpected embeddings texts: %v", texts)
+	}
+	if doc, _ := ParseRequest("images/generations", []byte(`{}`)); doc != nil {
+		t.Error("Expected unsupported route to return nil")
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	doc, err := ParseResponse("responses", []byte(`{"output":[{"type":"reasoning","summary":[]},{"type":"message","content":[{"type":"output_text","text":"answer"}]}]}`))
+	if err != nil || len(doc.Texts()) != 1 || doc.Texts()[0] != "answer" {
+		t.Errorf("Unexpected response texts: %v, %v", doc.Texts(), err)
+	}
+}
+
+func chatChunk(index int, content string) string {
+	data, _ := json.Marshal(map[string]any{"choices": []any{map[string]any{"index": index, "delta": map[string]any{"content": content}}}})
+	return "data: " + string(data) + "\n\n"
+}
+
+func TestStream_Redact(t *testing.T) {
+	engine := newTestEngine(t, nil, &Policy{Filters: []*FilterConfig{
+		{Type: TYPE_KEYWORDS, Stage: STAGE_OUTPUT, Action: ACTION_REDACT, Keywords: []string{"secret code"}},
+	}})
+	var out bytes.Buffer
+	stream := NewStream(context.Background(), engine.Chain("", "gpt-4o", STAGE_OUTPUT), Input{}, "chat/completions", 10, &out)
+	var results []*Result
+	stream.OnResult = func(result *Result) { results = append(results, result) }
+
+	// 关键词跨越多个分片，且事件在分片之间被切分
+	input := chatChunk(0, "The sec") + chatChunk(0, "ret code is 1. ") + chatChunk(0, "Done") + "data: [DONE]\n\n"
+	for i := 0; i < len(input); i += 7 {
+		_, _ = stream.Write([]byte(input[i:min(i+7, len(input))]))
+	}
+	_ = stream.Close()
+
+	text := collectChat(t, out.String())
+	if text != "The [REDACTED:pattern_1] is 1. Done" {
+		t.Errorf("Unexpected output: %q", text)
+	}
+	if !strings.HasSuffix(out.String(), "data: [DONE]\n\n") || len(results) != 1 {
+		t.Errorf("Unexpected stream: %q, results %d", out.String(), len(results))
+	}
+}
+
+func TestStream_Block(t *testing.T) {
+	engine := newTestEngine(t, nil, &Policy{Filters: []*FilterConfig{
+		{Type: TYPE_KEYWORDS, Stage: STAGE_OUTPUT, Keywords: []string{"forbidden"}},
+	}})
+	var out bytes.Buffer
+	stream := NewStream(context.Background(), engine.Chain("", "gpt-4o", STAGE_OUTPUT), Input{}, "chat/completions", 10, &out)
+
+	_, _ = stream.Write([]byte(chatChunk(0, "First part is fine. ")))
+	_, _ = stream.Write([]byte(chatChunk(0, "Then a forbidden ") + chatChunk(0, "word. ")))
+	_, _ = stream.Write([]byte(chatChunk(0, "More text") + "data: [DONE]\n\n"))
+	_ = stream.Close()
+
+	if !stream.Blocked() || !strings.Contains(out.String(), `"code":"content_filtered"`) {
+		t.Fatalf("Expected stream to be blocked: %q", out.String())
+	}
+	if strings.Contains(out.String(), "forbidden") || strings.Contains(out.String(), "More text") {
+		t.Errorf("Expected blocked output to be dropped: %q", out.String())
+	}
+	if !strings.Contains(out.String(), "First part is fine.") {
+		t.Errorf("Expected screened output before the block to be sent: %q", out.String())
+	}
+}
+
+func TestStream_ResponsesSnapshot(t *testing.T) {
+	engine := newTestEngine(t, nil, &Policy{Filters: []*FilterConfig{
+		{Type: TYPE_KEYWORDS, Stage: STAGE_OUTPUT, Action: ACTION_REDACT, Keywords: []string{"secret"}},
+	}})
+	var out bytes.Buffer
+	stream := NewStream(context.Background(), engine.Chain("", "gpt-4o", STAGE_OUTPUT), Input{}, "responses", 100, &out)
+	_, _ = stream.Write([]byte("event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"output_index\":0,\"content_index\":0,\"delta\":\"a secret\"}\n\n"))
+	_, _ = stream.Write([]byte("event: response.output_text.done\ndata: {\"type\":\"response.output_text.done\",\"text\":\"a secret\"}\n\n"))
+	_ = stream.Close()
+
+	if strings.Contains(out.String(), "secret") || strings.Count(out.String(), "[REDACTED:pattern_1]") != 2 {
+		t.Errorf("Expected deltas and full text to be redacted: %q", out.String())
+	}
+	if !strings.Contains(out.String(), "event: response.output_text.done\n") {
+		t.Errorf("Expected event lines to be kept: %q", out.String())
+	}
+}
+
+// collectChat 拼接 chat/completions 流中的增量文本
+func collectChat(t *testing.T, stream string) string {
+	var text strings.Builder
+	for _, line := range strings.Split(stream, "\n") {
+		payload, ok := strings.CutPrefix(line, "data: ")
+		if !ok || payload == "[DONE]" {
+			continue
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+			t.Fatalf("Invalid chunk %q: %v", payload, err)
+		}
+		for _, choice := range chunk.Choices {
+			text.WriteString(choice.Delta.Content)
+		}
+	}
+	return text.String()
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	t.Setenv("MODERATION_POLICIES", "")
+	t.Setenv("MODERATION_POLICIES_FILE", "")
+	cfg, err := LoadConfigFromEnv()
+	if err != nil || cfg.Enabled {
+		t.Errorf("Expected moderation to be disabled by default: %+v, %v", cfg, err)
+	}
+
+	file := filepath.Join(t.TempDir(), "policies.json")
+	_ = os.WriteFile(file, []byte(`[{"name":"legal","teams":["legal"],"filters":[{"type":"keywords","keywords":["x"]}]}]`), 0o600)
+	t.Setenv("MODERATION_POLICIES_FILE", file)
+	t.Setenv("MODERATION_STREAM_WINDOW", "50")
+	cfg, err = LoadConfigFromEnv()
+	if err != nil || !cfg.Enabled || cfg.Policies[0].Name != "legal" || cfg.StreamWindow != 50 {
+		t.Errorf("Unexpected config: %+v, %v", cfg, err)
+	}
+
+	t.Setenv("MODERATION_POLICIES_FILE", "")
+	t.Setenv("MODERATION_POLICIES", "{")
+	if _, err := LoadConfigFromEnv(); err == nil {
+		t.Error("Expected error for invalid MODERATION_POLICIES")
+	}
+}
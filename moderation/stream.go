@@ -0,0 +1,343 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"openai-forward/apierror"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxEventBytes 单个 SSE 事件的大小上限，超出时视为非 SSE 内容直接写出
+const maxEventBytes = 1 << 20
+
+// blockedError 流式输出被拦截时发送给客户端的错误
+var blockedError = apierror.New(http.StatusBadRequest, "content_filtered", "The response was blocked by the content policy")
+
+// deltaRef 流式事件中的增量文本，key 区分不同的输出（如 choices 的 index）
+type deltaRef struct {
+	textRef
+	key   string
+	event *streamEvent
+}
+
+// streamEvent SSE 流中的一个事件
+type streamEvent struct {
+	raw   []byte
+	lines []string
+	// dataLine data 行的序号，没有唯一的 data 行时为 -1
+	dataLine int
+	data     map[string]any
+	done     bool
+	deltas   []*deltaRef
+	// snapshot 包含完整输出文本的事件，如 Responses API 的 response.output_text.done、response.completed
+	snapshot *Document
+	modified bool
+}
+
+// parseEvent 解析 SSE 事件并提取输出文本，无法解析的事件原样写出
+func parseEvent(route string, raw []byte) *streamEvent {
+	ev := &streamEvent{raw: raw, dataLine: -1}
+	ev.lines = strings.Split(strings.TrimRight(string(raw), "\r\n"), "\n")
+	for i, line := range ev.lines {
+		ev.lines[i] = strings.TrimSuffix(line, "\r")
+		if strings.HasPrefix(ev.lines[i], "data:") {
+			if ev.dataLine >= 0 {
+				ev.dataLine = -1
+				return ev
+			}
+			ev.dataLine = i
+		}
+	}
+	if ev.dataLine < 0 {
+		return ev
+	}
+
+	payload := strings.TrimSpace(strings.TrimPrefix(ev.lines[ev.dataLine], "data:"))
+	if payload == "[DONE]" {
+		ev.done = true
+		return ev
+	}
+	data, err := decode([]byte(payload))
+	if err != nil {
+		return ev
+	}
+	ev.data = data
+
+	switch route {
+	case "chat/completions", "completions":
+		for _, choice := range objects(data["choices"]) {
+			key := fmt.Sprint("choice:", choice["index"])
+			if delta, ok := choice["delta"].(map[string]any); ok {
+				ev.delta(delta, "content", key)
+			} else {
+				ev.delta(choice, "text", key)
+			}
+		}
+	case "responses":
+		eventType, _ := data["type"].(string)
+		if eventType == "response.output_text.delta" {
+			ev.delta(data, "delta", fmt.Sprint(data["output_index"], ":", data["content_index"]))
+			break
+		}
+		doc := &Document{root: data}
+		if eventType == "response.output_text.done" {
+			doc.field(data, "text")
+		}
+		if part, ok := data["part"].(map[string]any); ok {
+			if partType, _ := part["type"].(string); textPartTypes[partType] {
+				doc.field(part, "text")
+			}
+		}
+		if item, ok := data["item"].(map[string]any); ok {
+			doc.content(item, "content")
+		}
+		if response, ok := data["response"].(map[string]any); ok {
+			doc.output(response)
+		}
+		if len(doc.refs) > 0 {
+			ev.snapshot = doc
+		}
+	}
+	return ev
+}
+
+// delta 记录事件中的增量文本
+func (ev *streamEvent) delta(obj map[string]any, field string, key string) {
+	value, ok := obj[field].(string)
+	if !ok || value == "" {
+		return
+	}
+	ref := &deltaRef{key: key, event: ev}
+	ref.value = value
+	ref.set = func(text string) {
+		obj[field] = text
+		ev.modified = true
+	}
+	ev.deltas = append(ev.deltas, ref)
+}
+
+// bytes 返回事件的原始内容，文本被改写时重新编码 data 行
+func (ev *streamEvent) bytes() []byte {
+	if !ev.modified {
+		return ev.raw
+	}
+	data, err := marshal(ev.data)
+	if err != nil {
+		return ev.raw
+	}
+	lines := append([]string{}, ev.lines...)
+	lines[ev.dataLine] = "data: " + string(data)
+	return []byte(strings.Join(lines, "\n") + "\n\n")
+}
+
+// Stream 增量审核 SSE 流式响应：缓存事件直到累计的输出文本达到审核窗口并在词句边界处，审核通过后再写出。
+// 被拦截时发送错误事件并丢弃后续输出；跨窗口的匹配无法脱敏，需要完整匹配的规则应使用 block。
+type Stream struct {
+	ctx    context.Context
+	chain  *Chain
+	input  Input
+	route  string
+	window int
+	out    io.Writer
+	// OnResult 每次审核有过滤器命中时调用，用于记录审核决定
+	OnResult func(*Result)
+
+	buf      []byte
+	held     []*streamEvent
+	pending  int
+	blocked  bool
+	screened map[string]*Result
+}
+
+// NewStream 创建流式响应审核，审核后的事件写入 out，out 实现 Flush 时每次写出后刷新
+func NewStream(ctx context.Context, chain *Chain, input Input, route string, window int, out io.Writer) *Stream {
+	input.Stage = STAGE_OUTPUT
+	return &Stream{ctx: ctx, chain: chain, input: input, route: route, window: window, out: out,
+		screened: map[string]*Result{}}
+}
+
+// Blocked 输出是否已被拦截
+func (s *Stream) Blocked() bool {
+	return s.blocked
+}
+
+// Write 接收上游的响应分片，按事件处理
+func (s *Stream) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+	for {
+		end := eventEnd(s.buf)
+		if end < 0 {
+			break
+		}
+		raw := append([]byte(nil), s.buf[:end]...)
+		s.buf = s.buf[end:]
+		s.handle(parseEvent(s.route, raw))
+	}
+	if len(s.buf) > maxEventBytes {
+		s.screenHeld()
+		s.write(s.buf)
+		s.buf = nil
+	}
+	s.flush()
+	return len(p), nil
+}
+
+// Close 审核并写出剩余的事件
+func (s *Stream) Close() error {
+	s.screenHeld()
+	if len(s.buf) > 0 {
+		s.write(s.buf)
+		s.buf = nil
+	}
+	s.flush()
+	return nil
+}
+
+// handle 处理一个事件：增量文本缓存至窗口审核，包含完整文本的事件单独审核，其他事件按顺序写出
+func (s *Stream) handle(ev *streamEvent) {
+	if s.blocked {
+		return
+	}
+	switch {
+	case ev.done:
+		s.screenHeld()
+		s.write(ev.bytes())
+	case ev.snapshot != nil:
+		s.screenHeld()
+		texts := ev.snapshot.Texts()
+		result := s.screen(texts)
+		if result.Blocked() {
+			s.block()
+			return
+		}
+		if result != nil && result.Redacted() {
+			ev.modified = ev.snapshot.Replace(result.Texts)
+		}
+		s.write(ev.bytes())
+	case len(ev.deltas) > 0:
+		s.held = append(s.held, ev)
+		for _, delta := range ev.deltas {
+			s.pending += utf8.RuneCountInString(delta.value)
+		}
+		last := ev.deltas[len(ev.deltas)-1].value
+		if s.pending >= 2*s.window || (s.pending >= s.window && boundary(last)) {
+			s.screenHeld()
+		}
+	case len(s.held) > 0:
+		s.held = append(s.held, ev)
+	default:
+		s.write(ev.bytes())
+	}
+}
+
+// screenHeld 审核缓存的增量文本（每个输出合并为一段），通过后写出缓存的事件
+func (s *Stream) screenHeld() {
+	if len(s.held) == 0 || s.blocked {
+		return
+	}
+	held := s.held
+	s.held, s.pending = nil, 0
+
+	keys := []string{}
+	refs := map[string][]*deltaRef{}
+	for _, ev := range held {
+		for _, delta := range ev.deltas {
+			if _, ok := refs[delta.key]; !ok {
+				keys = append(keys, delta.key)
+			}
+			refs[delta.key] = append(refs[delta.key], delta)
+		}
+	}
+	texts := make([]string, len(keys))
+	for i, key := range keys {
+		var builder strings.Builder
+		for _, delta := range refs[key] {
+			builder.WriteString(delta.value)
+		}
+		texts[i] = builder.String()
+	}
+
+	result := s.screen(texts)
+	if result.Blocked() {
+		s.block()
+		return
+	}
+	if result != nil && result.Redacted() {
+		// 脱敏后的文本写入该输出的第一个分片，其余分片置空
+		for i, key := range keys {
+			if result.Texts[i] == texts[i] {
+				continue
+			}
+			for j, delta := range refs[key] {
+				if j == 0 {
+					delta.set(result.Texts[i])
+				} else {
+					delta.set("")
+				}
+			}
+		}
+	}
+	for _, ev := range held {
+		s.write(ev.bytes())
+	}
+}
+
+// screen 审核文本，相同的文本（如 Responses API 多个事件中重复的完整输出）只审核一次
+func (s *Stream) screen(texts []string) *Result {
+	if strings.TrimSpace(strings.Join(texts, "")) == "" {
+		return nil
+	}
+	cacheKey := strings.Join(texts, "\x00")
+	if result, ok := s.screened[cacheKey]; ok {
+		return result
+	}
+	input := s.input
+	input.Texts = texts
+	result := s.chain.Screen(s.ctx, &input)
+	s.screened[cacheKey] = result
+	if len(result.Decisions) > 0 && s.OnResult != nil {
+		s.OnResult(result)
+	}
+	return result
+}
+
+// block 拦截后续输出并发送错误事件
+func (s *Stream) block() {
+	s.blocked = true
+	s.held, s.buf = nil, nil
+	data, _ := marshal(blockedError)
+	s.write([]byte("data: " + string(data) + "\n\ndata: [DONE]\n\n"))
+}
+
+func (s *Stream) write(p []byte) {
+	_, _ = s.out.Write(p)
+}
+
+func (s *Stream) flush() {
+	if flusher, ok := s.out.(interface{ Flush() }); ok {
+		flusher.Flush()
+	}
+}
+
+// eventEnd 返回缓冲区中第一个完整事件的结束位置（包含空行），没有完整事件时返回 -1
+func eventEnd(buf []byte) int {
+	end := -1
+	if i := bytes.Index(buf, []byte("\n\n")); i >= 0 {
+		end = i + 2
+	}
+	if i := bytes.Index(buf, []byte("\r\n\r\n")); i >= 0 && (end < 0 || i+4 < end) {
+		end = i + 4
+	}
+	return end
+}
+
+// boundary 文本是否在空白或标点处结束，避免在单词中间切分审核窗口
+func boundary(text string) bool {
+	r, _ := utf8.DecodeLastRuneInString(text)
+	return unicode.IsSpace(r) || unicode.IsPunct(r)
+}
@@ -0,0 +1,180 @@
+package moderation
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// textPartTypes 内容分片中包含文本的类型
+var textPartTypes = map[string]bool{"text": true, "input_text": true, "output_text": true}
+
+// textRef 文档中一个文本字段的位置
+type textRef struct {
+	value string
+	set   func(string)
+}
+
+// Document 解析后的请求或响应 JSON，记录需要审核的文本字段以便写回
+type Document struct {
+	root map[string]any
+	refs []*textRef
+}
+
+// decode 解析 JSON 对象，保留数字的原始表示
+func decode(body []byte) (map[string]any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var root map[string]any
+	if err := decoder.Decode(&root); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// ParseRequest 解析请求体中的提示词：chat/completions 的 messages、completions 的 prompt、
+// embeddings 的 input 及 responses 的 input 与 instructions，不支持的接口返回 nil
+func ParseRequest(route string, body []byte) (*Document, error) {
+	root, err := decode(body)
+	if err != nil {
+		return nil, err
+	}
+	doc := &Document{root: root}
+	switch route {
+	case "chat/completions":
+		for _, message := range objects(root["messages"]) {
+			doc.content(message, "content")
+		}
+	case "completions":
+		doc.strings(root, "prompt")
+	case "embeddings":
+		doc.strings(root, "input")
+	case "responses":
+		doc.field(root, "instructions")
+		if _, ok := root["input"].(string); ok {
+			doc.field(root, "input")
+		}
+		for _, item := range objects(root["input"]) {
+			doc.content(item, "content")
+		}
+	default:
+		return nil, nil
+	}
+	return doc, nil
+}
+
+// ParseResponse 解析非流式响应中模型的输出，不支持的接口返回 nil
+func ParseResponse(route string, body []byte) (*Document, error) {
+	root, err := decode(body)
+	if err != nil {
+		return nil, err
+	}
+	doc := &Document{root: root}
+	switch route {
+	case "chat/completions":
+		for _, choice := range objects(root["choices"]) {
+			if message, ok := choice["message"].(map[string]any); ok {
+				doc.content(message, "content")
+			}
+		}
+	case "completions":
+		for _, choice := range objects(root["choices"]) {
+			doc.field(choice, "text")
+		}
+	case "responses":
+		doc.output(root)
+	default:
+		return nil, nil
+	}
+	return doc, nil
+}
+
+// Texts 文档中待审核的文本
+func (d *Document) Texts() []string {
+	texts := make([]string, len(d.refs))
+	for i, ref := range d.refs {
+		texts[i] = ref.value
+	}
+	return texts
+}
+
+// Replace 写回审核后的文本，返回是否有文本被改写
+func (d *Document) Replace(texts []string) bool {
+	changed := false
+	for i, ref := range d.refs {
+		if i < len(texts) && texts[i] != ref.value {
+			ref.value = texts[i]
+			ref.set(texts[i])
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Marshal 重新编码文档
+func (d *Document) Marshal() ([]byte, error) {
+	return marshal(d.root)
+}
+
+// marshal 编码 JSON，不转义 HTML 字符且不带末尾换行
+func marshal(value any) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// field 记录对象中的字符串字段
+func (d *Document) field(obj map[string]any, key string) {
+	if value, ok := obj[key].(string); ok {
+		d.refs = append(d.refs, &textRef{value: value, set: func(text string) { obj[key] = text }})
+	}
+}
+
+// strings 记录字符串或字符串数组字段，忽略 token 数组
+func (d *Document) strings(obj map[string]any, key string) {
+	switch value := obj[key].(type) {
+	case string:
+		d.field(obj, key)
+	case []any:
+		for i, item := range value {
+			if text, ok := item.(string); ok {
+				d.refs = append(d.refs, &textRef{value: text, set: func(text string) { value[i] = text }})
+			}
+		}
+	}
+}
+
+// content 记录消息内容：字符串，或包含文本分片的数组（忽略图片、音频等分片）
+func (d *Document) content(obj map[string]any, key string) {
+	if _, ok := obj[key].(string); ok {
+		d.field(obj, key)
+		return
+	}
+	for _, part := range objects(obj[key]) {
+		if partType, _ := part["type"].(string); textPartTypes[partType] {
+			d.field(part, "text")
+		}
+	}
+}
+
+// output 记录 Responses API 响应中输出消息的文本
+func (d *Document) output(response map[string]any) {
+	for _, item := range objects(response["output"]) {
+		d.content(item, "content")
+	}
+}
+
+// objects 返回数组中的对象元素
+func objects(value any) []map[string]any {
+	items, _ := value.([]any)
+	result := make([]map[string]any, 0, len(items))
+	for _, item := range items {
+		if obj, ok := item.(map[string]any); ok {
+			result = append(result, obj)
+		}
+	}
+	return result
+}
@@ -0,0 +1,96 @@
+package notify
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore 进程内发件箱，未配置数据库时使用，重启后未发送的通知会丢失
+type MemoryStore struct {
+	mutex      sync.Mutex
+	deliveries map[string]*Delivery
+}
+
+// NewMemoryStore 创建进程内发件箱
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{deliveries: map[string]*Delivery{}}
+}
+
+// SaveDeliveries 实现 Store
+func (s *MemoryStore) SaveDeliveries(deliveries []*Delivery) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, delivery := range deliveries {
+		stored := *delivery
+		s.deliveries[delivery.ID] = &stored
+	}
+	return nil
+}
+
+// ClaimDeliveries 实现 Store
+func (s *MemoryStore) ClaimDeliveries(now time.Time, leaseUntil time.Time, limit int) ([]*Delivery, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	due := []*Delivery{}
+	for _, delivery := range s.deliveries {
+		if delivery.Status == STATUS_PENDING && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].CreatedAt.Before(due[j].CreatedAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	claimed := make([]*Delivery, len(due))
+	for i, delivery := range due {
+		delivery.NextAttemptAt = leaseUntil
+		copied := *delivery
+		claimed[i] = &copied
+	}
+	return claimed, nil
+}
+
+// UpdateDelivery 实现 Store
+func (s *MemoryStore) UpdateDelivery(delivery *Delivery) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stored := *delivery
+	s.deliveries[delivery.ID] = &stored
+	return nil
+}
+
+// ListDeliveries 实现 Store，按创建时间倒序返回
+func (s *MemoryStore) ListDeliveries(filter *DeliveryFilter) ([]*Delivery, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := []*Delivery{}
+	for _, delivery := range s.deliveries {
+		if (filter.Status != "" && delivery.Status != filter.Status) ||
+			(filter.EventType != "" && delivery.Event.Type != filter.EventType) ||
+			(filter.Target != "" && delivery.Target != filter.Target) {
+			continue
+		}
+		copied := *delivery
+		result = append(result, &copied)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.After(result[j].CreatedAt) })
+	if filter.Limit > 0 && len(result) > filter.Limit {
+		result = result[:filter.Limit]
+	}
+	return result, nil
+}
+
+// DeleteDeliveriesBefore 实现 Store
+func (s *MemoryStore) DeleteDeliveriesBefore(before time.Time) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var deleted int64
+	for id, delivery := range s.deliveries {
+		if delivery.Status != STATUS_PENDING && delivery.CreatedAt.Before(before) {
+			delete(s.deliveries, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,296 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"openai-forward/logging"
+	"openai-forward/metrics"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// claimBatchSize 每轮领取的通知数
+const claimBatchSize = 50
+
+// maxErrorLength 记录的发送错误长度上限
+const maxErrorLength = 512
+
+// ErrUnknownTarget 指定的通知目标不存在
+var ErrUnknownTarget = errors.New("unknown notification target")
+
+// Notifier 将事件按类型路由到通知目标，写入发件箱后由后台发送，失败时按指数退避重试
+type Notifier struct {
+	config *Config
+	store  Store
+	client *http.Client
+	wake   chan struct{}
+	// now 当前时间，测试时可替换
+	now func() time.Time
+
+	mutex  sync.Mutex
+	recent map[string]time.Time
+}
+
+// New 创建通知器
+func New(cfg *Config, store Store) *Notifier {
+	return &Notifier{
+		config: cfg,
+		store:  store,
+		client: &http.Client{Timeout: cfg.Timeout},
+		wake:   make(chan struct{}, 1),
+		now:    time.Now,
+		recent: map[string]time.Time{},
+	}
+}
+
+// Config 返回通知配置
+func (n *Notifier) Config() *Config {
+	return n.config
+}
+
+// Store 返回发件箱
+func (n *Notifier) Store() Store {
+	return n.store
+}
+
+// Notify 发送事件，未启用时忽略；冷却时间内的重复事件只发送一次。写入发件箱失败时只记录日志，不影响调用方
+func (n *Notifier) Notify(event *Event) {
+	if n == nil {
+		return
+	}
+	if event.Key != "" && n.duplicate(event.Type+"\x00"+event.Key) {
+		return
+	}
+	if _, err := n.Enqueue(event, ""); err != nil {
+		logging.Logger.Errorf("Failed to enqueue %s notification: %v", event.Type, err)
+	}
+}
+
+// Enqueue 为接收该事件的每个目标（或指定的目标）写入发件箱并唤醒发送，返回新建的通知
+func (n *Notifier) Enqueue(event *Event, target string) ([]*Delivery, error) {
+	now := n.now()
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+	if event.Time.IsZero() {
+		event.Time = now
+	}
+	if event.Severity == "" {
+		event.Severity = defaultSeverities[event.Type]
+	}
+	if event.Severity == "" {
+		event.Severity = SEVERITY_INFO
+	}
+
+	deliveries := []*Delivery{}
+	for _, t := range n.config.Targets {
+		if target != "" && t.Name != target {
+			continue
+		}
+		if target == "" && event.Type != EVENT_TEST && !t.Match(event.Type) {
+			continue
+		}
+		deliveries = append(deliveries, &Delivery{
+			ID:            uuid.New().String(),
+			Target:        t.Name,
+			Event:         event,
+			Status:        STATUS_PENDING,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		})
+	}
+	if target != "" && len(deliveries) == 0 {
+		return nil, ErrUnknownTarget
+	}
+	if len(deliveries) == 0 {
+		return deliveries, nil
+	}
+	if err := n.store.SaveDeliveries(deliveries); err != nil {
+		return nil, err
+	}
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+	return deliveries, nil
+}
+
+// duplicate 判断事件是否在冷却时间内已发送过，并记录本次发送
+func (n *Notifier) duplicate(key string) bool {
+	if n.config.Cooldown <= 0 {
+		return false
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	now := n.now()
+	if last, ok := n.recent[key]; ok && now.Sub(last) < n.config.Cooldown {
+		return true
+	}
+	if len(n.recent) > 1000 {
+		for k, last := range n.recent {
+			if now.Sub(last) >= n.config.Cooldown {
+				delete(n.recent, k)
+			}
+		}
+	}
+	n.recent[key] = now
+	return false
+}
+
+// Run 发送发件箱中到期的通知，并定期清理超出保留期的通知，ctx 取消时返回
+func (n *Notifier) Run(ctx context.Context) {
+	ticker := time.NewTicker(n.config.PollInterval)
+	defer ticker.Stop()
+	lastPurge := time.Time{}
+
+	for {
+		n.DeliverPending(ctx)
+		if time.Since(lastPurge) > time.Hour {
+			lastPurge = time.Now()
+			deleted, err := n.store.DeleteDeliveriesBefore(n.now().Add(-n.config.Retention))
+			if err != nil {
+				logging.Logger.Errorf("Failed to purge notifications: %v", err)
+			} else if deleted > 0 {
+				logging.Logger.Infof("Purged %d notifications", deleted)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-n.wake:
+		}
+	}
+}
+
+// DeliverPending 发送所有到期的通知，返回本轮发送的数量
+func (n *Notifier) DeliverPending(ctx context.Context) int {
+	sent := 0
+	for ctx.Err() == nil {
+		now := n.now()
+		// 领取后在发送超时前其他实例不会再次领取，实例退出时到期后由其他实例重试
+		deliveries, err := n.store.ClaimDeliveries(now, now.Add(2*n.config.Timeout+time.Minute), claimBatchSize)
+		if err != nil {
+			logging.Logger.Errorf("Failed to claim notifications: %v", err)
+			return sent
+		}
+		for _, delivery := range deliveries {
+			n.deliver(ctx, delivery)
+			sent++
+		}
+		if len(deliveries) < claimBatchSize {
+			return sent
+		}
+	}
+	return sent
+}
+
+// deliver 发送一个通知并保存结果：成功或不可重试的错误时结束，否则按退避时间重试
+func (n *Notifier) deliver(ctx context.Context, delivery *Delivery) {
+	target := n.config.Target(delivery.Target)
+	delivery.Attempts++
+	var status int
+	var err error
+	if target == nil {
+		// 目标已从配置中移除
+		err = ErrUnknownTarget
+	} else {
+		status, err = n.send(ctx, target, delivery)
+	}
+
+	now := n.now()
+	delivery.UpdatedAt = now
+	delivery.LastStatus = status
+	delivery.LastError = ""
+	result := STATUS_DELIVERED
+	switch {
+	case err == nil:
+		delivery.Status = STATUS_DELIVERED
+		delivery.DeliveredAt = &now
+	case retryable(status, err) && delivery.Attempts < n.config.MaxAttempts:
+		result = "retry"
+		delivery.LastError = truncate(err.Error())
+		delivery.NextAttemptAt = now.Add(n.backoff(delivery.Attempts))
+	default:
+		result = STATUS_FAILED
+		delivery.Status = STATUS_FAILED
+		delivery.LastError = truncate(err.Error())
+		logging.Logger.Errorf("Notification %s (%s) to %s failed after %d attempts: %v",
+			delivery.ID, delivery.Event.Type, delivery.Target, delivery.Attempts, err)
+	}
+	metrics.NotificationDeliveries.WithLabelValues(delivery.Target, delivery.Event.Type, result).Inc()
+
+	if err := n.store.UpdateDelivery(delivery); err != nil {
+		logging.Logger.Errorf("Failed to save notification %s: %v", delivery.ID, err)
+	}
+}
+
+// send 按目标格式生成请求体、签名并发送，返回响应状态码
+func (n *Notifier) send(ctx context.Context, target *Target, delivery *Delivery) (int, error) {
+	body, err := Render(target.Format, delivery.Event)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(n.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HEADER_ID, delivery.ID)
+	req.Header.Set(HEADER_EVENT, delivery.Event.Type)
+	req.Header.Set(HEADER_TIMESTAMP, timestamp)
+	if target.Secret != "" {
+		req.Header.Set(HEADER_SIGNATURE, Sign(target.Secret, timestamp, body))
+	}
+	for name, value := range target.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff 第 attempts 次失败后的等待时间，每次加倍且不超过 MaxBackoff
+func (n *Notifier) backoff(attempts int) time.Duration {
+	wait := n.config.Backoff
+	for i := 1; i < attempts && wait < n.config.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, n.config.MaxBackoff)
+}
+
+// retryable 网络错误、超时、限流及服务端错误可重试，其他 4xx 说明请求本身有误，不再重试
+func retryable(status int, err error) bool {
+	if errors.Is(err, ErrUnknownTarget) {
+		return false
+	}
+	if status == 0 {
+		return true
+	}
+	return status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+func truncate(message string) string {
+	if len(message) > maxErrorLength {
+		return message[:maxErrorLength]
+	}
+	return message
+}
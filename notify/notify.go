@@ -0,0 +1,260 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 事件类型
+const (
+	// EVENT_QUOTA_EXHAUSTED 上游返回 insufficient_quota，上游账号或团队密钥的额度已用完
+	EVENT_QUOTA_EXHAUSTED = "quota.exhausted"
+	// EVENT_DOMAIN_DENIED 用户邮箱域名不在 OIDC_ALLOWED_DOMAINS 中，登录或续期被拒绝
+	EVENT_DOMAIN_DENIED = "auth.domain_denied"
+	// EVENT_IP_DENIED 请求来源 IP 不在全局或密钥的允许列表中
+	EVENT_IP_DENIED = "auth.ip_denied"
+	// EVENT_DEPENDENCY_UNHEALTHY / EVENT_DEPENDENCY_RECOVERED 就绪检查中上游、存储或 OIDC 的状态变化
+	EVENT_DEPENDENCY_UNHEALTHY = "dependency.unhealthy"
+	EVENT_DEPENDENCY_RECOVERED = "dependency.recovered"
+	// EVENT_SENSITIVE_DATA 请求包含需拒绝的密钥或个人信息
+	EVENT_SENSITIVE_DATA = "security.sensitive_data"
+	// EVENT_CONTENT_BLOCKED 请求或输出被内容审核拦截
+	EVENT_CONTENT_BLOCKED = "security.content_blocked"
+	// EVENT_TEST 管理员发送的测试通知，发送给所有（或指定的）目标
+	EVENT_TEST = "notification.test"
+)
+
+// 事件级别
+const (
+	SEVERITY_INFO     = "info"
+	SEVERITY_WARNING  = "warning"
+	SEVERITY_CRITICAL = "critical"
+)
+
+// defaultSeverities 各事件类型的默认级别
+var defaultSeverities = map[string]string{
+	EVENT_QUOTA_EXHAUSTED:      SEVERITY_CRITICAL,
+	EVENT_DOMAIN_DENIED:        SEVERITY_WARNING,
+	EVENT_IP_DENIED:            SEVERITY_WARNING,
+	EVENT_DEPENDENCY_UNHEALTHY: SEVERITY_CRITICAL,
+	EVENT_DEPENDENCY_RECOVERED: SEVERITY_INFO,
+	EVENT_SENSITIVE_DATA:       SEVERITY_WARNING,
+	EVENT_CONTENT_BLOCKED:      SEVERITY_WARNING,
+	EVENT_TEST:                 SEVERITY_INFO,
+}
+
+// 通知格式
+const (
+	FORMAT_GENERIC = "generic"
+	FORMAT_SLACK   = "slack"
+	FORMAT_TEAMS   = "teams"
+)
+
+// Event 通知事件
+type Event struct {
+	ID       string    `json:"id"`
+	Type     string    `json:"type"`
+	Severity string    `json:"severity"`
+	Time     time.Time `json:"time"`
+	Summary  string    `json:"summary"`
+	// Attributes 事件相关的信息，如上游、团队、用户，不包含密钥等敏感内容
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Key 去重键，相同类型与键的事件在 Cooldown 内只通知一次，为空时不去重
+	Key string `json:"-"`
+}
+
+// Target webhook 通知目标
+type Target struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Format 请求体格式：generic（事件 JSON）、slack 或 teams
+	Format string `json:"format,omitempty"`
+	// Secret HMAC 签名密钥，为空时不签名
+	Secret string `json:"secret,omitempty"`
+	// Events 接收的事件类型，支持通配符（如 auth.*），为空时接收所有事件
+	Events []string `json:"events,omitempty"`
+	// Headers 附加请求头
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Match 判断目标是否接收该类型的事件
+func (t *Target) Match(eventType string) bool {
+	if len(t.Events) == 0 {
+		return true
+	}
+	for _, pattern := range t.Events {
+		if matched, _ := path.Match(pattern, eventType); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Config 通知配置
+type Config struct {
+	Enabled bool      `json:"enabled"`
+	Targets []*Target `json:"targets"`
+	// MaxAttempts 每个通知的最大发送次数
+	MaxAttempts int `json:"max_attempts"`
+	// Backoff / MaxBackoff 首次重试的等待时间及上限，每次失败后加倍
+	Backoff    time.Duration `json:"backoff"`
+	MaxBackoff time.Duration `json:"max_backoff"`
+	// Timeout 单次发送的超时时间
+	Timeout time.Duration `json:"timeout"`
+	// Cooldown 相同事件的去重时间
+	Cooldown time.Duration `json:"cooldown"`
+	// PollInterval 检查待发送通知的间隔
+	PollInterval time.Duration `json:"poll_interval"`
+	// Retention 已发送或最终失败的通知保留时长
+	Retention time.Duration `json:"retention"`
+	// HealthInterval 定期执行健康检查的间隔，依赖失败与恢复的通知不依赖外部探针，为 0 时不定期检查
+	HealthInterval time.Duration `json:"health_interval"`
+}
+
+// LoadConfigFromEnv 从环境变量加载通知配置，目标来自 NOTIFY_TARGETS_FILE 指定的文件或 NOTIFY_TARGETS
+func LoadConfigFromEnv() (*Config, error) {
+	cfg := &Config{
+		MaxAttempts:    8,
+		Backoff:        30 * time.Second,
+		MaxBackoff:     time.Hour,
+		Timeout:        10 * time.Second,
+		Cooldown:       10 * time.Minute,
+		PollInterval:   10 * time.Second,
+		Retention:      7 * 24 * time.Hour,
+		HealthInterval: time.Minute,
+	}
+	if attempts, err := strconv.Atoi(os.Getenv("NOTIFY_MAX_ATTEMPTS")); err == nil && attempts > 0 {
+		cfg.MaxAttempts = attempts
+	}
+	durations := map[string]*time.Duration{
+		"NOTIFY_BACKOFF":       &cfg.Backoff,
+		"NOTIFY_MAX_BACKOFF":   &cfg.MaxBackoff,
+		"NOTIFY_TIMEOUT":       &cfg.Timeout,
+		"NOTIFY_POLL_INTERVAL": &cfg.PollInterval,
+		"NOTIFY_RETENTION":     &cfg.Retention,
+	}
+	for name, target := range durations {
+		if d, err := time.ParseDuration(os.Getenv(name)); err == nil && d > 0 {
+			*target = d
+		}
+	}
+	if cooldown, err := time.ParseDuration(os.Getenv("NOTIFY_COOLDOWN")); err == nil && cooldown >= 0 {
+		cfg.Cooldown = cooldown
+	}
+	if interval, err := time.ParseDuration(os.Getenv("NOTIFY_HEALTH_INTERVAL")); err == nil && interval >= 0 {
+		cfg.HealthInterval = interval
+	}
+
+	targets := []byte(os.Getenv("NOTIFY_TARGETS"))
+	if file := os.Getenv("NOTIFY_TARGETS_FILE"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to read NOTIFY_TARGETS_FILE: %w", err)
+		}
+		targets = data
+	}
+	if len(targets) > 0 {
+		if err := json.Unmarshal(targets, &cfg.Targets); err != nil {
+			return cfg, fmt.Errorf("invalid notification targets: %w", err)
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		cfg.Targets = nil
+		return cfg, err
+	}
+	cfg.Enabled = len(cfg.Targets) > 0
+	return cfg, nil
+}
+
+// Validate 校验通知目标，并补全默认的名称与格式
+func (c *Config) Validate() error {
+	names := map[string]bool{}
+	for i, target := range c.Targets {
+		if target.Name == "" {
+			target.Name = fmt.Sprintf("target_%d", i+1)
+		}
+		if names[target.Name] {
+			return fmt.Errorf("duplicate notification target %q", target.Name)
+		}
+		names[target.Name] = true
+		if !strings.HasPrefix(target.URL, "http://") && !strings.HasPrefix(target.URL, "https://") {
+			return fmt.Errorf("notification target %s: invalid url %q", target.Name, target.URL)
+		}
+		if target.Format == "" {
+			target.Format = FORMAT_GENERIC
+		}
+		if target.Format != FORMAT_GENERIC && target.Format != FORMAT_SLACK && target.Format != FORMAT_TEAMS {
+			return fmt.Errorf("notification target %s: invalid format %q", target.Name, target.Format)
+		}
+	}
+	return nil
+}
+
+// String 返回通知配置的简要描述，不包含地址及签名密钥
+func (c *Config) String() string {
+	targets := make([]string, len(c.Targets))
+	for i, target := range c.Targets {
+		targets[i] = fmt.Sprintf("%s(%s)%v", target.Name, target.Format, target.Events)
+	}
+	return fmt.Sprintf("targets=%v max_attempts=%d backoff=%s cooldown=%s health_interval=%s", targets, c.MaxAttempts, c.Backoff, c.Cooldown, c.HealthInterval)
+}
+
+// Target 返回指定名称的目标，不存在时返回 nil
+func (c *Config) Target(name string) *Target {
+	for _, target := range c.Targets {
+		if target.Name == name {
+			return target
+		}
+	}
+	return nil
+}
+
+// 通知发送状态
+const (
+	STATUS_PENDING   = "pending"
+	STATUS_DELIVERED = "delivered"
+	STATUS_FAILED    = "failed"
+)
+
+// Delivery 发件箱中发往一个目标的通知
+type Delivery struct {
+	ID     string `json:"id"`
+	Target string `json:"target"`
+	Event  *Event `json:"event"`
+	Status string `json:"status"`
+	// Attempts 已发送次数
+	Attempts int `json:"attempts"`
+	// LastStatus / LastError 最近一次发送的响应状态码及错误
+	LastStatus    int        `json:"last_status,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+}
+
+// DeliveryFilter 发件箱查询条件，零值字段不参与过滤
+type DeliveryFilter struct {
+	Status    string
+	EventType string
+	Target    string
+	Limit     int
+}
+
+// Store 持久化的发件箱，多个实例共享
+type Store interface {
+	// SaveDeliveries 保存新的通知
+	SaveDeliveries(deliveries []*Delivery) error
+	// ClaimDeliveries 领取到期的待发送通知并将下次发送时间推迟到 leaseUntil，多个实例同时领取时仅有一个成功
+	ClaimDeliveries(now time.Time, leaseUntil time.Time, limit int) ([]*Delivery, error)
+	// UpdateDelivery 保存发送结果
+	UpdateDelivery(delivery *Delivery) error
+	ListDeliveries(filter *DeliveryFilter) ([]*Delivery, error)
+	// DeleteDeliveriesBefore 删除指定时间前创建且已结束的通知
+	DeleteDeliveriesBefore(before time.Time) (int64, error)
+}
@@ -0,0 +1,216 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver 本地 webhook 接收端，按顺序返回 statuses 中的状态码，用完后返回 200
+type receiver struct {
+	mutex    sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+	status := http.StatusOK
+	if len(rc.statuses) > 0 {
+		status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func newTestNotifier(targets ...*Target) (*Notifier, *time.Time) {
+	cfg := &Config{Enabled: true, Targets: targets, MaxAttempts: 3, Backoff: time.Second, MaxBackoff: 3 * time.Second,
+		Timeout: time.Second, Cooldown: time.Minute, PollInterval: time.Second, Retention: time.Hour}
+	_ = cfg.Validate()
+	n := New(cfg, NewMemoryStore())
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	n.now = func() time.Time { return now }
+	return n, &now
+}
+
+func TestNotifier_Deliver(t *testing.T) {
+	rc := &receiver{}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	n, _ := newTestNotifier(
+		&Target{Name: "security", URL: server.URL, Secret: "s3cret", Events: []string{"auth.*"}},
+		&Target{Name: "ops", URL: server.URL, Events: []string{EVENT_QUOTA_EXHAUSTED}},
+	)
+	n.Notify(&Event{Type: EVENT_IP_DENIED, Summary: "denied", Attributes: map[string]string{"client_ip": "10.0.0.1"}})
+	if sent := n.DeliverPending(context.Background()); sent != 1 || len(rc.requests) != 1 {
+		t.Fatalf("Expected 1 delivery, got %d", sent)
+	}
+
+	req, body := rc.requests[0], rc.bodies[0]
+	if req.Header.Get(HEADER_EVENT) != EVENT_IP_DENIED || req.Header.Get(HEADER_ID) == "" {
+		t.Errorf("Unexpected headers: %v", req.Header)
+	}
+	if !Verify("s3cret", req.Header.Get(HEADER_TIMESTAMP), body, req.Header.Get(HEADER_SIGNATURE)) {
+		t.Errorf("Invalid signature %s", req.Header.Get(HEADER_SIGNATURE))
+	}
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil || event.Severity != SEVERITY_WARNING || event.Attributes["client_ip"] != "10.0.0.1" {
+		t.Errorf("Unexpected event: %s", body)
+	}
+
+	deliveries, _ := n.store.ListDeliveries(&DeliveryFilter{})
+	if len(deliveries) != 1 || deliveries[0].Status != STATUS_DELIVERED || deliveries[0].Target != "security" {
+		t.Errorf("Unexpected deliveries: %+v", deliveries)
+	}
+}
+
+func TestNotifier_Retry(t *testing.T) {
+	rc := &receiver{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	n, now := newTestNotifier(&Target{Name: "ops", URL: server.URL})
+	n.Notify(&Event{Type: EVENT_QUOTA_EXHAUSTED, Summary: "quota"})
+	n.DeliverPending(context.Background())
+
+	deliveries, _ := n.store.ListDeliveries(&DeliveryFilter{})
+	if deliveries[0].Status != STATUS_PENDING || deliveries[0].Attempts != 1 || !deliveries[0].NextAttemptAt.Equal(now.Add(time.Second)) {
+		t.Fatalf("Expected retry after 1s: %+v", deliveries[0])
+	}
+
+	// 未到重试时间时不发送
+	if sent := n.DeliverPending(context.Background()); sent != 0 {
+		t.Errorf("Expected no delivery before backoff, got %d", sent)
+	}
+	*now = now.Add(time.Second)
+	n.DeliverPending(context.Background())
+	deliveries, _ = n.store.ListDeliveries(&DeliveryFilter{})
+	if !deliveries[0].NextAttemptAt.Equal(now.Add(2 * time.Second)) {
+		t.Fatalf("Expected backoff to double: %+v", deliveries[0])
+	}
+
+	*now = now.Add(2 * time.Second)
+	n.DeliverPending(context.Background())
+	deliveries, _ = n.store.ListDeliveries(&DeliveryFilter{})
+	if deliveries[0].Status != STATUS_DELIVERED || deliveries[0].Attempts != 3 || len(rc.requests) != 3 {
+		t.Errorf("Expected delivery on third attempt: %+v", deliveries[0])
+	}
+}
+
+func TestNotifier_Failure(t *testing.T) {
+	rc := &receiver{statuses: []int{http.StatusBadRequest, 500, 500, 500}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	n, now := newTestNotifier(&Target{Name: "ops", URL: server.URL})
+	n.Notify(&Event{Type: EVENT_QUOTA_EXHAUSTED})
+	n.DeliverPending(context.Background())
+	deliveries, _ := n.store.ListDeliveries(&DeliveryFilter{Status: STATUS_FAILED})
+	if len(deliveries) != 1 || deliveries[0].Attempts != 1 || deliveries[0].LastStatus != http.StatusBadRequest {
+		t.Fatalf("Expected client error not to be retried: %+v", deliveries)
+	}
+
+	n.Notify(&Event{Type: EVENT_DEPENDENCY_UNHEALTHY})
+	for i := 0; i < 3; i++ {
+		n.DeliverPending(context.Background())
+		*now = now.Add(time.Hour)
+	}
+	deliveries, _ = n.store.ListDeliveries(&DeliveryFilter{EventType: EVENT_DEPENDENCY_UNHEALTHY})
+	if deliveries[0].Status != STATUS_FAILED || deliveries[0].Attempts != 3 {
+		t.Errorf("Expected delivery to fail after max attempts: %+v", deliveries[0])
+	}
+
+	if deleted, _ := n.store.DeleteDeliveriesBefore(now.Add(time.Minute)); deleted != 2 {
+		t.Errorf("Expected finished deliveries to be purged, got %d", deleted)
+	}
+}
+
+func TestNotifier_CooldownAndTest(t *testing.T) {
+	n, now := newTestNotifier(&Target{Name: "ops", URL: "http://127.0.0.1:1", Events: []string{"quota.*"}})
+	n.Notify(&Event{Type: EVENT_QUOTA_EXHAUSTED, Key: "openai"})
+	n.Notify(&Event{Type: EVENT_QUOTA_EXHAUSTED, Key: "openai"})
+	n.Notify(&Event{Type: EVENT_QUOTA_EXHAUSTED, Key: "azure"})
+	*now = now.Add(time.Minute)
+	n.Notify(&Event{Type: EVENT_QUOTA_EXHAUSTED, Key: "openai"})
+	if deliveries, _ := n.store.ListDeliveries(&DeliveryFilter{}); len(deliveries) != 3 {
+		t.Errorf("Expected duplicate to be suppressed, got %d deliveries", len(deliveries))
+	}
+
+	// 测试通知不受事件路由限制
+	if deliveries, err := n.Enqueue(&Event{Type: EVENT_TEST}, "ops"); err != nil || len(deliveries) != 1 {
+		t.Errorf("Expected test notification to be enqueued: %v", err)
+	}
+	if _, err := n.Enqueue(&Event{Type: EVENT_TEST}, "unknown"); err != ErrUnknownTarget {
+		t.Errorf("Expected ErrUnknownTarget, got %v", err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	event := &Event{ID: "evt", Type: EVENT_QUOTA_EXHAUSTED, Severity: SEVERITY_CRITICAL, Time: time.Unix(1700000000, 0),
+		Summary: "Upstream openai quota exhausted", Attributes: map[string]string{"upstream": "openai", "team": "ml"}}
+
+	body, _ := Render(FORMAT_SLACK, event)
+	var slack struct {
+		Text        string `json:"text"`
+		Attachments []struct {
+			Color  string `json:"color"`
+			Fields []struct {
+				Title string `json:"title"`
+			} `json:"fields"`
+		} `json:"attachments"`
+	}
+	if err := json.Unmarshal(body, &slack); err != nil || slack.Text != "*[CRITICAL] quota.exhausted* Upstream openai quota exhausted" ||
+		slack.Attachments[0].Color != "#E01E5A" || slack.Attachments[0].Fields[0].Title != "team" {
+		t.Errorf("Unexpected slack payload: %s", body)
+	}
+
+	body, _ = Render(FORMAT_TEAMS, event)
+	var teams struct {
+		Type        string `json:"type"`
+		Attachments []struct {
+			ContentType string `json:"contentType"`
+			Content     struct {
+				Type string           `json:"type"`
+				Body []map[string]any `json:"body"`
+			} `json:"content"`
+		} `json:"attachments"`
+	}
+	if err := json.Unmarshal(body, &teams); err != nil || teams.Type != "message" ||
+		teams.Attachments[0].Content.Type != "AdaptiveCard" || len(teams.Attachments[0].Content.Body) != 3 {
+		t.Errorf("Unexpected teams payload: %s", body)
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	t.Setenv("NOTIFY_TARGETS", "")
+	cfg, err := LoadConfigFromEnv()
+	if err != nil || cfg.Enabled {
+		t.Errorf("Expected notifications to be disabled by default: %+v, %v", cfg, err)
+	}
+
+	t.Setenv("NOTIFY_TARGETS", `[{"url":"https://hooks.slack.com/services/x","format":"slack","events":["auth.*"]},{"name":"ops","url":"http://localhost:9000"}]`)
+	t.Setenv("NOTIFY_BACKOFF", "5s")
+	t.Setenv("NOTIFY_HEALTH_INTERVAL", "0")
+	cfg, err = LoadConfigFromEnv()
+	if err != nil || !cfg.Enabled || cfg.Targets[0].Name != "target_1" || cfg.Targets[1].Format != FORMAT_GENERIC || cfg.Backoff != 5*time.Second || cfg.HealthInterval != 0 {
+		t.Errorf("Unexpected config: %+v, %v", cfg, err)
+	}
+	if !cfg.Targets[0].Match(EVENT_DOMAIN_DENIED) || cfg.Targets[0].Match(EVENT_QUOTA_EXHAUSTED) || !cfg.Targets[1].Match(EVENT_QUOTA_EXHAUSTED) {
+		t.Error("Unexpected event routing")
+	}
+
+	t.Setenv("NOTIFY_TARGETS", `[{"url":"https://example.com","format":"discord"}]`)
+	if cfg, err := LoadConfigFromEnv(); err == nil || cfg.Enabled {
+		t.Error("Expected error for invalid format")
+	}
+}
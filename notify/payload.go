@@ -0,0 +1,117 @@
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// 发送通知时附带的请求头，接收方可据此验证签名并按通知 ID 去重
+const (
+	HEADER_ID        = "X-Webhook-Id"
+	HEADER_EVENT     = "X-Webhook-Event"
+	HEADER_TIMESTAMP = "X-Webhook-Timestamp"
+	HEADER_SIGNATURE = "X-Webhook-Signature"
+)
+
+// severityColors Slack 与 Teams 消息中各级别的颜色
+var severityColors = map[string]string{
+	SEVERITY_INFO:     "#2EB67D",
+	SEVERITY_WARNING:  "#ECB22E",
+	SEVERITY_CRITICAL: "#E01E5A",
+}
+
+// teamsColors Teams Adaptive Card 文本颜色
+var teamsColors = map[string]string{
+	SEVERITY_INFO:     "Good",
+	SEVERITY_WARNING:  "Warning",
+	SEVERITY_CRITICAL: "Attention",
+}
+
+// Sign 计算签名：对 "<timestamp>.<body>" 做 HMAC-SHA256，格式为 sha256=<hex>
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify 校验签名，供接收方使用
+func Verify(secret string, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Render 按目标格式生成请求体
+func Render(format string, event *Event) ([]byte, error) {
+	switch format {
+	case FORMAT_SLACK:
+		return json.Marshal(slackPayload(event))
+	case FORMAT_TEAMS:
+		return json.Marshal(teamsPayload(event))
+	default:
+		return json.Marshal(event)
+	}
+}
+
+// title 消息标题，如 [CRITICAL] quota.exhausted
+func title(event *Event) string {
+	return fmt.Sprintf("[%s] %s", strings.ToUpper(event.Severity), event.Type)
+}
+
+// attributeNames 按名称排序的事件属性，保证消息内容稳定
+func attributeNames(event *Event) []string {
+	names := make([]string, 0, len(event.Attributes))
+	for name := range event.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// slackPayload Slack incoming webhook 消息，text 用于通知预览
+func slackPayload(event *Event) map[string]any {
+	fields := []map[string]any{}
+	for _, name := range attributeNames(event) {
+		fields = append(fields, map[string]any{"title": name, "value": event.Attributes[name], "short": true})
+	}
+	return map[string]any{
+		"text": fmt.Sprintf("*%s* %s", title(event), event.Summary),
+		"attachments": []map[string]any{{
+			"color":  severityColors[event.Severity],
+			"fields": fields,
+			"footer": "openai-forward · " + event.ID,
+			"ts":     event.Time.Unix(),
+		}},
+	}
+}
+
+// teamsPayload Teams Workflows webhook 接受的 Adaptive Card 消息
+func teamsPayload(event *Event) map[string]any {
+	facts := []map[string]any{{"title": "time", "value": event.Time.UTC().Format("2006-01-02 15:04:05 UTC")}}
+	for _, name := range attributeNames(event) {
+		facts = append(facts, map[string]any{"title": name, "value": event.Attributes[name]})
+	}
+	card := map[string]any{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body": []map[string]any{
+			{"type": "TextBlock", "text": title(event), "weight": "Bolder", "size": "Medium", "wrap": true, "color": teamsColors[event.Severity]},
+			{"type": "TextBlock", "text": event.Summary, "wrap": true},
+			{"type": "FactSet", "facts": facts},
+		},
+	}
+	return map[string]any{
+		"type":    "message",
+		"summary": title(event),
+		"attachments": []map[string]any{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content":     card,
+		}},
+	}
+}
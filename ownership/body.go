@@ -0,0 +1,186 @@
+package ownership
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
+// Reference 请求体中引用的资源
+type Reference struct {
+	ID   string
+	Type string
+}
+
+// referenceFields 请求体中引用其他资源的字段，如 batches 的 input_file_id、runs 的 assistant_id
+var referenceFields = map[string]string{
+	"input_file_id":    TYPE_FILE,
+	"file_id":          TYPE_FILE,
+	"file_ids":         TYPE_FILE,
+	"assistant_id":     TYPE_ASSISTANT,
+	"vector_store_ids": TYPE_VECTOR_STORE,
+}
+
+// References 读取 JSON 请求体中引用的资源，字段可位于任意层级（如 tool_resources、消息附件）
+func References(body []byte) []Reference {
+	// 大多数请求不引用资源，先按字段名粗筛以避免解析
+	if !bytes.Contains(body, []byte(`_id"`)) && !bytes.Contains(body, []byte(`_ids"`)) {
+		return nil
+	}
+	var value any
+	if json.Unmarshal(body, &value) != nil {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	references := []Reference{}
+	add := func(id any, resourceType string) {
+		text, ok := id.(string)
+		if ok && text != "" && !seen[text] {
+			seen[text] = true
+			references = append(references, Reference{ID: text, Type: resourceType})
+		}
+	}
+
+	var walk func(value any)
+	walk = func(value any) {
+		switch v := value.(type) {
+		case map[string]any:
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				child := v[key]
+				if resourceType, ok := referenceFields[key]; ok {
+					if ids, isList := child.([]any); isList {
+						for _, id := range ids {
+							add(id, resourceType)
+						}
+					} else {
+						add(child, resourceType)
+					}
+					continue
+				}
+				walk(child)
+			}
+		case []any:
+			for _, item := range v {
+				walk(item)
+			}
+		}
+	}
+	walk(value)
+	return references
+}
+
+// Created 从创建请求的响应中读取新资源的 ID，无法识别时返回空字符串
+//
+// POST /threads/runs 的响应为运行对象，新建的线程 ID 位于 thread_id；流式响应从首个携带 thread_id 的事件中读取。
+func Created(target *Target, body []byte) string {
+	if target.Type == TYPE_THREAD && target.Sub == "runs" {
+		return createdThread(body)
+	}
+	if target.Sub != "" {
+		return ""
+	}
+	var object struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(body, &object) != nil {
+		return ""
+	}
+	return object.ID
+}
+
+// createdThread 读取运行对象或流式事件中的 thread_id
+func createdThread(body []byte) string {
+	var object struct {
+		ThreadID string `json:"thread_id"`
+	}
+	if json.Unmarshal(body, &object) == nil {
+		return object.ThreadID
+	}
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		data, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:"))
+		if !ok {
+			continue
+		}
+		if json.Unmarshal(bytes.TrimSpace(data), &object) == nil && object.ThreadID != "" {
+			return object.ThreadID
+		}
+	}
+	return ""
+}
+
+// BatchFiles 读取批处理对象中由上游生成的输出文件与错误文件
+func BatchFiles(body []byte) []string {
+	var batch struct {
+		OutputFileID string `json:"output_file_id"`
+		ErrorFileID  string `json:"error_file_id"`
+	}
+	if json.Unmarshal(body, &batch) != nil {
+		return nil
+	}
+	files := []string{}
+	for _, id := range []string{batch.OutputFileID, batch.ErrorFileID} {
+		if id != "" {
+			files = append(files, id)
+		}
+	}
+	return files
+}
+
+// listPage 列表响应，保留 data 之外的字段（如 object、has_more）
+type listPage map[string]json.RawMessage
+
+// parseList 解析列表响应中的 data 及其中各项的 ID
+func parseList(body []byte) (listPage, []json.RawMessage, []string, error) {
+	var page listPage
+	err := json.Unmarshal(body, &page)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var items []json.RawMessage
+	err = json.Unmarshal(page["data"], &items)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ids := make([]string, len(items))
+	for i, item := range items {
+		var object struct {
+			ID string `json:"id"`
+		}
+		_ = json.Unmarshal(item, &object)
+		ids[i] = object.ID
+	}
+	return page, items, ids, nil
+}
+
+// ListIDs 读取列表响应中各项的 ID
+func ListIDs(body []byte) ([]string, error) {
+	_, _, ids, err := parseList(body)
+	return ids, err
+}
+
+// FilterList 仅保留列表响应中 keep 返回 true 的项
+//
+// has_more 与分页游标保持上游的值，过滤后的一页可能少于请求的 limit。
+func FilterList(body []byte, keep func(id string) bool) ([]byte, error) {
+	page, items, ids, err := parseList(body)
+	if err != nil {
+		return nil, err
+	}
+	kept := make([]json.RawMessage, 0, len(items))
+	for i, item := range items {
+		if keep(ids[i]) {
+			kept = append(kept, item)
+		}
+	}
+	page["data"], err = json.Marshal(kept)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(page)
+}
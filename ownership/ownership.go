@@ -0,0 +1,187 @@
+package ownership
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Scope 上游资源的隔离范围
+type Scope string
+
+const (
+	// SCOPE_OFF 不隔离，所有用户共享上游资源
+	SCOPE_OFF Scope = "off"
+	// SCOPE_USER 资源仅创建者可见
+	SCOPE_USER Scope = "user"
+	// SCOPE_TEAM 资源在创建者所属团队内共享，未加入团队的用户按用户隔离
+	SCOPE_TEAM Scope = "team"
+)
+
+// 资源类型
+const (
+	TYPE_FILE         = "file"
+	TYPE_BATCH        = "batch"
+	TYPE_VECTOR_STORE = "vector_store"
+	TYPE_ASSISTANT    = "assistant"
+	TYPE_THREAD       = "thread"
+)
+
+// collections 接口路径中的资源集合与资源类型
+var collections = map[string]string{
+	"files":         TYPE_FILE,
+	"batches":       TYPE_BATCH,
+	"vector_stores": TYPE_VECTOR_STORE,
+	"assistants":    TYPE_ASSISTANT,
+	"threads":       TYPE_THREAD,
+}
+
+// pathPrefixes 代理接口的路径前缀，去除后为上游的资源路径
+var pathPrefixes = []string{"/openai/v1/", "/azure/openai/"}
+
+// Config 资源归属隔离配置
+type Config struct {
+	Scope Scope `json:"scope"`
+	// AllowUntracked 是否允许访问未记录归属的资源（如启用隔离前创建的资源）
+	AllowUntracked bool `json:"allow_untracked"`
+}
+
+// LoadConfigFromEnv 从环境变量加载资源归属隔离配置
+func LoadConfigFromEnv() *Config {
+	return &Config{
+		Scope:          ParseScope(os.Getenv("OWNERSHIP_SCOPE")),
+		AllowUntracked: os.Getenv("OWNERSHIP_ALLOW_UNTRACKED") == "true",
+	}
+}
+
+// ParseScope 解析隔离范围，无法识别时为 off
+func ParseScope(value string) Scope {
+	switch Scope(strings.ToLower(strings.TrimSpace(value))) {
+	case SCOPE_USER:
+		return SCOPE_USER
+	case SCOPE_TEAM:
+		return SCOPE_TEAM
+	default:
+		return SCOPE_OFF
+	}
+}
+
+// String 返回隔离配置的简要描述
+func (c *Config) String() string {
+	return fmt.Sprintf("scope=%s allow_untracked=%t", c.Scope, c.AllowUntracked)
+}
+
+// Owner 发起请求的用户
+type Owner struct {
+	Subject string
+	Email   string
+	TeamID  string
+}
+
+// Resource 上游资源的归属记录
+type Resource struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Upstream string `json:"upstream"`
+	Subject  string `json:"subject,omitempty"`
+	Email    string `json:"email,omitempty"`
+	TeamID   string `json:"team_id,omitempty"`
+	// CreatedAt 网关记录归属的时间
+	CreatedAt time.Time `json:"created_at"`
+	// DeletedAt 通过网关删除的时间，未删除时为空
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// NewResource 创建归属于 owner 的资源记录
+func NewResource(id string, resourceType string, upstream string, owner *Owner) *Resource {
+	return &Resource{
+		ID:        id,
+		Type:      resourceType,
+		Upstream:  upstream,
+		Subject:   owner.Subject,
+		Email:     owner.Email,
+		TeamID:    owner.TeamID,
+		CreatedAt: time.Now(),
+	}
+}
+
+// Store 资源归属存储，GetResources 仅返回存在记录的资源
+type Store interface {
+	SaveResource(resource *Resource) error
+	GetResources(ids []string) ([]*Resource, error)
+	MarkResourceDeleted(id string, deletedAt time.Time) error
+}
+
+// Allowed 判断 owner 能否访问资源，未记录归属的资源（resource 为 nil）按 AllowUntracked 处理
+func (c *Config) Allowed(owner *Owner, resource *Resource) bool {
+	if resource == nil {
+		return c.AllowUntracked
+	}
+	if c.Scope == SCOPE_TEAM && owner.TeamID != "" {
+		return resource.TeamID == owner.TeamID
+	}
+	if owner.Subject != "" {
+		return resource.Subject == owner.Subject
+	}
+	return owner.Email != "" && strings.EqualFold(resource.Email, owner.Email)
+}
+
+// Target 请求访问的上游资源
+type Target struct {
+	// Type 资源类型
+	Type string
+	// ID 资源 ID，访问资源集合（列表、创建）时为空
+	ID string
+	// Sub 资源 ID 之后的子路径，如 content、cancel、files/file-abc
+	Sub string
+}
+
+// ParseTarget 解析代理请求路径对应的资源，非资源接口返回 nil
+func ParseTarget(path string) *Target {
+	rest := ""
+	for _, prefix := range pathPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			rest = strings.TrimPrefix(path, prefix)
+			break
+		}
+	}
+	if rest == "" {
+		return nil
+	}
+
+	parts := strings.SplitN(strings.Trim(rest, "/"), "/", 3)
+	resourceType, ok := collections[parts[0]]
+	if !ok {
+		return nil
+	}
+	target := &Target{Type: resourceType}
+	if len(parts) > 1 {
+		target.ID = parts[1]
+	}
+	if len(parts) > 2 {
+		target.Sub = parts[2]
+	}
+	// POST /threads/runs 创建线程并运行，不是访问 ID 为 runs 的线程
+	if target.Type == TYPE_THREAD && target.ID == "runs" {
+		target.ID = ""
+		target.Sub = "runs"
+	}
+	return target
+}
+
+// IsList 是否为列出资源集合的请求
+func (t *Target) IsList(method string) bool {
+	return method == http.MethodGet && t.ID == "" && t.Sub == ""
+}
+
+// IsCreate 是否为创建资源的请求
+func (t *Target) IsCreate(method string) bool {
+	return method == http.MethodPost && t.ID == ""
+}
+
+// IsDelete 是否为删除资源本身的请求
+func (t *Target) IsDelete(method string) bool {
+	return method == http.MethodDelete && t.ID != "" && t.Sub == ""
+}
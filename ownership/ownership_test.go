@@ -0,0 +1,116 @@
+package ownership
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseTarget(t *testing.T) {
+	testCases := []struct {
+		path     string
+		expected *Target
+	}{
+		{"/openai/v1/files", &Target{Type: TYPE_FILE}},
+		{"/openai/v1/files/file-abc/content", &Target{Type: TYPE_FILE, ID: "file-abc", Sub: "content"}},
+		{"/azure/openai/batches/batch_1/cancel", &Target{Type: TYPE_BATCH, ID: "batch_1", Sub: "cancel"}},
+		{"/openai/v1/vector_stores/vs_1/files/file-abc", &Target{Type: TYPE_VECTOR_STORE, ID: "vs_1", Sub: "files/file-abc"}},
+		{"/openai/v1/threads/runs", &Target{Type: TYPE_THREAD, Sub: "runs"}},
+		{"/openai/v1/threads/thread_1/runs", &Target{Type: TYPE_THREAD, ID: "thread_1", Sub: "runs"}},
+		{"/openai/v1/chat/completions", nil},
+		{"/api/v1/files", nil},
+	}
+
+	for _, tc := range testCases {
+		target := ParseTarget(tc.path)
+		if (target == nil) != (tc.expected == nil) || (target != nil && *target != *tc.expected) {
+			t.Errorf("ParseTarget(%s) = %+v, expected %+v", tc.path, target, tc.expected)
+		}
+	}
+}
+
+func TestConfig_Allowed(t *testing.T) {
+	resource := &Resource{ID: "file-abc", Subject: "alice", Email: "alice@example.com", TeamID: "team-a"}
+	alice := &Owner{Subject: "alice", TeamID: "team-a"}
+	bob := &Owner{Subject: "bob", TeamID: "team-a"}
+
+	user := &Config{Scope: SCOPE_USER}
+	if !user.Allowed(alice, resource) || user.Allowed(bob, resource) {
+		t.Error("Expected user scope to isolate by subject")
+	}
+	team := &Config{Scope: SCOPE_TEAM}
+	if !team.Allowed(bob, resource) || team.Allowed(&Owner{Subject: "carol", TeamID: "team-b"}, resource) {
+		t.Error("Expected team scope to share within the team")
+	}
+	if user.Allowed(alice, nil) || !(&Config{Scope: SCOPE_USER, AllowUntracked: true}).Allowed(alice, nil) {
+		t.Error("Expected untracked resources to follow AllowUntracked")
+	}
+}
+
+func TestReferences(t *testing.T) {
+	body := `{"assistant_id":"asst_1","tool_resources":{"file_search":{"vector_store_ids":["vs_1"]},
+		"code_interpreter":{"file_ids":["file-a","file-b"]}},"input_file_id":"file-a"}`
+	references := References([]byte(body))
+	types := map[string]string{}
+	for _, ref := range references {
+		types[ref.ID] = ref.Type
+	}
+	expected := map[string]string{"asst_1": TYPE_ASSISTANT, "vs_1": TYPE_VECTOR_STORE, "file-a": TYPE_FILE, "file-b": TYPE_FILE}
+	if len(types) != len(expected) {
+		t.Fatalf("Unexpected references: %+v", references)
+	}
+	for id, resourceType := range expected {
+		if types[id] != resourceType {
+			t.Errorf("Expected %s to be a %s reference, got '%s'", id, resourceType, types[id])
+		}
+	}
+
+	if refs := References([]byte(`{"model":"gpt-4o","messages":[]}`)); len(refs) != 0 {
+		t.Errorf("Expected no references, got %+v", refs)
+	}
+}
+
+func TestCreated(t *testing.T) {
+	if id := Created(&Target{Type: TYPE_FILE}, []byte(`{"id":"file-abc","object":"file"}`)); id != "file-abc" {
+		t.Errorf("Expected file-abc, got '%s'", id)
+	}
+	if id := Created(&Target{Type: TYPE_THREAD, Sub: "runs"}, []byte(`{"id":"run_1","thread_id":"thread_1"}`)); id != "thread_1" {
+		t.Errorf("Expected thread_1, got '%s'", id)
+	}
+	stream := "event: thread.created\ndata: {\"id\":\"thread_2\",\"object\":\"thread\"}\n\n" +
+		"event: thread.run.created\ndata: {\"id\":\"run_2\",\"thread_id\":\"thread_2\"}\n\n"
+	if id := Created(&Target{Type: TYPE_THREAD, Sub: "runs"}, []byte(stream)); id != "thread_2" {
+		t.Errorf("Expected thread_2 from stream, got '%s'", id)
+	}
+	files := BatchFiles([]byte(`{"id":"batch_1","output_file_id":"file-out","error_file_id":null}`))
+	if len(files) != 1 || files[0] != "file-out" {
+		t.Errorf("Unexpected batch files: %v", files)
+	}
+}
+
+func TestFilterList(t *testing.T) {
+	body := `{"object":"list","data":[{"id":"file-a","bytes":1},{"id":"file-b","bytes":2}],"has_more":false}`
+	ids, err := ListIDs([]byte(body))
+	if err != nil || len(ids) != 2 || ids[1] != "file-b" {
+		t.Fatalf("Unexpected ids: %v, %v", ids, err)
+	}
+
+	filtered, err := FilterList([]byte(body), func(id string) bool { return id == "file-b" })
+	if err != nil {
+		t.Fatalf("Failed to filter list: %v", err)
+	}
+	var page struct {
+		Object  string           `json:"object"`
+		Data    []map[string]any `json:"data"`
+		HasMore bool             `json:"has_more"`
+	}
+	if err := json.Unmarshal(filtered, &page); err != nil {
+		t.Fatalf("Invalid filtered list: %v", err)
+	}
+	if page.Object != "list" || len(page.Data) != 1 || page.Data[0]["id"] != "file-b" {
+		t.Errorf("Unexpected filtered list: %s", filtered)
+	}
+
+	if _, err := FilterList([]byte(`not json`), func(string) bool { return true }); err == nil {
+		t.Error("Expected error for invalid list")
+	}
+}
@@ -4,13 +4,19 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"openai-forward/apierror"
 	"openai-forward/logging"
+	"openai-forward/metrics"
+	"openai-forward/retry"
+	"openai-forward/tracing"
 	"os"
 	"path"
+	"sort"
 	"strings"
 )
 
@@ -20,6 +26,10 @@ type AzureConfig struct {
 	APIVersion    string            `json:"api_version"`
 	ModelMappings map[string]string `json:"model_mappings"`
 	DefaultModel  string            `json:"default_model"`
+	// RealtimeAPIVersion Realtime API 使用的 api-version，GA 版本的 api-version 不支持 Realtime
+	RealtimeAPIVersion string `json:"realtime_api_version"`
+	// UpstreamKeyMode 客户端自带上游密钥模式：off、allow、require
+	UpstreamKeyMode string `json:"upstream_key_mode"`
 }
 
 type AzureProxy struct {
@@ -32,13 +42,16 @@ func NewAzureProxy(config *AzureConfig) (*AzureProxy, error) {
 		return nil, fmt.Errorf("endpoint is required")
 	}
 
-	if config.APIKey == "" {
+	if config.APIKey == "" && ParseUpstreamKeyMode(config.UpstreamKeyMode) != UPSTREAM_KEY_REQUIRE {
 		return nil, fmt.Errorf("api_key is required")
 	}
 
 	if config.APIVersion == "" {
 		config.APIVersion = "2023-05-15"
 	}
+	if config.RealtimeAPIVersion == "" {
+		config.RealtimeAPIVersion = "2024-10-01-preview"
+	}
 
 	// 如果没有设置模型映射，则初始化为空map
 	if config.ModelMappings == nil {
@@ -52,7 +65,7 @@ func NewAzureProxy(config *AzureConfig) (*AzureProxy, error) {
 
 	return &AzureProxy{
 		config: config,
-		client: &http.Client{},
+		client: &http.Client{Transport: retry.NewTransport(tracing.NewTransport(nil, "azure"), "azure")},
 	}, nil
 }
 
@@ -69,20 +82,23 @@ func NewAzureConfigFromENV() *AzureConfig {
 	// 可以通过环境变量或配置文件扩展
 
 	return &AzureConfig{
-		Endpoint:      os.Getenv("AZURE_OPENAI_ENDPOINT"),
-		APIKey:        os.Getenv("AZURE_OPENAI_API_KEY"),
-		APIVersion:    os.Getenv("AZURE_OPENAI_API_VERSION"),
-		DefaultModel:  os.Getenv("AZURE_OPENAI_DEFAULT_MODEL"),
-		ModelMappings: modelMappings,
+		Endpoint:           os.Getenv("AZURE_OPENAI_ENDPOINT"),
+		APIKey:             os.Getenv("AZURE_OPENAI_API_KEY"),
+		APIVersion:         os.Getenv("AZURE_OPENAI_API_VERSION"),
+		DefaultModel:       os.Getenv("AZURE_OPENAI_DEFAULT_MODEL"),
+		RealtimeAPIVersion: os.Getenv("AZURE_OPENAI_REALTIME_API_VERSION"),
+		ModelMappings:      modelMappings,
+		UpstreamKeyMode:    os.Getenv("AZURE_OPENAI_UPSTREAM_KEY_MODE"),
 	}
 }
 
-// 获取模型列表
+// 获取模型列表，按名称排序
 func (p *AzureProxy) ListModels() []string {
 	models := make([]string, 0, len(p.config.ModelMappings))
 	for model := range p.config.ModelMappings {
 		models = append(models, model)
 	}
+	sort.Strings(models)
 	return models
 }
 
@@ -153,47 +169,87 @@ func (p *AzureProxy) ProxyRequest(w http.ResponseWriter, r *http.Request) {
 	// 解析原始请求
 	requestPath := strings.TrimPrefix(r.URL.Path, "/")
 
-	// 读取请求体
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusBadRequest)
+	// 客户端自带的上游密钥，仅用于本次转发
+	upstreamKey, ok := takeUpstreamKey(r, ParseUpstreamKeyMode(p.config.UpstreamKeyMode))
+	if !ok {
+		apierror.Write(w, missingUpstreamKey())
 		return
 	}
+	if upstreamKey == "" {
+		upstreamKey = p.config.APIKey
+	}
 
-	//logging.Logger.Infof("Request Body: %s", string(body))
-
-	// 从请求体中提取模型名称
-	modelName := p.extractModelName(body)
-
-	//logging.Logger.Infof("Model Name: %s", modelName)
+	// JSON 请求体需完整读取以提取模型名称，因此限制大小；文件上传等其他请求体直接流式转发，大小由请求校验限制
+	var body io.Reader = r.Body
+	modelName := p.config.DefaultModel
+	if contentType := r.Header.Get("Content-Type"); contentType == "" || strings.HasPrefix(contentType, "application/json") {
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxModelPeekSize+1))
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			apierror.Write(w, apierror.RequestTooLarge(maxBytesErr.Limit))
+			return
+		}
+		if err != nil {
+			apierror.Write(w, apierror.InvalidRequest("", "invalid_body", "Failed to read request body"))
+			return
+		}
+		if len(data) > maxModelPeekSize {
+			apierror.Write(w, apierror.New(http.StatusRequestEntityTooLarge, "request_too_large", "Request body is too large"))
+			return
+		}
+		body = bytes.NewReader(data)
+		// 从请求体中提取模型名称
+		modelName = p.extractModelName(data)
+	}
 
 	// 构建目标URL
 	targetURL, err := p.buildTargetURL(requestPath, r.URL.Query(), modelName)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to build target URL: %v", err), http.StatusBadRequest)
+		apierror.Write(w, apierror.InvalidRequest("", "invalid_request_path", fmt.Sprintf("Failed to build target URL: %v", err)))
 		return
 	}
 
 	//logging.Logger.Infof("Requesting Targetr URL %s", targetURL)
 
 	// 创建新的请求
-	req, err := http.NewRequestWithContext(context.Background(), r.Method, targetURL, bytes.NewReader(body))
+	// 保留请求上下文中的链路信息，但客户端断开时不取消上游请求
+	req, err := http.NewRequestWithContext(context.WithoutCancel(r.Context()), r.Method, targetURL, body)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create request: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, apierror.New(http.StatusInternalServerError, "internal_error", "Failed to create upstream request"))
 		return
 	}
+	if req.ContentLength == 0 && r.ContentLength > 0 {
+		req.ContentLength = r.ContentLength
+	}
 
 	// 设置请求头
 	req.Header = r.Header.Clone()
-	req.Header.Set("api-key", p.config.APIKey)
+	req.Header.Set("api-key", upstreamKey)
+	// 由 Transport 透明处理压缩，以便解析响应中的用量
+	req.Header.Del("Accept-Encoding")
 
 	// 发送请求
 	resp, err := p.client.Do(req)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		// 流式转发途中请求体超过接口上限，属于客户端错误，不计为上游故障
+		apierror.Write(w, apierror.RequestTooLarge(maxBytesErr.Limit))
+		return
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to send request: %v", err), http.StatusBadGateway)
+		metrics.UpstreamErrors.WithLabelValues("azure", "transport").Inc()
+		logging.Logger.Errorf("Failed to proxy request to azure: %v", err)
+		apierror.Write(w, apierror.UpstreamUnavailable())
 		return
 	}
 	defer resp.Body.Close()
+	observeUpstreamStatus("azure", resp.StatusCode)
+	err = apierror.NormalizeResponse(resp)
+	if err != nil {
+		logging.Logger.Errorf("Failed to read azure error response: %v", err)
+		apierror.Write(w, apierror.UpstreamUnavailable())
+		return
+	}
 
 	// 复制响应头
 	for key, values := range resp.Header {
@@ -1,24 +1,36 @@
 package proxy
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"openai-forward/apierror"
 	"openai-forward/config"
 	"openai-forward/logging"
+	"openai-forward/metrics"
+	"openai-forward/retry"
+	"openai-forward/tracing"
+	"sort"
+	"strconv"
+	"time"
 )
 
-// ErrorResponse 定义了统一的错误响应结构
-type ErrorResponse struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+// missingUpstreamKey 要求客户端自带上游密钥但未提供时的错误
+func missingUpstreamKey() *apierror.Error {
+	return apierror.New(http.StatusUnauthorized, "missing_upstream_key", "Missing upstream API key in "+UpstreamKeyHeader)
 }
 
-// sendErrorResponse 发送统一格式的错误响应
-func sendErrorResponse(w http.ResponseWriter, code int, message string) {
-	http.Error(w, fmt.Sprintf("{\"code\": %d, \"message\": \"%s\"}", code, message), code)
+// observeUpstreamStatus 记录上游返回的错误状态码（限流及服务端错误）
+func observeUpstreamStatus(upstream string, status int) {
+	if status == http.StatusTooManyRequests || status >= http.StatusInternalServerError {
+		metrics.UpstreamErrors.WithLabelValues(upstream, strconv.Itoa(status)).Inc()
+	}
 }
 
 // OpenAIProxy OpenAI API 代理
@@ -39,7 +51,14 @@ func (p *OpenAIProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// 设置目标 URL
 	target, err := url.Parse(p.config.TargetBaseURL)
 	if err != nil {
-		sendErrorResponse(w, http.StatusInternalServerError, "Failed to parse target URL")
+		apierror.Write(w, apierror.New(http.StatusInternalServerError, "invalid_upstream_url", "Failed to parse target URL"))
+		return
+	}
+
+	// 客户端自带的上游密钥，仅用于本次转发
+	upstreamKey, ok := takeUpstreamKey(r, ParseUpstreamKeyMode(p.config.UpstreamKeyMode))
+	if !ok {
+		apierror.Write(w, missingUpstreamKey())
 		return
 	}
 
@@ -53,6 +72,15 @@ func (p *OpenAIProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		req.Header.Del("X-Forwarded-For")
 		req.Header.Del("X-Real-IP")
 
+		// 由 Transport 透明处理压缩，以便解析响应中的用量
+		req.Header.Del("Accept-Encoding")
+
+		// 使用客户端自带密钥时，组织与项目由客户端自行指定
+		if upstreamKey != "" {
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", upstreamKey))
+			return
+		}
+
 		// 设置认证信息
 		if p.config.APIKey != "" {
 			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.config.APIKey))
@@ -66,14 +94,32 @@ func (p *OpenAIProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 创建反向代理并处理请求
-	proxy := &httputil.ReverseProxy{Director: director}
+	proxy := &httputil.ReverseProxy{
+		Director:  director,
+		Transport: retry.NewTransport(tracing.NewTransport(nil, "openai"), "openai"),
+		ModifyResponse: func(resp *http.Response) error {
+			observeUpstreamStatus("openai", resp.StatusCode)
+			return apierror.NormalizeResponse(resp)
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			// 转发途中请求体超过接口上限，属于客户端错误，不计为上游故障
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				apierror.Write(w, apierror.RequestTooLarge(maxBytesErr.Limit))
+				return
+			}
+			metrics.UpstreamErrors.WithLabelValues("openai", "transport").Inc()
+			logging.Logger.Errorf("Failed to proxy request to openai: %v", err)
+			apierror.Write(w, apierror.UpstreamUnavailable())
+		},
+	}
 	proxy.ServeHTTP(w, r)
 }
 
 type Model struct {
 	ID      string `json:"id"`
 	Object  string `json:"object"`
-	Created int    `json:"created"`
+	Created int64  `json:"created"`
 	OwnedBy string `json:"owned_by"`
 }
 
@@ -82,31 +128,84 @@ type ModelsResponse struct {
 	Data   []*Model `json:"data"`
 }
 
-func (p *OpenAIProxy) ListAvailableModels() []string {
-	req, err := http.NewRequest("GET", p.config.TargetBaseURL+"/v1/models", nil)
+// modelsTimeout 拉取上游模型列表的超时时间
+const modelsTimeout = 10 * time.Second
+
+// Get 使用服务端密钥请求上游 GET 接口，非 200 响应返回错误，调用方负责关闭返回的响应体
+func (p *OpenAIProxy) Get(ctx context.Context, path string) (io.ReadCloser, error) {
+	return p.do(ctx, "GET", path, nil)
+}
+
+// Post 使用服务端密钥以 JSON 请求体调用上游 POST 接口，非 200 响应返回错误，调用方负责关闭返回的响应体
+func (p *OpenAIProxy) Post(ctx context.Context, path string, body []byte) (io.ReadCloser, error) {
+	return p.do(ctx, "POST", path, body)
+}
+
+// do 使用服务端密钥请求上游
+func (p *OpenAIProxy) do(ctx context.Context, method string, path string, body []byte) (io.ReadCloser, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.config.TargetBaseURL+path, reader)
 	if err != nil {
-		logging.Logger.Errorf("Failed to create request: %v", err)
-		return []string{}
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
 	}
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.config.APIKey))
-	resp, err := http.DefaultClient.Do(req)
+	if p.config.OrgID != "" {
+		req.Header.Set("OpenAI-Organization", p.config.OrgID)
+	}
+	if p.config.ProjectID != "" {
+		req.Header.Set("OpenAI-Project", p.config.ProjectID)
+	}
+
+	client := &http.Client{Transport: tracing.NewTransport(nil, "openai")}
+	resp, err := client.Do(req)
 	if err != nil {
-		logging.Logger.Errorf("Failed to send request: %v", err)
-		return []string{}
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("upstream returned status %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// FetchModels 从上游 /v1/models 拉取模型列表，按名称排序
+func (p *OpenAIProxy) FetchModels(ctx context.Context) ([]*Model, error) {
+	ctx, cancel := context.WithTimeout(ctx, modelsTimeout)
+	defer cancel()
+
+	body, err := p.Get(ctx, "/v1/models")
+	if err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
+	defer body.Close()
 
 	var modelResp ModelsResponse
-	err = json.NewDecoder(resp.Body).Decode(&modelResp)
+	err = json.NewDecoder(body).Decode(&modelResp)
 	if err != nil {
-		logging.Logger.Errorf("Failed to decode response: %v", err)
-		return []string{}
+		return nil, err
 	}
-	list := []string{}
+	sort.Slice(modelResp.Data, func(i, j int) bool {
+		return modelResp.Data[i].ID < modelResp.Data[j].ID
+	})
+	return modelResp.Data, nil
+}
 
-	//logging.Logger.Infof("Models: %+v", modelResp.Data)
+// ListAvailableModels 返回上游模型列表中属于白名单的模型，拉取失败时返回空列表
+func (p *OpenAIProxy) ListAvailableModels() []string {
+	models, err := p.FetchModels(context.Background())
+	if err != nil {
+		logging.Logger.Errorf("Failed to list models: %v", err)
+		return []string{}
+	}
 
-	for _, model := range modelResp.Data {
+	list := []string{}
+	for _, model := range models {
 		for _, whiteListModel := range p.config.ModelsWhiteList {
 			if model.ID == whiteListModel {
 				list = append(list, model.ID)
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"openai-forward/apierror"
+	"openai-forward/realtime"
+	"strings"
+)
+
+// realtimeHeaders 需要转发给上游的 Realtime 请求头，WebSocket 握手相关的请求头由 Dialer 生成
+var realtimeHeaders = []string{"OpenAI-Beta", "User-Agent"}
+
+// websocketURL 将 http(s) 地址转换为 ws(s) 地址
+func websocketURL(target *url.URL) {
+	switch target.Scheme {
+	case "https":
+		target.Scheme = "wss"
+	case "http":
+		target.Scheme = "ws"
+	}
+}
+
+// realtimeHeader 复制客户端的 Realtime 请求头
+func realtimeHeader(r *http.Request) http.Header {
+	header := http.Header{}
+	for _, name := range realtimeHeaders {
+		if value := r.Header.Get(name); value != "" {
+			header.Set(name, value)
+		}
+	}
+	return header
+}
+
+// ServeRealtime 代理 OpenAI Realtime API 的 WebSocket 连接，握手失败时返回 nil
+func (p *OpenAIProxy) ServeRealtime(w http.ResponseWriter, r *http.Request, relay *realtime.Relay) *realtime.Session {
+	target, err := url.Parse(p.config.TargetBaseURL)
+	if err != nil {
+		apierror.Write(w, apierror.New(http.StatusInternalServerError, "invalid_upstream_url", "Failed to parse target URL"))
+		return nil
+	}
+	upstreamKey, ok := takeUpstreamKey(r, ParseUpstreamKeyMode(p.config.UpstreamKeyMode))
+	if !ok {
+		apierror.Write(w, missingUpstreamKey())
+		return nil
+	}
+
+	websocketURL(target)
+	target.Path = strings.TrimRight(target.Path, "/") + "/v1/realtime"
+	query := url.Values{}
+	if model := r.URL.Query().Get("model"); model != "" {
+		query.Set("model", model)
+	}
+	target.RawQuery = query.Encode()
+
+	header := realtimeHeader(r)
+	if upstreamKey != "" {
+		header.Set("Authorization", fmt.Sprintf("Bearer %s", upstreamKey))
+	} else {
+		if p.config.APIKey != "" {
+			header.Set("Authorization", fmt.Sprintf("Bearer %s", p.config.APIKey))
+		}
+		if p.config.OrgID != "" {
+			header.Set("OpenAI-Organization", p.config.OrgID)
+		}
+		if p.config.ProjectID != "" {
+			header.Set("OpenAI-Project", p.config.ProjectID)
+		}
+	}
+
+	return relay.Serve(w, r, target.String(), header)
+}
+
+// RealtimeDeployment 返回 Realtime 请求对应的部署名称，deployment 参数为模型名称时按模型映射转换
+func (p *AzureProxy) RealtimeDeployment(r *http.Request) string {
+	deployment := r.URL.Query().Get("deployment")
+	if deployment == "" {
+		deployment = p.config.DefaultModel
+	}
+	return p.getDeploymentID(deployment)
+}
+
+// ServeRealtime 代理 Azure OpenAI Realtime API 的 WebSocket 连接，握手失败时返回 nil
+//
+// api-version 优先使用客户端指定的值，其次为配置的 RealtimeAPIVersion。
+func (p *AzureProxy) ServeRealtime(w http.ResponseWriter, r *http.Request, relay *realtime.Relay) *realtime.Session {
+	upstreamKey, ok := takeUpstreamKey(r, ParseUpstreamKeyMode(p.config.UpstreamKeyMode))
+	if !ok {
+		apierror.Write(w, missingUpstreamKey())
+		return nil
+	}
+	if upstreamKey == "" {
+		upstreamKey = p.config.APIKey
+	}
+
+	deployment := p.RealtimeDeployment(r)
+	if deployment == "" {
+		apierror.Write(w, apierror.InvalidRequest("deployment", "missing_required_parameter", "Missing required parameter: 'deployment'."))
+		return nil
+	}
+
+	target, err := url.Parse(p.config.Endpoint)
+	if err != nil {
+		apierror.Write(w, apierror.New(http.StatusInternalServerError, "invalid_upstream_url", "Failed to parse target URL"))
+		return nil
+	}
+	websocketURL(target)
+	target.Path = "/openai/realtime"
+
+	apiVersion := r.URL.Query().Get("api-version")
+	if apiVersion == "" {
+		apiVersion = p.config.RealtimeAPIVersion
+	}
+	query := url.Values{}
+	query.Set("api-version", apiVersion)
+	query.Set("deployment", deployment)
+	target.RawQuery = query.Encode()
+
+	header := realtimeHeader(r)
+	header.Set("api-key", upstreamKey)
+
+	return relay.Serve(w, r, target.String(), header)
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+// UpstreamKeyHeader 客户端自带上游密钥（BYOK）时使用的请求头，网关认证仍使用 Authorization/Api-Key
+const UpstreamKeyHeader = "X-Upstream-Api-Key"
+
+// UpstreamKeyMode 客户端自带上游密钥的模式
+type UpstreamKeyMode string
+
+const (
+	// UPSTREAM_KEY_OFF 忽略客户端提供的上游密钥，始终使用服务端密钥
+	UPSTREAM_KEY_OFF UpstreamKeyMode = "off"
+	// UPSTREAM_KEY_ALLOW 客户端提供上游密钥时使用之，否则使用服务端密钥
+	UPSTREAM_KEY_ALLOW UpstreamKeyMode = "allow"
+	// UPSTREAM_KEY_REQUIRE 必须由客户端提供上游密钥
+	UPSTREAM_KEY_REQUIRE UpstreamKeyMode = "require"
+)
+
+// ParseUpstreamKeyMode 解析上游密钥模式，无法识别时为 off
+func ParseUpstreamKeyMode(value string) UpstreamKeyMode {
+	switch UpstreamKeyMode(strings.ToLower(strings.TrimSpace(value))) {
+	case UPSTREAM_KEY_ALLOW:
+		return UPSTREAM_KEY_ALLOW
+	case UPSTREAM_KEY_REQUIRE:
+		return UPSTREAM_KEY_REQUIRE
+	default:
+		return UPSTREAM_KEY_OFF
+	}
+}
+
+// UpstreamKeyModeFromEnv 返回上游（openai 或 azure）配置的上游密钥模式
+func UpstreamKeyModeFromEnv(upstream string) UpstreamKeyMode {
+	if upstream == "azure" {
+		return ParseUpstreamKeyMode(os.Getenv("AZURE_OPENAI_UPSTREAM_KEY_MODE"))
+	}
+	return ParseUpstreamKeyMode(os.Getenv("OPENAI_UPSTREAM_KEY_MODE"))
+}
+
+// takeUpstreamKey 读取并从请求中移除客户端提供的上游密钥，确保其不会被转发、记录或持久化
+func takeUpstreamKey(r *http.Request, mode UpstreamKeyMode) (string, bool) {
+	value := strings.TrimSpace(r.Header.Get(UpstreamKeyHeader))
+	r.Header.Del(UpstreamKeyHeader)
+
+	value = strings.TrimSpace(strings.TrimPrefix(value, "Bearer "))
+	switch mode {
+	case UPSTREAM_KEY_ALLOW:
+		return value, true
+	case UPSTREAM_KEY_REQUIRE:
+		return value, value != ""
+	default:
+		return "", true
+	}
+}
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseUpstreamKeyMode(t *testing.T) {
+	testCases := map[string]UpstreamKeyMode{
+		"":        UPSTREAM_KEY_OFF,
+		"off":     UPSTREAM_KEY_OFF,
+		"Allow":   UPSTREAM_KEY_ALLOW,
+		"require": UPSTREAM_KEY_REQUIRE,
+		"unknown": UPSTREAM_KEY_OFF,
+	}
+
+	for value, expected := range testCases {
+		if mode := ParseUpstreamKeyMode(value); mode != expected {
+			t.Errorf("Expected mode '%s' for '%s', got '%s'", expected, value, mode)
+		}
+	}
+}
+
+func TestTakeUpstreamKey(t *testing.T) {
+	req := httptest.NewRequest("POST", "/openai/v1/chat/completions", nil)
+	req.Header.Set(UpstreamKeyHeader, "Bearer sk-client")
+
+	key, ok := takeUpstreamKey(req, UPSTREAM_KEY_ALLOW)
+	if !ok || key != "sk-client" {
+		t.Errorf("Expected client key 'sk-client', got '%s' (ok=%v)", key, ok)
+	}
+	if req.Header.Get(UpstreamKeyHeader) != "" {
+		t.Error("Upstream key header should be removed from the request")
+	}
+
+	// off 模式忽略客户端密钥
+	req.Header.Set(UpstreamKeyHeader, "sk-client")
+	key, ok = takeUpstreamKey(req, UPSTREAM_KEY_OFF)
+	if !ok || key != "" {
+		t.Errorf("Expected client key to be ignored, got '%s'", key)
+	}
+
+	// require 模式缺少密钥时拒绝
+	_, ok = takeUpstreamKey(req, UPSTREAM_KEY_REQUIRE)
+	if ok {
+		t.Error("Expected missing key to be rejected in require mode")
+	}
+}
+
+func TestAzureProxy_UpstreamKeyPassthrough(t *testing.T) {
+	// 创建模拟的 Azure 上游
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("api-key") != "client-azure-key" {
+			t.Errorf("Expected client api-key to be forwarded, got '%s'", r.Header.Get("api-key"))
+		}
+		if r.Header.Get(UpstreamKeyHeader) != "" {
+			t.Error("Upstream key header should not be forwarded")
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, "OK")
+	}))
+	defer ts.Close()
+
+	proxy, err := NewAzureProxy(&AzureConfig{
+		Endpoint:        ts.URL,
+		APIVersion:      "2024-10-01",
+		UpstreamKeyMode: "require",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create AzureProxy: %v", err)
+	}
+
+	body := `{"model":"gpt-4o","messages":[]}`
+	req := httptest.NewRequest("POST", "/azure/openai/deployments/gpt-4o/chat/completions", strings.NewReader(body))
+	req.Header.Set(UpstreamKeyHeader, "client-azure-key")
+	w := httptest.NewRecorder()
+	proxy.ProxyRequest(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	// 缺少客户端密钥时返回 401
+	req = httptest.NewRequest("POST", "/azure/openai/deployments/gpt-4o/chat/completions", strings.NewReader(body))
+	w = httptest.NewRecorder()
+	proxy.ProxyRequest(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestAzureProxy_StreamsUploads(t *testing.T) {
+	// 超过 32MB 的文件上传不应被缓冲或拒绝
+	var received int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	proxy, err := NewAzureProxy(&AzureConfig{Endpoint: ts.URL, APIKey: "server-key", APIVersion: "2024-10-01"})
+	if err != nil {
+		t.Fatalf("Failed to create AzureProxy: %v", err)
+	}
+
+	size := int64(maxModelPeekSize + 1<<20)
+	req := httptest.NewRequest("POST", "/azure/openai/files", io.LimitReader(zeroReader{}, size))
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+	req.ContentLength = size
+	w := httptest.NewRecorder()
+	proxy.ProxyRequest(w, req)
+	if w.Code != http.StatusOK || received != size {
+		t.Errorf("Expected %d bytes to be forwarded, got status %d and %d bytes: %s", size, w.Code, received, w.Body.String())
+	}
+
+	// 流式转发途中超过接口上限时返回 413
+	req = httptest.NewRequest("POST", "/azure/openai/files", strings.NewReader(strings.Repeat("a", 1024)))
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+	req.ContentLength = -1
+	w = httptest.NewRecorder()
+	req.Body = http.MaxBytesReader(w, req.Body, 100)
+	proxy.ProxyRequest(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status code %d, got %d: %s", http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+	}
+}
+
+// zeroReader 无限输出零字节
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
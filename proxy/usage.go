@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// maxModelPeekSize 读取请求体提取模型名称时的大小上限
+const maxModelPeekSize = 32 << 20
+
+// Usage 上游返回的 token 用量，兼容 Chat Completions（prompt/completion）与 Responses（input/output）两种格式
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	InputTokens      int `json:"input_tokens"`
+	OutputTokens     int `json:"output_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Prompt 输入 token 数
+func (u *Usage) Prompt() int {
+	if u.PromptTokens > 0 {
+		return u.PromptTokens
+	}
+	return u.InputTokens
+}
+
+// Completion 输出 token 数
+func (u *Usage) Completion() int {
+	if u.CompletionTokens > 0 {
+		return u.CompletionTokens
+	}
+	return u.OutputTokens
+}
+
+// Total 总 token 数
+func (u *Usage) Total() int {
+	if u.TotalTokens > 0 {
+		return u.TotalTokens
+	}
+	return u.Prompt() + u.Completion()
+}
+
+var usageField = []byte(`"usage"`)
+
+// ParseUsage 从响应体（JSON 或 SSE 流）中提取最后一个有效的 usage 对象，未找到时返回 nil
+//
+// 流式响应仅在请求设置 stream_options.include_usage 时于最后一个分片返回 usage，
+// 此前分片中的 "usage": null 会被跳过。
+func ParseUsage(body []byte) *Usage {
+	end := len(body)
+	for end > 0 {
+		index := bytes.LastIndex(body[:end], usageField)
+		if index < 0 {
+			return nil
+		}
+		end = index
+
+		rest := bytes.TrimLeft(body[index+len(usageField):], " \t\r\n")
+		if len(rest) == 0 || rest[0] != ':' {
+			continue
+		}
+		rest = bytes.TrimLeft(rest[1:], " \t\r\n")
+		if len(rest) == 0 || rest[0] != '{' {
+			continue
+		}
+
+		var usage Usage
+		err := json.NewDecoder(bytes.NewReader(rest)).Decode(&usage)
+		if err != nil {
+			continue
+		}
+		if usage.Total() > 0 {
+			return &usage
+		}
+	}
+	return nil
+}
+
+// PeekModel 读取 JSON 请求体中的 model 字段，并恢复请求体供后续转发使用。
+// 最多读取 limit 字节（不超过 32MB），为 0 时读取 32MB，应传入接口的请求体上限，避免在校验前读入过大的请求体。
+func PeekModel(r *http.Request, limit int64) string {
+	if r.Body == nil || r.Body == http.NoBody {
+		return ""
+	}
+	if !bytes.HasPrefix([]byte(r.Header.Get("Content-Type")), []byte("application/json")) {
+		return ""
+	}
+
+	if limit <= 0 || limit > maxModelPeekSize {
+		limit = maxModelPeekSize
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit))
+	if err != nil {
+		return ""
+	}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+
+	var req struct {
+		Model string `json:"model"`
+	}
+	_ = json.Unmarshal(body, &req)
+	return req.Model
+}
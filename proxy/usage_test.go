@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseUsage_JSON(t *testing.T) {
+	body := `{"object":"list","data":[],"usage":{"prompt_tokens":8,"total_tokens":8}}`
+	usage := ParseUsage([]byte(body))
+	if usage == nil {
+		t.Fatal("Expected usage to be parsed")
+	}
+	if usage.Prompt() != 8 || usage.Completion() != 0 || usage.Total() != 8 {
+		t.Errorf("Unexpected usage: %+v", usage)
+	}
+}
+
+func TestParseUsage_Stream(t *testing.T) {
+	body := "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}],\"usage\":null}\n\n" +
+		"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":5,\"completion_tokens\":7,\"total_tokens\":12}}\n\n" +
+		"data: [DONE]\n\n"
+	usage := ParseUsage([]byte(body))
+	if usage == nil {
+		t.Fatal("Expected usage to be parsed")
+	}
+	if usage.Prompt() != 5 || usage.Completion() != 7 {
+		t.Errorf("Unexpected usage: %+v", usage)
+	}
+
+	if ParseUsage([]byte("data: {\"usage\":null}\n\n")) != nil {
+		t.Error("Expected nil usage for null usage")
+	}
+}
+
+func TestParseUsage_Responses(t *testing.T) {
+	body := `{"id":"resp_1","usage":{"input_tokens":3,"output_tokens":4,"total_tokens":7}}`
+	usage := ParseUsage([]byte(body))
+	if usage == nil || usage.Prompt() != 3 || usage.Completion() != 4 {
+		t.Errorf("Unexpected usage: %+v", usage)
+	}
+}
+
+func TestPeekModel(t *testing.T) {
+	body := `{"model":"gpt-4o","messages":[]}`
+	req := httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	if model := PeekModel(req, 0); model != "gpt-4o" {
+		t.Errorf("Expected model 'gpt-4o', got '%s'", model)
+	}
+
+	restored, _ := io.ReadAll(req.Body)
+	if string(restored) != body {
+		t.Errorf("Expected body to be restored, got '%s'", string(restored))
+	}
+
+	// 超出上限时只读取上限内的部分，其余内容仍保留在请求体中
+	req = httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if model := PeekModel(req, 8); model != "" {
+		t.Errorf("Expected no model from truncated body, got '%s'", model)
+	}
+	if restored, _ := io.ReadAll(req.Body); string(restored) != body {
+		t.Errorf("Expected body to be restored, got '%s'", string(restored))
+	}
+}
@@ -0,0 +1,174 @@
+package realtime
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestTokenFromRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/openai/v1/realtime?model=gpt-4o-realtime-preview", nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Sec-WebSocket-Protocol", "realtime, openai-insecure-api-key.gateway-key, openai-beta.realtime-v1")
+
+	if token := TokenFromRequest(r); token != "gateway-key" {
+		t.Errorf("Expected token from subprotocol, got %q", token)
+	}
+	if protocols := Subprotocols(r); strings.Join(protocols, ",") != "realtime,openai-beta.realtime-v1" {
+		t.Errorf("Key subprotocol should not be forwarded, got %v", protocols)
+	}
+
+	r = httptest.NewRequest("GET", "/openai/v1/realtime?api_key=query-key", nil)
+	if token := TokenFromRequest(r); token != "" {
+		t.Errorf("Query token should only be accepted on upgrade requests, got %q", token)
+	}
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	if token := TokenFromRequest(r); token != "query-key" {
+		t.Errorf("Expected token from query, got %q", token)
+	}
+}
+
+func TestSession_Observe(t *testing.T) {
+	session := &Session{}
+	session.observe([]byte(`{"type":"session.created","session":{"model":"gpt-4o-realtime-preview"}}`))
+	session.observe([]byte(`{"type":"response.audio.delta","delta":"response.done"}`))
+	for i := 0; i < 2; i++ {
+		session.observe([]byte(`{"type":"response.done","response":{"usage":{"total_tokens":30,"input_tokens":20,"output_tokens":10,` +
+			`"input_token_details":{"cached_tokens":5,"text_tokens":8,"audio_tokens":12},"output_token_details":{"text_tokens":4,"audio_tokens":6}}}}`))
+	}
+
+	if session.Model != "gpt-4o-realtime-preview" {
+		t.Errorf("Expected model from session.created, got %q", session.Model)
+	}
+	want := Usage{Responses: 2, InputTokens: 40, OutputTokens: 20, InputTextTokens: 16, InputAudioTokens: 24,
+		CachedTokens: 10, OutputTextTokens: 8, OutputAudioTokens: 12}
+	if session.Usage != want {
+		t.Errorf("Expected %+v, got %+v", want, session.Usage)
+	}
+}
+
+// newUpstream 模拟 Realtime 上游：连接后发送 session.created，收到消息后回复 response.done
+func newUpstream(t *testing.T, headers chan<- http.Header) *httptest.Server {
+	upgrader := websocket.Upgrader{Subprotocols: []string{"realtime"}}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer upstream-key" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":{"message":"Incorrect API key provided","type":"invalid_request_error","code":"invalid_api_key"}}`))
+			return
+		}
+		headers <- r.Header.Clone()
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"session.created","session":{"model":"gpt-4o-realtime-preview"}}`))
+		for {
+			_, _, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"response.done","response":{"usage":{"input_tokens":7,"output_tokens":3}}}`))
+		}
+	}))
+}
+
+func TestRelay_Serve(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	upstream := newUpstream(t, headers)
+	defer upstream.Close()
+
+	sessions := make(chan *Session, 1)
+	relay := NewRelay(&Config{MaxDuration: time.Minute, MaxMessageBytes: 1 << 20, HandshakeTimeout: time.Second})
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := http.Header{"Authorization": {"Bearer " + r.URL.Query().Get("upstream")}}
+		if session := relay.Serve(w, r, "ws"+strings.TrimPrefix(upstream.URL, "http"), header); session != nil {
+			sessions <- session
+		}
+	}))
+	defer gateway.Close()
+
+	// 上游握手失败时以 OpenAI 格式返回错误
+	dialer := websocket.Dialer{Subprotocols: []string{"realtime", SUBPROTOCOL_API_KEY_PREFIX + "gateway-key"}}
+	_, resp, err := dialer.Dial("ws"+strings.TrimPrefix(gateway.URL, "http")+"?upstream=wrong", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 handshake failure, got %v", err)
+	}
+
+	conn, resp, err := dialer.Dial("ws"+strings.TrimPrefix(gateway.URL, "http")+"?upstream=upstream-key", nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	if protocol := resp.Header.Get("Sec-WebSocket-Protocol"); protocol != "realtime" {
+		t.Errorf("Expected negotiated subprotocol realtime, got %q", protocol)
+	}
+	if protocols := (<-headers).Get("Sec-WebSocket-Protocol"); strings.Contains(protocols, "gateway-key") {
+		t.Errorf("Gateway key forwarded to upstream: %s", protocols)
+	}
+
+	for i := 0; i < 3; i++ {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if i > 0 && !strings.Contains(string(message), "response.done") {
+			t.Errorf("Unexpected message: %s", message)
+		}
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"response.create"}`))
+	}
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	conn.Close()
+
+	select {
+	case session := <-sessions:
+		if session.CloseReason != CLOSE_CLIENT {
+			t.Errorf("Expected client_closed, got %s", session.CloseReason)
+		}
+		if session.Model != "gpt-4o-realtime-preview" || session.Usage.InputTokens < 14 || session.Usage.OutputTokens < 6 {
+			t.Errorf("Unexpected session accounting: %+v", session)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Session did not finish")
+	}
+}
+
+func TestRelay_MaxDuration(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	upstream := newUpstream(t, headers)
+	defer upstream.Close()
+
+	relay := NewRelay(&Config{MaxDuration: 100 * time.Millisecond, MaxMessageBytes: 1 << 20, HandshakeTimeout: time.Second})
+	sessions := make(chan *Session, 1)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessions <- relay.Serve(w, r, "ws"+strings.TrimPrefix(upstream.URL, "http"), http.Header{"Authorization": {"Bearer upstream-key"}})
+	}))
+	defer gateway.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(gateway.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	var closeErr *websocket.CloseError
+	for {
+		_, _, err := conn.ReadMessage()
+		if err != nil {
+			closeErr, _ = err.(*websocket.CloseError)
+			break
+		}
+	}
+	if closeErr == nil || closeErr.Code != websocket.ClosePolicyViolation {
+		t.Errorf("Expected policy violation close, got %v", closeErr)
+	}
+	if session := <-sessions; session == nil || session.CloseReason != CLOSE_MAX_DURATION {
+		t.Errorf("Expected max_duration, got %+v", session)
+	}
+}
@@ -0,0 +1,271 @@
+package realtime
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"openai-forward/apierror"
+	"openai-forward/logging"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// SUBPROTOCOL_API_KEY_PREFIX 浏览器无法为 WebSocket 设置请求头，与 OpenAI 一致通过该前缀的子协议传递密钥
+const SUBPROTOCOL_API_KEY_PREFIX = "openai-insecure-api-key."
+
+// TOKEN_QUERY_PARAM 也可通过该查询参数传递网关密钥
+const TOKEN_QUERY_PARAM = "api_key"
+
+// 会话结束原因
+const (
+	CLOSE_CLIENT       = "client_closed"
+	CLOSE_UPSTREAM     = "upstream_closed"
+	CLOSE_MAX_DURATION = "max_duration"
+	CLOSE_ERROR        = "error"
+)
+
+// closeTimeout 发送关闭帧的超时时间
+const closeTimeout = time.Second
+
+// Config Realtime 代理配置
+type Config struct {
+	// MaxDuration 单个会话的最长时长，超出时由网关关闭连接
+	MaxDuration time.Duration
+	// MaxMessageBytes 客户端单条消息的大小上限
+	MaxMessageBytes int64
+	// HandshakeTimeout 与上游握手的超时时间
+	HandshakeTimeout time.Duration
+}
+
+// LoadConfigFromEnv 从环境变量加载 Realtime 代理配置
+func LoadConfigFromEnv() *Config {
+	cfg := &Config{
+		MaxDuration:      30 * time.Minute,
+		MaxMessageBytes:  16 << 20,
+		HandshakeTimeout: 10 * time.Second,
+	}
+	if duration, err := time.ParseDuration(os.Getenv("REALTIME_MAX_DURATION")); err == nil && duration > 0 {
+		cfg.MaxDuration = duration
+	}
+	if maxBytes, err := strconv.ParseInt(os.Getenv("REALTIME_MAX_MESSAGE_BYTES"), 10, 64); err == nil && maxBytes > 0 {
+		cfg.MaxMessageBytes = maxBytes
+	}
+	return cfg
+}
+
+// TokenFromRequest 从子协议或查询参数中读取网关密钥，仅用于 WebSocket 升级请求
+func TokenFromRequest(r *http.Request) string {
+	if !websocket.IsWebSocketUpgrade(r) {
+		return ""
+	}
+	for _, protocol := range websocket.Subprotocols(r) {
+		if strings.HasPrefix(protocol, SUBPROTOCOL_API_KEY_PREFIX) {
+			return strings.TrimPrefix(protocol, SUBPROTOCOL_API_KEY_PREFIX)
+		}
+	}
+	return r.URL.Query().Get(TOKEN_QUERY_PARAM)
+}
+
+// Subprotocols 返回需要转发给上游的子协议，携带密钥的子协议不会转发
+func Subprotocols(r *http.Request) []string {
+	protocols := []string{}
+	for _, protocol := range websocket.Subprotocols(r) {
+		if !strings.HasPrefix(protocol, SUBPROTOCOL_API_KEY_PREFIX) {
+			protocols = append(protocols, protocol)
+		}
+	}
+	return protocols
+}
+
+// Session 一次 Realtime 会话的计量信息
+type Session struct {
+	Model          string
+	Usage          Usage
+	StartedAt      time.Time
+	EndedAt        time.Time
+	CloseReason    string
+	ClientMessages int
+	ServerMessages int
+}
+
+// Duration 会话时长
+func (s *Session) Duration() time.Duration {
+	return s.EndedAt.Sub(s.StartedAt)
+}
+
+// Relay 在客户端与上游之间转发 WebSocket 消息
+type Relay struct {
+	config *Config
+}
+
+// NewRelay 创建 WebSocket 转发器
+func NewRelay(cfg *Config) *Relay {
+	return &Relay{config: cfg}
+}
+
+// Serve 连接上游后升级客户端连接，双向转发消息直到任一端关闭或超出会话时长
+//
+// 与上游握手失败时不升级客户端连接，以 OpenAI 格式返回上游的错误并返回 nil。
+func (rl *Relay) Serve(w http.ResponseWriter, r *http.Request, target string, header http.Header) *Session {
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: rl.config.HandshakeTimeout,
+		Subprotocols:     Subprotocols(r),
+	}
+	upstream, resp, err := dialer.DialContext(r.Context(), target, header)
+	if err != nil {
+		if resp != nil {
+			data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+			_ = resp.Body.Close()
+			apierror.Write(w, apierror.FromUpstream(resp.StatusCode, resp.Header.Get("Content-Type"), data))
+			return nil
+		}
+		logging.Logger.Errorf("Failed to connect to realtime upstream: %v", err)
+		apierror.Write(w, apierror.UpstreamUnavailable())
+		return nil
+	}
+	defer upstream.Close()
+
+	upgrader := websocket.Upgrader{
+		// 网关已完成认证，允许浏览器跨域连接
+		CheckOrigin:  func(r *http.Request) bool { return true },
+		Subprotocols: selectSubprotocol(upstream.Subprotocol(), Subprotocols(r)),
+	}
+	client, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade 失败时已写入错误响应
+		logging.Logger.Warnf("Failed to upgrade realtime connection: %v", err)
+		return nil
+	}
+	defer client.Close()
+	client.SetReadLimit(rl.config.MaxMessageBytes)
+
+	session := &Session{StartedAt: time.Now()}
+	session.CloseReason = rl.pump(session, client, upstream)
+	session.EndedAt = time.Now()
+	return session
+}
+
+// selectSubprotocol 返回给客户端的子协议，上游未选择时沿用客户端提供的第一个，否则浏览器会拒绝连接
+func selectSubprotocol(negotiated string, offered []string) []string {
+	if negotiated != "" {
+		return []string{negotiated}
+	}
+	if len(offered) > 0 {
+		return offered[:1]
+	}
+	return nil
+}
+
+// relayResult 单向转发结束的原因
+type relayResult struct {
+	reason string
+	err    error
+}
+
+// pump 双向转发消息，返回会话结束原因
+func (rl *Relay) pump(session *Session, client *websocket.Conn, upstream *websocket.Conn) string {
+	results := make(chan relayResult, 2)
+
+	// 上游事件仅在该 goroutine 中解析，会话结束前 session 的其他字段不会被并发修改
+	go func() {
+		err := forward(upstream, client, func(messageType int, message []byte) {
+			session.ServerMessages++
+			if messageType == websocket.TextMessage {
+				session.observe(message)
+			}
+		})
+		results <- relayResult{reason: CLOSE_UPSTREAM, err: err}
+	}()
+	clientMessages := 0
+	go func() {
+		err := forward(client, upstream, func(int, []byte) {
+			clientMessages++
+		})
+		results <- relayResult{reason: CLOSE_CLIENT, err: err}
+	}()
+
+	timer := time.NewTimer(rl.config.MaxDuration)
+	defer timer.Stop()
+
+	var reason string
+	pending := 2
+	select {
+	case result := <-results:
+		pending--
+		reason = result.reason
+		if !isClose(result.err) {
+			reason = CLOSE_ERROR
+		}
+		// 将关闭帧转发给另一端
+		code, text := closeCode(result.err)
+		sendClose(client, code, text)
+		sendClose(upstream, code, text)
+	case <-timer.C:
+		reason = CLOSE_MAX_DURATION
+		sendClose(client, websocket.ClosePolicyViolation, "session duration limit exceeded")
+		sendClose(upstream, websocket.CloseNormalClosure, "")
+	}
+
+	// 等待对端确认关闭，超时后强制断开
+	drain := time.NewTimer(closeTimeout)
+	defer drain.Stop()
+	for pending > 0 {
+		select {
+		case <-results:
+			pending--
+		case <-drain.C:
+			_ = client.Close()
+			_ = upstream.Close()
+			for ; pending > 0; pending-- {
+				<-results
+			}
+		}
+	}
+
+	session.ClientMessages = clientMessages
+	return reason
+}
+
+// forward 将 src 的消息写入 dst，直到读取或写入失败
+func forward(src *websocket.Conn, dst *websocket.Conn, observe func(messageType int, message []byte)) error {
+	for {
+		messageType, message, err := src.ReadMessage()
+		if err != nil {
+			return err
+		}
+		observe(messageType, message)
+		err = dst.WriteMessage(messageType, message)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// isClose 是否为对端正常发起的关闭
+func isClose(err error) bool {
+	var closeErr *websocket.CloseError
+	return errors.As(err, &closeErr)
+}
+
+// closeCode 从关闭错误中取出关闭码，其他错误视为异常关闭
+func closeCode(err error) (int, string) {
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		switch closeErr.Code {
+		case websocket.CloseNoStatusReceived, websocket.CloseAbnormalClosure, websocket.CloseTLSHandshake:
+			return websocket.CloseNormalClosure, ""
+		}
+		return closeErr.Code, closeErr.Text
+	}
+	return websocket.CloseInternalServerErr, ""
+}
+
+// sendClose 发送关闭帧，WriteControl 可与其他写操作并发调用
+func sendClose(conn *websocket.Conn, code int, text string) {
+	_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(closeTimeout))
+}
@@ -0,0 +1,86 @@
+package realtime
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Usage 会话内累计的 token 用量，来自每个 response.done 事件
+type Usage struct {
+	Responses         int `json:"responses"`
+	InputTokens       int `json:"input_tokens"`
+	OutputTokens      int `json:"output_tokens"`
+	InputTextTokens   int `json:"input_text_tokens"`
+	InputAudioTokens  int `json:"input_audio_tokens"`
+	CachedTokens      int `json:"cached_tokens"`
+	OutputTextTokens  int `json:"output_text_tokens"`
+	OutputAudioTokens int `json:"output_audio_tokens"`
+}
+
+// serverEvent 上游事件中用于计量的字段
+type serverEvent struct {
+	Type    string `json:"type"`
+	Session *struct {
+		Model string `json:"model"`
+	} `json:"session"`
+	Response *struct {
+		Usage *struct {
+			InputTokens       int `json:"input_tokens"`
+			OutputTokens      int `json:"output_tokens"`
+			InputTokenDetails struct {
+				TextTokens   int `json:"text_tokens"`
+				AudioTokens  int `json:"audio_tokens"`
+				CachedTokens int `json:"cached_tokens"`
+			} `json:"input_token_details"`
+			OutputTokenDetails struct {
+				TextTokens  int `json:"text_tokens"`
+				AudioTokens int `json:"audio_tokens"`
+			} `json:"output_token_details"`
+		} `json:"usage"`
+	} `json:"response"`
+}
+
+// eventMarkers 需要解析的事件类型，其余事件（如音频分片）不做 JSON 解析
+var eventMarkers = [][]byte{
+	[]byte(`"response.done"`),
+	[]byte(`"session.created"`),
+	[]byte(`"session.updated"`),
+}
+
+// observe 解析上游事件，累计 response.done 的用量并记录会话使用的模型
+func (s *Session) observe(message []byte) {
+	matched := false
+	for _, marker := range eventMarkers {
+		if bytes.Contains(message, marker) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return
+	}
+
+	var event serverEvent
+	if json.Unmarshal(message, &event) != nil {
+		return
+	}
+	switch event.Type {
+	case "session.created", "session.updated":
+		if event.Session != nil && event.Session.Model != "" {
+			s.Model = event.Session.Model
+		}
+	case "response.done":
+		if event.Response == nil || event.Response.Usage == nil {
+			return
+		}
+		usage := event.Response.Usage
+		s.Usage.Responses++
+		s.Usage.InputTokens += usage.InputTokens
+		s.Usage.OutputTokens += usage.OutputTokens
+		s.Usage.InputTextTokens += usage.InputTokenDetails.TextTokens
+		s.Usage.InputAudioTokens += usage.InputTokenDetails.AudioTokens
+		s.Usage.CachedTokens += usage.InputTokenDetails.CachedTokens
+		s.Usage.OutputTextTokens += usage.OutputTokenDetails.TextTokens
+		s.Usage.OutputAudioTokens += usage.OutputTokenDetails.AudioTokens
+	}
+}
@@ -0,0 +1,129 @@
+package redact
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Detector 敏感信息检测规则
+type Detector struct {
+	Name    string
+	Pattern *regexp.Regexp
+	// Validate 对匹配结果做进一步校验（如信用卡号的 Luhn 校验），为空时全部替换
+	Validate func(match string) bool
+}
+
+// builtinDetectors 内置检测规则
+var builtinDetectors = []*Detector{
+	{Name: "api_key", Pattern: regexp.MustCompile(`\bsk-[A-Za-z0-9_\-]{20,}`)},
+	{Name: "bearer_token", Pattern: regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/=\-]{16,}`)},
+	{Name: "aws_key", Pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{Name: "aws_secret_key", Pattern: regexp.MustCompile(`(?i)\baws.{0,20}?(?:secret|private).{0,20}?[:=]\s*["']?[A-Za-z0-9/+]{40}`)},
+	{Name: "github_token", Pattern: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})`)},
+	{Name: "slack_token", Pattern: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9\-]{10,}`)},
+	{Name: "private_key", Pattern: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)},
+	{Name: "email", Pattern: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)},
+	{Name: "credit_card", Pattern: regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`), Validate: luhnValid},
+	{Name: "cn_id", Pattern: regexp.MustCompile(`\b\d{17}[\dXx]\b`)},
+	{Name: "cn_phone", Pattern: regexp.MustCompile(`\b1[3-9]\d{9}\b`)},
+	// phone 带分隔符的北美格式号码或以 + 开头的国际号码，不匹配不带分隔符的普通数字
+	{Name: "phone", Pattern: regexp.MustCompile(`(?:\+[1-9]\d{0,2}[ .\-]?)?(?:\(\d{3}\)[ .\-]?|\b\d{3}[ .\-])\d{3}[ .\-]\d{4}\b|\+[1-9]\d{7,14}\b`)},
+}
+
+// BuiltinNames 返回所有内置检测规则名称
+func BuiltinNames() []string {
+	names := make([]string, 0, len(builtinDetectors))
+	for _, detector := range builtinDetectors {
+		names = append(names, detector.Name)
+	}
+	return names
+}
+
+// Redactor 按检测规则将敏感信息替换为 [REDACTED:<name>]
+type Redactor struct {
+	detectors []*Detector
+}
+
+// New 创建 Redactor，builtin 为启用的内置规则名称（"all" 表示全部），patterns 为自定义正则表达式
+func New(builtin []string, patterns []string) (*Redactor, error) {
+	r := &Redactor{}
+
+	for _, name := range builtin {
+		name = strings.TrimSpace(name)
+		if name == "" || name == "none" {
+			continue
+		}
+		found := false
+		for _, detector := range builtinDetectors {
+			if name == "all" || detector.Name == name {
+				r.detectors = append(r.detectors, detector)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown redaction detector: %s", name)
+		}
+	}
+
+	for i, pattern := range patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", pattern, err)
+		}
+		r.detectors = append(r.detectors, &Detector{Name: fmt.Sprintf("pattern_%d", i+1), Pattern: compiled})
+	}
+
+	return r, nil
+}
+
+// Empty 判断是否未启用任何规则
+func (r *Redactor) Empty() bool {
+	return r == nil || len(r.detectors) == 0
+}
+
+// Redact 替换文本中的敏感信息，返回替换后的文本及各规则的命中次数
+func (r *Redactor) Redact(text string) (string, map[string]int) {
+	if r.Empty() || text == "" {
+		return text, nil
+	}
+
+	var hits map[string]int
+	for _, detector := range r.detectors {
+		text = detector.Pattern.ReplaceAllStringFunc(text, func(match string) string {
+			if detector.Validate != nil && !detector.Validate(match) {
+				return match
+			}
+			if hits == nil {
+				hits = make(map[string]int)
+			}
+			hits[detector.Name]++
+			return "[REDACTED:" + detector.Name + "]"
+		})
+	}
+	return text, hits
+}
+
+// luhnValid 使用 Luhn 算法校验卡号，避免误伤普通数字
+func luhnValid(match string) bool {
+	sum := 0
+	digits := 0
+	double := false
+	for i := len(match) - 1; i >= 0; i-- {
+		c := match[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+		double = !double
+	}
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
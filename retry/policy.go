@@ -0,0 +1,193 @@
+package retry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"openai-forward/logging"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy 重试策略
+type Policy struct {
+	// MaxAttempts 最多请求次数（含首次），为 1 时不重试
+	MaxAttempts int
+	// BaseDelay 首次重试前的退避时间，之后每次翻倍
+	BaseDelay time.Duration
+	// MaxDelay 单次退避时间上限
+	MaxDelay time.Duration
+	// Deadline 从首次请求开始计算的总时长上限，超出时不再重试
+	Deadline time.Duration
+	// Statuses 需要重试的上游状态码
+	Statuses []int
+}
+
+// Retryable 状态码是否需要重试
+func (p *Policy) Retryable(status int) bool {
+	for _, s := range p.Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff 第 attempt 次重试（从 1 开始）前的退避时间，在 [d/2, d] 之间随机抖动
+func (p *Policy) Backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// policyOverride 配置中覆盖的策略字段
+type policyOverride struct {
+	MaxAttempts *int   `json:"max_attempts"`
+	BaseDelay   string `json:"base_delay"`
+	MaxDelay    string `json:"max_delay"`
+	Deadline    string `json:"deadline"`
+	Statuses    []int  `json:"statuses"`
+}
+
+func (o *policyOverride) apply(p Policy) (Policy, error) {
+	if o.MaxAttempts != nil {
+		p.MaxAttempts = *o.MaxAttempts
+	}
+	for _, field := range []struct {
+		value  string
+		target *time.Duration
+	}{{o.BaseDelay, &p.BaseDelay}, {o.MaxDelay, &p.MaxDelay}, {o.Deadline, &p.Deadline}} {
+		if field.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(field.value)
+		if err != nil {
+			return p, err
+		}
+		*field.target = duration
+	}
+	if o.Statuses != nil {
+		p.Statuses = o.Statuses
+	}
+	return p, nil
+}
+
+// createRoutes 创建资源的接口，POST 请求重试可能在上游重复创建文件、批处理或运行，默认不重试
+var createRoutes = map[string]bool{
+	"files":         true,
+	"uploads":       true,
+	"batches":       true,
+	"assistants":    true,
+	"threads":       true,
+	"vector_stores": true,
+	"fine_tuning":   true,
+}
+
+// Config 重试配置，Overrides 依次按 "上游"、"接口"、"上游/接口" 覆盖默认策略，后者优先
+type Config struct {
+	Default   Policy
+	Overrides map[string]*policyOverride
+}
+
+// LoadConfigFromEnv 从环境变量加载重试配置
+func LoadConfigFromEnv() *Config {
+	cfg := &Config{
+		Default: Policy{
+			MaxAttempts: 3,
+			BaseDelay:   500 * time.Millisecond,
+			MaxDelay:    10 * time.Second,
+			Deadline:    30 * time.Second,
+			Statuses:    []int{http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable},
+		},
+		Overrides: map[string]*policyOverride{},
+	}
+
+	if maxAttempts, err := strconv.Atoi(os.Getenv("RETRY_MAX_ATTEMPTS")); err == nil && maxAttempts > 0 {
+		cfg.Default.MaxAttempts = maxAttempts
+	}
+	for name, target := range map[string]*time.Duration{
+		"RETRY_BASE_DELAY": &cfg.Default.BaseDelay,
+		"RETRY_MAX_DELAY":  &cfg.Default.MaxDelay,
+		"RETRY_DEADLINE":   &cfg.Default.Deadline,
+	} {
+		if duration, err := time.ParseDuration(os.Getenv(name)); err == nil && duration >= 0 {
+			*target = duration
+		}
+	}
+	if statuses := os.Getenv("RETRY_STATUSES"); statuses != "" {
+		cfg.Default.Statuses = nil
+		for _, value := range strings.Split(statuses, ",") {
+			status, err := strconv.Atoi(strings.TrimSpace(value))
+			if err == nil {
+				cfg.Default.Statuses = append(cfg.Default.Statuses, status)
+			}
+		}
+	}
+	if policies := os.Getenv("RETRY_POLICIES"); policies != "" {
+		err := json.Unmarshal([]byte(policies), &cfg.Overrides)
+		if err != nil {
+			logging.Logger.Errorf("Failed to parse RETRY_POLICIES: %v", err)
+		}
+	}
+
+	return cfg
+}
+
+// Policy 返回请求方法、上游及接口对应的重试策略
+//
+// 创建资源的 POST 请求默认只请求一次，仅 "接口" 或 "上游/接口" 的覆盖配置可以重新开启重试。
+func (c *Config) Policy(method string, upstream string, route string) Policy {
+	policy := c.Default
+	for i, key := range []string{upstream, route, upstream + "/" + route} {
+		if i == 1 && method == http.MethodPost && createRoutes[route] {
+			policy.MaxAttempts = 1
+		}
+		override, ok := c.Overrides[key]
+		if !ok {
+			continue
+		}
+		var err error
+		policy, err = override.apply(policy)
+		if err != nil {
+			logging.Logger.Errorf("Invalid retry policy %s: %v", key, err)
+		}
+	}
+	return policy
+}
+
+// String 返回重试配置的简要描述
+func (c *Config) String() string {
+	keys := make([]string, 0, len(c.Overrides))
+	for key := range c.Overrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return fmt.Sprintf("max_attempts=%d deadline=%s overrides=%s", c.Default.MaxAttempts, c.Default.Deadline, strings.Join(keys, ","))
+}
+
+type policyContextKey struct{}
+
+// WithPolicy 在请求上下文中设置重试策略，Transport 据此决定是否重试
+func WithPolicy(ctx context.Context, policy Policy) context.Context {
+	return context.WithValue(ctx, policyContextKey{}, &policy)
+}
+
+// PolicyFromContext 获取请求上下文中的重试策略
+func PolicyFromContext(ctx context.Context) *Policy {
+	policy, _ := ctx.Value(policyContextKey{}).(*Policy)
+	return policy
+}
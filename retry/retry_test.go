@@ -0,0 +1,177 @@
+package retry
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testPolicy() Policy {
+	return Policy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    5 * time.Millisecond,
+		Deadline:    time.Second,
+		Statuses:    []int{http.StatusTooManyRequests, http.StatusServiceUnavailable},
+	}
+}
+
+func send(t *testing.T, url string, policy Policy, body string) *http.Response {
+	req, err := http.NewRequestWithContext(WithPolicy(context.Background(), policy), "POST", url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	client := &http.Client{Transport: NewTransport(nil, "openai")}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	return resp
+}
+
+func TestTransport_RetriesWithReplayedBody(t *testing.T) {
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != `{"model":"gpt-4o"}` {
+			t.Errorf("Expected body to be replayed, got '%s'", body)
+		}
+		if attempts < 3 {
+			w.Header().Set("Retry-After-Ms", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = io.WriteString(w, "OK")
+	}))
+	defer ts.Close()
+
+	resp := send(t, ts.URL, testPolicy(), `{"model":"gpt-4o"}`)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get(RetriesHeader) != "2" {
+		t.Errorf("Expected 2 retries, got '%s'", resp.Header.Get(RetriesHeader))
+	}
+}
+
+func TestTransport_GivesUp(t *testing.T) {
+	testCases := []struct {
+		name     string
+		status   int
+		body     string
+		header   string
+		policy   func(p *Policy)
+		attempts int
+	}{
+		{"max attempts", http.StatusServiceUnavailable, "", "", nil, 3},
+		{"not retryable", http.StatusBadRequest, "", "", nil, 1},
+		{"insufficient quota", http.StatusTooManyRequests, `{"error":{"code":"insufficient_quota"}}`, "", nil, 1},
+		{"retry after beyond deadline", http.StatusTooManyRequests, "", "60", nil, 1},
+		{"disabled", http.StatusServiceUnavailable, "", "", func(p *Policy) { p.MaxAttempts = 1 }, 1},
+	}
+
+	for _, tc := range testCases {
+		attempts := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			if tc.header != "" {
+				w.Header().Set("Retry-After", tc.header)
+			}
+			w.WriteHeader(tc.status)
+			_, _ = io.WriteString(w, tc.body)
+		}))
+
+		policy := testPolicy()
+		if tc.policy != nil {
+			tc.policy(&policy)
+		}
+		resp := send(t, ts.URL, policy, "{}")
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		ts.Close()
+
+		if attempts != tc.attempts {
+			t.Errorf("%s: expected %d attempts, got %d", tc.name, tc.attempts, attempts)
+		}
+		if resp.StatusCode != tc.status || string(body) != tc.body {
+			t.Errorf("%s: expected upstream response to be returned, got %d '%s'", tc.name, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	header := http.Header{}
+	header.Set("Retry-After", "2")
+	if delay := RetryAfter(header); delay != 2*time.Second {
+		t.Errorf("Expected 2s, got %s", delay)
+	}
+	header.Set("Retry-After-Ms", "150")
+	if delay := RetryAfter(header); delay != 150*time.Millisecond {
+		t.Errorf("Expected retry-after-ms to take precedence, got %s", delay)
+	}
+
+	header = http.Header{}
+	header.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	if delay := RetryAfter(header); delay != maxRetryAfter {
+		t.Errorf("Expected delay to be capped, got %s", delay)
+	}
+}
+
+func TestConfig_Policy(t *testing.T) {
+	maxAttempts := 5
+	cfg := &Config{
+		Default: testPolicy(),
+		Overrides: map[string]*policyOverride{
+			"azure":                  {Deadline: "1m"},
+			"azure/chat/completions": {MaxAttempts: &maxAttempts},
+		},
+	}
+
+	policy := cfg.Policy(http.MethodPost, "azure", "chat/completions")
+	if policy.MaxAttempts != 5 || policy.Deadline != time.Minute {
+		t.Errorf("Unexpected policy: %+v", policy)
+	}
+	if policy := cfg.Policy(http.MethodPost, "openai", "chat/completions"); policy.MaxAttempts != 3 {
+		t.Errorf("Expected default policy, got %+v", policy)
+	}
+
+	backoff := policy.Backoff(10)
+	if backoff < policy.MaxDelay/2 || backoff > policy.MaxDelay {
+		t.Errorf("Expected backoff to be capped, got %s", backoff)
+	}
+}
+
+func TestConfig_PolicyCreateRoutes(t *testing.T) {
+	maxAttempts := 4
+	cfg := &Config{
+		Default: testPolicy(),
+		Overrides: map[string]*policyOverride{
+			"openai":         {MaxAttempts: &maxAttempts},
+			"openai/batches": {MaxAttempts: &maxAttempts},
+		},
+	}
+
+	tests := []struct {
+		method   string
+		route    string
+		expected int
+	}{
+		{http.MethodPost, "files", 1},
+		{http.MethodPost, "threads", 1},
+		{http.MethodGet, "files", 4},
+		{http.MethodPost, "chat/completions", 4},
+		{http.MethodPost, "batches", 4},
+	}
+	for _, tt := range tests {
+		if policy := cfg.Policy(tt.method, "openai", tt.route); policy.MaxAttempts != tt.expected {
+			t.Errorf("%s %s: expected %d attempts, got %d", tt.method, tt.route, tt.expected, policy.MaxAttempts)
+		}
+	}
+}
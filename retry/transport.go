@@ -0,0 +1,150 @@
+package retry
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"openai-forward/logging"
+	"openai-forward/metrics"
+	"strconv"
+	"time"
+)
+
+// RetriesHeader 响应头，记录本次请求对上游的重试次数
+const RetriesHeader = "X-Upstream-Retries"
+
+// maxReplayBytes 为重试缓存的请求体大小上限，超出时不重试
+const maxReplayBytes = 32 << 20
+
+// maxRetryAfter 采信的 Retry-After 上限，避免上游返回异常值时长时间等待
+const maxRetryAfter = 5 * time.Minute
+
+// Transport 在上游返回可重试状态码时按请求上下文中的策略重试
+//
+// 重试发生在 RoundTrip 返回之前，此时尚未向客户端写入任何响应内容。
+type Transport struct {
+	base     http.RoundTripper
+	upstream string
+}
+
+// NewTransport 创建重试 Transport，base 为空时使用 http.DefaultTransport
+func NewTransport(base http.RoundTripper, upstream string) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{base: base, upstream: upstream}
+}
+
+// RoundTrip 实现 http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	policy := PolicyFromContext(req.Context())
+	if policy == nil || policy.MaxAttempts <= 1 {
+		return t.base.RoundTrip(req)
+	}
+
+	body, ok := bufferBody(req)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		if body != nil {
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			req.GetBody = func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(body)), nil
+			}
+		}
+
+		resp, err := t.base.RoundTrip(req)
+		if err != nil || attempt >= policy.MaxAttempts || !policy.Retryable(resp.StatusCode) || !retryableBody(resp) {
+			if resp != nil && attempt > 1 {
+				resp.Header.Set(RetriesHeader, strconv.Itoa(attempt-1))
+			}
+			return resp, err
+		}
+
+		delay := policy.Backoff(attempt)
+		if retryAfter := RetryAfter(resp.Header); retryAfter > delay {
+			delay = retryAfter
+		}
+		if time.Since(start)+delay > policy.Deadline {
+			if attempt > 1 {
+				resp.Header.Set(RetriesHeader, strconv.Itoa(attempt-1))
+			}
+			return resp, nil
+		}
+
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		_ = resp.Body.Close()
+
+		reason := strconv.Itoa(resp.StatusCode)
+		metrics.UpstreamRetries.WithLabelValues(t.upstream, reason).Inc()
+		logging.Logger.Warnf("Upstream %s returned %d for %s, retrying in %s (attempt %d/%d)",
+			t.upstream, resp.StatusCode, req.URL.Path, delay, attempt+1, policy.MaxAttempts)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// bufferBody 读取请求体以便重试时重放，请求体过大时恢复请求体并返回 false
+func bufferBody(req *http.Request) ([]byte, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true
+	}
+	if req.ContentLength > maxReplayBytes {
+		return nil, false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxReplayBytes+1))
+	if err != nil || len(body) > maxReplayBytes {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		return nil, false
+	}
+	_ = req.Body.Close()
+	return body, true
+}
+
+// retryableBody 检查错误响应内容，额度耗尽（insufficient_quota）的 429 不会因重试而恢复
+func retryableBody(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return true
+	}
+	head, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), resp.Body), resp.Body}
+	return !bytes.Contains(head, []byte("insufficient_quota"))
+}
+
+// RetryAfter 解析 retry-after-ms 与 Retry-After（秒数或 HTTP 日期）响应头
+func RetryAfter(header http.Header) time.Duration {
+	var delay time.Duration
+	if ms, err := strconv.ParseFloat(header.Get("Retry-After-Ms"), 64); err == nil && ms > 0 {
+		delay = time.Duration(ms * float64(time.Millisecond))
+	} else if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+			delay = time.Duration(seconds * float64(time.Second))
+		} else if date, err := http.ParseTime(value); err == nil {
+			delay = time.Until(date)
+		}
+	}
+
+	if delay < 0 {
+		return 0
+	}
+	if delay > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return delay
+}
@@ -0,0 +1,179 @@
+package scheduler
+
+import (
+	"context"
+	"openai-forward/metrics"
+	"sync"
+	"time"
+)
+
+// waiter 等待并发槽位的请求
+type waiter struct {
+	priority string
+	user     string
+	ready    chan struct{}
+	granted  bool
+}
+
+// class 同一优先级的等待请求，按用户分组，用户之间轮转以免单个用户的大量请求占满槽位
+type class struct {
+	users   []string
+	waiters map[string][]*waiter
+	// current 平滑加权轮询的当前值
+	current int
+}
+
+// queue 单个上游的并发槽位与等待队列
+type queue struct {
+	upstream string
+	limit    int
+	maxQueue int
+	weights  map[string]int
+	mu       sync.Mutex
+	running  int
+	waiting  int
+	classes  map[string]*class
+}
+
+func newQueue(upstream string, limit int, maxQueue int, weights map[string]int) *queue {
+	q := &queue{upstream: upstream, limit: limit, maxQueue: maxQueue, weights: weights, classes: map[string]*class{}}
+	for _, priority := range priorities {
+		q.classes[priority] = &class{waiters: map[string][]*waiter{}}
+	}
+	return q
+}
+
+// acquire 申请槽位，有空闲槽位且无人排队时立即返回，否则排队等待调度
+func (q *queue) acquire(ctx context.Context, priority string, user string, maxWait time.Duration) (*Ticket, error) {
+	start := time.Now()
+	q.mu.Lock()
+	if q.running < q.limit && q.waiting == 0 {
+		q.running++
+		q.mu.Unlock()
+		metrics.QueueWait.WithLabelValues(q.upstream, priority).Observe(0)
+		return q.ticket(priority, 0), nil
+	}
+	if q.waiting >= q.maxQueue {
+		q.mu.Unlock()
+		metrics.QueueRejected.WithLabelValues(q.upstream, priority, "queue_full").Inc()
+		return nil, ErrQueueFull
+	}
+	w := &waiter{priority: priority, user: user, ready: make(chan struct{})}
+	q.push(w)
+	q.mu.Unlock()
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-w.ready:
+	case <-timer.C:
+		err = ErrQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	wait := time.Since(start)
+	metrics.QueueWait.WithLabelValues(q.upstream, priority).Observe(wait.Seconds())
+	if err != nil {
+		q.mu.Lock()
+		granted := w.granted
+		if !granted {
+			q.remove(w)
+		}
+		q.mu.Unlock()
+		if !granted {
+			if err == ErrQueueTimeout {
+				metrics.QueueRejected.WithLabelValues(q.upstream, priority, "queue_timeout").Inc()
+			}
+			return nil, err
+		}
+		// 超时的同时已获得槽位，继续执行
+	}
+	return q.ticket(priority, wait), nil
+}
+
+// ticket 创建归还槽位时调度下一个请求的 Ticket
+func (q *queue) ticket(priority string, wait time.Duration) *Ticket {
+	return &Ticket{Priority: priority, Wait: wait, release: func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		q.running--
+		q.dispatch()
+	}}
+}
+
+// push 将请求加入所属优先级及用户的队尾，调用方需持有锁
+func (q *queue) push(w *waiter) {
+	c := q.classes[w.priority]
+	if len(c.waiters[w.user]) == 0 {
+		c.users = append(c.users, w.user)
+	}
+	c.waiters[w.user] = append(c.waiters[w.user], w)
+	q.waiting++
+	metrics.QueueDepth.WithLabelValues(q.upstream, w.priority).Inc()
+}
+
+// remove 移除放弃等待的请求，调用方需持有锁
+func (q *queue) remove(w *waiter) {
+	c := q.classes[w.priority]
+	waiters := c.waiters[w.user]
+	for i, other := range waiters {
+		if other == w {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(c.waiters, w.user)
+		for i, user := range c.users {
+			if user == w.user {
+				c.users = append(c.users[:i], c.users[i+1:]...)
+				break
+			}
+		}
+		if len(c.users) == 0 {
+			// 没有等待请求的优先级不参与轮询，重新排队时从头计算
+			c.current = 0
+		}
+	} else {
+		c.waiters[w.user] = waiters
+	}
+	q.waiting--
+	metrics.QueueDepth.WithLabelValues(q.upstream, w.priority).Dec()
+}
+
+// dispatch 将空闲槽位分配给等待的请求，调用方需持有锁
+func (q *queue) dispatch() {
+	for q.running < q.limit && q.waiting > 0 {
+		w := q.next()
+		q.remove(w)
+		w.granted = true
+		q.running++
+		close(w.ready)
+	}
+}
+
+// next 按平滑加权轮询选择优先级，再在该优先级内按用户轮转选择最早等待的请求，调用方需持有锁且队列非空
+func (q *queue) next() *waiter {
+	var selected *class
+	total := 0
+	for _, priority := range priorities {
+		c := q.classes[priority]
+		if len(c.users) == 0 {
+			continue
+		}
+		weight := max(q.weights[priority], 1)
+		total += weight
+		c.current += weight
+		if selected == nil || c.current > selected.current {
+			selected = c
+		}
+	}
+	selected.current -= total
+
+	user := selected.users[0]
+	// 轮转到队尾，remove 会在该用户没有其他等待请求时将其移出
+	selected.users = append(selected.users[1:], user)
+	return selected.waiters[user][0]
+}
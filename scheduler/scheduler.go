@@ -0,0 +1,187 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 请求优先级，从高到低
+const (
+	PRIORITY_HIGH   = "high"
+	PRIORITY_NORMAL = "normal"
+	PRIORITY_LOW    = "low"
+)
+
+// priorities 按从高到低排列的优先级
+var priorities = []string{PRIORITY_HIGH, PRIORITY_NORMAL, PRIORITY_LOW}
+
+var (
+	// ErrQueueFull 等待的请求数已达上限
+	ErrQueueFull = errors.New("upstream queue is full")
+	// ErrQueueTimeout 请求等待超过最长排队时间
+	ErrQueueTimeout = errors.New("request waited too long in upstream queue")
+)
+
+// Valid 判断是否为有效的优先级
+func Valid(priority string) bool {
+	return rank(priority) >= 0
+}
+
+// rank 返回优先级的序号，越小越优先，无效的优先级返回 -1
+func rank(priority string) int {
+	for i, p := range priorities {
+		if p == priority {
+			return i
+		}
+	}
+	return -1
+}
+
+// Lower 返回两个优先级中较低的一个，无效的优先级被忽略
+func Lower(a string, b string) string {
+	if rank(b) > rank(a) {
+		return b
+	}
+	return a
+}
+
+// Config 上游调度配置
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// MaxConcurrency 每个实例对每个上游同时转发的请求数
+	MaxConcurrency int `json:"max_concurrency"`
+	// Concurrency 按上游覆盖 MaxConcurrency
+	Concurrency map[string]int `json:"concurrency"`
+	// MaxQueue 每个上游等待的请求数上限，超出时直接拒绝
+	MaxQueue int `json:"max_queue"`
+	// MaxWait 请求最长排队时间，超时后返回 503
+	MaxWait time.Duration `json:"max_wait"`
+	// DefaultPriority 密钥与团队均未指定优先级时使用的优先级
+	DefaultPriority string `json:"default_priority"`
+	// Weights 各优先级获得空闲槽位的权重，高优先级请求较多时低优先级请求仍能按比例执行
+	Weights map[string]int `json:"weights"`
+}
+
+// LoadConfigFromEnv 从环境变量加载上游调度配置，SCHEDULER_MAX_CONCURRENCY 为 0（默认）时不启用
+func LoadConfigFromEnv() (*Config, error) {
+	cfg := &Config{
+		Concurrency:     map[string]int{},
+		MaxQueue:        256,
+		MaxWait:         30 * time.Second,
+		DefaultPriority: PRIORITY_NORMAL,
+		Weights:         map[string]int{PRIORITY_HIGH: 8, PRIORITY_NORMAL: 4, PRIORITY_LOW: 1},
+	}
+	if concurrency, err := strconv.Atoi(os.Getenv("SCHEDULER_MAX_CONCURRENCY")); err == nil && concurrency > 0 {
+		cfg.MaxConcurrency = concurrency
+	}
+	if maxQueue, err := strconv.Atoi(os.Getenv("SCHEDULER_MAX_QUEUE")); err == nil && maxQueue >= 0 {
+		cfg.MaxQueue = maxQueue
+	}
+	if maxWait, err := time.ParseDuration(os.Getenv("SCHEDULER_MAX_WAIT")); err == nil && maxWait > 0 {
+		cfg.MaxWait = maxWait
+	}
+	if value := os.Getenv("SCHEDULER_CONCURRENCY"); value != "" {
+		if err := json.Unmarshal([]byte(value), &cfg.Concurrency); err != nil {
+			return cfg, fmt.Errorf("invalid SCHEDULER_CONCURRENCY: %w", err)
+		}
+	}
+	if value := strings.ToLower(os.Getenv("SCHEDULER_DEFAULT_PRIORITY")); value != "" {
+		if !Valid(value) {
+			return cfg, fmt.Errorf("invalid SCHEDULER_DEFAULT_PRIORITY: %s", value)
+		}
+		cfg.DefaultPriority = value
+	}
+	if value := os.Getenv("SCHEDULER_WEIGHTS"); value != "" {
+		weights := map[string]int{}
+		if err := json.Unmarshal([]byte(value), &weights); err != nil {
+			return cfg, fmt.Errorf("invalid SCHEDULER_WEIGHTS: %w", err)
+		}
+		for priority, weight := range weights {
+			if !Valid(priority) || weight <= 0 {
+				return cfg, fmt.Errorf("invalid SCHEDULER_WEIGHTS: %s=%d", priority, weight)
+			}
+			cfg.Weights[priority] = weight
+		}
+	}
+	cfg.Enabled = cfg.MaxConcurrency > 0 || len(cfg.Concurrency) > 0
+	return cfg, nil
+}
+
+// String 返回上游调度配置的简要描述
+func (c *Config) String() string {
+	return fmt.Sprintf("max_concurrency=%d concurrency=%v max_queue=%d max_wait=%s default_priority=%s weights=%v",
+		c.MaxConcurrency, c.Concurrency, c.MaxQueue, c.MaxWait, c.DefaultPriority, c.Weights)
+}
+
+// Limit 上游的并发上限，0 表示不限制
+func (c *Config) Limit(upstream string) int {
+	if limit, ok := c.Concurrency[upstream]; ok {
+		return limit
+	}
+	return c.MaxConcurrency
+}
+
+// Scheduler 在每个上游前维护一个有界的等待队列，按优先级权重及用户轮转分配并发槽位
+type Scheduler struct {
+	config *Config
+	mu     sync.Mutex
+	queues map[string]*queue
+}
+
+// New 创建上游调度器
+func New(cfg *Config) *Scheduler {
+	return &Scheduler{config: cfg, queues: map[string]*queue{}}
+}
+
+// Acquire 为请求申请上游并发槽位，等待期间 ctx 取消、队列已满或等待超时时返回错误。
+// 返回的 Ticket 记录排队时间，请求结束后必须调用 Release；上游不限制并发时立即返回。
+func (s *Scheduler) Acquire(ctx context.Context, upstream string, priority string, user string) (*Ticket, error) {
+	if !Valid(priority) {
+		priority = s.config.DefaultPriority
+	}
+	q := s.queue(upstream)
+	if q == nil {
+		return &Ticket{Priority: priority}, nil
+	}
+	return q.acquire(ctx, priority, user, s.config.MaxWait)
+}
+
+// queue 返回上游对应的队列，上游不限制并发时返回 nil
+func (s *Scheduler) queue(upstream string) *queue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if q, ok := s.queues[upstream]; ok {
+		return q
+	}
+	var q *queue
+	if limit := s.config.Limit(upstream); limit > 0 {
+		q = newQueue(upstream, limit, s.config.MaxQueue, s.config.Weights)
+	}
+	s.queues[upstream] = q
+	return q
+}
+
+// Ticket 已获得的上游并发槽位
+type Ticket struct {
+	// Priority 调度时使用的优先级
+	Priority string
+	// Wait 排队等待的时间
+	Wait    time.Duration
+	release func()
+	once    sync.Once
+}
+
+// Release 归还并发槽位，可重复调用
+func (t *Ticket) Release() {
+	if t == nil || t.release == nil {
+		return
+	}
+	t.once.Do(t.release)
+}
@@ -0,0 +1,165 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestScheduler(limit int, maxQueue int, maxWait time.Duration) *Scheduler {
+	return New(&Config{
+		Enabled:         true,
+		MaxConcurrency:  limit,
+		MaxQueue:        maxQueue,
+		MaxWait:         maxWait,
+		DefaultPriority: PRIORITY_NORMAL,
+		Weights:         map[string]int{PRIORITY_HIGH: 8, PRIORITY_NORMAL: 4, PRIORITY_LOW: 1},
+	})
+}
+
+// enqueue 在后台申请槽位，等待其进入队列后返回，获得槽位时将标识写入 order 并立即归还
+func enqueue(t *testing.T, s *Scheduler, wg *sync.WaitGroup, mu *sync.Mutex, order *[]string, priority string, user string, id string) {
+	q := s.queue("openai")
+	q.mu.Lock()
+	waiting := q.waiting
+	q.mu.Unlock()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticket, err := s.Acquire(context.Background(), "openai", priority, user)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", id, err)
+			return
+		}
+		mu.Lock()
+		*order = append(*order, id)
+		mu.Unlock()
+		ticket.Release()
+	}()
+
+	for {
+		q.mu.Lock()
+		queued := q.waiting > waiting
+		q.mu.Unlock()
+		if queued {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestScheduler_FairAcrossUsers(t *testing.T) {
+	s := newTestScheduler(1, 10, time.Second)
+	blocker, err := s.Acquire(context.Background(), "openai", PRIORITY_NORMAL, "blocker")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	order := []string{}
+	for _, id := range []string{"a1", "a2", "a3"} {
+		enqueue(t, s, &wg, &mu, &order, PRIORITY_NORMAL, "alice", id)
+	}
+	enqueue(t, s, &wg, &mu, &order, PRIORITY_NORMAL, "bob", "b1")
+	blocker.Release()
+	wg.Wait()
+
+	// bob 的请求不必等待 alice 先入队的全部请求
+	expected := []string{"a1", "b1", "a2", "a3"}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("Expected order %v, got %v", expected, order)
+		}
+	}
+}
+
+func TestScheduler_Priority(t *testing.T) {
+	s := newTestScheduler(1, 10, time.Second)
+	blocker, _ := s.Acquire(context.Background(), "openai", PRIORITY_NORMAL, "blocker")
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	order := []string{}
+	for _, id := range []string{"l1", "l2"} {
+		enqueue(t, s, &wg, &mu, &order, PRIORITY_LOW, "batch", id)
+	}
+	for _, id := range []string{"h1", "h2"} {
+		enqueue(t, s, &wg, &mu, &order, PRIORITY_HIGH, "chat", id)
+	}
+	blocker.Release()
+	wg.Wait()
+
+	if order[0] != "h1" || order[1] != "h2" {
+		t.Errorf("Expected high priority requests first, got %v", order)
+	}
+}
+
+func TestScheduler_Shed(t *testing.T) {
+	s := newTestScheduler(1, 1, 20*time.Millisecond)
+	ticket, _ := s.Acquire(context.Background(), "openai", PRIORITY_NORMAL, "alice")
+	defer ticket.Release()
+
+	done := make(chan error)
+	go func() {
+		_, err := s.Acquire(context.Background(), "openai", PRIORITY_NORMAL, "bob")
+		done <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := s.Acquire(context.Background(), "openai", PRIORITY_NORMAL, "carol"); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected ErrQueueFull, got %v", err)
+	}
+	if err := <-done; !errors.Is(err, ErrQueueTimeout) {
+		t.Errorf("Expected ErrQueueTimeout, got %v", err)
+	}
+	if q := s.queue("openai"); q.waiting != 0 {
+		t.Errorf("Expected timed out request to leave the queue, %d waiting", q.waiting)
+	}
+}
+
+func TestScheduler_Unlimited(t *testing.T) {
+	s := New(&Config{Concurrency: map[string]int{"azure": 1}, DefaultPriority: PRIORITY_NORMAL})
+	for i := 0; i < 3; i++ {
+		ticket, err := s.Acquire(context.Background(), "openai", "", "alice")
+		if err != nil || ticket.Priority != PRIORITY_NORMAL {
+			t.Fatalf("Unexpected result: %+v, %v", ticket, err)
+		}
+	}
+}
+
+func TestLower(t *testing.T) {
+	if Lower(PRIORITY_HIGH, PRIORITY_LOW) != PRIORITY_LOW || Lower(PRIORITY_LOW, PRIORITY_HIGH) != PRIORITY_LOW {
+		t.Error("Expected the lower priority")
+	}
+	if Lower(PRIORITY_NORMAL, "urgent") != PRIORITY_NORMAL {
+		t.Error("Expected invalid priority to be ignored")
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	t.Setenv("SCHEDULER_MAX_CONCURRENCY", "")
+	cfg, err := LoadConfigFromEnv()
+	if err != nil || cfg.Enabled {
+		t.Errorf("Expected scheduler to be disabled by default: %+v, %v", cfg, err)
+	}
+
+	t.Setenv("SCHEDULER_MAX_CONCURRENCY", "16")
+	t.Setenv("SCHEDULER_CONCURRENCY", `{"azure": 4}`)
+	t.Setenv("SCHEDULER_WEIGHTS", `{"low": 2}`)
+	cfg, err = LoadConfigFromEnv()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !cfg.Enabled || cfg.Limit("openai") != 16 || cfg.Limit("azure") != 4 || cfg.Weights[PRIORITY_LOW] != 2 {
+		t.Errorf("Unexpected config: %+v", cfg)
+	}
+
+	t.Setenv("SCHEDULER_DEFAULT_PRIORITY", "urgent")
+	if _, err := LoadConfigFromEnv(); err == nil {
+		t.Error("Expected error for invalid SCHEDULER_DEFAULT_PRIORITY")
+	}
+}
@@ -0,0 +1,187 @@
+package scrub
+
+import (
+	"encoding/json"
+	"fmt"
+	"openai-forward/moderation"
+	"openai-forward/redact"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 命中敏感信息时的处理方式
+const (
+	// ACTION_MASK 将敏感信息替换为 [REDACTED:<类型>] 后转发
+	ACTION_MASK = "mask"
+	// ACTION_REJECT 拒绝请求，不转发到上游
+	ACTION_REJECT = "reject"
+)
+
+// Config 请求体敏感信息清除配置
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// Action 默认处理方式
+	Action string `json:"action"`
+	// Detectors 启用的内置检测规则，"all" 表示全部
+	Detectors []string `json:"detectors"`
+	// Patterns 自定义正则表达式，按顺序命名为 pattern_1、pattern_2 ...
+	Patterns []string `json:"patterns"`
+	// Actions 按检测规则覆盖处理方式，如 {"private_key": "reject"}
+	Actions map[string]string `json:"actions"`
+	// MaxBodyBytes 检查的请求体大小上限，超出时无法完整检查，拒绝请求
+	MaxBodyBytes int `json:"max_body_bytes"`
+}
+
+// LoadConfigFromEnv 从环境变量加载配置，SCRUB_MODE 为 mask 或 reject 时启用
+func LoadConfigFromEnv() (*Config, error) {
+	cfg := &Config{Action: ACTION_MASK, Detectors: []string{"all"}, MaxBodyBytes: 16 << 20}
+	switch mode := strings.ToLower(strings.TrimSpace(os.Getenv("SCRUB_MODE"))); mode {
+	case "", "off":
+	case ACTION_MASK, ACTION_REJECT:
+		cfg.Enabled = true
+		cfg.Action = mode
+	default:
+		return cfg, fmt.Errorf("invalid SCRUB_MODE %q", mode)
+	}
+	if detectors := os.Getenv("SCRUB_DETECTORS"); detectors != "" {
+		cfg.Detectors = strings.Split(detectors, ",")
+	}
+	if patterns := os.Getenv("SCRUB_PATTERNS"); patterns != "" {
+		if err := json.Unmarshal([]byte(patterns), &cfg.Patterns); err != nil {
+			return cfg, fmt.Errorf("invalid SCRUB_PATTERNS: %w", err)
+		}
+	}
+	if actions := os.Getenv("SCRUB_ACTIONS"); actions != "" {
+		if err := json.Unmarshal([]byte(actions), &cfg.Actions); err != nil {
+			return cfg, fmt.Errorf("invalid SCRUB_ACTIONS: %w", err)
+		}
+	}
+	if maxBodyBytes, err := strconv.Atoi(os.Getenv("SCRUB_MAX_BODY_BYTES")); err == nil && maxBodyBytes > 0 {
+		cfg.MaxBodyBytes = maxBodyBytes
+	}
+	return cfg, nil
+}
+
+// String 返回配置的简要描述
+func (c *Config) String() string {
+	return fmt.Sprintf("action=%s detectors=%v patterns=%d actions=%v", c.Action, c.Detectors, len(c.Patterns), c.Actions)
+}
+
+// action 检测规则的处理方式
+func (c *Config) action(detector string) string {
+	if action, ok := c.Actions[detector]; ok {
+		return action
+	}
+	return c.Action
+}
+
+// Scrubber 检查请求中的提示词并清除密钥与个人信息
+type Scrubber struct {
+	config   *Config
+	redactor *redact.Redactor
+}
+
+// New 校验配置并创建 Scrubber
+func New(cfg *Config) (*Scrubber, error) {
+	if cfg.Action != ACTION_MASK && cfg.Action != ACTION_REJECT {
+		return nil, fmt.Errorf("invalid scrub action %q", cfg.Action)
+	}
+	for detector, action := range cfg.Actions {
+		if action != ACTION_MASK && action != ACTION_REJECT {
+			return nil, fmt.Errorf("invalid scrub action %q for %s", action, detector)
+		}
+	}
+	redactor, err := redact.New(cfg.Detectors, cfg.Patterns)
+	if err != nil {
+		return nil, err
+	}
+	if redactor.Empty() {
+		return nil, fmt.Errorf("no scrub detectors enabled")
+	}
+	return &Scrubber{config: cfg, redactor: redactor}, nil
+}
+
+// Config 返回配置
+func (s *Scrubber) Config() *Config {
+	return s.config
+}
+
+// Result 一次检查的结果，不包含敏感信息原文
+type Result struct {
+	// Counts 各检测规则的命中次数
+	Counts map[string]int
+	// Rejected 处理方式为 reject 且命中的检测规则
+	Rejected []string
+	// Body 清除敏感信息后的请求体，未命中或被拒绝时为 nil
+	Body []byte
+}
+
+// Total 命中的敏感信息总数
+func (r *Result) Total() int {
+	total := 0
+	for _, n := range r.Counts {
+		total += n
+	}
+	return total
+}
+
+// Action 最终的处理方式，未命中时为空
+func (r *Result) Action() string {
+	switch {
+	case len(r.Rejected) > 0:
+		return ACTION_REJECT
+	case r.Total() > 0:
+		return ACTION_MASK
+	}
+	return ""
+}
+
+// Detectors 命中的检测规则及次数，如 aws_key=1,email=2
+func (r *Result) Detectors() string {
+	names := make([]string, 0, len(r.Counts))
+	for name := range r.Counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = fmt.Sprintf("%s=%d", name, r.Counts[name])
+	}
+	return strings.Join(names, ",")
+}
+
+// Scrub 检查 chat/completions 的 messages、completions 的 prompt、responses 的 input 及 embeddings 的 input，
+// 不支持的接口返回 nil
+func (s *Scrubber) Scrub(route string, body []byte) (*Result, error) {
+	doc, err := moderation.ParseRequest(route, body)
+	if err != nil || doc == nil {
+		return nil, err
+	}
+
+	result := &Result{Counts: map[string]int{}}
+	texts := doc.Texts()
+	for i, text := range texts {
+		var hits map[string]int
+		texts[i], hits = s.redactor.Redact(text)
+		for name, n := range hits {
+			result.Counts[name] += n
+		}
+	}
+	for name := range result.Counts {
+		if s.config.action(name) == ACTION_REJECT {
+			result.Rejected = append(result.Rejected, name)
+		}
+	}
+	sort.Strings(result.Rejected)
+	if result.Total() == 0 || len(result.Rejected) > 0 {
+		return result, nil
+	}
+
+	doc.Replace(texts)
+	result.Body, err = doc.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -3,13 +3,17 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"openai-forward/tracing"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"golang.org/x/oauth2"
 )
 
@@ -21,6 +25,8 @@ type OIDCConfig struct {
 	Scopes         []string `json:"scopes,omitempty"`
 	Debug          bool     `json:"debug,omitempty"`
 	AllowedDomains []string `json:"allowed_domains,omitempty"`
+	// TeamClaim 用于推导团队成员关系的声明名称，默认为 groups
+	TeamClaim string `json:"team_claim,omitempty"`
 }
 
 func LoadOIDCConfigFromEnv() *OIDCConfig {
@@ -31,6 +37,11 @@ func LoadOIDCConfigFromEnv() *OIDCConfig {
 		RedirectURL:    os.Getenv("OIDC_REDIRECT_URL"),
 		Debug:          os.Getenv("OIDC_DEBUG") == "true",
 		AllowedDomains: strings.Split(os.Getenv("OIDC_ALLOWED_DOMAINS"), ","),
+		TeamClaim:      os.Getenv("OIDC_TEAM_CLAIM"),
+	}
+
+	if conf.TeamClaim == "" {
+		conf.TeamClaim = "groups"
 	}
 
 	scopes := os.Getenv("OIDC_SCOPES")
@@ -53,6 +64,9 @@ func LoadOIDCConfigFromEnv() *OIDCConfig {
 	return conf
 }
 
+// ErrEmailDomainNotAllowed 用户邮箱域名不在允许列表中
+var ErrEmailDomainNotAllowed = errors.New("email domain not allowed")
+
 type OIDCService struct {
 	OIDCConfig
 	provider     *oidc.Provider
@@ -61,10 +75,18 @@ type OIDCService struct {
 }
 
 func NewOIDCService(conf *OIDCConfig) (*OIDCService, error) {
-	ctx := context.Background()
+	return NewOIDCServiceWithContext(context.Background(), conf)
+}
+
+// NewOIDCServiceWithContext 创建 OIDC 服务，ctx 用于 provider discovery 请求及链路追踪
+func NewOIDCServiceWithContext(ctx context.Context, conf *OIDCConfig) (*OIDCService, error) {
+	ctx, span := tracing.Start(ctx, "oidc.discovery", attribute.String("oidc.issuer", conf.IssuerURL))
+	defer span.End()
 
 	provider, err := oidc.NewProvider(ctx, conf.IssuerURL)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return nil, fmt.Errorf("failed to create OIDC provider: %w", err)
 	}
 
@@ -92,13 +114,17 @@ type OIDCToken struct {
 	OAuth2Token *oauth2.Token
 	IDToken     *oidc.IDToken
 	RawIDToken  string
+	UserInfo    *UserInfo
 }
 
 type UserInfo struct {
-	Email    string `json:"email"`
-	Verified bool   `json:"email_verified"`
-	Name     string `json:"name"`
-	Subject  string `json:"sub"`
+	Email     string `json:"email"`
+	Verified  bool   `json:"email_verified"`
+	Name      string `json:"name"`
+	Subject   string `json:"sub"`
+	SessionID string `json:"sid,omitempty"`
+	// Groups 团队声明（TeamClaim）中的取值，声明不存在时为 nil
+	Groups []string `json:"-"`
 }
 
 func (s *OIDCService) ValidateEmailDomain(email string) bool {
@@ -150,16 +176,88 @@ func (s *OIDCService) Exchange(ctx context.Context, code string) (*OIDCToken, er
 	}
 
 	if !s.ValidateEmailDomain(userInfo.Email) {
-		return nil, fmt.Errorf("email domain not allowed: %s", userInfo.Email)
+		return nil, fmt.Errorf("%w: %s", ErrEmailDomainNotAllowed, userInfo.Email)
 	}
 
 	return &OIDCToken{
 		OAuth2Token: oauth2Token,
 		IDToken:     idToken,
 		RawIDToken:  rawIDToken,
+		UserInfo:    userInfo,
 	}, nil
 }
 
+// Refresh 使用 refresh token 向 IdP 换取新 token，IdP 拒绝（如用户已停用）时返回错误
+func (s *OIDCService) Refresh(ctx context.Context, refreshToken string) (*OIDCToken, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, client)
+
+	// 将 access token 标记为已过期，强制使用 refresh token 刷新
+	source := s.oauth2Config.TokenSource(ctx, &oauth2.Token{
+		RefreshToken: refreshToken,
+		Expiry:       time.Now().Add(-time.Minute),
+	})
+	oauth2Token, err := source.Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh token: %w", err)
+	}
+
+	token := &OIDCToken{
+		OAuth2Token: oauth2Token,
+	}
+
+	// 部分 IdP 刷新时不会返回新的 ID Token，此时通过 userinfo 接口确认用户状态
+	rawIDToken, ok := oauth2Token.Extra("id_token").(string)
+	if ok && rawIDToken != "" {
+		idToken, err := s.verifier.Verify(ctx, rawIDToken)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify ID token: %w", err)
+		}
+		userInfo, err := s.GetUserInfo(ctx, idToken)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user info: %w", err)
+		}
+		token.IDToken = idToken
+		token.RawIDToken = rawIDToken
+		token.UserInfo = userInfo
+	} else {
+		info, err := s.provider.UserInfo(ctx, oauth2.StaticTokenSource(oauth2Token))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user info: %w", err)
+		}
+		token.UserInfo = &UserInfo{
+			Email:    info.Email,
+			Verified: info.EmailVerified,
+			Subject:  info.Subject,
+		}
+		_ = info.Claims(token.UserInfo)
+
+		var claims map[string]interface{}
+		if err := info.Claims(&claims); err == nil {
+			token.UserInfo.Groups = claimValues(claims[s.TeamClaim])
+		}
+	}
+
+	if !s.ValidateEmailDomain(token.UserInfo.Email) {
+		return nil, fmt.Errorf("%w: %s", ErrEmailDomainNotAllowed, token.UserInfo.Email)
+	}
+
+	return token, nil
+}
+
+// IsInvalidGrant 判断错误是否为 IdP 明确拒绝刷新（refresh token 失效或用户被停用）
+func IsInvalidGrant(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		if retrieveErr.ErrorCode == "invalid_grant" {
+			return true
+		}
+		return retrieveErr.Response != nil && retrieveErr.Response.StatusCode == http.StatusUnauthorized
+	}
+	return false
+}
+
 func (s *OIDCService) GetUserInfo(ctx context.Context, idToken *oidc.IDToken) (*UserInfo, error) {
 	var claims json.RawMessage
 	if err := idToken.Claims(&claims); err != nil {
@@ -171,9 +269,71 @@ func (s *OIDCService) GetUserInfo(ctx context.Context, idToken *oidc.IDToken) (*
 		return nil, fmt.Errorf("failed to unmarshal user info: %w", err)
 	}
 
+	if s.TeamClaim != "" {
+		var allClaims map[string]interface{}
+		if err := json.Unmarshal(claims, &allClaims); err == nil {
+			userInfo.Groups = claimValues(allClaims[s.TeamClaim])
+		}
+	}
+
 	return &userInfo, nil
 }
 
+// claimValues 将字符串或字符串数组形式的声明转换为字符串列表
+func claimValues(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' })
+	case []interface{}:
+		values := []string{}
+		for _, item := range value {
+			if str, ok := item.(string); ok && str != "" {
+				values = append(values, str)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// backChannelLogoutEvent back-channel logout token 中必须包含的事件类型
+const backChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// LogoutClaims back-channel logout token 中的声明
+type LogoutClaims struct {
+	Subject   string                     `json:"sub"`
+	SessionID string                     `json:"sid"`
+	Events    map[string]json.RawMessage `json:"events"`
+	Nonce     string                     `json:"nonce"`
+}
+
+// VerifyLogoutToken 验证 IdP 发送的 back-channel logout token
+func (s *OIDCService) VerifyLogoutToken(ctx context.Context, rawToken string) (*LogoutClaims, error) {
+	token, err := s.verifier.Verify(ctx, rawToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify logout token: %w", err)
+	}
+
+	var claims LogoutClaims
+	if err := token.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to get logout token claims: %w", err)
+	}
+
+	if _, ok := claims.Events[backChannelLogoutEvent]; !ok {
+		return nil, fmt.Errorf("logout token missing backchannel-logout event")
+	}
+	// 规范禁止 logout token 携带 nonce，以防与 ID Token 混用
+	if claims.Nonce != "" {
+		return nil, fmt.Errorf("logout token must not contain nonce")
+	}
+	if claims.Subject == "" && claims.SessionID == "" {
+		return nil, fmt.Errorf("logout token missing sub and sid")
+	}
+
+	return &claims, nil
+}
+
 func (s *OIDCService) AuthCodeURL(state string, opts ...oauth2.AuthCodeOption) string {
 	return s.oauth2Config.AuthCodeURL(state, opts...)
 }
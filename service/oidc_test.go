@@ -82,3 +82,19 @@ func TestOIDCService_GetUserInfo(t *testing.T) {
 
 	logging.Logger.Debugf("Got user info: %+v", test.ToJSON(userInfo))
 }
+
+func TestClaimValues(t *testing.T) {
+	values := claimValues([]interface{}{"team-a", "team-b", 1})
+	if len(values) != 2 || values[0] != "team-a" || values[1] != "team-b" {
+		t.Errorf("Unexpected values from array claim: %v", values)
+	}
+
+	values = claimValues("team-a,team-b")
+	if len(values) != 2 {
+		t.Errorf("Unexpected values from string claim: %v", values)
+	}
+
+	if claimValues(nil) != nil {
+		t.Error("Missing claim should return nil")
+	}
+}
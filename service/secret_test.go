@@ -0,0 +1,50 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestSecretBox_SealAndOpen(t *testing.T) {
+	box, err := NewSecretBox("test-secret")
+	if err != nil {
+		t.Fatalf("Failed to create secret box: %v", err)
+	}
+
+	sealed, err := box.Seal("refresh-token-value")
+	if err != nil {
+		t.Fatalf("Failed to seal: %v", err)
+	}
+
+	if sealed == "refresh-token-value" {
+		t.Error("Sealed value should not equal plain text")
+	}
+
+	plain, err := box.Open(sealed)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+
+	if plain != "refresh-token-value" {
+		t.Errorf("Expected 'refresh-token-value', got '%s'", plain)
+	}
+}
+
+func TestSecretBox_OpenWithWrongSecret(t *testing.T) {
+	box, _ := NewSecretBox("secret-a")
+	other, _ := NewSecretBox("secret-b")
+
+	sealed, err := box.Seal("value")
+	if err != nil {
+		t.Fatalf("Failed to seal: %v", err)
+	}
+
+	if _, err := other.Open(sealed); err == nil {
+		t.Error("Expected error when opening with a different secret")
+	}
+}
+
+func TestNewSecretBox_EmptySecret(t *testing.T) {
+	if _, err := NewSecretBox(""); err == nil {
+		t.Error("Expected error for empty secret")
+	}
+}
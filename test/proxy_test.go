@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"openai-forward/config"
@@ -76,3 +77,79 @@ func TestOpenAIProxy_ListAvailableModels(t *testing.T) {
 
 	t.Log(ToJSON(models))
 }
+
+func TestOpenAIProxy_UpstreamKeyPassthrough(t *testing.T) {
+	// 创建测试服务器，验证转发的是客户端自带的密钥
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sk-client" {
+			t.Errorf("Expected client key to be forwarded, got '%s'", r.Header.Get("Authorization"))
+		}
+		if r.Header.Get("OpenAI-Organization") != "" {
+			t.Error("Server organization should not be sent with a client key")
+		}
+		if r.Header.Get(proxy.UpstreamKeyHeader) != "" {
+			t.Error("Upstream key header should not be forwarded")
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, "OK")
+	}))
+	defer ts.Close()
+
+	p := proxy.NewOpenAIProxy(&config.Config{
+		TargetBaseURL:   ts.URL,
+		APIKey:          "sk-server",
+		OrgID:           "org-server",
+		UpstreamKeyMode: "allow",
+	})
+
+	req := httptest.NewRequest("GET", "http://example.com/v1/models", nil)
+	req.Header.Set("Authorization", "Bearer gateway-key")
+	req.Header.Set(proxy.UpstreamKeyHeader, "sk-client")
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestOpenAIProxy_RequestTooLarge(t *testing.T) {
+	// 转发途中请求体超过上限时返回 413，而不是 502
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	p := proxy.NewOpenAIProxy(&config.Config{TargetBaseURL: ts.URL, APIKey: "sk-server"})
+
+	req := httptest.NewRequest("POST", "http://example.com/v1/files", strings.NewReader(strings.Repeat("a", 1024)))
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+	req.ContentLength = -1
+	w := httptest.NewRecorder()
+	req.Body = http.MaxBytesReader(w, req.Body, 100)
+	p.ServeHTTP(w, req)
+
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), "request_too_large") {
+		t.Errorf("Expected status code %d, got %d: %s", http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+	}
+}
+
+func TestOpenAIProxy_UpstreamRequestID(t *testing.T) {
+	// 上游的 X-Request-Id 应原样返回给客户端
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "req_upstream")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	p := proxy.NewOpenAIProxy(&config.Config{TargetBaseURL: ts.URL, APIKey: "sk-server"})
+
+	req := httptest.NewRequest("GET", "http://example.com/v1/models", nil)
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+
+	if ids := w.Header().Values("X-Request-ID"); len(ids) != 1 || ids[0] != "req_upstream" {
+		t.Errorf("Expected upstream request ID 'req_upstream', got %v", ids)
+	}
+}
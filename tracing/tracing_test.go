@@ -0,0 +1,112 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// setupRecorder 使用内存导出器替换全局 TracerProvider
+func setupRecorder(t *testing.T) (*tracetest.InMemoryExporter, *sdktrace.TracerProvider) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider, err := NewProvider(context.Background(), exporter)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		_ = provider.Shutdown(context.Background())
+		otel.SetTracerProvider(previous)
+	})
+	return exporter, provider
+}
+
+func TestInit_ExportsToCollector(t *testing.T) {
+	// 进程内的 OTLP/HTTP collector
+	var received atomic.Int32
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/traces" {
+			received.Add(1)
+		}
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", collector.URL)
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+
+	shutdown, err := Init(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to initialize tracing: %v", err)
+	}
+
+	_, span := Start(context.Background(), "test")
+	span.End()
+
+	err = shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to shutdown tracing: %v", err)
+	}
+	if received.Load() == 0 {
+		t.Error("Expected collector to receive spans")
+	}
+}
+
+func TestExtract_TraceParent(t *testing.T) {
+	_, err := Init(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to initialize tracing: %v", err)
+	}
+
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := Extract(context.Background(), propagation.HeaderCarrier(header))
+
+	spanContext := trace.SpanContextFromContext(ctx)
+	if spanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected trace ID to be propagated, got %s", spanContext.TraceID())
+	}
+}
+
+func TestTransport_Spans(t *testing.T) {
+	exporter, provider := setupRecorder(t)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("traceparent") != "" {
+			t.Error("Expected traceparent not to be sent upstream")
+		}
+		_, _ = w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer upstream.Close()
+
+	client := &http.Client{Transport: NewTransport(nil, "openai")}
+	resp, err := client.Get(upstream.URL + "/v1/chat/completions")
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	_, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	_ = provider.ForceFlush(context.Background())
+
+	names := []string{}
+	for _, span := range exporter.GetSpans() {
+		names = append(names, span.Name)
+	}
+	joined := strings.Join(names, ",")
+	if !strings.Contains(joined, "upstream openai") || !strings.Contains(joined, "upstream.stream") {
+		t.Errorf("Expected upstream and stream spans, got %s", joined)
+	}
+}
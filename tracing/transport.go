@@ -0,0 +1,99 @@
+package tracing
+
+import (
+	"io"
+	"net/http"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Transport 为上游请求创建 span：upstream 覆盖到收到响应头为止，upstream.stream 覆盖响应体的传输
+//
+// 不向上游注入 traceparent，避免将内部链路信息发送给第三方服务。
+type Transport struct {
+	Base     http.RoundTripper
+	Upstream string
+}
+
+// NewTransport 创建带链路追踪的 Transport，base 为空时使用 http.DefaultTransport
+func NewTransport(base http.RoundTripper, upstream string) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{Base: base, Upstream: upstream}
+}
+
+// RoundTrip 实现 http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := Tracer().Start(req.Context(), "upstream "+t.Upstream,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("upstream", t.Upstream),
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.ServerAddress(req.URL.Hostname()),
+			semconv.URLPath(req.URL.Path),
+		),
+	)
+
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return nil, err
+	}
+
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if requestID := resp.Header.Get("X-Request-Id"); requestID != "" {
+		span.SetAttributes(attribute.String("upstream.request_id", requestID))
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	span.End()
+
+	// 协议升级（WebSocket）时响应体需保持 io.ReadWriteCloser，不做包装
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		return resp, nil
+	}
+
+	_, streamSpan := Tracer().Start(ctx, "upstream.stream", trace.WithAttributes(attribute.String("upstream", t.Upstream)))
+	resp.Body = &tracedBody{ReadCloser: resp.Body, span: streamSpan}
+	return resp, nil
+}
+
+// tracedBody 在响应体读取完毕或关闭时结束 span
+type tracedBody struct {
+	io.ReadCloser
+	span  trace.Span
+	bytes int64
+	once  sync.Once
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.bytes += int64(n)
+	if err == io.EOF {
+		b.end()
+	} else if err != nil {
+		b.span.RecordError(err)
+		b.end()
+	}
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	b.end()
+	return b.ReadCloser.Close()
+}
+
+func (b *tracedBody) end() {
+	b.once.Do(func() {
+		b.span.SetAttributes(attribute.Int64("http.response.body.size", b.bytes))
+		b.span.End()
+	})
+}
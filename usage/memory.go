@@ -0,0 +1,51 @@
+package usage
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore 进程内用量存储，未配置数据库时使用，只保留汇总数据，重启后丢失
+type MemoryStore struct {
+	mutex sync.Mutex
+	rows  map[string]*Row
+}
+
+// NewMemoryStore 创建进程内用量存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{rows: map[string]*Row{}}
+}
+
+// SaveUsageRecords 实现 Store
+func (s *MemoryStore) SaveUsageRecords(records []*Record) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, record := range records {
+		row := record.Row()
+		key := row.key(Groups)
+		if existing, ok := s.rows[key]; ok {
+			existing.Add(row)
+			continue
+		}
+		s.rows[key] = row
+	}
+	return nil
+}
+
+// QueryUsage 实现 Store
+func (s *MemoryStore) QueryUsage(filter *Filter) ([]*Row, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	matched := []*Row{}
+	for _, row := range s.rows {
+		if filter.Match(row) {
+			matched = append(matched, row)
+		}
+	}
+	return Aggregate(matched, filter.GroupBy), nil
+}
+
+// DeleteUsageRecordsBefore 实现 Store，不保留逐请求记录，无需清理
+func (s *MemoryStore) DeleteUsageRecordsBefore(before time.Time) (int64, error) {
+	return 0, nil
+}
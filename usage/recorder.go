@@ -0,0 +1,103 @@
+package usage
+
+import (
+	"openai-forward/logging"
+	"sync"
+	"time"
+)
+
+// queueSize 待写入用量记录的队列长度，队列满时丢弃记录以免阻塞请求
+const queueSize = 8192
+
+// Recorder 异步批量写入用量记录
+type Recorder struct {
+	config *Config
+	store  Store
+	queue  chan *Record
+	wg     sync.WaitGroup
+}
+
+// NewRecorder 创建用量记录器并启动后台写入
+func NewRecorder(cfg *Config, store Store) *Recorder {
+	r := &Recorder{
+		config: cfg,
+		store:  store,
+		queue:  make(chan *Record, queueSize),
+	}
+	r.wg.Add(1)
+	go r.run()
+	return r
+}
+
+// Config 返回用量统计配置
+func (r *Recorder) Config() *Config {
+	return r.config
+}
+
+// Store 返回用量存储
+func (r *Recorder) Store() Store {
+	return r.store
+}
+
+// Submit 提交用量记录，队列已满时丢弃并记录错误日志
+func (r *Recorder) Submit(record *Record) {
+	select {
+	case r.queue <- record:
+	default:
+		logging.Logger.Errorf("Usage queue is full, dropping record %s", record.RequestID)
+	}
+}
+
+func (r *Recorder) run() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.config.FlushInterval)
+	defer ticker.Stop()
+
+	pending := make([]*Record, 0, r.config.BatchSize)
+	for {
+		select {
+		case record, ok := <-r.queue:
+			if !ok {
+				r.flush(pending)
+				return
+			}
+			pending = append(pending, record)
+			if len(pending) >= r.config.BatchSize {
+				r.flush(pending)
+				pending = pending[:0]
+			}
+		case <-ticker.C:
+			r.flush(pending)
+			pending = pending[:0]
+		}
+	}
+}
+
+// flush 写入待写入的记录，失败时丢弃并记录错误日志
+func (r *Recorder) flush(records []*Record) {
+	if len(records) == 0 {
+		return
+	}
+	if err := r.store.SaveUsageRecords(records); err != nil {
+		logging.Logger.Errorf("Failed to save %d usage records: %v", len(records), err)
+	}
+}
+
+// Purge 清理超出保留期的逐请求记录
+func (r *Recorder) Purge() {
+	before := time.Now().Add(-r.config.Retention)
+	n, err := r.store.DeleteUsageRecordsBefore(before)
+	if err != nil {
+		logging.Logger.Errorf("Failed to purge usage records: %v", err)
+		return
+	}
+	if n > 0 {
+		logging.Logger.Infof("Purged %d usage records before %s", n, before.Format(time.RFC3339))
+	}
+}
+
+// Close 等待队列中的记录写入完毕
+func (r *Recorder) Close() {
+	close(r.queue)
+	r.wg.Wait()
+}
@@ -0,0 +1,382 @@
+package usage
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 报表的分组维度
+const (
+	GROUP_DAY      = "day"
+	GROUP_MODEL    = "model"
+	GROUP_USER     = "user"
+	GROUP_TEAM     = "team"
+	GROUP_KEY      = "key"
+	GROUP_UPSTREAM = "upstream"
+)
+
+// Groups 支持的分组维度，按报表中的列顺序排列
+var Groups = []string{GROUP_DAY, GROUP_TEAM, GROUP_USER, GROUP_KEY, GROUP_UPSTREAM, GROUP_MODEL}
+
+// 报表排序字段，均按降序排列
+const (
+	ORDER_COST     = "cost"
+	ORDER_REQUESTS = "requests"
+	ORDER_TOKENS   = "tokens"
+)
+
+// DAY_FORMAT 汇总表中日期的格式，按 UTC 计算
+const DAY_FORMAT = "2006-01-02"
+
+// Config 用量统计配置
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// FlushInterval 批量写入用量记录的间隔
+	FlushInterval time.Duration `json:"flush_interval"`
+	// BatchSize 每次写入的记录数上限，待写入记录达到该数量时立即写入
+	BatchSize int `json:"batch_size"`
+	// Retention 逐请求记录的保留时长，汇总数据不清理
+	Retention time.Duration `json:"retention"`
+}
+
+// LoadConfigFromEnv 从环境变量加载用量统计配置
+func LoadConfigFromEnv() *Config {
+	cfg := &Config{
+		Enabled:       os.Getenv("USAGE_TRACKING") != "off",
+		FlushInterval: 5 * time.Second,
+		BatchSize:     500,
+		Retention:     90 * 24 * time.Hour,
+	}
+	if interval, err := time.ParseDuration(os.Getenv("USAGE_FLUSH_INTERVAL")); err == nil && interval > 0 {
+		cfg.FlushInterval = interval
+	}
+	if size, err := strconv.Atoi(os.Getenv("USAGE_BATCH_SIZE")); err == nil && size > 0 {
+		cfg.BatchSize = size
+	}
+	if retention, err := time.ParseDuration(os.Getenv("USAGE_RETENTION")); err == nil && retention > 0 {
+		cfg.Retention = retention
+	}
+	return cfg
+}
+
+// String 返回用量统计配置的简要描述
+func (c *Config) String() string {
+	return fmt.Sprintf("flush_interval=%s batch_size=%d retention=%s", c.FlushInterval, c.BatchSize, c.Retention)
+}
+
+// Record 一次计费请求的用量，批处理按任务与模型记录，Realtime 按会话记录
+type Record struct {
+	RequestID        string    `json:"request_id,omitempty"`
+	Time             time.Time `json:"time"`
+	Subject          string    `json:"subject,omitempty"`
+	Email            string    `json:"email,omitempty"`
+	TeamID           string    `json:"team_id,omitempty"`
+	KeyPrefix        string    `json:"key_prefix,omitempty"`
+	Upstream         string    `json:"upstream"`
+	Route            string    `json:"route"`
+	Model            string    `json:"model"`
+	Status           int       `json:"status"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	// Cached 命中响应缓存，未消耗上游用量，不计 token 与费用
+	Cached bool `json:"cached,omitempty"`
+	// Cost 按模型价格计算的费用（美元），价格未知时为 0
+	Cost float64 `json:"cost"`
+}
+
+// User 报表中的用户，优先使用邮箱
+func (r *Record) User() string {
+	if r.Email != "" {
+		return r.Email
+	}
+	return r.Subject
+}
+
+// Row 转换为按全部维度汇总的一行
+func (r *Record) Row() *Row {
+	row := &Row{
+		Day:      r.Time.UTC().Format(DAY_FORMAT),
+		Team:     r.TeamID,
+		User:     r.User(),
+		Key:      r.KeyPrefix,
+		Upstream: r.Upstream,
+		Model:    r.Model,
+		Requests: 1,
+	}
+	if r.Cached {
+		row.CachedRequests = 1
+		return row
+	}
+	row.PromptTokens = int64(r.PromptTokens)
+	row.CompletionTokens = int64(r.CompletionTokens)
+	row.TotalTokens = row.PromptTokens + row.CompletionTokens
+	row.Cost = r.Cost
+	return row
+}
+
+// Row 报表中的一行，未参与分组的维度为空
+type Row struct {
+	Day              string  `json:"day,omitempty"`
+	Team             string  `json:"team,omitempty"`
+	User             string  `json:"user,omitempty"`
+	Key              string  `json:"key,omitempty"`
+	Upstream         string  `json:"upstream,omitempty"`
+	Model            string  `json:"model,omitempty"`
+	Requests         int64   `json:"requests"`
+	CachedRequests   int64   `json:"cached_requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// Add 累加另一行的用量
+func (r *Row) Add(other *Row) {
+	r.Requests += other.Requests
+	r.CachedRequests += other.CachedRequests
+	r.PromptTokens += other.PromptTokens
+	r.CompletionTokens += other.CompletionTokens
+	r.TotalTokens += other.TotalTokens
+	r.Cost += other.Cost
+}
+
+// Dimension 返回指定维度的值
+func (r *Row) Dimension(group string) string {
+	switch group {
+	case GROUP_DAY:
+		return r.Day
+	case GROUP_TEAM:
+		return r.Team
+	case GROUP_USER:
+		return r.User
+	case GROUP_KEY:
+		return r.Key
+	case GROUP_UPSTREAM:
+		return r.Upstream
+	case GROUP_MODEL:
+		return r.Model
+	default:
+		return ""
+	}
+}
+
+// SetDimension 设置指定维度的值
+func (r *Row) SetDimension(group string, value string) {
+	switch group {
+	case GROUP_DAY:
+		r.Day = value
+	case GROUP_TEAM:
+		r.Team = value
+	case GROUP_USER:
+		r.User = value
+	case GROUP_KEY:
+		r.Key = value
+	case GROUP_UPSTREAM:
+		r.Upstream = value
+	case GROUP_MODEL:
+		r.Model = value
+	}
+}
+
+// project 只保留参与分组的维度
+func (r *Row) project(groups []string) *Row {
+	row := &Row{}
+	for _, group := range groups {
+		row.SetDimension(group, r.Dimension(group))
+	}
+	return row
+}
+
+// Filter 用量查询条件，零值字段不参与过滤；时间按 UTC 日期计算，Until 不包含在内
+type Filter struct {
+	Since    time.Time
+	Until    time.Time
+	User     string
+	Team     string
+	Key      string
+	Model    string
+	Upstream string
+	GroupBy  []string
+}
+
+// Match 判断一行是否满足查询条件
+func (f *Filter) Match(row *Row) bool {
+	if !f.Since.IsZero() && row.Day < f.Since.UTC().Format(DAY_FORMAT) {
+		return false
+	}
+	if !f.Until.IsZero() && row.Day >= f.Until.UTC().Format(DAY_FORMAT) {
+		return false
+	}
+	return (f.User == "" || f.User == row.User) && (f.Team == "" || f.Team == row.Team) && (f.Key == "" || f.Key == row.Key) &&
+		(f.Model == "" || f.Model == row.Model) && (f.Upstream == "" || f.Upstream == row.Upstream)
+}
+
+// ParseGroupBy 解析逗号分隔的分组维度，按报表列顺序返回，未知维度返回错误
+func ParseGroupBy(value string) ([]string, error) {
+	selected := map[string]bool{}
+	for _, group := range strings.Split(value, ",") {
+		group = strings.ToLower(strings.TrimSpace(group))
+		if group == "" {
+			continue
+		}
+		known := false
+		for _, g := range Groups {
+			known = known || g == group
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown group %q, expected one of %s", group, strings.Join(Groups, ", "))
+		}
+		selected[group] = true
+	}
+	groups := []string{}
+	for _, group := range Groups {
+		if selected[group] {
+			groups = append(groups, group)
+		}
+	}
+	return groups, nil
+}
+
+// ParseDay 解析 2006-01-02 形式的日期或 RFC 3339 时间，返回所在的 UTC 日期
+func ParseDay(value string) (time.Time, error) {
+	if day, err := time.Parse(DAY_FORMAT, value); err == nil {
+		return day, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.New("expected a date (2006-01-02) or an RFC 3339 timestamp")
+	}
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+}
+
+// Aggregate 将按全部维度汇总的行按指定维度合并，按维度排序
+func Aggregate(rows []*Row, groups []string) []*Row {
+	merged := map[string]*Row{}
+	for _, row := range rows {
+		projected := row.project(groups)
+		key := projected.key(groups)
+		if existing, ok := merged[key]; ok {
+			existing.Add(row)
+			continue
+		}
+		projected.Add(row)
+		merged[key] = projected
+	}
+	result := make([]*Row, 0, len(merged))
+	for _, row := range merged {
+		result = append(result, row)
+	}
+	Sort(result, groups)
+	return result
+}
+
+func (r *Row) key(groups []string) string {
+	values := make([]string, len(groups))
+	for i, group := range groups {
+		values[i] = r.Dimension(group)
+	}
+	return strings.Join(values, "\x00")
+}
+
+// Sort 按分组维度依次排序
+func Sort(rows []*Row, groups []string) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, group := range groups {
+			a, b := rows[i].Dimension(group), rows[j].Dimension(group)
+			if a != b {
+				return a < b
+			}
+		}
+		return false
+	})
+}
+
+// Report 用量报表
+type Report struct {
+	Since   time.Time `json:"since"`
+	Until   time.Time `json:"until"`
+	GroupBy []string  `json:"group_by"`
+	Rows    []*Row    `json:"rows"`
+	Total   *Row      `json:"total"`
+}
+
+// NewReport 根据查询结果生成报表，忽略没有请求的行
+func NewReport(filter *Filter, rows []*Row) *Report {
+	report := &Report{Since: filter.Since, Until: filter.Until, GroupBy: filter.GroupBy, Rows: []*Row{}, Total: &Row{}}
+	for _, row := range rows {
+		if row.Requests == 0 {
+			continue
+		}
+		report.Rows = append(report.Rows, row)
+		report.Total.Add(row)
+	}
+	return report
+}
+
+// Top 按指定字段降序排列报表行，limit 大于 0 时只保留前 limit 行，合计不变；用于查询用量最多的用户、团队等
+func (r *Report) Top(order string, limit int) error {
+	var value func(row *Row) float64
+	switch order {
+	case ORDER_COST:
+		value = func(row *Row) float64 { return row.Cost }
+	case ORDER_REQUESTS:
+		value = func(row *Row) float64 { return float64(row.Requests) }
+	case ORDER_TOKENS:
+		value = func(row *Row) float64 { return float64(row.TotalTokens) }
+	default:
+		return fmt.Errorf("unknown order %q, expected one of %s, %s, %s", order, ORDER_COST, ORDER_REQUESTS, ORDER_TOKENS)
+	}
+	sort.SliceStable(r.Rows, func(i, j int) bool {
+		return value(r.Rows[i]) > value(r.Rows[j])
+	})
+	if limit > 0 && len(r.Rows) > limit {
+		r.Rows = r.Rows[:limit]
+	}
+	return nil
+}
+
+// WriteCSV 以 CSV 格式写出报表，首行为列名，费用保留 6 位小数
+func (r *Report) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	header := append([]string{}, r.GroupBy...)
+	header = append(header, "requests", "cached_requests", "prompt_tokens", "completion_tokens", "total_tokens", "cost")
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, row := range r.Rows {
+		record := make([]string, 0, len(header))
+		for _, group := range r.GroupBy {
+			record = append(record, row.Dimension(group))
+		}
+		record = append(record,
+			strconv.FormatInt(row.Requests, 10),
+			strconv.FormatInt(row.CachedRequests, 10),
+			strconv.FormatInt(row.PromptTokens, 10),
+			strconv.FormatInt(row.CompletionTokens, 10),
+			strconv.FormatInt(row.TotalTokens, 10),
+			strconv.FormatFloat(row.Cost, 'f', 6, 64),
+		)
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Store 用量存储：保存逐请求记录并同时累加到按日汇总的数据中，查询只读取汇总数据
+type Store interface {
+	SaveUsageRecords(records []*Record) error
+	// QueryUsage 按条件查询汇总数据，按 GroupBy 分组
+	QueryUsage(filter *Filter) ([]*Row, error)
+	// DeleteUsageRecordsBefore 删除指定时间前的逐请求记录，汇总数据保留
+	DeleteUsageRecordsBefore(before time.Time) (int64, error)
+}
@@ -0,0 +1,123 @@
+package usage
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func testRecords() []*Record {
+	day1 := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	day2 := time.Date(2024, 5, 2, 23, 30, 0, 0, time.UTC)
+	return []*Record{
+		{Time: day1, Email: "alice@example.com", TeamID: "ml", KeyPrefix: "sk-a", Upstream: "openai", Model: "gpt-4o", PromptTokens: 100, CompletionTokens: 50, Cost: 0.75},
+		{Time: day1, Email: "alice@example.com", TeamID: "ml", KeyPrefix: "sk-a", Upstream: "openai", Model: "gpt-4o", PromptTokens: 100, CompletionTokens: 50, Cached: true},
+		{Time: day2, Subject: "bob", TeamID: "ml", KeyPrefix: "sk-b", Upstream: "azure", Model: "gpt-4o-mini", PromptTokens: 1000, Cost: 0.15},
+		{Time: day2, Email: "alice@example.com", TeamID: "ml", KeyPrefix: "sk-a", Upstream: "openai", Model: "gpt-4o", PromptTokens: 200, CompletionTokens: 100, Cost: 1.5},
+	}
+}
+
+func TestMemoryStore_Query(t *testing.T) {
+	store := NewMemoryStore()
+	if err := store.SaveUsageRecords(testRecords()); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, _ := store.QueryUsage(&Filter{GroupBy: []string{GROUP_MODEL, GROUP_DAY}})
+	if len(rows) != 3 || rows[0].Day != "2024-05-01" || rows[0].Model != "gpt-4o" || rows[0].User != "" {
+		t.Fatalf("Unexpected rows: %+v", rows)
+	}
+	if rows[0].Requests != 2 || rows[0].CachedRequests != 1 || rows[0].TotalTokens != 150 || rows[0].Cost != 0.75 {
+		t.Errorf("Cached request should not count tokens or cost: %+v", rows[0])
+	}
+
+	rows, _ = store.QueryUsage(&Filter{User: "alice@example.com", GroupBy: []string{GROUP_USER}})
+	if len(rows) != 1 || rows[0].Requests != 3 || rows[0].PromptTokens != 300 || rows[0].Cost != 2.25 {
+		t.Errorf("Unexpected user rows: %+v", rows)
+	}
+
+	since, _ := ParseDay("2024-05-02")
+	rows, _ = store.QueryUsage(&Filter{Since: since, GroupBy: []string{GROUP_USER}})
+	if len(rows) != 2 || rows[0].User != "alice@example.com" || rows[1].User != "bob" {
+		t.Errorf("Unexpected rows since %s: %+v", since, rows)
+	}
+
+	rows, _ = store.QueryUsage(&Filter{Until: since})
+	report := NewReport(&Filter{Until: since}, rows)
+	if len(report.Rows) != 1 || report.Total.Requests != 2 {
+		t.Errorf("Unexpected report: %+v", report.Total)
+	}
+}
+
+func TestParseGroupBy(t *testing.T) {
+	groups, err := ParseGroupBy("model, DAY")
+	if err != nil || strings.Join(groups, ",") != "day,model" {
+		t.Errorf("Expected groups in column order, got %v, %v", groups, err)
+	}
+	if groups, err := ParseGroupBy(""); err != nil || len(groups) != 0 {
+		t.Errorf("Expected no groups, got %v, %v", groups, err)
+	}
+	if _, err := ParseGroupBy("hour"); err == nil {
+		t.Error("Expected error for unknown group")
+	}
+}
+
+func TestParseDay(t *testing.T) {
+	day, err := ParseDay("2024-05-01T23:00:00-02:00")
+	if err != nil || !day.Equal(time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected UTC day, got %s, %v", day, err)
+	}
+	if _, err := ParseDay("May 1"); err == nil {
+		t.Error("Expected error for invalid date")
+	}
+}
+
+func TestReport_WriteCSV(t *testing.T) {
+	store := NewMemoryStore()
+	_ = store.SaveUsageRecords(testRecords())
+	filter := &Filter{GroupBy: []string{GROUP_TEAM, GROUP_USER}}
+	rows, _ := store.QueryUsage(filter)
+
+	var out strings.Builder
+	if err := NewReport(filter, rows).WriteCSV(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := "team,user,requests,cached_requests,prompt_tokens,completion_tokens,total_tokens,cost\n" +
+		"ml,alice@example.com,3,1,300,150,450,2.250000\n" +
+		"ml,bob,1,0,1000,0,1000,0.150000\n"
+	if out.String() != want {
+		t.Errorf("Unexpected CSV:\n%s", out.String())
+	}
+}
+
+func TestReport_Top(t *testing.T) {
+	store := NewMemoryStore()
+	_ = store.SaveUsageRecords(testRecords())
+	filter := &Filter{GroupBy: []string{GROUP_USER}}
+	rows, _ := store.QueryUsage(filter)
+
+	report := NewReport(filter, rows)
+	if err := report.Top(ORDER_TOKENS, 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Rows) != 1 || report.Rows[0].User != "bob" || report.Total.Requests != 4 {
+		t.Errorf("Expected top user by tokens with unchanged total: %+v, %+v", report.Rows, report.Total)
+	}
+	if err := report.Top("latency", 0); err == nil {
+		t.Error("Expected error for unknown order")
+	}
+}
+
+func TestRecorder_Flush(t *testing.T) {
+	store := NewMemoryStore()
+	recorder := NewRecorder(&Config{Enabled: true, FlushInterval: time.Hour, BatchSize: 2, Retention: time.Hour}, store)
+	for _, record := range testRecords() {
+		recorder.Submit(record)
+	}
+	recorder.Close()
+
+	rows, _ := store.QueryUsage(&Filter{})
+	if len(rows) != 1 || rows[0].Requests != 4 {
+		t.Errorf("Expected all records to be flushed on close: %+v", rows)
+	}
+}
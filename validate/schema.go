@@ -0,0 +1,221 @@
+package validate
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Kind 字段的 JSON 类型
+type Kind int
+
+const (
+	KIND_STRING Kind = iota
+	KIND_NUMBER
+	KIND_INTEGER
+	KIND_BOOLEAN
+	KIND_ARRAY
+	KIND_OBJECT
+	// KIND_STRING_OR_ARRAY 字符串或数组，如 embeddings 的 input
+	KIND_STRING_OR_ARRAY
+	// KIND_ANY 任意类型，仅用于声明必填
+	KIND_ANY
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KIND_STRING:
+		return "string"
+	case KIND_NUMBER:
+		return "number"
+	case KIND_INTEGER:
+		return "integer"
+	case KIND_BOOLEAN:
+		return "boolean"
+	case KIND_ARRAY:
+		return "array"
+	case KIND_OBJECT:
+		return "object"
+	case KIND_STRING_OR_ARRAY:
+		return "string or array"
+	default:
+		return "any"
+	}
+}
+
+// Field 字段约束
+type Field struct {
+	Kind     Kind
+	Required bool
+	// NonEmpty 字符串或数组不能为空
+	NonEmpty bool
+	// Enum 字符串的可选值
+	Enum []string
+	// Min / Max 数值范围，为 nil 时不限制
+	Min *float64
+	Max *float64
+}
+
+// Schema 请求体的字段约束，未声明的字段不做校验
+type Schema map[string]Field
+
+func bound(value float64) *float64 {
+	return &value
+}
+
+// schemas 主要接口的请求体约束
+var schemas = map[string]Schema{
+	"chat/completions": {
+		"model":                 {Kind: KIND_STRING, Required: true, NonEmpty: true},
+		"messages":              {Kind: KIND_ARRAY, Required: true, NonEmpty: true},
+		"max_tokens":            {Kind: KIND_INTEGER, Min: bound(1)},
+		"max_completion_tokens": {Kind: KIND_INTEGER, Min: bound(1)},
+		"n":                     {Kind: KIND_INTEGER, Min: bound(1)},
+		"temperature":           {Kind: KIND_NUMBER, Min: bound(0), Max: bound(2)},
+		"top_p":                 {Kind: KIND_NUMBER, Min: bound(0), Max: bound(1)},
+		"seed":                  {Kind: KIND_INTEGER},
+		"stream":                {Kind: KIND_BOOLEAN},
+		"stream_options":        {Kind: KIND_OBJECT},
+		"tools":                 {Kind: KIND_ARRAY},
+		"response_format":       {Kind: KIND_OBJECT},
+	},
+	"completions": {
+		"model":       {Kind: KIND_STRING, Required: true, NonEmpty: true},
+		"prompt":      {Kind: KIND_STRING_OR_ARRAY, Required: true},
+		"max_tokens":  {Kind: KIND_INTEGER, Min: bound(1)},
+		"n":           {Kind: KIND_INTEGER, Min: bound(1)},
+		"temperature": {Kind: KIND_NUMBER, Min: bound(0), Max: bound(2)},
+		"stream":      {Kind: KIND_BOOLEAN},
+	},
+	"responses": {
+		"model":             {Kind: KIND_STRING, Required: true, NonEmpty: true},
+		"input":             {Kind: KIND_STRING_OR_ARRAY},
+		"instructions":      {Kind: KIND_STRING},
+		"max_output_tokens": {Kind: KIND_INTEGER, Min: bound(1)},
+		"temperature":       {Kind: KIND_NUMBER, Min: bound(0), Max: bound(2)},
+		"stream":            {Kind: KIND_BOOLEAN},
+		"tools":             {Kind: KIND_ARRAY},
+	},
+	"embeddings": {
+		"model":           {Kind: KIND_STRING, Required: true, NonEmpty: true},
+		"input":           {Kind: KIND_STRING_OR_ARRAY, Required: true, NonEmpty: true},
+		"dimensions":      {Kind: KIND_INTEGER, Min: bound(1)},
+		"encoding_format": {Kind: KIND_STRING, Enum: []string{"float", "base64"}},
+	},
+	"images/generations": {
+		"prompt":  {Kind: KIND_STRING, Required: true, NonEmpty: true},
+		"model":   {Kind: KIND_STRING},
+		"n":       {Kind: KIND_INTEGER, Min: bound(1)},
+		"size":    {Kind: KIND_STRING},
+		"quality": {Kind: KIND_STRING},
+	},
+	"audio/speech": {
+		"model": {Kind: KIND_STRING, Required: true, NonEmpty: true},
+		"input": {Kind: KIND_STRING, Required: true, NonEmpty: true},
+		"voice": {Kind: KIND_STRING, Required: true, NonEmpty: true},
+		"speed": {Kind: KIND_NUMBER, Min: bound(0.25), Max: bound(4)},
+	},
+}
+
+// check 按约束校验请求体中的字段
+func (s Schema) check(body map[string]json.RawMessage) *Error {
+	// 按字段名排序以保证错误信息稳定
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		field := s[name]
+		raw, ok := body[name]
+		if !ok || string(raw) == "null" {
+			if field.Required {
+				return invalidParam(name, "missing_required_parameter", fmt.Sprintf("Missing required parameter: '%s'.", name))
+			}
+			continue
+		}
+		err := field.check(name, raw)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f Field) check(name string, raw json.RawMessage) *Error {
+	invalidType := invalidParam(name, "invalid_type",
+		fmt.Sprintf("Invalid type for '%s': expected %s.", name, f.Kind))
+
+	var value any
+	err := json.Unmarshal(raw, &value)
+	if err != nil {
+		return invalidType
+	}
+
+	switch f.Kind {
+	case KIND_STRING:
+		text, ok := value.(string)
+		if !ok {
+			return invalidType
+		}
+		if f.NonEmpty && strings.TrimSpace(text) == "" {
+			return invalidParam(name, "empty_string", fmt.Sprintf("'%s' must not be empty.", name))
+		}
+		if len(f.Enum) > 0 && !contains(f.Enum, text) {
+			return invalidParam(name, "invalid_value",
+				fmt.Sprintf("Invalid value for '%s': expected one of %s.", name, strings.Join(f.Enum, ", ")))
+		}
+	case KIND_NUMBER, KIND_INTEGER:
+		number, ok := value.(float64)
+		if !ok || (f.Kind == KIND_INTEGER && number != float64(int64(number))) {
+			return invalidType
+		}
+		if f.Min != nil && number < *f.Min {
+			return invalidParam(name, "invalid_value", fmt.Sprintf("'%s' must be at least %v.", name, *f.Min))
+		}
+		if f.Max != nil && number > *f.Max {
+			return invalidParam(name, "invalid_value", fmt.Sprintf("'%s' must be at most %v.", name, *f.Max))
+		}
+	case KIND_BOOLEAN:
+		if _, ok := value.(bool); !ok {
+			return invalidType
+		}
+	case KIND_ARRAY:
+		items, ok := value.([]any)
+		if !ok {
+			return invalidType
+		}
+		if f.NonEmpty && len(items) == 0 {
+			return invalidParam(name, "empty_array", fmt.Sprintf("'%s' must not be empty.", name))
+		}
+	case KIND_OBJECT:
+		if _, ok := value.(map[string]any); !ok {
+			return invalidType
+		}
+	case KIND_STRING_OR_ARRAY:
+		switch typed := value.(type) {
+		case string:
+			if f.NonEmpty && typed == "" {
+				return invalidParam(name, "empty_string", fmt.Sprintf("'%s' must not be empty.", name))
+			}
+		case []any:
+			if f.NonEmpty && len(typed) == 0 {
+				return invalidParam(name, "empty_array", fmt.Sprintf("'%s' must not be empty.", name))
+			}
+		default:
+			return invalidType
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,234 @@
+package validate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"openai-forward/logging"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Error 请求校验错误，对应 OpenAI 的 invalid_request_error
+type Error struct {
+	Message string
+	Param   string
+	Code    string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func invalidParam(param string, code string, message string) *Error {
+	return &Error{Message: message, Param: param, Code: code}
+}
+
+// Config 请求校验配置
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// MaxBodyBytes 未单独配置的接口的请求体大小上限
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+	// BodyLimits 各接口的请求体大小上限
+	BodyLimits map[string]int64 `json:"body_limits"`
+	// MaxOutputTokens max_tokens / max_completion_tokens / max_output_tokens 的上限，为 0 时不限制
+	MaxOutputTokens int `json:"max_output_tokens"`
+	// MaxN n 的上限，为 0 时不限制
+	MaxN int `json:"max_n"`
+	// ImageSizes 允许的图片尺寸，为空时不限制
+	ImageSizes []string `json:"image_sizes"`
+}
+
+// defaultBodyLimits 默认的各接口请求体大小上限，上传文件的接口与 OpenAI 的限制一致
+var defaultBodyLimits = map[string]int64{
+	"chat/completions":     20 << 20,
+	"responses":            20 << 20,
+	"completions":          4 << 20,
+	"embeddings":           4 << 20,
+	"images/generations":   1 << 20,
+	"images/edits":         50 << 20,
+	"images/variations":    50 << 20,
+	"audio/speech":         1 << 20,
+	"audio/transcriptions": 25 << 20,
+	"audio/translations":   25 << 20,
+	"files":                512 << 20,
+	"uploads":              64 << 20,
+}
+
+// LoadConfigFromEnv 从环境变量加载请求校验配置
+func LoadConfigFromEnv() *Config {
+	cfg := &Config{
+		Enabled:      os.Getenv("REQUEST_VALIDATION") != "off",
+		MaxBodyBytes: 32 << 20,
+		BodyLimits:   map[string]int64{},
+		MaxN:         10,
+		ImageSizes: []string{"auto", "256x256", "512x512", "1024x1024", "1536x1024", "1024x1536",
+			"1792x1024", "1024x1792"},
+	}
+	for route, limit := range defaultBodyLimits {
+		cfg.BodyLimits[route] = limit
+	}
+
+	if maxBodyBytes, err := strconv.ParseInt(os.Getenv("REQUEST_MAX_BODY_BYTES"), 10, 64); err == nil && maxBodyBytes > 0 {
+		cfg.MaxBodyBytes = maxBodyBytes
+	}
+	if limits := os.Getenv("REQUEST_BODY_LIMITS"); limits != "" {
+		overrides := map[string]int64{}
+		err := json.Unmarshal([]byte(limits), &overrides)
+		if err != nil {
+			logging.Logger.Errorf("Failed to parse REQUEST_BODY_LIMITS: %v", err)
+		}
+		for route, limit := range overrides {
+			cfg.BodyLimits[route] = limit
+		}
+	}
+	if maxOutputTokens, err := strconv.Atoi(os.Getenv("REQUEST_MAX_OUTPUT_TOKENS")); err == nil && maxOutputTokens >= 0 {
+		cfg.MaxOutputTokens = maxOutputTokens
+	}
+	if maxN, err := strconv.Atoi(os.Getenv("REQUEST_MAX_N")); err == nil && maxN >= 0 {
+		cfg.MaxN = maxN
+	}
+	if sizes, ok := os.LookupEnv("REQUEST_IMAGE_SIZES"); ok {
+		cfg.ImageSizes = nil
+		for _, size := range strings.Split(sizes, ",") {
+			if size = strings.TrimSpace(size); size != "" {
+				cfg.ImageSizes = append(cfg.ImageSizes, size)
+			}
+		}
+	}
+
+	return cfg
+}
+
+// BodyLimit 返回接口的请求体大小上限
+func (c *Config) BodyLimit(route string) int64 {
+	if limit, ok := c.BodyLimits[route]; ok && limit > 0 {
+		return limit
+	}
+	return c.MaxBodyBytes
+}
+
+// Validator 在转发前校验请求体
+type Validator struct {
+	config *Config
+}
+
+// NewValidator 创建校验器，未启用时返回 nil
+func NewValidator(cfg *Config) *Validator {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &Validator{config: cfg}
+}
+
+// BodyLimit 接口的请求体大小上限，未启用校验时返回 0
+func (v *Validator) BodyLimit(route string) int64 {
+	if v == nil {
+		return 0
+	}
+	return v.config.BodyLimit(route)
+}
+
+// Check 校验请求体大小及内容，route 为归一化后的接口名称，upstream 为 azure 时 model 可由部署路径指定
+//
+// JSON 请求体会被完整读取并恢复，multipart 等其他请求体在读取时限制大小。
+func (v *Validator) Check(w http.ResponseWriter, r *http.Request, upstream string, route string) *Error {
+	if v == nil || r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+
+	limit := v.config.BodyLimit(route)
+	tooLarge := &Error{
+		Message: fmt.Sprintf("Request body is too large, the limit for this endpoint is %d bytes.", limit),
+		Code:    "request_too_large",
+	}
+	if r.ContentLength > limit {
+		return tooLarge
+	}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	_ = r.Body.Close()
+	if err != nil {
+		return &Error{Message: "Failed to read request body.", Code: "invalid_body"}
+	}
+	if int64(len(body)) > limit {
+		return tooLarge
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+
+	if r.Method != http.MethodPost {
+		return nil
+	}
+	return v.checkJSON(body, upstream, route)
+}
+
+func (v *Validator) checkJSON(body []byte, upstream string, route string) *Error {
+	schema, ok := schemas[route]
+	if !ok {
+		return nil
+	}
+
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(body, &fields)
+	if err != nil {
+		return &Error{
+			Message: "We could not parse the JSON body of your request. The request body must be a JSON object.",
+			Code:    "invalid_json",
+		}
+	}
+
+	if upstream == "azure" {
+		// Azure 的模型由部署路径或默认模型确定
+		relaxed := Schema{}
+		for name, field := range schema {
+			relaxed[name] = field
+		}
+		model := relaxed["model"]
+		model.Required = false
+		relaxed["model"] = model
+		schema = relaxed
+	}
+	if err := schema.check(fields); err != nil {
+		return err
+	}
+
+	return v.checkLimits(fields, route)
+}
+
+// checkLimits 检查输出 token 数、n 及图片尺寸的上限
+func (v *Validator) checkLimits(fields map[string]json.RawMessage, route string) *Error {
+	if v.config.MaxOutputTokens > 0 {
+		for _, name := range []string{"max_tokens", "max_completion_tokens", "max_output_tokens"} {
+			var value int
+			if json.Unmarshal(fields[name], &value) == nil && value > v.config.MaxOutputTokens {
+				return invalidParam(name, "invalid_value",
+					fmt.Sprintf("'%s' must be at most %d.", name, v.config.MaxOutputTokens))
+			}
+		}
+	}
+
+	if v.config.MaxN > 0 {
+		var n int
+		if json.Unmarshal(fields["n"], &n) == nil && n > v.config.MaxN {
+			return invalidParam("n", "invalid_value", fmt.Sprintf("'n' must be at most %d.", v.config.MaxN))
+		}
+	}
+
+	if route == "images/generations" && len(v.config.ImageSizes) > 0 {
+		var size string
+		if json.Unmarshal(fields["size"], &size) == nil && size != "" && !contains(v.config.ImageSizes, size) {
+			return invalidParam("size", "invalid_value",
+				fmt.Sprintf("Invalid value for 'size': expected one of %s.", strings.Join(v.config.ImageSizes, ", ")))
+		}
+	}
+
+	return nil
+}
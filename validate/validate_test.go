@@ -0,0 +1,118 @@
+package validate
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newValidator() *Validator {
+	return NewValidator(&Config{
+		Enabled:         true,
+		MaxBodyBytes:    1 << 20,
+		BodyLimits:      map[string]int64{"embeddings": 64},
+		MaxOutputTokens: 1000,
+		MaxN:            4,
+		ImageSizes:      []string{"1024x1024"},
+	})
+}
+
+func check(v *Validator, upstream string, route string, body string) *Error {
+	req := httptest.NewRequest("POST", "/openai/v1/"+route, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return v.Check(httptest.NewRecorder(), req, upstream, route)
+}
+
+func TestValidator_Schema(t *testing.T) {
+	v := newValidator()
+
+	testCases := []struct {
+		route string
+		body  string
+		param string
+	}{
+		{"chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`, ""},
+		{"chat/completions", `{"model":"gpt-4o"}`, "messages"},
+		{"chat/completions", `{"model":"gpt-4o","messages":[]}`, "messages"},
+		{"chat/completions", `{"model":"gpt-4o","messages":[{}],"temperature":3}`, "temperature"},
+		{"chat/completions", `{"model":"gpt-4o","messages":[{}],"max_tokens":1.5}`, "max_tokens"},
+		{"chat/completions", `{"model":"gpt-4o","messages":[{}],"max_tokens":2000}`, "max_tokens"},
+		{"chat/completions", `{"model":"gpt-4o","messages":[{}],"n":5}`, "n"},
+		{"responses", `{"model":"gpt-4o","input":"hi","max_output_tokens":2000}`, "max_output_tokens"},
+		{"embeddings", `{"model":"m","input":42}`, "input"},
+		{"images/generations", `{"prompt":"cat","size":"4096x4096"}`, "size"},
+		{"audio/speech", `{"model":"tts-1","input":"hi"}`, "voice"},
+		{"moderations", `{"input":42}`, ""},
+	}
+
+	for _, tc := range testCases {
+		err := check(v, "openai", tc.route, tc.body)
+		if tc.param == "" {
+			if err != nil {
+				t.Errorf("Expected %s to be valid, got %v", tc.body, err)
+			}
+			continue
+		}
+		if err == nil || err.Param != tc.param {
+			t.Errorf("Expected %s to fail on '%s', got %+v", tc.body, tc.param, err)
+		}
+	}
+}
+
+func TestValidator_AzureModelOptional(t *testing.T) {
+	v := newValidator()
+	if err := check(v, "azure", "chat/completions", `{"messages":[{}]}`); err != nil {
+		t.Errorf("Expected model to be optional for azure, got %v", err)
+	}
+	if err := check(v, "openai", "chat/completions", `{"messages":[{}]}`); err == nil || err.Param != "model" {
+		t.Errorf("Expected model to be required for openai, got %+v", err)
+	}
+}
+
+func TestValidator_BodyLimit(t *testing.T) {
+	v := newValidator()
+
+	err := check(v, "openai", "embeddings", `{"model":"text-embedding-3-small","input":"`+strings.Repeat("a", 64)+`"}`)
+	if err == nil || err.Code != "request_too_large" {
+		t.Errorf("Expected body limit error, got %+v", err)
+	}
+
+	err = check(v, "openai", "chat/completions", `not json`)
+	if err == nil || err.Code != "invalid_json" {
+		t.Errorf("Expected invalid JSON error, got %+v", err)
+	}
+
+	// 校验后请求体可被再次读取
+	req := httptest.NewRequest("POST", "/openai/v1/embeddings", strings.NewReader(`{"model":"m","input":"a"}`))
+	req.Header.Set("Content-Type", "application/json")
+	if err := v.Check(httptest.NewRecorder(), req, "openai", "embeddings"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	body, _ := io.ReadAll(req.Body)
+	if string(body) != `{"model":"m","input":"a"}` {
+		t.Errorf("Expected body to be restored, got '%s'", body)
+	}
+
+	// 非 JSON 请求体在读取时限制大小
+	req = httptest.NewRequest("POST", "/openai/v1/embeddings", strings.NewReader(strings.Repeat("a", 100)))
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+	req.ContentLength = -1
+	if err := v.Check(httptest.NewRecorder(), req, "openai", "embeddings"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, readErr := io.ReadAll(req.Body)
+	var maxBytesErr *http.MaxBytesError
+	if readErr == nil || !errors.As(readErr, &maxBytesErr) {
+		t.Errorf("Expected max bytes error, got %v", readErr)
+	}
+}
+
+func TestLoadConfigFromEnv_UploadLimits(t *testing.T) {
+	cfg := LoadConfigFromEnv()
+	if cfg.BodyLimit("files") != 512<<20 || cfg.BodyLimit("uploads") != 64<<20 {
+		t.Errorf("Unexpected upload limits: files=%d uploads=%d", cfg.BodyLimit("files"), cfg.BodyLimit("uploads"))
+	}
+}
@@ -0,0 +1,62 @@
+// 用量頁面共用的格式化與日期工具，日期均按 UTC 計算，與後端的按日匯總一致
+
+export const formatNumber = (value) => Number(value || 0).toLocaleString();
+
+export const formatCost = (value) => `$${Number(value || 0).toFixed(4)}`;
+
+export const formatTokens = (value) => {
+    const n = Number(value || 0);
+    if (n >= 1e9) {
+        return `${(n / 1e9).toFixed(2)}B`;
+    }
+    if (n >= 1e6) {
+        return `${(n / 1e6).toFixed(2)}M`;
+    }
+    if (n >= 1e3) {
+        return `${(n / 1e3).toFixed(1)}K`;
+    }
+    return `${n}`;
+};
+
+export const formatTime = (value) => value ? new Date(value).toLocaleString() : "-";
+
+// toDay 返回 2006-01-02 格式的 UTC 日期
+export const toDay = (date) => date.toISOString().slice(0, 10);
+
+// monthRange 本月第一天至明天（until 不包含在內）
+export const monthRange = () => {
+    const now = new Date();
+    const since = new Date(Date.UTC(now.getUTCFullYear(), now.getUTCMonth(), 1));
+    const until = new Date(Date.UTC(now.getUTCFullYear(), now.getUTCMonth(), now.getUTCDate() + 1));
+    return {since: toDay(since), until: toDay(until)};
+};
+
+// lastDays 最近 n 天（含今天）
+export const lastDays = (n) => {
+    const now = new Date();
+    const since = new Date(Date.UTC(now.getUTCFullYear(), now.getUTCMonth(), now.getUTCDate() - n + 1));
+    const until = new Date(Date.UTC(now.getUTCFullYear(), now.getUTCMonth(), now.getUTCDate() + 1));
+    return {since: toDay(since), until: toDay(until)};
+};
+
+// daysBetween 列出 [since, until) 之間的每一天
+export const daysBetween = (since, until) => {
+    const days = [];
+    const end = Date.parse(until);
+    for (let t = Date.parse(since); t < end; t += 24 * 3600 * 1000) {
+        days.push(toDay(new Date(t)));
+    }
+    return days;
+};
+
+// downloadBlob 以指定檔名下載內容
+export const downloadBlob = (blob, filename) => {
+    const url = URL.createObjectURL(blob);
+    const link = document.createElement("a");
+    link.href = url;
+    link.download = filename;
+    document.body.appendChild(link);
+    link.click();
+    link.remove();
+    URL.revokeObjectURL(url);
+};
@@ -11,6 +11,8 @@ const query = new URLSearchParams(window.location.search);
 if (query.has('code')) {
     const code = query.get('code');
     httpService.authCallback(code);
+} else {
+    httpService.scheduleRefresh();
 }
 
 
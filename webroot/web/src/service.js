@@ -24,6 +24,10 @@ http.interceptors.request.use((config) => {
 })
 
 http.interceptors.response.use((response) => {
+    // 下載檔案（如 CSV 報表）時直接返回內容
+    if (response.config.responseType === "blob") {
+        return response.data;
+    }
     const json = response.data;
     if (response.status == 401) {
         return Promise.reject(new AuthError("Unauthorized", response.status));
@@ -36,24 +40,108 @@ http.interceptors.response.use((response) => {
     }
     return Promise.reject(new HttpError("Unknown error", response.status));
 }, (error) => {
-    if (error.response.status == 401) {
-        return Promise.reject(new AuthError("Unauthorized", error.response.status));
+    const response = error.response;
+    if (!response) {
+        return Promise.reject(error);
+    }
+    if (response.status == 401) {
+        return Promise.reject(new AuthError("Unauthorized", response.status));
     }
-    return Promise.reject(error);
+    // /api/v1 的錯誤格式為 {"status": false, "error": "...", "code": "..."}
+    if (response.data && typeof response.data.error === "string") {
+        return Promise.reject(new HttpError(response.data.error, response.status));
+    }
+    return Promise.reject(new HttpError(error.message, response.status));
 })
 
+// 登入與換取 token 時必須使用相同的 redirect_uri
+const redirectURL = () => `${window.location.origin}${window.location.pathname}`;
+
+// 距離到期少於該時間時自動續期
+const REFRESH_BEFORE_MS = 30 * 60 * 1000;
+
+const saveToken = (apikey) => {
+    const {key, expire_at} = apikey;
+    localStorage.setItem("token", key);
+    localStorage.setItem("token_expires_at", expire_at);
+}
+
 export default {
     async auth() {
-        let redirect = window.location.href;
-        window.location.href = `${BASE_URL}/auth?redirect=${encodeURI(redirect)}`;
+        window.location.href = `${BASE_URL}/auth?redirect=${encodeURIComponent(redirectURL())}`;
     },
     async authCallback(code) {
-        const apikey = await http.get(`/auth/callback?code=${code}"`)
-        const {key, expire_at} = apikey;
-        localStorage.setItem("token", key);
-        localStorage.setItem("token_expires_at", expire_at);
+        const apikey = await http.get(`/auth/callback?code=${encodeURIComponent(code)}&redirect=${encodeURIComponent(redirectURL())}`)
+        saveToken(apikey);
         window.location.href = "/";
     },
+    async refresh() {
+        try {
+            const apikey = await http.post("/auth/refresh");
+            saveToken(apikey);
+            return apikey;
+        } catch (e) {
+            if (e instanceof AuthError) {
+                localStorage.removeItem("token");
+                localStorage.removeItem("token_expires_at");
+            }
+            throw e;
+        }
+    },
+    scheduleRefresh() {
+        const expiresAt = Date.parse(localStorage.getItem("token_expires_at") || "");
+        if (!localStorage.getItem("token") || isNaN(expiresAt)) {
+            return;
+        }
+        const delay = Math.max(expiresAt - Date.now() - REFRESH_BEFORE_MS, 0);
+        setTimeout(async () => {
+            try {
+                await this.refresh();
+                this.scheduleRefresh();
+            } catch (e) {
+                console.error(e);
+            }
+        }, delay);
+    },
+
+    async logout(all = false) {
+        try {
+            await http.post(`/auth/logout${all ? "?all=true" : ""}`);
+        } finally {
+            localStorage.removeItem("token");
+            localStorage.removeItem("token_expires_at");
+        }
+    },
+    async Sessions() {
+        return http.get("/auth/sessions");
+    },
+    async Me() {
+        return http.get("/auth/me");
+    },
+    // 簽發服務密鑰，密鑰本身只在返回結果中出現一次
+    async CreateServiceKey({label, expires_in, allowed_ips = []}) {
+        return http.post("/auth/sessions", {label, expires_in, allowed_ips});
+    },
+    async RevokeSession(id) {
+        return http.delete(`/auth/sessions/${encodeURIComponent(id)}`);
+    },
+
+    // 用量報表，params 見 README「用量報表」
+    async Usage(params = {}) {
+        return http.get("/usage", {params});
+    },
+    async UsageCSV(params = {}) {
+        return http.get("/usage", {params: {...params, format: "csv"}, responseType: "blob"});
+    },
+    async Status() {
+        return http.get("/status");
+    },
+    async Teams() {
+        return http.get("/admin/teams");
+    },
+    async RevokeUserKeys(user) {
+        return http.delete(`/admin/users/${encodeURIComponent(user)}/keys`);
+    },
 
     async AzureModels() {
         return http.get("/azure/models");
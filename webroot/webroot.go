@@ -0,0 +1,11 @@
+// Package webroot 编译时内嵌的静态文件：首页、Swagger 文档及 Web UI（由 web 目录构建到 ui 目录）
+package webroot
+
+import "embed"
+
+//go:generate sh -c "cd web && yarn install && yarn build"
+
+// FS 内嵌的静态文件，修改 web 目录中的源码后需先执行 go generate ./webroot 重新生成 ui 目录
+//
+//go:embed index.html swagger ui
+var FS embed.FS